@@ -0,0 +1,103 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AttestationPolicySpec defines the desired state of AttestationPolicy
+type AttestationPolicySpec struct {
+	// KbsConfigName is the name of the KbsConfig serving the policy.
+	// Every KbsConfig of the namespace serves the policy when not set.
+	// +optional
+	KbsConfigName string `json:"kbsConfigName,omitempty"`
+
+	// PolicyID is the attestation service policy ID, stored as <policyID>.rego.
+	// default_cpu and default_gpu replace the default CPU and GPU policies.
+	// The name of the AttestationPolicy is used when not set.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-]+$`
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	PolicyID string `json:"policyID,omitempty"`
+
+	// Policy is the Rego attestation policy
+	// +kubebuilder:validation:MinLength=1
+	Policy string `json:"policy"`
+}
+
+const (
+	// AttestationPolicyConditionValid is True when the policy compiles.
+	// An invalid policy is not rolled out, the last valid version is kept.
+	AttestationPolicyConditionValid = "Valid"
+
+	// AttestationPolicyConditionReady is True when the policy ID is served by at least one KbsConfig,
+	// with the last valid version of the policy
+	AttestationPolicyConditionReady = "Ready"
+)
+
+// AttestationPolicyStatus defines the observed state of AttestationPolicy
+type AttestationPolicyStatus struct {
+	// ObservedGeneration is the most recent AttestationPolicy generation observed by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// PolicyID is the effective attestation service policy ID
+	// +optional
+	PolicyID string `json:"policyID,omitempty"`
+
+	// KbsConfigs are the names of the KbsConfigs serving the policy
+	// +optional
+	KbsConfigs []string `json:"kbsConfigs,omitempty"`
+
+	// Conditions represent the latest available observations of the AttestationPolicy state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Policy ID",type=string,JSONPath=`.status.policyID`
+//+kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// AttestationPolicy is the Schema for the attestationpolicies API
+type AttestationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AttestationPolicySpec   `json:"spec,omitempty"`
+	Status AttestationPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// AttestationPolicyList contains a list of AttestationPolicy
+type AttestationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AttestationPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AttestationPolicy{}, &AttestationPolicyList{})
+}
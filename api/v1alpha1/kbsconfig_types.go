@@ -17,8 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -60,12 +65,500 @@ type KbsLocalCertCacheSpec struct {
 	Secrets []KbsLocalCertCacheEntry `json:"secrets,omitempty"`
 }
 
+// KbsSecretResourceEntry publishes the keys of a secret as KBS resources under a custom path
+type KbsSecretResourceEntry struct {
+	// SecretName is the name of the secret holding the resources
+	// +kubebuilder:validation:MinLength=1
+	SecretName string `json:"secretName"`
+
+	// Repository is the first element of the resource path, the
+	// kbs.confidentialcontainers.org/repository annotation of the secret or "default" when not set
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Type is the second element of the resource path, the kbs.confidentialcontainers.org/type
+	// annotation of the secret or the secret name when not set
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Keys renames secret keys, the other keys are published with their name as tag
+	// +optional
+	Keys []KbsSecretResourceKey `json:"keys,omitempty"`
+}
+
+// KbsSecretResourceKey publishes a secret key under another tag
+type KbsSecretResourceKey struct {
+	// Key is the secret key
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// Tag is the last element of the resource path
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	Tag string `json:"tag"`
+}
+
+// KbsVaultResourceSource publishes the keys of a secret of a Vault-compatible KV secrets engine
+// (HashiCorp Vault, OpenBao) as KBS resources. The secret-converter logs in with the Kubernetes
+// auth method, using a token of the service account of the KBS pods
+type KbsVaultResourceSource struct {
+	// Address of the Vault server, e.g. https://vault.vault.svc:8200
+	// +kubebuilder:validation:Pattern=`^https?://`
+	Address string `json:"address"`
+
+	// Namespace of the Vault Enterprise or OpenBao namespace holding the secret
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// CAConfigMapName is a ConfigMap whose ca.crt key holds the CA bundle of the Vault server,
+	// the system CAs are used when not set
+	// +optional
+	CAConfigMapName string `json:"caConfigMapName,omitempty"`
+
+	// AuthMountPath is the mount path of the Kubernetes auth method, "kubernetes" by default
+	// +optional
+	AuthMountPath string `json:"authMountPath,omitempty"`
+
+	// Role is the role of the Kubernetes auth method bound to the service account of the KBS pods
+	// +kubebuilder:validation:MinLength=1
+	Role string `json:"role"`
+
+	// TokenAudience is the audience of the service account token, the one of the Kubernetes API
+	// server when not set
+	// +optional
+	TokenAudience string `json:"tokenAudience,omitempty"`
+
+	// MountPath is the mount path of the KV secrets engine, "secret" by default
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+
+	// KVVersion is the version of the KV secrets engine, 2 by default
+	// +kubebuilder:validation:Enum=1;2
+	// +optional
+	KVVersion int32 `json:"kvVersion,omitempty"`
+
+	// Path of the secret in the KV secrets engine
+	// +kubebuilder:validation:MinLength=1
+	Path string `json:"path"`
+
+	// Repository is the first element of the resource path, "default" when not set
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Type is the second element of the resource path, the last element of Path when not set
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Keys renames keys of the secret, the other keys are published with their name as tag
+	// +optional
+	Keys []KbsSecretResourceKey `json:"keys,omitempty"`
+}
+
 // KbsDeploymentSpec defines the configuration for trustee deployment
 type KbsDeploymentSpec struct {
 	// Number of desired trustee pods. This is a pointer to distinguish between explicit
-	// zero and not specified. Defaults to 1.
+	// zero and not specified. Defaults to 1. Ignored when autoscaling is set
 	// +optional
 	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Resources are the compute resources of the kbs container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// SecretConverterResources are the compute resources of the secret-converter init container of the KBS pods
+	// +optional
+	SecretConverterResources corev1.ResourceRequirements `json:"secretConverterResources,omitempty"`
+
+	// NodeSelector constrains the pods to the nodes with these labels
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the pods
+	// +optional
+	// +listType=atomic
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity scheduling rules of the pods
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// TopologySpreadConstraints spread the pods across the topology domains, e.g. the zones.
+	// A constraint without labelSelector selects the pods of the deployment
+	// +optional
+	// +listType=atomic
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// PriorityClassName is the priority class of the pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// ServiceAccountName is the service account the pods run as, the namespace default one when not set
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// PodDisruptionBudget limits the number of KBS pods evicted at once, e.g. by node drains.
+	// No PodDisruptionBudget is created when not set
+	// +optional
+	PodDisruptionBudget *KbsPodDisruptionBudgetSpec `json:"podDisruptionBudget,omitempty"`
+
+	// Autoscaling scales the KBS deployment with a HorizontalPodAutoscaler, which then owns the replica count
+	// +optional
+	Autoscaling *KbsAutoscalingSpec `json:"autoscaling,omitempty"`
+}
+
+// KbsPodDisruptionBudgetSpec defines the PodDisruptionBudget of the KBS pods.
+// Only one of minAvailable and maxUnavailable can be set, maxUnavailable defaults to 1
+type KbsPodDisruptionBudgetSpec struct {
+	// MinAvailable is the number or percentage of KBS pods that must remain available
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is the number or percentage of KBS pods that can be unavailable
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// KbsAutoscalingSpec defines the HorizontalPodAutoscaler of the KBS deployment
+type KbsAutoscalingSpec struct {
+	// MinReplicas is the lower limit of the number of KBS pods. Defaults to 1.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of the number of KBS pods
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the average CPU utilization of the KBS pods, relative to their
+	// requests, targeted by the autoscaler. Defaults to 80 when no metrics are set
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// Metrics are additional metrics, e.g. custom or external ones, the autoscaler scales on
+	// +optional
+	// +listType=atomic
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+
+	// Behavior configures the scaling up and down behavior of the autoscaler
+	// +optional
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// MicroservicesSpec configures the attestation service and RVPS of the MicroservicesDeployment topology
+type MicroservicesSpec struct {
+	// SeparateDeployments runs the attestation service and RVPS in Deployments and Services of their own,
+	// scaled independently of KBS. They run as containers of the KBS pods when false
+	// +optional
+	SeparateDeployments bool `json:"separateDeployments,omitempty"`
+
+	// AsDeploymentSpec is the deployment of the attestation service
+	// +optional
+	AsDeploymentSpec MicroserviceDeploymentSpec `json:"asDeploymentSpec,omitempty"`
+
+	// RvpsDeploymentSpec is the deployment of RVPS
+	// +optional
+	RvpsDeploymentSpec MicroserviceDeploymentSpec `json:"rvpsDeploymentSpec,omitempty"`
+}
+
+// MicroserviceDeploymentSpec defines the deployment of the attestation service or RVPS. The replicas and the
+// pod settings only apply with separateDeployments, the pod settings that are not set, the affinity and the
+// topology spread constraints included, are taken from the KBS deployment
+type MicroserviceDeploymentSpec struct {
+	// Number of desired pods. Defaults to 1.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Resources are the compute resources of the as or rvps container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector constrains the pods to the nodes with these labels
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the pods
+	// +optional
+	// +listType=atomic
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Affinity of the pods. The affinity of the KBS pods is not inherited, since its pod affinity
+	// terms select the KBS pods
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// TopologySpreadConstraints spread the pods across the topology domains. The constraints without label
+	// selector select the pods of the component. The constraints of the KBS pods apply when not set,
+	// with their label selector replaced by the labels of the component pods
+	// +optional
+	// +listType=atomic
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// PriorityClassName is the priority class of the pods
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// ServiceAccountName is the service account the pods run as
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// StorageType determines the volume backing a KBS storage directory
+// +enum
+type StorageType string
+
+const (
+	// StorageTypeEmptyDir: the directory is an in-memory emptyDir and its content is lost on pod restart
+	StorageTypeEmptyDir StorageType = "EmptyDir"
+
+	// StorageTypeManagedPVC: the operator creates and owns a PersistentVolumeClaim for the directory
+	StorageTypeManagedPVC StorageType = "ManagedPVC"
+
+	// StorageTypeExistingPVC: the directory is backed by a PersistentVolumeClaim provided by the user
+	StorageTypeExistingPVC StorageType = "ExistingPVC"
+)
+
+// KbsStorageVolumeSpec defines the volume backing a single KBS storage directory
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'ExistingPVC' || (has(self.claimName) && size(self.claimName) > 0)",message="claimName is required when type is ExistingPVC"
+type KbsStorageVolumeSpec struct {
+	// Type is the kind of volume backing the directory
+	// It can assume one of the following values:
+	//    EmptyDir: in-memory emptyDir volume, the content is lost on pod restart
+	//    ManagedPVC: PersistentVolumeClaim created and owned by the operator
+	//    ExistingPVC: PersistentVolumeClaim provided by the user
+	// +kubebuilder:validation:Enum=EmptyDir;ManagedPVC;ExistingPVC
+	// Default value is EmptyDir
+	// +optional
+	Type StorageType `json:"type,omitempty"`
+
+	// Size is the requested capacity of the managed PersistentVolumeClaim
+	// Used only when type is ManagedPVC. Default value is 1Gi
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// StorageClassName is the storage class of the managed PersistentVolumeClaim
+	// Used only when type is ManagedPVC. The cluster default storage class is used if not specified
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// AccessMode is the access mode of the managed PersistentVolumeClaim
+	// Used only when type is ManagedPVC. Default value is ReadWriteOnce
+	// ReadWriteMany is required to share the directory across replicas scheduled on different nodes
+	// +kubebuilder:validation:Enum=ReadWriteOnce;ReadWriteMany;ReadWriteOncePod
+	// +optional
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+
+	// ClaimName is the name of an existing PersistentVolumeClaim in the operator namespace
+	// Required when type is ExistingPVC
+	// +optional
+	ClaimName string `json:"claimName,omitempty"`
+}
+
+// KbsStorageSpec selects the volumes backing the writable KBS storage directories
+// Directories that are not specified use an in-memory emptyDir volume
+type KbsStorageSpec struct {
+	// BaseStorageDir backs /opt/confidential-containers/storage, used among others for the session storage
+	// +optional
+	BaseStorageDir *KbsStorageVolumeSpec `json:"baseStorageDir,omitempty"`
+
+	// RepositoryDir backs /opt/confidential-containers/storage/repository, where KBS resources are stored
+	// +optional
+	RepositoryDir *KbsStorageVolumeSpec `json:"repositoryDir,omitempty"`
+
+	// RvpsDir backs /opt/confidential-containers/storage/local_json, where RVPS reference values are stored
+	// +optional
+	RvpsDir *KbsStorageVolumeSpec `json:"rvpsDir,omitempty"`
+
+	// AttestationPolicyDir backs /opt/confidential-containers/storage/attestation_service_policy,
+	// where the attestation policies are stored
+	// +optional
+	AttestationPolicyDir *KbsStorageVolumeSpec `json:"attestationPolicyDir,omitempty"`
+}
+
+// SecretConverterMode determines how the secret-converter fills the KBS repository
+// +enum
+type SecretConverterMode string
+
+const (
+	// SecretConverterInitContainer: the resources are copied once, when the KBS pods start
+	SecretConverterInitContainer SecretConverterMode = "InitContainer"
+
+	// SecretConverterSidecar: the resources are kept in sync while the KBS pods run
+	SecretConverterSidecar SecretConverterMode = "Sidecar"
+)
+
+// SharedStorageType determines the database shared by the KBS replicas
+// +enum
+type SharedStorageType string
+
+const (
+	// SharedStorageManagedPostgres: the operator deploys a single PostgreSQL instance for the KBS replicas
+	SharedStorageManagedPostgres SharedStorageType = "ManagedPostgres"
+
+	// SharedStoragePostgres: the KBS replicas use an existing PostgreSQL database
+	SharedStoragePostgres SharedStorageType = "Postgres"
+)
+
+// KbsSharedStorageSpec configures a storage backend shared by the KBS replicas, so that they don't rely on
+// session affinity
+// +kubebuilder:validation:XValidation:rule="self.type != 'Postgres' || has(self.postgres)",message="postgres is required when type is Postgres"
+type KbsSharedStorageSpec struct {
+	// Type is the kind of shared storage
+	// It can assume one of the following values:
+	//    ManagedPostgres: single PostgreSQL instance deployed by the operator
+	//    Postgres: existing PostgreSQL database
+	// +kubebuilder:validation:Enum=ManagedPostgres;Postgres
+	Type SharedStorageType `json:"type"`
+
+	// Postgres is the existing database, required when type is Postgres
+	// +optional
+	Postgres *PostgresEndpointSpec `json:"postgres,omitempty"`
+
+	// ManagedPostgres configures the database deployed by the operator, used only when type is ManagedPostgres
+	// +optional
+	ManagedPostgres *ManagedPostgresSpec `json:"managedPostgres,omitempty"`
+}
+
+// PostgresEndpointSpec defines the connection to an existing PostgreSQL database
+type PostgresEndpointSpec struct {
+	// Host is the DNS name or the address of the database server
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// Port of the database server. Default value is 5432
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port,omitempty"`
+
+	// Database is the name of the database. Default value is kbs
+	// +optional
+	Database string `json:"database,omitempty"`
+
+	// CredentialsSecretName is the Secret in the operator namespace holding the username and password keys
+	// +kubebuilder:validation:MinLength=1
+	CredentialsSecretName string `json:"credentialsSecretName"`
+}
+
+// ManagedPostgresSpec defines the PostgreSQL instance deployed by the operator
+type ManagedPostgresSpec struct {
+	// Storage is the volume backing the database, an emptyDir by default whose content is lost on pod restart
+	// +optional
+	Storage *KbsStorageVolumeSpec `json:"storage,omitempty"`
+
+	// Resources are the compute resources of the postgres container
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// ExposureType determines the kind of object exposing the KBS service outside the cluster
+// +enum
+type ExposureType string
+
+const (
+	// ExposureTypeIngress: the KBS service is exposed through a Kubernetes Ingress
+	ExposureTypeIngress ExposureType = "Ingress"
+
+	// ExposureTypeRoute: the KBS service is exposed through an OpenShift Route
+	ExposureTypeRoute ExposureType = "Route"
+
+	// ExposureTypeHTTPRoute: the KBS service is exposed through a Gateway API HTTPRoute
+	ExposureTypeHTTPRoute ExposureType = "HTTPRoute"
+)
+
+// RouteTermination determines where TLS is terminated for an OpenShift Route
+// +enum
+type RouteTermination string
+
+const (
+	// RouteTerminationEdge: the router terminates TLS and forwards plain HTTP to KBS
+	RouteTerminationEdge RouteTermination = "edge"
+
+	// RouteTerminationPassthrough: the router forwards the TLS connection to KBS untouched
+	RouteTerminationPassthrough RouteTermination = "passthrough"
+
+	// RouteTerminationReencrypt: the router terminates TLS and opens a new TLS connection to KBS
+	RouteTerminationReencrypt RouteTermination = "reencrypt"
+)
+
+// KbsIngressSpec defines the Ingress specific exposure options
+type KbsIngressSpec struct {
+	// IngressClassName is the name of the IngressClass handling the Ingress
+	// The cluster default IngressClass is used if not specified
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// TlsSecretName is the name of a kubernetes.io/tls secret used by the ingress controller to terminate TLS
+	// +optional
+	TlsSecretName string `json:"tlsSecretName,omitempty"`
+}
+
+// KbsRouteSpec defines the OpenShift Route specific exposure options
+type KbsRouteSpec struct {
+	// Termination is the TLS termination of the Route
+	// Default value is passthrough when KBS serves HTTPS and edge otherwise
+	// passthrough and reencrypt require KBS to serve HTTPS, edge requires KBS to serve plain HTTP
+	// +kubebuilder:validation:Enum=edge;passthrough;reencrypt
+	// +optional
+	Termination RouteTermination `json:"termination,omitempty"`
+}
+
+// KbsHTTPRouteSpec defines the Gateway API HTTPRoute specific exposure options
+type KbsHTTPRouteSpec struct {
+	// GatewayName is the name of the Gateway the HTTPRoute attaches to
+	GatewayName string `json:"gatewayName"`
+
+	// GatewayNamespace is the namespace of the Gateway
+	// Default value is the operator namespace
+	// +optional
+	GatewayNamespace string `json:"gatewayNamespace,omitempty"`
+
+	// SectionName is the name of the Gateway listener the HTTPRoute attaches to
+	// The HTTPRoute attaches to all the compatible listeners if not specified
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// KbsExposureSpec defines how the KBS service is exposed outside the cluster
+// +kubebuilder:validation:XValidation:rule="self.type != 'HTTPRoute' || has(self.httpRoute)",message="httpRoute is required when type is HTTPRoute"
+type KbsExposureSpec struct {
+	// Type is the kind of object created to expose KBS
+	// It can assume one of the following values:
+	//    Ingress: Kubernetes Ingress
+	//    Route: OpenShift Route
+	//    HTTPRoute: Gateway API HTTPRoute
+	// +kubebuilder:validation:Enum=Ingress;Route;HTTPRoute
+	Type ExposureType `json:"type"`
+
+	// Hostname is the external host name of KBS
+	// OpenShift generates a host name for Routes if not specified
+	// +optional
+	Hostname string `json:"hostname,omitempty"`
+
+	// SessionAffinity pins the requests of a client to the same KBS replica, which is required
+	// when running more than one replica since KBS keeps the attestation sessions in memory
+	// Default value is true
+	// +optional
+	SessionAffinity *bool `json:"sessionAffinity,omitempty"`
+
+	// Annotations are added to the created object and take precedence over the annotations set by the operator
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Ingress holds the options used when type is Ingress
+	// +optional
+	Ingress *KbsIngressSpec `json:"ingress,omitempty"`
+
+	// Route holds the options used when type is Route
+	// +optional
+	Route *KbsRouteSpec `json:"route,omitempty"`
+
+	// HTTPRoute holds the options used when type is HTTPRoute
+	// +optional
+	HTTPRoute *KbsHTTPRouteSpec `json:"httpRoute,omitempty"`
 }
 
 // TlsConfig defines TLS protocol and cipher configuration for Trustee HTTPS server.
@@ -155,6 +648,16 @@ type KbsConfigSpec struct {
 	// KbsAuthSecretName is the name of the secret that contains the KBS auth secret
 	KbsAuthSecretName string `json:"kbsAuthSecretName,omitempty"`
 
+	// KbsItaApiKeySecretRef selects the Intel Trust Authority API key, set as attestation_service.api_key
+	// of the configuration of KbsConfigMapName. The configuration is then mounted from an owned Secret
+	// +optional
+	KbsItaApiKeySecretRef *corev1.SecretKeySelector `json:"kbsItaApiKeySecretRef,omitempty"`
+
+	// KbsAdminPublicKeysSecretName is the name of the secret holding the public keys of the KBS admin personas,
+	// mounted under /etc/admin-public-keys
+	// +optional
+	KbsAdminPublicKeysSecretName string `json:"kbsAdminPublicKeysSecretName,omitempty"`
+
 	// KbsServiceType is the type of service to create for KBS
 	// Default value is ClusterIP
 	// +optional
@@ -165,10 +668,15 @@ type KbsConfigSpec struct {
 	//    AllInOneDeployment: all the KBS components will be deployed in the same container
 	//    MicroservicesDeployment: all the KBS components will be deployed in separate containers
 	// +kubebuilder:validation:Enum=AllInOneDeployment;MicroservicesDeployment
-	// Default value is AllInOneDeployment
+	// Default value is MicroservicesDeployment when both kbsAsConfigMapName and kbsRvpsConfigMapName
+	// are set, AllInOneDeployment otherwise
 	// +optional
 	KbsDeploymentType DeploymentType `json:"kbsDeploymentType,omitempty"`
 
+	// KbsMicroservicesSpec configures the attestation service and RVPS of the MicroservicesDeployment type
+	// +optional
+	KbsMicroservicesSpec *MicroservicesSpec `json:"kbsMicroservicesSpec,omitempty"`
+
 	// KbsHttpsKeySecretName is the name of the secret that contains the KBS https private key
 	KbsHttpsKeySecretName string `json:"kbsHttpsKeySecretName,omitempty"`
 
@@ -187,6 +695,28 @@ type KbsConfigSpec struct {
 	// +optional
 	KbsSecretResources []string `json:"kbsSecretResources,omitempty"`
 
+	// KbsSecretResourceEntries are secrets whose keys are published under a custom repository, type or tag,
+	// in addition to the ones of KbsSecretResources
+	// +optional
+	KbsSecretResourceEntries []KbsSecretResourceEntry `json:"kbsSecretResourceEntries,omitempty"`
+
+	// KbsVaultResources are secrets of Vault-compatible KV secrets engines published as KBS resources
+	// by the secret-converter, without copying them to Kubernetes Secrets
+	// +optional
+	KbsVaultResources []KbsVaultResourceSource `json:"kbsVaultResources,omitempty"`
+
+	// KbsRolloutExcludedSecrets lists the referenced secrets whose changes don't restart the KBS pods,
+	// e.g. the ones KBS reloads without a restart
+	// +optional
+	KbsRolloutExcludedSecrets []string `json:"kbsRolloutExcludedSecrets,omitempty"`
+
+	// KbsSecretConverterMode determines how the kbsSecretResources and the KbsResources are copied to the
+	// KBS repository. InitContainer (default) copies them when the pods start and restarts the pods on
+	// changes, Sidecar keeps the repository in sync without restarting them
+	// +kubebuilder:validation:Enum=InitContainer;Sidecar
+	// +optional
+	KbsSecretConverterMode SecretConverterMode `json:"kbsSecretConverterMode,omitempty"`
+
 	// KbsAttestationPolicyConfigMapName is the name of the configmap that contains the Attestation Policy
 	// +optional
 	KbsAttestationPolicyConfigMapName string `json:"kbsAttestationPolicyConfigMapName,omitempty"`
@@ -213,19 +743,94 @@ type KbsConfigSpec struct {
 
 	// KbsDeploymentSpec is the struct for trustee deployment options
 	KbsDeploymentSpec KbsDeploymentSpec `json:"KbsDeploymentSpec,omitempty"`
+
+	// KbsStorageSpec selects emptyDir or persistent volumes for the KBS storage directories,
+	// so that resources, policies and reference values survive pod restarts
+	// +optional
+	KbsStorageSpec KbsStorageSpec `json:"storage,omitempty"`
+
+	// KbsExposureSpec exposes the KBS service outside the cluster through an Ingress,
+	// an OpenShift Route or a Gateway API HTTPRoute created and owned by the operator
+	// +optional
+	KbsExposureSpec *KbsExposureSpec `json:"exposure,omitempty"`
+
+	// KbsSharedStorageSpec configures a storage backend shared by the KBS replicas. The attestation
+	// sessions stay in the memory of each replica, the clients are still pinned with session affinity
+	// +optional
+	KbsSharedStorageSpec *KbsSharedStorageSpec `json:"sharedStorage,omitempty"`
+}
+
+// DeploymentType returns the KBS deployment type, defaulted when unset: MicroservicesDeployment
+// if both the AS and RVPS configurations are provided, AllInOneDeployment otherwise.
+// The admission webhook and the controller share this default.
+func (spec *KbsConfigSpec) DeploymentType() DeploymentType {
+	if spec.KbsDeploymentType != "" {
+		return spec.KbsDeploymentType
+	}
+	// AS and RVPS configurations only make sense with separate containers
+	if spec.KbsAsConfigMapName != "" && spec.KbsRvpsConfigMapName != "" {
+		return DeploymentTypeMicroservices
+	}
+	return DeploymentTypeAllInOne
 }
 
+// Condition types reported in KbsConfigStatus.Conditions
+const (
+	// KbsConfigConditionReady is True when the configuration is resolved, the
+	// KBS deployment is available and the KBS service exists
+	KbsConfigConditionReady = "Ready"
+
+	// KbsConfigConditionConfigResolved is True when every ConfigMap and Secret
+	// referenced by the KbsConfig exists
+	KbsConfigConditionConfigResolved = "ConfigResolved"
+
+	// KbsConfigConditionDeploymentAvailable is True when all the replicas of
+	// the KBS deployment are ready
+	KbsConfigConditionDeploymentAvailable = "DeploymentAvailable"
+
+	// KbsConfigConditionServiceReady is True when the KBS service has been
+	// created or updated successfully
+	KbsConfigConditionServiceReady = "ServiceReady"
+
+	// KbsConfigConditionTlsConfigured is True when KBS serves HTTPS
+	KbsConfigConditionTlsConfigured = "TlsConfigured"
+
+	// KbsConfigConditionDegraded is True when the last reconciliation failed
+	// or the configuration is only partially applied
+	KbsConfigConditionDegraded = "Degraded"
+
+	// KbsConfigConditionExposed is True when the object exposing KBS outside the
+	// cluster exists and its external URL is known. It is only set when exposure
+	// is configured and does not gate readiness
+	KbsConfigConditionExposed = "Exposed"
+)
+
 // KbsConfigStatus defines the observed state of KbsConfig
 type KbsConfigStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the most recent KbsConfig generation observed by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 
-	// IsReady is true when the KBS configuration is ready
-	IsReady bool `json:"isReady"`
+	// Conditions represent the latest available observations of the KbsConfig state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// ExternalURL is the URL clients outside the cluster use to reach KBS
+	// It is only set when exposure is configured
+	// +optional
+	ExternalURL string `json:"externalURL,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.externalURL`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // KbsConfig is the Schema for the kbsconfigs API
 type KbsConfig struct {
@@ -250,6 +855,119 @@ type HttpsSpec struct {
 	// TlsSecretName is the name of the Kubernetes TLS secret (type: kubernetes.io/tls)
 	// that contains the TLS certificate and private key
 	TlsSecretName string `json:"tlsSecretName,omitempty"`
+
+	// CertManager makes the operator request the KBS serving certificate from a cert-manager issuer.
+	// Ignored when tlsSecretName is set.
+	// +optional
+	CertManager *CertManagerCertificateSpec `json:"certManager,omitempty"`
+
+	// SelfSigned makes the operator generate a CA and a KBS serving certificate when neither
+	// tlsSecretName nor certManager is set. It is implied by the Restricted profile.
+	// +optional
+	SelfSigned *SelfSignedCertificateSpec `json:"selfSigned,omitempty"`
+}
+
+// IssuerReference references a cert-manager Issuer or ClusterIssuer
+type IssuerReference struct {
+	// Name of the issuer
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Kind of the issuer
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +kubebuilder:default=Issuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group of the issuer, defaults to cert-manager.io. Set it for external issuers.
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// CertManagerCertificateSpec configures the cert-manager Certificate created by the operator
+type CertManagerCertificateSpec struct {
+	// IssuerRef is the issuer signing the certificate. Issuers must be in the TrusteeConfig namespace.
+	IssuerRef IssuerReference `json:"issuerRef"`
+
+	// Duration is the requested validity of the certificate, defaults to the cert-manager default (90 days)
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// RenewBefore is how long before expiry cert-manager renews the certificate,
+	// defaults to a third of its validity
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+
+	// DNSNames are additional subject alternative names of the HTTPS certificate.
+	// The KBS service DNS names and the exposure hostname are always included.
+	// Ignored for the attestation token certificate.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+// SelfSignedCertificateSpec configures the serving certificate issued by the operator CA
+type SelfSignedCertificateSpec struct {
+	// Duration is the validity of the serving certificate, shorter than the 10 year validity of the CA
+	// +kubebuilder:default="2160h"
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// RenewBefore is how long before expiry the serving certificate is renewed
+	// +kubebuilder:default="720h"
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+
+	// DNSNames are additional subject alternative names of the serving certificate.
+	// The KBS service DNS names and the exposure hostname are always included.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+const (
+	// DefaultSelfSignedDuration is the default validity of the self-signed serving certificate
+	DefaultSelfSignedDuration = 2160 * time.Hour
+
+	// DefaultSelfSignedRenewBefore is the default renewal window of the self-signed serving certificate
+	DefaultSelfSignedRenewBefore = 720 * time.Hour
+
+	// SelfSignedCAValidity is the validity of the CA issuing the self-signed serving certificate,
+	// the serving certificate duration must be shorter
+	SelfSignedCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// Durations returns the validity and the renewal window of the serving certificate, defaulted when unset.
+// The admission webhook and the controller share these defaults.
+func (spec *SelfSignedCertificateSpec) Durations() (time.Duration, time.Duration) {
+	duration, renewBefore := DefaultSelfSignedDuration, DefaultSelfSignedRenewBefore
+	if spec == nil {
+		return duration, renewBefore
+	}
+	if spec.Duration != nil {
+		duration = spec.Duration.Duration
+	}
+	if spec.RenewBefore != nil {
+		renewBefore = spec.RenewBefore.Duration
+	}
+	return duration, renewBefore
+}
+
+// CertificateStatus reports the serving certificate issued by the operator or by cert-manager
+type CertificateStatus struct {
+	// NotAfter is the expiry time of the certificate
+	NotAfter metav1.Time `json:"notAfter"`
+
+	// RenewalTime is the time the certificate will be renewed
+	// +optional
+	RenewalTime *metav1.Time `json:"renewalTime,omitempty"`
+
+	// DNSNames are the subject alternative names of the certificate
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+
+	// CABundleConfigMapName is the ConfigMap publishing the CA certificate under the ca.crt key.
+	// Empty when the cert-manager issuer does not provide the CA.
+	// +optional
+	CABundleConfigMapName string `json:"caBundleConfigMapName,omitempty"`
 }
 
 // AttestationTokenVerificationSpec token validation using trusted certificate authorities
@@ -257,6 +975,11 @@ type AttestationTokenVerificationSpec struct {
 	// TlsSecretName is the name of the Kubernetes TLS secret (type: kubernetes.io/tls)
 	// that contains the TLS certificate for attestation token verification
 	TlsSecretName string `json:"tlsSecretName,omitempty"`
+
+	// CertManager makes the operator request the attestation token certificate from a cert-manager issuer.
+	// Ignored when tlsSecretName is set.
+	// +optional
+	CertManager *CertManagerCertificateSpec `json:"certManager,omitempty"`
 }
 
 // Profile Type string determines the trustee profile
@@ -287,6 +1010,143 @@ type IbmSETeeConfig struct {
 	PVName string `json:"pvName"`
 }
 
+// VcekSourceType is a source of the VCEK certificates of the SNP verifier
+// +kubebuilder:validation:Enum=OfflineStore;KDS
+type VcekSourceType string
+
+const (
+	// VcekSourceOfflineStore reads the VCEK certificates from the local certificate cache
+	VcekSourceOfflineStore VcekSourceType = "OfflineStore"
+
+	// VcekSourceKDS fetches the VCEK certificates from the AMD Key Distribution Service
+	VcekSourceKDS VcekSourceType = "KDS"
+)
+
+// KbsSettingsSpec holds settings of the KBS configuration generated by TrusteeConfig.
+// The profile provides the value of the fields that are not set.
+type KbsSettingsSpec struct {
+	// WorkerCount is the number of workers of the KBS HTTP server
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=1024
+	// +optional
+	WorkerCount *int32 `json:"workerCount,omitempty"`
+
+	// AttestationTokenDuration is the validity of the attestation tokens, a whole number of minutes
+	// +optional
+	AttestationTokenDuration *metav1.Duration `json:"attestationTokenDuration,omitempty"`
+
+	// VcekSources are the sources of the VCEK certificates of the SNP verifier, tried in order
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:MaxItems=2
+	// +kubebuilder:validation:XValidation:rule="self.all(x, self.exists_one(y, y == x))",message="vcekSources must not contain duplicates"
+	// +listType=atomic
+	// +optional
+	VcekSources []VcekSourceType `json:"vcekSources,omitempty"`
+}
+
+// AdminAuthorizationMode is the authorization of the KBS admin API requests
+// +kubebuilder:validation:Enum=DenyAll;InsecureAllowAll;Simple
+type AdminAuthorizationMode string
+
+const (
+	// AdminAuthorizationDenyAll rejects every admin API request
+	AdminAuthorizationDenyAll AdminAuthorizationMode = "DenyAll"
+
+	// AdminAuthorizationInsecureAllowAll accepts every admin API request without authentication, for tests only
+	AdminAuthorizationInsecureAllowAll AdminAuthorizationMode = "InsecureAllowAll"
+
+	// AdminAuthorizationSimple accepts the admin API requests signed with the private key of a persona
+	AdminAuthorizationSimple AdminAuthorizationMode = "Simple"
+)
+
+// AdminSpec configures the KBS admin API
+// +kubebuilder:validation:XValidation:rule="!has(self.personas) || (has(self.authorizationMode) && self.authorizationMode == 'Simple')",message="personas require the Simple authorization mode"
+type AdminSpec struct {
+	// AuthorizationMode is the authorization of the admin API requests.
+	// The profile provides the mode when not set, DenyAll for both profiles.
+	// +optional
+	AuthorizationMode AdminAuthorizationMode `json:"authorizationMode,omitempty"`
+
+	// Personas are the admin identities accepted in Simple mode. A single persona named admin,
+	// authenticated with the key pair generated by the operator, is configured when not set.
+	// +listType=map
+	// +listMapKey=id
+	// +optional
+	Personas []AdminPersona `json:"personas,omitempty"`
+}
+
+// AdminPersona is an admin identity authenticated with an Ed25519 public key
+type AdminPersona struct {
+	// ID identifies the persona in the KBS logs
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-]+$`
+	// +kubebuilder:validation:MaxLength=63
+	ID string `json:"id"`
+
+	// PublicKeySecretRef selects the PEM encoded public key of the persona in a Secret of the TrusteeConfig namespace.
+	// The persona is authenticated with the key pair generated by the operator when not set, the public key
+	// replaced by the last rotation being accepted during its grace period.
+	// +optional
+	PublicKeySecretRef *corev1.SecretKeySelector `json:"publicKeySecretRef,omitempty"`
+}
+
+// AttestationServiceType is the attestation service KBS verifies the evidence with
+// +kubebuilder:validation:Enum=Builtin;Remote;IntelTrustAuthority
+type AttestationServiceType string
+
+const (
+	// AttestationServiceBuiltin is the attestation service built into KBS
+	AttestationServiceBuiltin AttestationServiceType = "Builtin"
+
+	// AttestationServiceRemote is an attestation service reached over gRPC
+	AttestationServiceRemote AttestationServiceType = "Remote"
+
+	// AttestationServiceIntelTrustAuthority is the Intel Trust Authority
+	AttestationServiceIntelTrustAuthority AttestationServiceType = "IntelTrustAuthority"
+)
+
+// AttestationServiceSpec selects the attestation service of KBS, the operator picks the matching KBS image
+// +kubebuilder:validation:XValidation:rule="self.type != 'Remote' || has(self.remote)",message="remote is required with the Remote type"
+// +kubebuilder:validation:XValidation:rule="self.type != 'IntelTrustAuthority' || has(self.intelTrustAuthority)",message="intelTrustAuthority is required with the IntelTrustAuthority type"
+type AttestationServiceSpec struct {
+	// Type of the attestation service
+	// +kubebuilder:default=Builtin
+	// +optional
+	Type AttestationServiceType `json:"type,omitempty"`
+
+	// Remote configures the attestation service reached over gRPC
+	// +optional
+	Remote *RemoteAttestationServiceSpec `json:"remote,omitempty"`
+
+	// IntelTrustAuthority configures the Intel Trust Authority
+	// +optional
+	IntelTrustAuthority *IntelTrustAuthoritySpec `json:"intelTrustAuthority,omitempty"`
+}
+
+// RemoteAttestationServiceSpec configures an attestation service reached over gRPC
+type RemoteAttestationServiceSpec struct {
+	// Address is the URL of the gRPC attestation service, e.g. http://coco-as.trustee.svc:50004
+	// +kubebuilder:validation:Pattern=`^https?://[^\s"\\]+$`
+	Address string `json:"address"`
+}
+
+// IntelTrustAuthoritySpec configures the Intel Trust Authority
+type IntelTrustAuthoritySpec struct {
+	// BaseURL is the URL of the Intel Trust Authority API
+	// +kubebuilder:default="https://api.trustauthority.intel.com"
+	// +kubebuilder:validation:Pattern=`^https://[^\s"\\]+$`
+	// +optional
+	BaseURL string `json:"baseURL,omitempty"`
+
+	// CertsURL is the URL of the JWK set signing the Intel Trust Authority tokens
+	// +kubebuilder:default="https://portal.trustauthority.intel.com"
+	// +kubebuilder:validation:Pattern=`^https://[^\s"\\]+$`
+	// +optional
+	CertsURL string `json:"certsURL,omitempty"`
+
+	// APIKeySecretRef selects the Intel Trust Authority API key in a Secret of the TrusteeConfig namespace
+	APIKeySecretRef corev1.SecretKeySelector `json:"apiKeySecretRef"`
+}
+
 // TrusteeConfigSpec defines the desired state of TrusteeConfig
 type TrusteeConfigSpec struct {
 	// HttpsSpec is the struct that hosts the HTTPS configuration
@@ -311,10 +1171,88 @@ type TrusteeConfigSpec struct {
 	// +optional
 	KbsServiceType corev1.ServiceType `json:"kbsServiceType,omitempty"`
 
+	// KbsDeploymentType is the topology of the generated KBS deployment
+	// It can assume one of the following values:
+	//    AllInOneDeployment: KBS runs with the builtin attestation service and RVPS in a single container
+	//    MicroservicesDeployment: KBS, the gRPC attestation service and RVPS run in separate containers,
+	//    the operator generates the attestation service and RVPS configurations
+	// +kubebuilder:validation:Enum=AllInOneDeployment;MicroservicesDeployment
+	// Default value is AllInOneDeployment
+	// +optional
+	KbsDeploymentType DeploymentType `json:"kbsDeploymentType,omitempty"`
+
+	// MicroservicesSpec configures the attestation service and RVPS of the MicroservicesDeployment topology
+	// +optional
+	MicroservicesSpec *MicroservicesSpec `json:"microservicesSpec,omitempty"`
+
+	// KbsDeploymentSpec configures the replicas, the resources and the scheduling of the KBS pods.
+	// The fields set here take precedence over the edits of the generated KbsConfig
+	// +optional
+	KbsDeploymentSpec *KbsDeploymentSpec `json:"kbsDeploymentSpec,omitempty"`
+
+	// SharedStorage configures a storage backend shared by the KBS replicas
+	// +optional
+	SharedStorage *KbsSharedStorageSpec `json:"sharedStorage,omitempty"`
+
 	// TlsConfig defines TLS protocol and cipher configuration for KBS HTTPS server
 	// If not specified, defaults to "intermediate" profile (TLS 1.2+)
 	// +optional
 	TlsConfig *TlsConfig `json:"tlsConfig,omitempty"`
+
+	// AdminKeyRotation defines the rotation of the KBS admin Ed25519 key pair generated by the operator.
+	// The key pair can always be rotated on demand with the confidentialcontainers.org/rotate-admin-key annotation.
+	// +optional
+	AdminKeyRotation *AdminKeyRotationSpec `json:"adminKeyRotation,omitempty"`
+
+	// KbsSettings are settings rendered into the generated KBS configuration,
+	// the profile provides the value of the settings that are not set
+	// +optional
+	KbsSettings *KbsSettingsSpec `json:"kbsSettings,omitempty"`
+
+	// AdminSpec configures the KBS admin API
+	// +optional
+	AdminSpec *AdminSpec `json:"adminSpec,omitempty"`
+
+	// AttestationService selects the attestation service KBS verifies the evidence with,
+	// the attestation service built into KBS when not set
+	// +optional
+	AttestationService *AttestationServiceSpec `json:"attestationService,omitempty"`
+}
+
+// RotateAdminKeyAnnotation requests a rotation of the KBS admin key pair when set on a TrusteeConfig
+// to a value that has not been handled yet, e.g. a timestamp
+const RotateAdminKeyAnnotation = "confidentialcontainers.org/rotate-admin-key"
+
+// AdminKeyRotationSpec defines the rotation policy of the KBS admin key pair
+type AdminKeyRotationSpec struct {
+	// Interval rotates the admin key pair periodically.
+	// The key pair is only rotated on demand when not set.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// GracePeriod is how long the previous public key is kept next to the new one after a rotation
+	// +kubebuilder:default="24h"
+	// +optional
+	GracePeriod *metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// AdminKeyStatus reports the rotation of the KBS admin key pair
+type AdminKeyStatus struct {
+	// LastRotationTime is the time the current key pair was generated
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// NextRotationTime is the time the key pair will be rotated, when a rotation interval is set
+	// +optional
+	NextRotationTime *metav1.Time `json:"nextRotationTime,omitempty"`
+
+	// PreviousKeyExpirationTime is the time the previous public key is replaced by the current one
+	// +optional
+	PreviousKeyExpirationTime *metav1.Time `json:"previousKeyExpirationTime,omitempty"`
+
+	// LastRotationRequest is the last handled value of the confidentialcontainers.org/rotate-admin-key annotation
+	// +optional
+	LastRotationRequest string `json:"lastRotationRequest,omitempty"`
 }
 
 // TrusteeConfigStatus defines the observed state of TrusteeConfig
@@ -329,6 +1267,18 @@ type TrusteeConfigStatus struct {
 	// StatusDescription provides a human-readable description of the current status
 	// +optional
 	StatusDescription string `json:"statusDescription,omitempty"`
+
+	// HttpsCertificate reports the serving certificate, when self-signed or issued by cert-manager
+	// +optional
+	HttpsCertificate *CertificateStatus `json:"httpsCertificate,omitempty"`
+
+	// AttestationTokenCertificate reports the attestation token certificate, when issued by cert-manager
+	// +optional
+	AttestationTokenCertificate *CertificateStatus `json:"attestationTokenCertificate,omitempty"`
+
+	// AdminKey reports the rotation of the KBS admin key pair generated by the operator
+	// +optional
+	AdminKey *AdminKeyStatus `json:"adminKey,omitempty"`
 }
 
 //+kubebuilder:object:root=true
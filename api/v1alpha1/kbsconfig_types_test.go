@@ -0,0 +1,56 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import "testing"
+
+func TestKbsConfigSpecDeploymentType(t *testing.T) {
+	tests := []struct {
+		name string
+		spec KbsConfigSpec
+		want DeploymentType
+	}{
+		{
+			name: "unset defaults to all-in-one",
+			spec: KbsConfigSpec{},
+			want: DeploymentTypeAllInOne,
+		},
+		{
+			name: "unset with only the AS configuration defaults to all-in-one",
+			spec: KbsConfigSpec{KbsAsConfigMapName: "as-config"},
+			want: DeploymentTypeAllInOne,
+		},
+		{
+			name: "unset with the AS and RVPS configurations defaults to microservices",
+			spec: KbsConfigSpec{KbsAsConfigMapName: "as-config", KbsRvpsConfigMapName: "rvps-config"},
+			want: DeploymentTypeMicroservices,
+		},
+		{
+			name: "explicit type is kept",
+			spec: KbsConfigSpec{KbsDeploymentType: DeploymentTypeAllInOne, KbsAsConfigMapName: "as-config", KbsRvpsConfigMapName: "rvps-config"},
+			want: DeploymentTypeAllInOne,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.spec.DeploymentType(); got != tt.want {
+				t.Errorf("DeploymentType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
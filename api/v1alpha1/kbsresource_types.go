@@ -0,0 +1,133 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KbsResourceSpec defines the desired state of KbsResource
+type KbsResourceSpec struct {
+	// KbsConfigName is the name of the KbsConfig serving the resource.
+	// Every KbsConfig of the namespace serves the resource when not set.
+	// +optional
+	KbsConfigName string `json:"kbsConfigName,omitempty"`
+
+	// Repository is the first element of the resource path
+	// +kubebuilder:default=default
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Type is the second element of the resource path
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	Type string `json:"type"`
+
+	// Tag is the last element of the resource path
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`
+	Tag string `json:"tag"`
+
+	// Source is where the content of the resource comes from
+	Source KbsResourceSource `json:"source"`
+
+	// PolicySelector restricts the release of the resource to the attested workloads it matches,
+	// on top of the KBS resource policy
+	// +optional
+	PolicySelector *KbsResourcePolicySelector `json:"policySelector,omitempty"`
+}
+
+// KbsResourceSource is the source of the content of a KBS resource, exactly one field must be set
+// +kubebuilder:validation:XValidation:rule="[has(self.secretKeyRef), has(self.configMapKeyRef), has(self.inline)].filter(x, x).size() == 1",message="exactly one of secretKeyRef, configMapKeyRef or inline must be set"
+type KbsResourceSource struct {
+	// SecretKeyRef selects a key of a Secret in the namespace of the KbsResource
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// ConfigMapKeyRef selects a key of a ConfigMap in the namespace of the KbsResource
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+
+	// Inline is the content of the resource, for non-sensitive values
+	// +optional
+	Inline *string `json:"inline,omitempty"`
+}
+
+// KbsResourcePolicySelector selects the attested workloads allowed to fetch a resource
+type KbsResourcePolicySelector struct {
+	// Tees are the TEE types the attestation evidence must come from, e.g. snp, tdx or se
+	// +kubebuilder:validation:MinItems=1
+	Tees []string `json:"tees"`
+}
+
+const (
+	// KbsResourceConditionReady is True when the content of the resource is resolved
+	// and the resource is served by at least one KbsConfig
+	KbsResourceConditionReady = "Ready"
+)
+
+// KbsResourceStatus defines the observed state of KbsResource
+type KbsResourceStatus struct {
+	// ObservedGeneration is the most recent KbsResource generation observed by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// URI is the effective resource URI, kbs:///<repository>/<type>/<tag>
+	// +optional
+	URI string `json:"uri,omitempty"`
+
+	// KbsConfigs are the names of the KbsConfigs serving the resource
+	// +optional
+	KbsConfigs []string `json:"kbsConfigs,omitempty"`
+
+	// Conditions represent the latest available observations of the KbsResource state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="URI",type=string,JSONPath=`.status.uri`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// KbsResource is the Schema for the kbsresources API
+type KbsResource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KbsResourceSpec   `json:"spec,omitempty"`
+	Status KbsResourceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// KbsResourceList contains a list of KbsResource
+type KbsResourceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KbsResource `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KbsResource{}, &KbsResourceList{})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReferenceValueSpec defines the desired state of ReferenceValue
+type ReferenceValueSpec struct {
+	// KbsConfigName is the name of the KbsConfig serving the reference value.
+	// Every KbsConfig of the namespace serves the reference value when not set.
+	// +optional
+	KbsConfigName string `json:"kbsConfigName,omitempty"`
+
+	// Name is the reference value name queried by the attestation policies with query_reference_value,
+	// e.g. snp_launch_measurement. The name of the ReferenceValue is used when not set.
+	// The values of the ReferenceValues sharing a name are aggregated.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9_.-]+$`
+	// +kubebuilder:validation:MaxLength=253
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Values are the accepted values, strings, numbers or booleans
+	// +kubebuilder:validation:MinItems=1
+	Values []apiextensionsv1.JSON `json:"values"`
+
+	// Expiration is the time after which the values are no longer accepted
+	Expiration metav1.Time `json:"expiration"`
+
+	// Tee is the TEE type the values apply to, e.g. snp, tdx or se
+	// +optional
+	Tee string `json:"tee,omitempty"`
+
+	// Provenance describes where the values come from, e.g. a build pipeline or a vendor manifest
+	// +optional
+	Provenance string `json:"provenance,omitempty"`
+}
+
+const (
+	// ReferenceValueConditionReady is True when the values are valid, not expired
+	// and served by at least one KbsConfig
+	ReferenceValueConditionReady = "Ready"
+)
+
+// ReferenceValueStatus defines the observed state of ReferenceValue
+type ReferenceValueStatus struct {
+	// ObservedGeneration is the most recent ReferenceValue generation observed by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Name is the effective reference value name
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Expired is true once the expiration time is reached, the values are then no longer served
+	// +optional
+	Expired bool `json:"expired,omitempty"`
+
+	// KbsConfigs are the names of the KbsConfigs serving the values
+	// +optional
+	KbsConfigs []string `json:"kbsConfigs,omitempty"`
+
+	// Conditions represent the latest available observations of the ReferenceValue state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Reference Value",type=string,JSONPath=`.status.name`
+//+kubebuilder:printcolumn:name="Expiration",type=string,JSONPath=`.spec.expiration`
+//+kubebuilder:printcolumn:name="Expired",type=boolean,JSONPath=`.status.expired`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ReferenceValue is the Schema for the referencevalues API
+type ReferenceValue struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ReferenceValueSpec   `json:"spec,omitempty"`
+	Status ReferenceValueStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ReferenceValueList contains a list of ReferenceValue
+type ReferenceValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ReferenceValue `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ReferenceValue{}, &ReferenceValueList{})
+}
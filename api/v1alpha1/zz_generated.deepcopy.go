@@ -21,91 +21,1242 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	"k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminKeyRotationSpec) DeepCopyInto(out *AdminKeyRotationSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.GracePeriod != nil {
+		in, out := &in.GracePeriod, &out.GracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminKeyRotationSpec.
+func (in *AdminKeyRotationSpec) DeepCopy() *AdminKeyRotationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminKeyRotationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminKeyStatus) DeepCopyInto(out *AdminKeyStatus) {
+	*out = *in
+	if in.LastRotationTime != nil {
+		in, out := &in.LastRotationTime, &out.LastRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextRotationTime != nil {
+		in, out := &in.NextRotationTime, &out.NextRotationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.PreviousKeyExpirationTime != nil {
+		in, out := &in.PreviousKeyExpirationTime, &out.PreviousKeyExpirationTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminKeyStatus.
+func (in *AdminKeyStatus) DeepCopy() *AdminKeyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminKeyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminPersona) DeepCopyInto(out *AdminPersona) {
+	*out = *in
+	if in.PublicKeySecretRef != nil {
+		in, out := &in.PublicKeySecretRef, &out.PublicKeySecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminPersona.
+func (in *AdminPersona) DeepCopy() *AdminPersona {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminPersona)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdminSpec) DeepCopyInto(out *AdminSpec) {
+	*out = *in
+	if in.Personas != nil {
+		in, out := &in.Personas, &out.Personas
+		*out = make([]AdminPersona, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdminSpec.
+func (in *AdminSpec) DeepCopy() *AdminSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AdminSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationPolicy) DeepCopyInto(out *AttestationPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationPolicy.
+func (in *AttestationPolicy) DeepCopy() *AttestationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AttestationPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationPolicyList) DeepCopyInto(out *AttestationPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]AttestationPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationPolicyList.
+func (in *AttestationPolicyList) DeepCopy() *AttestationPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AttestationPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationPolicySpec) DeepCopyInto(out *AttestationPolicySpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationPolicySpec.
+func (in *AttestationPolicySpec) DeepCopy() *AttestationPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationPolicyStatus) DeepCopyInto(out *AttestationPolicyStatus) {
+	*out = *in
+	if in.KbsConfigs != nil {
+		in, out := &in.KbsConfigs, &out.KbsConfigs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationPolicyStatus.
+func (in *AttestationPolicyStatus) DeepCopy() *AttestationPolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationPolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AttestationServiceSpec) DeepCopyInto(out *AttestationServiceSpec) {
+	*out = *in
+	if in.Remote != nil {
+		in, out := &in.Remote, &out.Remote
+		*out = new(RemoteAttestationServiceSpec)
+		**out = **in
+	}
+	if in.IntelTrustAuthority != nil {
+		in, out := &in.IntelTrustAuthority, &out.IntelTrustAuthority
+		*out = new(IntelTrustAuthoritySpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationServiceSpec.
+func (in *AttestationServiceSpec) DeepCopy() *AttestationServiceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationServiceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AttestationTokenVerificationSpec) DeepCopyInto(out *AttestationTokenVerificationSpec) {
 	*out = *in
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerCertificateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationTokenVerificationSpec.
+func (in *AttestationTokenVerificationSpec) DeepCopy() *AttestationTokenVerificationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AttestationTokenVerificationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertManagerCertificateSpec) DeepCopyInto(out *CertManagerCertificateSpec) {
+	*out = *in
+	out.IssuerRef = in.IssuerRef
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertManagerCertificateSpec.
+func (in *CertManagerCertificateSpec) DeepCopy() *CertManagerCertificateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(CertManagerCertificateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateStatus) DeepCopyInto(out *CertificateStatus) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+	if in.RenewalTime != nil {
+		in, out := &in.RenewalTime, &out.RenewalTime
+		*out = (*in).DeepCopy()
+	}
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateStatus.
+func (in *CertificateStatus) DeepCopy() *CertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HttpsSpec) DeepCopyInto(out *HttpsSpec) {
+	*out = *in
+	if in.CertManager != nil {
+		in, out := &in.CertManager, &out.CertManager
+		*out = new(CertManagerCertificateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SelfSigned != nil {
+		in, out := &in.SelfSigned, &out.SelfSigned
+		*out = new(SelfSignedCertificateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HttpsSpec.
+func (in *HttpsSpec) DeepCopy() *HttpsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HttpsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IbmSEConfigSpec) DeepCopyInto(out *IbmSEConfigSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IbmSEConfigSpec.
+func (in *IbmSEConfigSpec) DeepCopy() *IbmSEConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IbmSEConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IbmSETeeConfig) DeepCopyInto(out *IbmSETeeConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IbmSETeeConfig.
+func (in *IbmSETeeConfig) DeepCopy() *IbmSETeeConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(IbmSETeeConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IntelTrustAuthoritySpec) DeepCopyInto(out *IntelTrustAuthoritySpec) {
+	*out = *in
+	in.APIKeySecretRef.DeepCopyInto(&out.APIKeySecretRef)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IntelTrustAuthoritySpec.
+func (in *IntelTrustAuthoritySpec) DeepCopy() *IntelTrustAuthoritySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IntelTrustAuthoritySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IssuerReference) DeepCopyInto(out *IssuerReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IssuerReference.
+func (in *IssuerReference) DeepCopy() *IssuerReference {
+	if in == nil {
+		return nil
+	}
+	out := new(IssuerReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsAutoscalingSpec) DeepCopyInto(out *KbsAutoscalingSpec) {
+	*out = *in
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetCPUUtilizationPercentage != nil {
+		in, out := &in.TargetCPUUtilizationPercentage, &out.TargetCPUUtilizationPercentage
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make([]v2.MetricSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Behavior != nil {
+		in, out := &in.Behavior, &out.Behavior
+		*out = new(v2.HorizontalPodAutoscalerBehavior)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsAutoscalingSpec.
+func (in *KbsAutoscalingSpec) DeepCopy() *KbsAutoscalingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsAutoscalingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsConfig) DeepCopyInto(out *KbsConfig) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfig.
+func (in *KbsConfig) DeepCopy() *KbsConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KbsConfig) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsConfigList) DeepCopyInto(out *KbsConfigList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KbsConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigList.
+func (in *KbsConfigList) DeepCopy() *KbsConfigList {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsConfigList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KbsConfigList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsConfigSpec) DeepCopyInto(out *KbsConfigSpec) {
+	*out = *in
+	if in.KbsItaApiKeySecretRef != nil {
+		in, out := &in.KbsItaApiKeySecretRef, &out.KbsItaApiKeySecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KbsMicroservicesSpec != nil {
+		in, out := &in.KbsMicroservicesSpec, &out.KbsMicroservicesSpec
+		*out = new(MicroservicesSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KbsSecretResources != nil {
+		in, out := &in.KbsSecretResources, &out.KbsSecretResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KbsSecretResourceEntries != nil {
+		in, out := &in.KbsSecretResourceEntries, &out.KbsSecretResourceEntries
+		*out = make([]KbsSecretResourceEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KbsVaultResources != nil {
+		in, out := &in.KbsVaultResources, &out.KbsVaultResources
+		*out = make([]KbsVaultResourceSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KbsRolloutExcludedSecrets != nil {
+		in, out := &in.KbsRolloutExcludedSecrets, &out.KbsRolloutExcludedSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.IbmSEConfigSpec = in.IbmSEConfigSpec
+	if in.KbsEnvVars != nil {
+		in, out := &in.KbsEnvVars, &out.KbsEnvVars
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.KbsLocalCertCacheSpec.DeepCopyInto(&out.KbsLocalCertCacheSpec)
+	in.KbsDeploymentSpec.DeepCopyInto(&out.KbsDeploymentSpec)
+	in.KbsStorageSpec.DeepCopyInto(&out.KbsStorageSpec)
+	if in.KbsExposureSpec != nil {
+		in, out := &in.KbsExposureSpec, &out.KbsExposureSpec
+		*out = new(KbsExposureSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KbsSharedStorageSpec != nil {
+		in, out := &in.KbsSharedStorageSpec, &out.KbsSharedStorageSpec
+		*out = new(KbsSharedStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigSpec.
+func (in *KbsConfigSpec) DeepCopy() *KbsConfigSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsConfigSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsConfigStatus) DeepCopyInto(out *KbsConfigStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigStatus.
+func (in *KbsConfigStatus) DeepCopy() *KbsConfigStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsConfigStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsDeploymentSpec) DeepCopyInto(out *KbsDeploymentSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	in.SecretConverterResources.DeepCopyInto(&out.SecretConverterResources)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PodDisruptionBudget != nil {
+		in, out := &in.PodDisruptionBudget, &out.PodDisruptionBudget
+		*out = new(KbsPodDisruptionBudgetSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Autoscaling != nil {
+		in, out := &in.Autoscaling, &out.Autoscaling
+		*out = new(KbsAutoscalingSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsDeploymentSpec.
+func (in *KbsDeploymentSpec) DeepCopy() *KbsDeploymentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsDeploymentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsExposureSpec) DeepCopyInto(out *KbsExposureSpec) {
+	*out = *in
+	if in.SessionAffinity != nil {
+		in, out := &in.SessionAffinity, &out.SessionAffinity
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(KbsIngressSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Route != nil {
+		in, out := &in.Route, &out.Route
+		*out = new(KbsRouteSpec)
+		**out = **in
+	}
+	if in.HTTPRoute != nil {
+		in, out := &in.HTTPRoute, &out.HTTPRoute
+		*out = new(KbsHTTPRouteSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsExposureSpec.
+func (in *KbsExposureSpec) DeepCopy() *KbsExposureSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsExposureSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsHTTPRouteSpec) DeepCopyInto(out *KbsHTTPRouteSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsHTTPRouteSpec.
+func (in *KbsHTTPRouteSpec) DeepCopy() *KbsHTTPRouteSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsHTTPRouteSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsIngressSpec) DeepCopyInto(out *KbsIngressSpec) {
+	*out = *in
+	if in.IngressClassName != nil {
+		in, out := &in.IngressClassName, &out.IngressClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsIngressSpec.
+func (in *KbsIngressSpec) DeepCopy() *KbsIngressSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsIngressSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsLocalCertCacheEntry) DeepCopyInto(out *KbsLocalCertCacheEntry) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsLocalCertCacheEntry.
+func (in *KbsLocalCertCacheEntry) DeepCopy() *KbsLocalCertCacheEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsLocalCertCacheEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsLocalCertCacheSpec) DeepCopyInto(out *KbsLocalCertCacheSpec) {
+	*out = *in
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]KbsLocalCertCacheEntry, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsLocalCertCacheSpec.
+func (in *KbsLocalCertCacheSpec) DeepCopy() *KbsLocalCertCacheSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsLocalCertCacheSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsPodDisruptionBudgetSpec) DeepCopyInto(out *KbsPodDisruptionBudgetSpec) {
+	*out = *in
+	if in.MinAvailable != nil {
+		in, out := &in.MinAvailable, &out.MinAvailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsPodDisruptionBudgetSpec.
+func (in *KbsPodDisruptionBudgetSpec) DeepCopy() *KbsPodDisruptionBudgetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsPodDisruptionBudgetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResource) DeepCopyInto(out *KbsResource) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResource.
+func (in *KbsResource) DeepCopy() *KbsResource {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KbsResource) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResourceList) DeepCopyInto(out *KbsResourceList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]KbsResource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResourceList.
+func (in *KbsResourceList) DeepCopy() *KbsResourceList {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResourceList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *KbsResourceList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResourcePolicySelector) DeepCopyInto(out *KbsResourcePolicySelector) {
+	*out = *in
+	if in.Tees != nil {
+		in, out := &in.Tees, &out.Tees
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResourcePolicySelector.
+func (in *KbsResourcePolicySelector) DeepCopy() *KbsResourcePolicySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResourcePolicySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResourceSource) DeepCopyInto(out *KbsResourceSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Inline != nil {
+		in, out := &in.Inline, &out.Inline
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResourceSource.
+func (in *KbsResourceSource) DeepCopy() *KbsResourceSource {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResourceSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResourceSpec) DeepCopyInto(out *KbsResourceSpec) {
+	*out = *in
+	in.Source.DeepCopyInto(&out.Source)
+	if in.PolicySelector != nil {
+		in, out := &in.PolicySelector, &out.PolicySelector
+		*out = new(KbsResourcePolicySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResourceSpec.
+func (in *KbsResourceSpec) DeepCopy() *KbsResourceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResourceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsResourceStatus) DeepCopyInto(out *KbsResourceStatus) {
+	*out = *in
+	if in.KbsConfigs != nil {
+		in, out := &in.KbsConfigs, &out.KbsConfigs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsResourceStatus.
+func (in *KbsResourceStatus) DeepCopy() *KbsResourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsResourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsRouteSpec) DeepCopyInto(out *KbsRouteSpec) {
+	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AttestationTokenVerificationSpec.
-func (in *AttestationTokenVerificationSpec) DeepCopy() *AttestationTokenVerificationSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsRouteSpec.
+func (in *KbsRouteSpec) DeepCopy() *KbsRouteSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(AttestationTokenVerificationSpec)
+	out := new(KbsRouteSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *HttpsSpec) DeepCopyInto(out *HttpsSpec) {
+func (in *KbsSecretResourceEntry) DeepCopyInto(out *KbsSecretResourceEntry) {
 	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]KbsSecretResourceKey, len(*in))
+		copy(*out, *in)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HttpsSpec.
-func (in *HttpsSpec) DeepCopy() *HttpsSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsSecretResourceEntry.
+func (in *KbsSecretResourceEntry) DeepCopy() *KbsSecretResourceEntry {
 	if in == nil {
 		return nil
 	}
-	out := new(HttpsSpec)
+	out := new(KbsSecretResourceEntry)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IbmSEConfigSpec) DeepCopyInto(out *IbmSEConfigSpec) {
+func (in *KbsSecretResourceKey) DeepCopyInto(out *KbsSecretResourceKey) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IbmSEConfigSpec.
-func (in *IbmSEConfigSpec) DeepCopy() *IbmSEConfigSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsSecretResourceKey.
+func (in *KbsSecretResourceKey) DeepCopy() *KbsSecretResourceKey {
 	if in == nil {
 		return nil
 	}
-	out := new(IbmSEConfigSpec)
+	out := new(KbsSecretResourceKey)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *IbmSETeeConfig) DeepCopyInto(out *IbmSETeeConfig) {
+func (in *KbsSettingsSpec) DeepCopyInto(out *KbsSettingsSpec) {
+	*out = *in
+	if in.WorkerCount != nil {
+		in, out := &in.WorkerCount, &out.WorkerCount
+		*out = new(int32)
+		**out = **in
+	}
+	if in.AttestationTokenDuration != nil {
+		in, out := &in.AttestationTokenDuration, &out.AttestationTokenDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.VcekSources != nil {
+		in, out := &in.VcekSources, &out.VcekSources
+		*out = make([]VcekSourceType, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsSettingsSpec.
+func (in *KbsSettingsSpec) DeepCopy() *KbsSettingsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsSettingsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsSharedStorageSpec) DeepCopyInto(out *KbsSharedStorageSpec) {
 	*out = *in
+	if in.Postgres != nil {
+		in, out := &in.Postgres, &out.Postgres
+		*out = new(PostgresEndpointSpec)
+		**out = **in
+	}
+	if in.ManagedPostgres != nil {
+		in, out := &in.ManagedPostgres, &out.ManagedPostgres
+		*out = new(ManagedPostgresSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IbmSETeeConfig.
-func (in *IbmSETeeConfig) DeepCopy() *IbmSETeeConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsSharedStorageSpec.
+func (in *KbsSharedStorageSpec) DeepCopy() *KbsSharedStorageSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(IbmSETeeConfig)
+	out := new(KbsSharedStorageSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsConfig) DeepCopyInto(out *KbsConfig) {
+func (in *KbsStorageSpec) DeepCopyInto(out *KbsStorageSpec) {
+	*out = *in
+	if in.BaseStorageDir != nil {
+		in, out := &in.BaseStorageDir, &out.BaseStorageDir
+		*out = new(KbsStorageVolumeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RepositoryDir != nil {
+		in, out := &in.RepositoryDir, &out.RepositoryDir
+		*out = new(KbsStorageVolumeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RvpsDir != nil {
+		in, out := &in.RvpsDir, &out.RvpsDir
+		*out = new(KbsStorageVolumeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AttestationPolicyDir != nil {
+		in, out := &in.AttestationPolicyDir, &out.AttestationPolicyDir
+		*out = new(KbsStorageVolumeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsStorageSpec.
+func (in *KbsStorageSpec) DeepCopy() *KbsStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsStorageVolumeSpec) DeepCopyInto(out *KbsStorageVolumeSpec) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsStorageVolumeSpec.
+func (in *KbsStorageVolumeSpec) DeepCopy() *KbsStorageVolumeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsStorageVolumeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KbsVaultResourceSource) DeepCopyInto(out *KbsVaultResourceSource) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]KbsSecretResourceKey, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsVaultResourceSource.
+func (in *KbsVaultResourceSource) DeepCopy() *KbsVaultResourceSource {
+	if in == nil {
+		return nil
+	}
+	out := new(KbsVaultResourceSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedPostgresSpec) DeepCopyInto(out *ManagedPostgresSpec) {
+	*out = *in
+	if in.Storage != nil {
+		in, out := &in.Storage, &out.Storage
+		*out = new(KbsStorageVolumeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedPostgresSpec.
+func (in *ManagedPostgresSpec) DeepCopy() *ManagedPostgresSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedPostgresSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MicroserviceDeploymentSpec) DeepCopyInto(out *MicroserviceDeploymentSpec) {
+	*out = *in
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]corev1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Affinity != nil {
+		in, out := &in.Affinity, &out.Affinity
+		*out = new(corev1.Affinity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]corev1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MicroserviceDeploymentSpec.
+func (in *MicroserviceDeploymentSpec) DeepCopy() *MicroserviceDeploymentSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MicroserviceDeploymentSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MicroservicesSpec) DeepCopyInto(out *MicroservicesSpec) {
+	*out = *in
+	in.AsDeploymentSpec.DeepCopyInto(&out.AsDeploymentSpec)
+	in.RvpsDeploymentSpec.DeepCopyInto(&out.RvpsDeploymentSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MicroservicesSpec.
+func (in *MicroservicesSpec) DeepCopy() *MicroservicesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MicroservicesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PostgresEndpointSpec) DeepCopyInto(out *PostgresEndpointSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PostgresEndpointSpec.
+func (in *PostgresEndpointSpec) DeepCopy() *PostgresEndpointSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PostgresEndpointSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReferenceValue) DeepCopyInto(out *ReferenceValue) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfig.
-func (in *KbsConfig) DeepCopy() *KbsConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReferenceValue.
+func (in *ReferenceValue) DeepCopy() *ReferenceValue {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsConfig)
+	out := new(ReferenceValue)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *KbsConfig) DeepCopyObject() runtime.Object {
+func (in *ReferenceValue) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -113,31 +1264,31 @@ func (in *KbsConfig) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsConfigList) DeepCopyInto(out *KbsConfigList) {
+func (in *ReferenceValueList) DeepCopyInto(out *ReferenceValueList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]KbsConfig, len(*in))
+		*out = make([]ReferenceValue, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigList.
-func (in *KbsConfigList) DeepCopy() *KbsConfigList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReferenceValueList.
+func (in *ReferenceValueList) DeepCopy() *ReferenceValueList {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsConfigList)
+	out := new(ReferenceValueList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *KbsConfigList) DeepCopyObject() runtime.Object {
+func (in *ReferenceValueList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -145,101 +1296,96 @@ func (in *KbsConfigList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsConfigSpec) DeepCopyInto(out *KbsConfigSpec) {
+func (in *ReferenceValueSpec) DeepCopyInto(out *ReferenceValueSpec) {
 	*out = *in
-	if in.KbsSecretResources != nil {
-		in, out := &in.KbsSecretResources, &out.KbsSecretResources
-		*out = make([]string, len(*in))
-		copy(*out, *in)
-	}
-	out.IbmSEConfigSpec = in.IbmSEConfigSpec
-	if in.KbsEnvVars != nil {
-		in, out := &in.KbsEnvVars, &out.KbsEnvVars
-		*out = make(map[string]string, len(*in))
-		for key, val := range *in {
-			(*out)[key] = val
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]apiextensionsv1.JSON, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
-	in.KbsLocalCertCacheSpec.DeepCopyInto(&out.KbsLocalCertCacheSpec)
-	in.KbsDeploymentSpec.DeepCopyInto(&out.KbsDeploymentSpec)
+	in.Expiration.DeepCopyInto(&out.Expiration)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigSpec.
-func (in *KbsConfigSpec) DeepCopy() *KbsConfigSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReferenceValueSpec.
+func (in *ReferenceValueSpec) DeepCopy() *ReferenceValueSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsConfigSpec)
+	out := new(ReferenceValueSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsConfigStatus) DeepCopyInto(out *KbsConfigStatus) {
+func (in *ReferenceValueStatus) DeepCopyInto(out *ReferenceValueStatus) {
 	*out = *in
-}
-
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsConfigStatus.
-func (in *KbsConfigStatus) DeepCopy() *KbsConfigStatus {
-	if in == nil {
-		return nil
+	if in.KbsConfigs != nil {
+		in, out := &in.KbsConfigs, &out.KbsConfigs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
 	}
-	out := new(KbsConfigStatus)
-	in.DeepCopyInto(out)
-	return out
-}
-
-// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsDeploymentSpec) DeepCopyInto(out *KbsDeploymentSpec) {
-	*out = *in
-	if in.Replicas != nil {
-		in, out := &in.Replicas, &out.Replicas
-		*out = new(int32)
-		**out = **in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsDeploymentSpec.
-func (in *KbsDeploymentSpec) DeepCopy() *KbsDeploymentSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReferenceValueStatus.
+func (in *ReferenceValueStatus) DeepCopy() *ReferenceValueStatus {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsDeploymentSpec)
+	out := new(ReferenceValueStatus)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsLocalCertCacheEntry) DeepCopyInto(out *KbsLocalCertCacheEntry) {
+func (in *RemoteAttestationServiceSpec) DeepCopyInto(out *RemoteAttestationServiceSpec) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsLocalCertCacheEntry.
-func (in *KbsLocalCertCacheEntry) DeepCopy() *KbsLocalCertCacheEntry {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemoteAttestationServiceSpec.
+func (in *RemoteAttestationServiceSpec) DeepCopy() *RemoteAttestationServiceSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsLocalCertCacheEntry)
+	out := new(RemoteAttestationServiceSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *KbsLocalCertCacheSpec) DeepCopyInto(out *KbsLocalCertCacheSpec) {
+func (in *SelfSignedCertificateSpec) DeepCopyInto(out *SelfSignedCertificateSpec) {
 	*out = *in
-	if in.Secrets != nil {
-		in, out := &in.Secrets, &out.Secrets
-		*out = make([]KbsLocalCertCacheEntry, len(*in))
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.DNSNames != nil {
+		in, out := &in.DNSNames, &out.DNSNames
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KbsLocalCertCacheSpec.
-func (in *KbsLocalCertCacheSpec) DeepCopy() *KbsLocalCertCacheSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelfSignedCertificateSpec.
+func (in *SelfSignedCertificateSpec) DeepCopy() *SelfSignedCertificateSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(KbsLocalCertCacheSpec)
+	out := new(SelfSignedCertificateSpec)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -331,18 +1477,53 @@ func (in *TrusteeConfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TrusteeConfigSpec) DeepCopyInto(out *TrusteeConfigSpec) {
 	*out = *in
-	out.HttpsSpec = in.HttpsSpec
-	out.AttestationTokenVerificationSpec = in.AttestationTokenVerificationSpec
+	in.HttpsSpec.DeepCopyInto(&out.HttpsSpec)
+	in.AttestationTokenVerificationSpec.DeepCopyInto(&out.AttestationTokenVerificationSpec)
 	if in.IbmSE != nil {
 		in, out := &in.IbmSE, &out.IbmSE
 		*out = new(IbmSETeeConfig)
 		**out = **in
 	}
+	if in.MicroservicesSpec != nil {
+		in, out := &in.MicroservicesSpec, &out.MicroservicesSpec
+		*out = new(MicroservicesSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KbsDeploymentSpec != nil {
+		in, out := &in.KbsDeploymentSpec, &out.KbsDeploymentSpec
+		*out = new(KbsDeploymentSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SharedStorage != nil {
+		in, out := &in.SharedStorage, &out.SharedStorage
+		*out = new(KbsSharedStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.TlsConfig != nil {
 		in, out := &in.TlsConfig, &out.TlsConfig
 		*out = new(TlsConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AdminKeyRotation != nil {
+		in, out := &in.AdminKeyRotation, &out.AdminKeyRotation
+		*out = new(AdminKeyRotationSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KbsSettings != nil {
+		in, out := &in.KbsSettings, &out.KbsSettings
+		*out = new(KbsSettingsSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdminSpec != nil {
+		in, out := &in.AdminSpec, &out.AdminSpec
+		*out = new(AdminSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AttestationService != nil {
+		in, out := &in.AttestationService, &out.AttestationService
+		*out = new(AttestationServiceSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrusteeConfigSpec.
@@ -360,9 +1541,24 @@ func (in *TrusteeConfigStatus) DeepCopyInto(out *TrusteeConfigStatus) {
 	*out = *in
 	if in.KbsConfigRef != nil {
 		in, out := &in.KbsConfigRef, &out.KbsConfigRef
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
+	if in.HttpsCertificate != nil {
+		in, out := &in.HttpsCertificate, &out.HttpsCertificate
+		*out = new(CertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AttestationTokenCertificate != nil {
+		in, out := &in.AttestationTokenCertificate, &out.AttestationTokenCertificate
+		*out = new(CertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AdminKey != nil {
+		in, out := &in.AdminKey, &out.AdminKey
+		*out = new(AdminKeyStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrusteeConfigStatus.
@@ -17,12 +17,20 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
 
 	controllers "github.com/confidential-containers/trustee-operator/internal/controller"
 )
@@ -30,59 +38,314 @@ import (
 var (
 	// Source directory where Kubernetes mounts the secrets
 	sourceDir = controllers.KbsSecretsMountPath
+	// Source directory where Kubernetes mounts the KbsResource contents as <repository>/<type>/<tag>
+	resourcesDir = controllers.KbsResourcesMountPath
+	// Source directory where Kubernetes mounts the secret gathering the resources synced by the sidecar
+	sidecarDir = controllers.SidecarResourcesMountPath
 	// Destination directory where KBS expects flat files
 	repoDir = controllers.RepositoryPath
+	// File whose content Kubernetes reports as the termination message of the container
+	terminationLogPath = "/dev/termination-log"
 )
 
 func main() {
-	log.Println("Converting secret directories to flat files...")
-
-	// Check if source directory exists
-	if _, err := os.Stat(sourceDir); os.IsNotExist(err) {
-		log.Printf("No secrets found in %s, skipping conversion", sourceDir)
+	var watch bool
+	var probeAddr string
+	var secretPaths string
+	var secretNames string
+	var missingSecrets string
+	var vaultSources string
+	var layout string
+	flag.BoolVar(&watch, "watch", false,
+		"Keep the repository in sync with the mounted secrets instead of converting them once.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", fmt.Sprintf(":%d", controllers.SecretConverterProbePort),
+		"The address the readiness endpoint binds to in watch mode.")
+	flag.StringVar(&secretPaths, controllers.SecretResourcePathsFlag, "",
+		"JSON map of the secret names to the repository, type and tags their keys are published under.")
+	flag.StringVar(&secretNames, controllers.SecretResourcesFlag, "",
+		"Comma separated secrets that must be mounted with at least one key.")
+	flag.StringVar(&missingSecrets, controllers.MissingSecretResourcesFlag, "",
+		"Comma separated secrets that did not exist when the pod was created, their optional volumes are empty.")
+	flag.StringVar(&vaultSources, controllers.VaultResourcesFlag, "",
+		"JSON list of the secrets of Vault-compatible KV secrets engines to publish.")
+	flag.StringVar(&layout, controllers.RepositoryLayoutFlag, controllers.RepositoryLayoutFlat,
+		"Layout of the repository, flat files for the kvstorage backend or <repository>/<type>/<tag> directories for the LocalFs plugin.")
+	flag.Parse()
+
+	if layout != controllers.RepositoryLayoutFlat && layout != controllers.RepositoryLayoutNested {
+		log.Fatalf("Invalid --%s %q", controllers.RepositoryLayoutFlag, layout)
+	}
+	c := newConverter(sourceDir, resourcesDir, repoDir)
+	c.sidecarDir = sidecarDir
+	c.layout = layout
+	if secretPaths != "" {
+		if err := json.Unmarshal([]byte(secretPaths), &c.secretPaths); err != nil {
+			log.Fatalf("Error parsing --%s: %v", controllers.SecretResourcePathsFlag, err)
+		}
+	}
+	if secretNames != "" {
+		c.secretNames = strings.Split(secretNames, ",")
+	}
+	if missingSecrets != "" {
+		c.missingSecrets = strings.Split(missingSecrets, ",")
+	}
+	if vaultSources != "" {
+		if err := json.Unmarshal([]byte(vaultSources), &c.vaultSources); err != nil {
+			log.Fatalf("Error parsing --%s: %v", controllers.VaultResourcesFlag, err)
+		}
+	}
+	if !watch {
+		log.Println("Converting secret directories to flat files...")
+		if err := c.sync(); err != nil {
+			exitWithError(err)
+		}
+		log.Printf("Secret conversion complete, %d resources in %s", len(c.written), repoDir)
 		return
-	} else if err != nil {
-		log.Fatalf("Error checking source directory: %v", err)
 	}
 
-	// Check if directory is empty
-	entries, err := os.ReadDir(sourceDir)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Printf("Watching %s and %s for changes", sourceDir, resourcesDir)
+	if err := c.watch(ctx, probeAddr); err != nil {
+		log.Fatalf("Error watching secrets: %v", err)
+	}
+}
+
+// convertedFile is a flat file of the repository together with the mounted file it is copied from
+type convertedFile struct {
+	flatName   string
+	sourcePath string
+	// secretName is the secret the file comes from, empty for the KbsResources and Vault sources
+	secretName string
+	// content is the content of the resources read from a Vault server, which have no source file
+	content []byte
+}
+
+// converter copies the mounted secrets and KbsResource contents to the flat files of the repository
+type converter struct {
+	sourceDir    string
+	resourcesDir string
+	repoDir      string
+	// sidecarDir holds the secret of the resources synced by the sidecar, see processSidecarResources
+	sidecarDir string
+	// layout is the layout the resources are written with, see repoFile
+	layout string
+
+	// secretPaths holds the custom paths of the secrets, the keys of the other ones are
+	// published under default/<secret>/<key>
+	secretPaths map[string]controllers.SecretResourcePath
+	// secretNames are the secrets that must be mounted with at least one key
+	secretNames []string
+	// missingSecrets are the secrets that did not exist when the pod was created
+	missingSecrets []string
+	// vaultSources are the secrets of Vault-compatible KV secrets engines, read through vaultClients
+	vaultSources []controllers.VaultResourceSource
+	vaultClients []*vaultClient
+
+	// written holds the flat files of the previous sync, the ones whose source is gone are removed.
+	// It is loaded from the manifest of the repository on the first sync
+	written map[string]bool
+	// writtenLayout is the layout of the previous sync, all its files are removed when it changed
+	writtenLayout string
+}
+
+func newConverter(sourceDir, resourcesDir, repoDir string) *converter {
+	return &converter{
+		sourceDir:    sourceDir,
+		resourcesDir: resourcesDir,
+		repoDir:      repoDir,
+		layout:       controllers.RepositoryLayoutFlat,
+	}
+}
+
+// repoFile returns the file of the repository holding the flat name resource in the given layout. The
+// flat layout keeps the escaped name as is, the nested one writes the <repository>/<type>/<tag> file
+// read by the LocalFs plugin. The name must be valid
+func (c *converter) repoFile(layout, name string) string {
+	if layout == controllers.RepositoryLayoutNested {
+		return filepath.Join(append([]string{c.repoDir}, strings.Split(name, flatNameSeparator)...)...)
+	}
+	return filepath.Join(c.repoDir, name)
+}
+
+// removeRepoFile removes the file of the resource and, in the nested layout, its type and repository
+// directories once they are empty
+func (c *converter) removeRepoFile(layout, name string) error {
+	path := c.repoFile(layout, name)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if layout != controllers.RepositoryLayoutNested {
+		return nil
+	}
+	for dir := filepath.Dir(path); dir != c.repoDir; dir = filepath.Dir(dir) {
+		// Removing a directory that still holds resources fails, which is expected
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// sync updates the flat files whose content changed, adds the new ones and removes the ones whose
+// source secret key or KbsResource is gone. It returns a *syncReport when a listed secret is missing or
+// empty, or a resource has an invalid path or more than one source
+func (c *converter) sync() error {
+	if c.written == nil {
+		written, layout, err := loadManifest(c.repoDir)
+		if err != nil {
+			return err
+		}
+		c.written, c.writtenLayout = written, layout
+	}
+
+	// KbsResources are converted first, they are independent of the secrets
+	files, err := processResourcesDir(c.resourcesDir)
 	if err != nil {
-		log.Fatalf("Error reading source directory: %v", err)
+		return fmt.Errorf("processing KbsResources: %w", err)
 	}
-	if len(entries) == 0 {
-		log.Printf("No secrets found in %s, skipping conversion", sourceDir)
-		return
+	secretFiles, err := processSourceDir(c.sourceDir, c.secretPaths)
+	if err != nil {
+		return err
+	}
+	files = append(files, secretFiles...)
+	report := c.checkSecrets(secretFiles)
+	sidecarFiles, err := processSidecarResources(c.sidecarDir, report)
+	if err != nil {
+		return fmt.Errorf("processing sidecar resources: %w", err)
 	}
+	files = append(files, sidecarFiles...)
+	vaultFiles, err := c.processVaultSources(report)
+	if err != nil {
+		return err
+	}
+	files = append(files, vaultFiles...)
 
-	// Process each secret directory
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	desired := make(map[string]bool, len(files))
+	var valid []convertedFile
+	for _, file := range files {
+		if !validFlatName(file.flatName) {
+			report.InvalidNames = append(report.InvalidNames, file.flatName)
+			continue
+		}
+		// Custom paths may point two sources at the same resource, the conflict fails the sync
+		if desired[file.flatName] {
+			report.Conflicts = append(report.Conflicts, file.flatName)
 			continue
 		}
+		desired[file.flatName] = true
+		valid = append(valid, file)
+	}
+	for _, file := range valid {
+		dst := c.repoFile(c.layout, file.flatName)
+		var changed bool
+		if file.content != nil {
+			changed, err = syncContent(file.content, dst)
+		} else {
+			changed, err = syncFile(file.sourcePath, dst)
+		}
+		if err != nil {
+			return fmt.Errorf("copying %s to %s: %w", file.sourcePath, file.flatName, err)
+		}
+		if changed {
+			log.Printf("  Converted %s -> %s", file.sourcePath, file.flatName)
+		}
+	}
 
-		secretName := entry.Name()
-		secretPath := filepath.Join(sourceDir, secretName)
-		log.Printf("Processing secret: %s", secretName)
+	for name := range c.written {
+		if desired[name] && c.writtenLayout == c.layout {
+			continue
+		}
+		log.Printf("  Removing %s", name)
+		if err := c.removeRepoFile(c.writtenLayout, name); err != nil {
+			return fmt.Errorf("removing %s: %w", name, err)
+		}
+	}
+	c.written, c.writtenLayout = desired, c.layout
+	if err := saveManifest(c.repoDir, c.layout, c.written); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+
+	if !report.empty() {
+		return report
+	}
+	return nil
+}
 
-		if err := processSecretDir(secretName, secretPath); err != nil {
-			log.Fatalf("Error processing secret %s: %v", secretName, err)
+// checkSecrets reports the listed secrets that are not mounted or have no key
+func (c *converter) checkSecrets(secretFiles []convertedFile) *syncReport {
+	report := &syncReport{}
+	keys := map[string]int{}
+	for _, file := range secretFiles {
+		keys[file.secretName]++
+	}
+	for _, secretName := range c.secretNames {
+		// The volumes of the missing secrets are optional, the kubelet mounts them empty
+		if _, err := os.Stat(filepath.Join(c.sourceDir, secretName)); errors.Is(err, os.ErrNotExist) ||
+			(keys[secretName] == 0 && slices.Contains(c.missingSecrets, secretName)) {
+			report.MissingSecrets = append(report.MissingSecrets, secretName)
+		} else if keys[secretName] == 0 {
+			report.EmptySecrets = append(report.EmptySecrets, secretName)
 		}
 	}
+	return report
+}
+
+// exitWithError exits with a non-zero code, printing the report of a failed sync as JSON to stderr and
+// to the termination message of the container, where kubectl shows it
+func exitWithError(err error) {
+	var report *syncReport
+	if !errors.As(err, &report) {
+		log.Fatalf("Error converting secrets: %v", err)
+	}
+	content := report.Error()
+	fmt.Fprintln(os.Stderr, content)
+	if err := os.WriteFile(terminationLogPath, []byte(content), 0644); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: could not write the termination message: %v", err)
+	}
+	log.Fatal("Error converting secrets, see the report above")
+}
 
-	log.Println("Secret conversion complete")
-	if err := listConvertedFiles(); err != nil {
-		log.Printf("Warning: could not list converted files: %v", err)
+// processSourceDir returns the flat files of all the secret directories
+func processSourceDir(dir string, secretPaths map[string]controllers.SecretResourcePath) ([]convertedFile, error) {
+	// Check if source directory exists
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Printf("No secrets found in %s, skipping conversion", dir)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("checking source directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading source directory: %w", err)
+	}
+
+	var files []convertedFile
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		secretName := entry.Name()
+		secretFiles, err := processSecretDir(secretName, filepath.Join(dir, secretName), secretPaths[secretName])
+		if err != nil {
+			return nil, fmt.Errorf("processing secret %s: %w", secretName, err)
+		}
+		files = append(files, secretFiles...)
 	}
+	return files, nil
 }
 
-// processSecretDir processes all files in a secret directory and converts them to flat files
-func processSecretDir(secretName, secretPath string) error {
+// processSecretDir returns the flat files of all the keys in a secret directory, published under
+// <repository>/<type>/<tag>: default/<secret>/<key> unless the path overrides them
+func processSecretDir(secretName, secretPath string, path controllers.SecretResourcePath) ([]convertedFile, error) {
 	entries, err := os.ReadDir(secretPath)
 	if err != nil {
-		return fmt.Errorf("reading secret directory: %w", err)
+		return nil, fmt.Errorf("reading secret directory: %w", err)
 	}
 
+	var files []convertedFile
 	for _, entry := range entries {
 		// Skip hidden files and directories
 		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
@@ -95,85 +358,198 @@ func processSecretDir(secretName, secretPath string) error {
 		// Follow symlinks to get the real file
 		realPath, err := filepath.EvalSymlinks(sourcePath)
 		if err != nil {
-			return fmt.Errorf("resolving symlink for %s: %w", sourcePath, err)
+			return nil, fmt.Errorf("resolving symlink for %s: %w", sourcePath, err)
 		}
 
 		// Check if it's a regular file
 		info, err := os.Stat(realPath)
 		if err != nil {
-			return fmt.Errorf("stat file %s: %w", realPath, err)
+			return nil, fmt.Errorf("stat file %s: %w", realPath, err)
 		}
 		if !info.Mode().IsRegular() {
 			continue
 		}
 
 		// Create flat file name with escaped slashes: default\x2Fsecret\x2Fkey
-		flatName := fmt.Sprintf("default\\x2F%s\\x2F%s", secretName, keyFile)
-		destPath := filepath.Join(repoDir, flatName)
+		repository, resourceType, tag := path.Resource(secretName, keyFile)
+		files = append(files, convertedFile{
+			flatName:   flatName(repository, resourceType, tag),
+			sourcePath: realPath,
+			secretName: secretName,
+		})
+	}
 
-		log.Printf("  Converting %s -> %s", keyFile, flatName)
+	return files, nil
+}
 
-		if err := copyFile(realPath, destPath); err != nil {
-			return fmt.Errorf("copying %s to %s: %w", realPath, destPath, err)
+// processSidecarResources returns the flat files of the secret gathering the resources synced by the
+// sidecar, described by its index, and adds the missing and empty secret resources to the report. The
+// directory is only mounted in sidecar mode
+func processSidecarResources(dir string, report *syncReport) ([]convertedFile, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(filepath.Join(dir, controllers.SidecarResourcesIndexKey))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	var index controllers.SidecarResourcesIndex
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("parsing index: %w", err)
+	}
+
+	var files []convertedFile
+	keys := map[string]int{}
+	for key, resource := range index.Resources {
+		// The keys come from the operator, they are checked anyway since they are joined to the directory
+		if !controllers.IsValidResourcePathElement(key) {
+			return nil, fmt.Errorf("invalid index key %q", key)
 		}
+		sourcePath := filepath.Join(dir, key)
+		if _, err := os.Stat(sourcePath); err != nil {
+			return nil, fmt.Errorf("stat file %s: %w", sourcePath, err)
+		}
+		files = append(files, convertedFile{
+			flatName:   flatName(resource.Repository, resource.Type, resource.Tag),
+			sourcePath: sourcePath,
+			secretName: resource.Secret,
+		})
+		keys[resource.Secret]++
 	}
+	// Map iteration is random, the conflicts are reported in a stable order
+	slices.SortFunc(files, func(a, b convertedFile) int { return strings.Compare(a.sourcePath, b.sourcePath) })
+
+	for secretName, found := range index.Secrets {
+		if !found {
+			report.MissingSecrets = append(report.MissingSecrets, secretName)
+		} else if keys[secretName] == 0 {
+			report.EmptySecrets = append(report.EmptySecrets, secretName)
+		}
+	}
+	slices.Sort(report.MissingSecrets)
+	slices.Sort(report.EmptySecrets)
+	return files, nil
+}
 
-	return nil
+// processResourcesDir returns the flat files of the <repository>/<type>/<tag> files of the KbsResources
+func processResourcesDir(dir string) ([]convertedFile, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Printf("No KbsResources found in %s, skipping conversion", dir)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("checking KbsResources directory: %w", err)
+	}
+
+	repositories, err := readVisibleDirs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []convertedFile
+	for _, repository := range repositories {
+		types, err := readVisibleDirs(filepath.Join(dir, repository))
+		if err != nil {
+			return nil, err
+		}
+		for _, resourceType := range types {
+			typePath := filepath.Join(dir, repository, resourceType)
+			entries, err := os.ReadDir(typePath)
+			if err != nil {
+				return nil, fmt.Errorf("reading KbsResources directory: %w", err)
+			}
+			for _, entry := range entries {
+				if strings.HasPrefix(entry.Name(), ".") {
+					continue
+				}
+				// os.Stat follows the symlinks of the projected volume
+				sourcePath := filepath.Join(typePath, entry.Name())
+				info, err := os.Stat(sourcePath)
+				if err != nil {
+					return nil, fmt.Errorf("stat file %s: %w", sourcePath, err)
+				}
+				if !info.Mode().IsRegular() {
+					continue
+				}
+
+				files = append(files, convertedFile{flatName: flatName(repository, resourceType, entry.Name()), sourcePath: sourcePath})
+			}
+		}
+	}
+	return files, nil
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) (err error) {
-	sourceFile, err := os.Open(src)
+// readVisibleDirs returns the names of the directories in dir, skipping the hidden
+// entries Kubernetes uses for atomic volume updates
+func readVisibleDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("opening source file: %w", err)
+		return nil, fmt.Errorf("reading KbsResources directory: %w", err)
 	}
-	defer func() {
-		if cerr := sourceFile.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("closing source file: %w", cerr)
+	var dirs []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
 		}
-	}()
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("stat %s: %w", entry.Name(), err)
+		}
+		if info.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// syncFile copies src to dst unless dst already has the same content, and reports whether dst changed
+func syncFile(src, dst string) (bool, error) {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return false, fmt.Errorf("reading source file: %w", err)
+	}
+	return syncContent(content, dst)
+}
 
+// syncContent writes content to dst unless dst already has it, and reports whether dst changed
+func syncContent(content []byte, dst string) (bool, error) {
+	if current, err := os.ReadFile(dst); err == nil && bytes.Equal(current, content) {
+		return false, nil
+	}
+	return true, writeFileAtomic(dst, bytes.NewReader(content))
+}
+
+// writeFileAtomic writes the content to a temporary file renamed to dst, so that KBS never
+// reads a partially written file
+func writeFileAtomic(dst string, content io.Reader) (err error) {
 	// Ensure destination directory exists
 	destDir := filepath.Dir(dst)
 	if err := os.MkdirAll(destDir, 0755); err != nil {
 		return fmt.Errorf("creating destination directory: %w", err)
 	}
 
-	destFile, err := os.Create(dst)
+	tmpFile, err := os.CreateTemp(destDir, ".tmp-")
 	if err != nil {
-		return fmt.Errorf("creating destination file: %w", err)
+		return fmt.Errorf("creating temporary file: %w", err)
 	}
 	defer func() {
-		if cerr := destFile.Close(); cerr != nil && err == nil {
-			err = fmt.Errorf("closing destination file: %w", cerr)
+		if err != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
 		}
 	}()
 
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
+	if _, err := io.Copy(tmpFile, content); err != nil {
 		return fmt.Errorf("copying file content: %w", err)
 	}
-
-	return destFile.Sync()
-}
-
-// listConvertedFiles lists all converted files in the repository directory
-func listConvertedFiles() error {
-	entries, err := os.ReadDir(repoDir)
-	if err != nil {
-		return err
+	if err := tmpFile.Chmod(0644); err != nil {
+		return fmt.Errorf("setting file mode: %w", err)
 	}
-
-	converted := false
-	for _, entry := range entries {
-		if strings.Contains(entry.Name(), "\\x2F") {
-			converted = true
-			break
-		}
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("syncing file content: %w", err)
 	}
-
-	if !converted {
-		log.Println("No converted files found (this might be an issue)")
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("closing temporary file: %w", err)
 	}
-
-	return nil
+	return os.Rename(tmpFile.Name(), dst)
 }
@@ -0,0 +1,426 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	controllers "github.com/confidential-containers/trustee-operator/internal/controller"
+)
+
+// projectSecret lays out a secret directory the way the kubelet does: the keys are symlinks to
+// ..data/<key>, and ..data is a symlink to a timestamped directory swapped on every update
+func projectSecret(t *testing.T, dir string, data map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tsDir, err := os.MkdirTemp(dir, "..ts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range data {
+		if err := os.WriteFile(filepath.Join(tsDir, key), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tmpLink := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(tsDir), tmpLink); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpLink, filepath.Join(dir, projectedDataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name[0] == '.' {
+			continue
+		}
+		if _, ok := data[name]; !ok {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for key := range data {
+		link := filepath.Join(dir, key)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(projectedDataDir, key), link); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readRepoFile(t *testing.T, repo, flatName string) (string, bool) {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(repo, flatName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return string(content), true
+}
+
+func TestConverterSync(t *testing.T) {
+	root := t.TempDir()
+	secrets, resources, repo := filepath.Join(root, "secrets"), filepath.Join(root, "resources"), filepath.Join(root, "repo")
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "value1", "key2": "value2"})
+	projectSecret(t, resources, map[string]string{})
+	if err := os.MkdirAll(filepath.Join(resources, "..data", "my-repo", "keys"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(resources, "..data", "my-repo", "keys", "image-key"), []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(projectedDataDir, "my-repo"), filepath.Join(resources, "my-repo")); err != nil {
+		t.Fatal(err)
+	}
+	// A resource registered through the KBS admin API
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, `default\x2Fadmin\x2Fkey`), []byte("admin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newConverter(secrets, resources, repo)
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	for flatName, want := range map[string]string{
+		`default\x2Fkbsres1\x2Fkey1`:   "value1",
+		`default\x2Fkbsres1\x2Fkey2`:   "value2",
+		`my-repo\x2Fkeys\x2Fimage-key`: "image",
+	} {
+		if got, _ := readRepoFile(t, repo, flatName); got != want {
+			t.Errorf("expected %s to hold %q, got %q", flatName, want, got)
+		}
+	}
+
+	// The kubelet swaps ..data: key1 is updated, key2 is removed and key3 is added
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "rotated", "key3": "value3"})
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey1`); got != "rotated" {
+		t.Errorf("expected key1 to be updated, got %q", got)
+	}
+	if got, _ := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey3`); got != "value3" {
+		t.Errorf("expected key3 to be added, got %q", got)
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey2`); ok {
+		t.Error("expected key2 to be removed")
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fadmin\x2Fkey`); !ok {
+		t.Error("expected the files not written by the converter to be kept")
+	}
+	entries, err := os.ReadDir(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The 4 resources and the manifest
+	if len(entries) != 5 {
+		t.Errorf("expected no temporary file to be left, got %v", entries)
+	}
+}
+
+func TestConverterManifest(t *testing.T) {
+	root := t.TempDir()
+	secrets, repo := filepath.Join(root, "secrets"), filepath.Join(root, "repo")
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "value1"})
+	projectSecret(t, filepath.Join(secrets, "kbsres2"), map[string]string{"key1": "value1"})
+	if err := newConverter(secrets, filepath.Join(root, "resources"), repo).sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next pod finds the files of the previous one on the persistent repository, kbsres2 was removed
+	if err := os.RemoveAll(filepath.Join(secrets, "kbsres2")); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(root, "outside")
+	if err := os.WriteFile(outside, []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	written, layout, err := loadManifest(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written[`..\x2F..\x2Foutside`] = true
+	if err := saveManifest(repo, layout, written); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := newConverter(secrets, filepath.Join(root, "resources"), repo).sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fkbsres2\x2Fkey1`); ok {
+		t.Error("expected the stale resource of the manifest to be removed")
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey1`); !ok {
+		t.Error("expected the current resource to be kept")
+	}
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("expected the invalid manifest entry to be ignored, got %v", err)
+	}
+}
+
+func TestConverterNestedLayout(t *testing.T) {
+	root := t.TempDir()
+	secrets, repo := filepath.Join(root, "secrets"), filepath.Join(root, "repo")
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "value1"})
+	projectSecret(t, filepath.Join(secrets, "kbsres2"), map[string]string{"key1": "value1"})
+
+	// The first pod ran without shared storage and wrote flat files
+	if err := newConverter(secrets, filepath.Join(root, "resources"), repo).sync(); err != nil {
+		t.Fatal(err)
+	}
+	c := newConverter(secrets, filepath.Join(root, "resources"), repo)
+	c.layout = controllers.RepositoryLayoutNested
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"default/kbsres1/key1", "default/kbsres2/key1"} {
+		if got, _ := readRepoFile(t, repo, path); got != "value1" {
+			t.Errorf("expected %s to hold %q, got %q", path, "value1", got)
+		}
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey1`); ok {
+		t.Error("expected the flat files of the previous layout to be removed")
+	}
+
+	if err := os.RemoveAll(filepath.Join(secrets, "kbsres2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "default", "kbsres2")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the empty type directory to be removed, got %v", err)
+	}
+	if _, ok := readRepoFile(t, repo, "default/kbsres1/key1"); !ok {
+		t.Error("expected the current resource to be kept")
+	}
+}
+
+func TestConverterSidecarResources(t *testing.T) {
+	root := t.TempDir()
+	sidecar, repo := filepath.Join(root, "sidecar"), filepath.Join(root, "repo")
+	projectSidecarResources := func(index controllers.SidecarResourcesIndex, data map[string]string) {
+		t.Helper()
+		content, err := json.Marshal(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[controllers.SidecarResourcesIndexKey] = string(content)
+		projectSecret(t, sidecar, data)
+	}
+	projectSidecarResources(controllers.SidecarResourcesIndex{
+		Secrets: map[string]bool{"keys": true, "absent": false},
+		Resources: map[string]controllers.SidecarResource{
+			"resource-0": {Repository: "default", Type: "keys", Tag: "key1", Secret: "keys"},
+			"resource-1": {Repository: "my-app", Type: "sample", Tag: "greeting"},
+		},
+	}, map[string]string{"resource-0": "value1", "resource-1": "hello"})
+
+	c := newConverter(filepath.Join(root, "secrets"), filepath.Join(root, "resources"), repo)
+	c.sidecarDir = sidecar
+	var report *syncReport
+	if err := c.sync(); !errors.As(err, &report) || !reflect.DeepEqual(report.MissingSecrets, []string{"absent"}) {
+		t.Fatalf("expected the missing secret to be reported, got %v", err)
+	}
+	for flatName, want := range map[string]string{
+		`default\x2Fkeys\x2Fkey1`:       "value1",
+		`my-app\x2Fsample\x2Fgreeting`: "hello",
+	} {
+		if got, _ := readRepoFile(t, repo, flatName); got != want {
+			t.Errorf("expected %s to hold %q, got %q", flatName, want, got)
+		}
+	}
+
+	// A secret resource is added and the missing one removed: the operator updates the mounted secret
+	projectSidecarResources(controllers.SidecarResourcesIndex{
+		Secrets: map[string]bool{"keys": true, "certs": true},
+		Resources: map[string]controllers.SidecarResource{
+			"resource-0": {Repository: "default", Type: "keys", Tag: "key1", Secret: "keys"},
+			"resource-1": {Repository: "default", Type: "certs", Tag: "tls.crt", Secret: "certs"},
+		},
+	}, map[string]string{"resource-0": "value1", "resource-1": "cert"})
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readRepoFile(t, repo, `default\x2Fcerts\x2Ftls.crt`); got != "cert" {
+		t.Errorf("expected the added secret resource, got %q", got)
+	}
+	if _, ok := readRepoFile(t, repo, `my-app\x2Fsample\x2Fgreeting`); ok {
+		t.Error("expected the removed KbsResource to be deleted")
+	}
+}
+
+func TestConverterSyncReport(t *testing.T) {
+	root := t.TempDir()
+	secrets, repo := filepath.Join(root, "secrets"), filepath.Join(root, "repo")
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "value1"})
+	projectSecret(t, filepath.Join(secrets, "empty"), map[string]string{})
+	projectSecret(t, filepath.Join(secrets, "traversal"), map[string]string{"key1": "value1"})
+	// The optional volume of a secret that did not exist is mounted empty
+	projectSecret(t, filepath.Join(secrets, "optional"), map[string]string{})
+
+	c := newConverter(secrets, filepath.Join(root, "resources"), repo)
+	c.secretNames = []string{"kbsres1", "empty", "missing", "optional", "traversal"}
+	c.missingSecrets = []string{"optional"}
+	c.secretPaths = map[string]controllers.SecretResourcePath{"traversal": {Repository: ".."}}
+	err := c.sync()
+	var report *syncReport
+	if !errors.As(err, &report) {
+		t.Fatalf("expected a sync report, got %v", err)
+	}
+	want := &syncReport{
+		MissingSecrets: []string{"missing", "optional"},
+		EmptySecrets:   []string{"empty"},
+		InvalidNames:   []string{`..\x2Ftraversal\x2Fkey1`},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("expected %v, got %v", want, report)
+	}
+
+	// The valid resources are synced nonetheless
+	if _, ok := readRepoFile(t, repo, `default\x2Fkbsres1\x2Fkey1`); !ok {
+		t.Error("expected the valid resource to be written")
+	}
+	if _, err := os.Stat(filepath.Join(root, "traversal")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing to be written outside of the repository, got %v", err)
+	}
+}
+
+func TestConverterSyncCustomPaths(t *testing.T) {
+	root := t.TempDir()
+	secrets, repo := filepath.Join(root, "secrets"), filepath.Join(root, "repo")
+	projectSecret(t, filepath.Join(secrets, "my-app-keys"), map[string]string{"workload.key": "workload", "backup.key": "backup"})
+	projectSecret(t, filepath.Join(secrets, "kbsres1"), map[string]string{"key1": "value1"})
+
+	c := newConverter(secrets, filepath.Join(root, "resources"), repo)
+	c.secretPaths = map[string]controllers.SecretResourcePath{
+		"my-app-keys": {Repository: "my-app", Type: "keys", Tags: map[string]string{"workload.key": "workload"}},
+	}
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	for flatName, want := range map[string]string{
+		`my-app\x2Fkeys\x2Fworkload`:   "workload",
+		`my-app\x2Fkeys\x2Fbackup.key`: "backup",
+		`default\x2Fkbsres1\x2Fkey1`:   "value1",
+	} {
+		if got, _ := readRepoFile(t, repo, flatName); got != want {
+			t.Errorf("expected %s to hold %q, got %q", flatName, want, got)
+		}
+	}
+	if _, ok := readRepoFile(t, repo, `default\x2Fmy-app-keys\x2Fworkload.key`); ok {
+		t.Error("expected the keys with a custom path not to be published under the default path")
+	}
+
+	// Two keys published on the same path are rejected
+	c.secretPaths["kbsres1"] = controllers.SecretResourcePath{Repository: "my-app", Type: "keys", Tags: map[string]string{"key1": "workload"}}
+	if err := c.sync(); err == nil {
+		t.Error("expected the sync to fail on a path conflict")
+	}
+}
+
+func TestSyncStatusHandler(t *testing.T) {
+	status := &syncStatus{}
+	handler := status.handler()
+	readyz := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return recorder.Code
+	}
+
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready before the first sync, got %d", code)
+	}
+	status.set(fmt.Errorf("secret directory unreadable"))
+	if code := readyz(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready after a failed sync, got %d", code)
+	}
+	status.set(nil)
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("expected ready after a successful sync, got %d", code)
+	}
+
+	// A missing secret resource is reported without failing the readiness of the pod
+	status.set(&syncReport{MissingSecrets: []string{"kbsres1"}})
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("expected ready after a sync with a report, got %d", code)
+	}
+}
+
+func TestConverterWatch(t *testing.T) {
+	root := t.TempDir()
+	secrets, repo := filepath.Join(root, "secrets"), filepath.Join(root, "repo")
+	secretDir := filepath.Join(secrets, "kbsres1")
+	projectSecret(t, secretDir, map[string]string{"key1": "value1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- newConverter(secrets, filepath.Join(root, "resources"), repo).watch(ctx, "127.0.0.1:0")
+	}()
+
+	waitFor := func(flatName, want string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if got, _ := readRepoFile(t, repo, flatName); got == want {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s to hold %q", flatName, want)
+	}
+	waitFor(`default\x2Fkbsres1\x2Fkey1`, "value1")
+
+	projectSecret(t, secretDir, map[string]string{"key1": "rotated", "key2": "value2"})
+	waitFor(`default\x2Fkbsres1\x2Fkey1`, "rotated")
+	waitFor(`default\x2Fkbsres1\x2Fkey2`, "value2")
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the watch to stop with the context, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the watch to stop")
+	}
+}
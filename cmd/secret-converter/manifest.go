@@ -0,0 +1,141 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	controllers "github.com/confidential-containers/trustee-operator/internal/controller"
+)
+
+const (
+	// manifestName is the file of the repository listing the flat files written by the converter. It is
+	// hidden, as the temporary files, so that it is never served as a resource
+	manifestName = ".secret-converter-manifest.json"
+
+	// flatNameSeparator is the escaped slash between the elements of a flat file name
+	flatNameSeparator = `\x2F`
+)
+
+// manifest lists the flat files written by the converter, so that the ones whose source is gone are
+// removed even when the repository outlives the pod, e.g. on a persistent volume
+type manifest struct {
+	// Layout is the layout the files are written with, empty for the flat layout of the first manifests
+	Layout string   `json:"layout,omitempty"`
+	Files  []string `json:"files"`
+}
+
+// flatName returns the flat file name of the <repository>/<type>/<tag> resource
+func flatName(repository, resourceType, tag string) string {
+	return strings.Join([]string{repository, resourceType, tag}, flatNameSeparator)
+}
+
+// validFlatName returns true when each element of the flat file name is a valid resource path element,
+// which rules out empty elements, "..", slashes and any other path traversal
+func validFlatName(name string) bool {
+	elements := strings.Split(name, flatNameSeparator)
+	if len(elements) != 3 {
+		return false
+	}
+	for _, element := range elements {
+		if !controllers.IsValidResourcePathElement(element) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadManifest returns the flat files listed in the manifest of the repository, skipping the invalid names,
+// and their layout
+func loadManifest(repoDir string) (map[string]bool, string, error) {
+	written := map[string]bool{}
+	content, err := os.ReadFile(filepath.Join(repoDir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return written, controllers.RepositoryLayoutFlat, nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("reading manifest: %w", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, "", fmt.Errorf("parsing manifest: %w", err)
+	}
+	layout := controllers.RepositoryLayoutFlat
+	if m.Layout == controllers.RepositoryLayoutNested {
+		layout = m.Layout
+	}
+	for _, name := range m.Files {
+		if !validFlatName(name) {
+			log.Printf("Warning: ignoring invalid manifest entry %q", name)
+			continue
+		}
+		written[name] = true
+	}
+	return written, layout, nil
+}
+
+// saveManifest writes the flat files of the last sync and their layout to the manifest of the repository
+func saveManifest(repoDir, layout string, written map[string]bool) error {
+	m := manifest{Layout: layout, Files: []string{}}
+	for name := range written {
+		m.Files = append(m.Files, name)
+	}
+	slices.Sort(m.Files)
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(repoDir, manifestName), bytes.NewReader(content))
+}
+
+// syncReport lists the problems found by a sync. The valid resources are still synced, the report
+// fails the sync afterwards
+type syncReport struct {
+	// MissingSecrets are the listed secrets that are not mounted
+	MissingSecrets []string `json:"missingSecrets,omitempty"`
+	// EmptySecrets are the listed secrets without any key
+	EmptySecrets []string `json:"emptySecrets,omitempty"`
+	// InvalidNames are the resources whose path is not valid, they are not written
+	InvalidNames []string `json:"invalidNames,omitempty"`
+	// Conflicts are the resources with more than one source, only the first one is written
+	Conflicts []string `json:"conflicts,omitempty"`
+	// MissingVaultSecrets are the secrets of the Vault sources that do not exist
+	MissingVaultSecrets []string `json:"missingVaultSecrets,omitempty"`
+	// EmptyVaultSecrets are the secrets of the Vault sources without any key
+	EmptyVaultSecrets []string `json:"emptyVaultSecrets,omitempty"`
+}
+
+func (r *syncReport) empty() bool {
+	return len(r.MissingSecrets) == 0 && len(r.EmptySecrets) == 0 && len(r.InvalidNames) == 0 && len(r.Conflicts) == 0 &&
+		len(r.MissingVaultSecrets) == 0 && len(r.EmptyVaultSecrets) == 0
+}
+
+func (r *syncReport) Error() string {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Sprintf("%+v", *r)
+	}
+	return string(content)
+}
@@ -0,0 +1,279 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	controllers "github.com/confidential-containers/trustee-operator/internal/controller"
+)
+
+const (
+	// vaultRequestTimeout bounds each request to a Vault server
+	vaultRequestTimeout = 30 * time.Second
+	// maxVaultResponseSize bounds the responses read from a Vault server
+	maxVaultResponseSize = 4 << 20
+)
+
+// errVaultSecretNotFound is returned when the secret of a Vault source does not exist
+var errVaultSecretNotFound = errors.New("secret not found")
+
+// vaultClient reads the secret of a Vault source from a Vault-compatible KV secrets engine, logging in
+// with the Kubernetes auth method
+type vaultClient struct {
+	source     controllers.VaultResourceSource
+	httpClient *http.Client
+
+	// token is reused until half of its lease elapsed
+	token       string
+	tokenExpiry time.Time
+}
+
+func newVaultClient(source controllers.VaultResourceSource) (*vaultClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if source.CAFile != "" {
+		caBundle, err := os.ReadFile(source.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificate found in %s", source.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &vaultClient{
+		source:     source,
+		httpClient: &http.Client{Transport: transport, Timeout: vaultRequestTimeout},
+	}, nil
+}
+
+// name identifies the secret of the Vault source in the logs and reports
+func (v *vaultClient) name() string {
+	return fmt.Sprintf("%s/%s/%s", v.source.Address, v.source.MountPath, v.source.Path)
+}
+
+// login exchanges the service account token for a Vault token
+func (v *vaultClient) login(ctx context.Context) error {
+	jwt, err := os.ReadFile(v.source.TokenFile)
+	if err != nil {
+		return fmt.Errorf("reading service account token: %w", err)
+	}
+	request := map[string]string{"role": v.source.Role, "jwt": strings.TrimSpace(string(jwt))}
+	var response struct {
+		Auth *struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int64  `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+v.source.AuthMountPath+"/login", "", request, &response); err != nil {
+		return fmt.Errorf("logging in with role %s: %w", v.source.Role, err)
+	}
+	if response.Auth == nil || response.Auth.ClientToken == "" {
+		return fmt.Errorf("logging in with role %s: no client token in the response", v.source.Role)
+	}
+
+	v.token = response.Auth.ClientToken
+	v.tokenExpiry = time.Time{}
+	if response.Auth.LeaseDuration > 0 {
+		v.tokenExpiry = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second / 2)
+	}
+	return nil
+}
+
+// readSecret returns the key/value pairs of the secret of the Vault source
+func (v *vaultClient) readSecret(ctx context.Context) (map[string]any, error) {
+	if v.token == "" || (!v.tokenExpiry.IsZero() && time.Now().After(v.tokenExpiry)) {
+		if err := v.login(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	secretPath := v.source.MountPath + "/" + v.source.Path
+	if v.source.KVVersion == 2 {
+		secretPath = v.source.MountPath + "/data/" + v.source.Path
+	}
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	err := v.do(ctx, http.MethodGet, secretPath, v.token, nil, &response)
+	var statusErr *vaultStatusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusForbidden {
+		// The token was revoked or expired early, log in again once
+		if err := v.login(ctx); err != nil {
+			return nil, err
+		}
+		err = v.do(ctx, http.MethodGet, secretPath, v.token, nil, &response)
+	}
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusNotFound {
+		return nil, errVaultSecretNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", v.name(), err)
+	}
+
+	data := map[string]any{}
+	if v.source.KVVersion == 2 {
+		var versioned struct {
+			Data map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal(response.Data, &versioned); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", v.name(), err)
+		}
+		// A deleted version has no data
+		if versioned.Data == nil {
+			return nil, errVaultSecretNotFound
+		}
+		data = versioned.Data
+	} else if err := json.Unmarshal(response.Data, &data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", v.name(), err)
+	}
+	return data, nil
+}
+
+// vaultStatusError is an error response of a Vault server
+type vaultStatusError struct {
+	status int
+	errors []string
+}
+
+func (e *vaultStatusError) Error() string {
+	if len(e.errors) == 0 {
+		return fmt.Sprintf("status %d", e.status)
+	}
+	return fmt.Sprintf("status %d: %s", e.status, strings.Join(e.errors, ", "))
+}
+
+// do sends a request to the Vault API and decodes the JSON response into out
+func (v *vaultClient) do(ctx context.Context, method, apiPath, token string, in, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, vaultRequestTimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, v.source.Address+"/v1/"+apiPath, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if v.source.Namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.source.Namespace)
+	}
+
+	response, err := v.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = response.Body.Close() }()
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxVaultResponseSize))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		statusErr := &vaultStatusError{status: response.StatusCode}
+		var errorResponse struct {
+			Errors []string `json:"errors"`
+		}
+		if json.Unmarshal(content, &errorResponse) == nil {
+			statusErr.errors = errorResponse.Errors
+		}
+		return statusErr
+	}
+	return json.Unmarshal(content, out)
+}
+
+// vaultValue returns the content of a resource: strings as they are, other values JSON encoded
+func vaultValue(value any) ([]byte, error) {
+	if s, ok := value.(string); ok {
+		// Never nil, even for an empty string, as the content of a convertedFile
+		return append([]byte{}, s...), nil
+	}
+	return json.Marshal(value)
+}
+
+// processVaultSources returns the resources of the secrets of the Vault sources, reporting the missing
+// and empty ones. Any other failure fails the sync, so that the resources of an unreachable server are kept
+func (c *converter) processVaultSources(report *syncReport) ([]convertedFile, error) {
+	if c.vaultClients == nil {
+		for _, source := range c.vaultSources {
+			client, err := newVaultClient(source)
+			if err != nil {
+				return nil, fmt.Errorf("vault source %s: %w", source.Address, err)
+			}
+			c.vaultClients = append(c.vaultClients, client)
+		}
+	}
+
+	var files []convertedFile
+	for _, client := range c.vaultClients {
+		data, err := client.readSecret(context.Background())
+		if errors.Is(err, errVaultSecretNotFound) {
+			report.MissingVaultSecrets = append(report.MissingVaultSecrets, client.name())
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			report.EmptyVaultSecrets = append(report.EmptyVaultSecrets, client.name())
+			continue
+		}
+
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			content, err := vaultValue(data[key])
+			if err != nil {
+				return nil, fmt.Errorf("encoding %s of %s: %w", key, client.name(), err)
+			}
+			tag := key
+			if client.source.Tags[key] != "" {
+				tag = client.source.Tags[key]
+			}
+			files = append(files, convertedFile{
+				flatName:   flatName(client.source.Repository, client.source.Type, tag),
+				sourcePath: client.name() + "#" + key,
+				content:    content,
+			})
+		}
+	}
+	return files, nil
+}
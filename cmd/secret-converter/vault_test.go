@@ -0,0 +1,242 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	controllers "github.com/confidential-containers/trustee-operator/internal/controller"
+)
+
+const (
+	testVaultRole = "kbs"
+	testVaultJWT  = "service-account-token"
+)
+
+// fakeVault is a stand-in for a Vault dev server: a Kubernetes auth method mounted at "kubernetes",
+// a KV version 2 engine mounted at "secret" and a KV version 1 engine mounted at "kv"
+type fakeVault struct {
+	mu       sync.Mutex
+	secrets  map[string]map[string]any
+	tokens   map[string]bool
+	logins   int
+	failRead bool
+	// namespace is the X-Vault-Namespace header of the last request
+	namespace string
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{secrets: map[string]map[string]any{}, tokens: map[string]bool{}}
+}
+
+func (f *fakeVault) setSecret(path string, data map[string]any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.secrets[path] = data
+}
+
+func (f *fakeVault) revokeTokens() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = map[string]bool{}
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.namespace = r.Header.Get("X-Vault-Namespace")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {message}})
+	}
+
+	if r.Method == http.MethodPost && r.URL.Path == "/v1/auth/kubernetes/login" {
+		var login struct{ Role, JWT string }
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.Role != testVaultRole || login.JWT != testVaultJWT {
+			writeError(http.StatusBadRequest, "invalid role or service account token")
+			return
+		}
+		f.logins++
+		token := fmt.Sprintf("token-%d", f.logins)
+		f.tokens[token] = true
+		_ = json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": token, "lease_duration": 3600}})
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		writeError(http.StatusMethodNotAllowed, "unsupported method")
+		return
+	}
+	if !f.tokens[r.Header.Get("X-Vault-Token")] {
+		writeError(http.StatusForbidden, "permission denied")
+		return
+	}
+	if f.failRead {
+		writeError(http.StatusInternalServerError, "internal error")
+		return
+	}
+	var data map[string]any
+	var ok bool
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		if data, ok = f.secrets["secret/"+strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]; ok {
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}}})
+			return
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if data, ok = f.secrets["kv/"+strings.TrimPrefix(r.URL.Path, "/v1/kv/")]; ok {
+			_ = json.NewEncoder(w).Encode(map[string]any{"data": data})
+			return
+		}
+	}
+	writeError(http.StatusNotFound, "")
+}
+
+// newTestVaultSource starts the fake Vault server behind TLS and returns a source reading path from it
+func newTestVaultSource(t *testing.T, vault *fakeVault, path string) controllers.VaultResourceSource {
+	t.Helper()
+	server := httptest.NewTLSServer(vault)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	tokenFile, caFile := filepath.Join(dir, "token"), filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(tokenFile, []byte(testVaultJWT+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caBundle, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return controllers.VaultResourceSource{
+		Address:       server.URL,
+		CAFile:        caFile,
+		AuthMountPath: "kubernetes",
+		Role:          testVaultRole,
+		TokenFile:     tokenFile,
+		MountPath:     "secret",
+		KVVersion:     2,
+		Path:          path,
+		Repository:    "default",
+		Type:          filepath.Base(path),
+	}
+}
+
+func TestConverterSyncVault(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	vault := newFakeVault()
+	vault.setSecret("secret/kbs/keys", map[string]any{
+		"workload.key": "workload",
+		"config":       map[string]any{"enabled": true},
+	})
+	source := newTestVaultSource(t, vault, "kbs/keys")
+	source.Repository = "my-app"
+	source.Tags = map[string]string{"workload.key": "workload"}
+
+	c := newConverter(filepath.Join(root, "secrets"), filepath.Join(root, "resources"), repo)
+	c.vaultSources = []controllers.VaultResourceSource{source}
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	for flatName, want := range map[string]string{
+		`my-app\x2Fkeys\x2Fworkload`: "workload",
+		`my-app\x2Fkeys\x2Fconfig`:   `{"enabled":true}`,
+	} {
+		if got, _ := readRepoFile(t, repo, flatName); got != want {
+			t.Errorf("expected %s to hold %q, got %q", flatName, want, got)
+		}
+	}
+
+	// The secret is rotated and the token revoked: the converter logs in again and prunes the removed key
+	vault.setSecret("secret/kbs/keys", map[string]any{"workload.key": "rotated"})
+	vault.revokeTokens()
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readRepoFile(t, repo, `my-app\x2Fkeys\x2Fworkload`); got != "rotated" {
+		t.Errorf("expected the key to be rotated, got %q", got)
+	}
+	if _, ok := readRepoFile(t, repo, `my-app\x2Fkeys\x2Fconfig`); ok {
+		t.Error("expected the removed key to be pruned")
+	}
+	if vault.logins != 2 {
+		t.Errorf("expected the token to be reused until it was revoked, got %d logins", vault.logins)
+	}
+
+	// An unavailable server fails the sync and keeps the resources
+	vault.failRead = true
+	if err := c.sync(); err == nil {
+		t.Error("expected the sync to fail")
+	}
+	if _, ok := readRepoFile(t, repo, `my-app\x2Fkeys\x2Fworkload`); !ok {
+		t.Error("expected the resource to be kept while the server is unavailable")
+	}
+}
+
+func TestConverterSyncVaultKVv1(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	vault := newFakeVault()
+	vault.setSecret("kv/tenant-a/certs", map[string]any{"ca.crt": "ca"})
+	source := newTestVaultSource(t, vault, "tenant-a/certs")
+	source.MountPath, source.KVVersion, source.Namespace = "kv", 1, "tenant-a"
+
+	c := newConverter(filepath.Join(root, "secrets"), filepath.Join(root, "resources"), repo)
+	c.vaultSources = []controllers.VaultResourceSource{source}
+	if err := c.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := readRepoFile(t, repo, `default\x2Fcerts\x2Fca.crt`); got != "ca" {
+		t.Errorf("expected the key of the KV version 1 engine, got %q", got)
+	}
+	if vault.namespace != "tenant-a" {
+		t.Errorf("expected the requests to carry the namespace, got %q", vault.namespace)
+	}
+}
+
+func TestConverterSyncVaultReport(t *testing.T) {
+	root := t.TempDir()
+	vault := newFakeVault()
+	vault.setSecret("secret/kbs/empty", map[string]any{})
+	missing := newTestVaultSource(t, vault, "kbs/missing")
+	empty := newTestVaultSource(t, vault, "kbs/empty")
+
+	c := newConverter(filepath.Join(root, "secrets"), filepath.Join(root, "resources"), filepath.Join(root, "repo"))
+	c.vaultSources = []controllers.VaultResourceSource{missing, empty}
+	var report *syncReport
+	if err := c.sync(); !errors.As(err, &report) {
+		t.Fatalf("expected a sync report, got %v", err)
+	}
+	want := &syncReport{
+		MissingVaultSecrets: []string{missing.Address + "/secret/kbs/missing"},
+		EmptyVaultSecrets:   []string{empty.Address + "/secret/kbs/empty"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("expected %v, got %v", want, report)
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// Name of the symlink Kubernetes swaps to update a projected volume atomically
+	projectedDataDir = "..data"
+
+	// The repository is synced periodically as well, in case an event was missed
+	resyncPeriod = time.Minute
+)
+
+// syncStatus is the outcome of the last sync, reported by the readiness endpoint
+type syncStatus struct {
+	mu     sync.Mutex
+	synced bool
+	err    error
+	report *syncReport
+}
+
+// set records the outcome of a sync. A sync report does not fail the sync: the valid resources are
+// written and the missing or invalid ones are reported, by the KbsConfig status as well
+func (s *syncStatus) set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = true
+	s.err, s.report = err, nil
+	if errors.As(err, &s.report) {
+		s.err = nil
+	}
+}
+
+// handler serves /readyz, failing until a sync of the repository finished and when the last one
+// could not write it, and /healthz
+func (s *syncStatus) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		s.mu.Lock()
+		synced, err, report := s.synced, s.err, s.report
+		s.mu.Unlock()
+		switch {
+		case !synced:
+			http.Error(w, "initial sync in progress", http.StatusServiceUnavailable)
+		case err != nil:
+			http.Error(w, fmt.Sprintf("sync failed: %v", err), http.StatusServiceUnavailable)
+		case report != nil:
+			_, _ = fmt.Fprintf(w, "ok, synced with warnings: %v\n", report)
+		default:
+			_, _ = fmt.Fprintln(w, "ok")
+		}
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// watch keeps the repository in sync with the mounted secrets and KbsResource contents until the
+// context is done. Kubernetes updates a mounted secret by swapping the ..data symlink of its
+// directory, each swap triggers a sync
+func (c *converter) watch(ctx context.Context, probeAddr string) error {
+	status := &syncStatus{}
+	server := &http.Server{Addr: probeAddr, Handler: status.handler(), ReadHeaderTimeout: 5 * time.Second}
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+	defer func() {
+		_ = server.Shutdown(context.Background())
+	}()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating watcher: %w", err)
+	}
+	defer func() {
+		_ = watcher.Close()
+	}()
+
+	ticker := time.NewTicker(resyncPeriod)
+	defer ticker.Stop()
+	for {
+		// Secret directories mounted after the previous sync are watched as well
+		if err := c.addWatches(watcher); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		err := c.sync()
+		if err != nil {
+			log.Printf("Error syncing the repository: %v", err)
+		}
+		status.set(err)
+
+		if !c.waitForChange(ctx, watcher, ticker.C, serverErr) {
+			return ctx.Err()
+		}
+	}
+}
+
+// waitForChange returns true when a mounted directory changed or the resync period elapsed, and
+// false once the context is done
+func (c *converter) waitForChange(ctx context.Context, watcher *fsnotify.Watcher, resync <-chan time.Time, serverErr <-chan error) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case err := <-serverErr:
+			log.Printf("Error serving the readiness endpoint: %v", err)
+		case <-resync:
+			return true
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return false
+			}
+			log.Printf("Warning: watch error: %v", err)
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return false
+			}
+			if triggersSync(event) {
+				log.Printf("Detected %s on %s", event.Op, event.Name)
+				return true
+			}
+		}
+	}
+}
+
+// triggersSync returns true for the swap of the ..data symlink and for visible entries, the other
+// hidden entries are the timestamped directories of the projected volumes
+func triggersSync(event fsnotify.Event) bool {
+	name := filepath.Base(event.Name)
+	return name == projectedDataDir || !strings.HasPrefix(name, ".")
+}
+
+// addWatches watches the secrets directory, each secret directory, the KbsResources directory and the
+// sidecar resources directory
+func (c *converter) addWatches(watcher *fsnotify.Watcher) error {
+	dirs := []string{c.sourceDir, c.resourcesDir, c.sidecarDir}
+	if entries, err := os.ReadDir(c.sourceDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				dirs = append(dirs, filepath.Join(c.sourceDir, entry.Name()))
+			}
+		}
+	}
+
+	var errs []error
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			errs = append(errs, fmt.Errorf("watching %s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -15,4 +15,19 @@ allow if {
      se_claims.image_phkh == "<se.image_phkh>"
      se_claims.tag == "<se.tag>"
      se_claims.version == 256
+     kbs_resource_allowed
      }
+
+# KbsResource policy selectors, appended by the trustee-operator
+default kbs_resource_selectors := {}
+
+kbs_resource_allowed if {
+    not kbs_resource_selectors[data["resource-path"]]
+}
+
+kbs_resource_allowed if {
+    selector := kbs_resource_selectors[data["resource-path"]]
+    some tee in selector.tees
+    some _, submod in input.submods
+    submod["ear.veraison.annotated-evidence"][tee]
+}
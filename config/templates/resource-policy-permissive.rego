@@ -8,4 +8,19 @@ plugin = data.plugin
 
 allow if {
   plugin == "resource"
+  kbs_resource_allowed
+}
+
+# KbsResource policy selectors, appended by the trustee-operator
+default kbs_resource_selectors := {}
+
+kbs_resource_allowed if {
+    not kbs_resource_selectors[data["resource-path"]]
+}
+
+kbs_resource_allowed if {
+    selector := kbs_resource_selectors[data["resource-path"]]
+    some tee in selector.tees
+    some _, submod in input.submods
+    submod["ear.veraison.annotated-evidence"][tee]
 }
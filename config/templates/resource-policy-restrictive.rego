@@ -6,10 +6,24 @@ default allow = false
 allow if {
     not any_not_affirming
     count(input.submods) > 0
-
+    kbs_resource_allowed
 }
 
 any_not_affirming if {
     some _, submod in input.submods
     submod["ear.status"] != "affirming"
 }
+
+# KbsResource policy selectors, appended by the trustee-operator
+default kbs_resource_selectors := {}
+
+kbs_resource_allowed if {
+    not kbs_resource_selectors[data["resource-path"]]
+}
+
+kbs_resource_allowed if {
+    selector := kbs_resource_selectors[data["resource-path"]]
+    some tee in selector.tees
+    some _, submod in input.submods
+    submod["ear.veraison.annotated-evidence"][tee]
+}
@@ -0,0 +1,165 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// The rotation state is kept on the auth secret so that it survives the loss of the TrusteeConfig status
+	adminKeyRotatedAtAnnotation         = "confidentialcontainers.org/admin-key-rotated-at"
+	adminKeyPreviousExpiresAtAnnotation = "confidentialcontainers.org/admin-key-previous-expires-at"
+	adminKeyRotationRequestAnnotation   = "confidentialcontainers.org/admin-key-rotation-request"
+
+	// adminPreviousPublicKey is the auth secret key holding the public key replaced by the last rotation
+	adminPreviousPublicKey = "previousPublicKey"
+
+	defaultAdminKeyGracePeriod = 24 * time.Hour
+)
+
+// adminKeyRotationPolicy returns the rotation interval, zero when the key pair is only rotated on demand,
+// and the grace period of the previous public key
+func (r *TrusteeConfigReconciler) adminKeyRotationPolicy() (time.Duration, time.Duration) {
+	interval, gracePeriod := time.Duration(0), defaultAdminKeyGracePeriod
+	if rotation := r.trusteeConfig.Spec.AdminKeyRotation; rotation != nil {
+		if rotation.Interval != nil {
+			interval = rotation.Interval.Duration
+		}
+		if rotation.GracePeriod != nil {
+			gracePeriod = rotation.GracePeriod.Duration
+		}
+	}
+	return interval, gracePeriod
+}
+
+// adminKeyRotationAnnotations returns the annotations recording a key pair generated at the given time
+func (r *TrusteeConfigReconciler) adminKeyRotationAnnotations(now time.Time) map[string]string {
+	annotations := map[string]string{adminKeyRotatedAtAnnotation: now.UTC().Format(time.RFC3339)}
+	// A pending rotation request is fulfilled by the newly generated key pair
+	if request := r.trusteeConfig.Annotations[confidentialcontainersorgv1alpha1.RotateAdminKeyAnnotation]; request != "" {
+		annotations[adminKeyRotationRequestAnnotation] = request
+	}
+	return annotations
+}
+
+// annotationTime parses a RFC 3339 timestamp annotation
+func annotationTime(obj metav1.Object, key string) (time.Time, bool) {
+	value, ok := obj.GetAnnotations()[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, err == nil
+}
+
+// adminKeyRotatedAt returns the time the current admin key pair was generated,
+// secrets created before rotation was supported fall back to their creation time
+func adminKeyRotatedAt(secret *corev1.Secret) time.Time {
+	if rotatedAt, ok := annotationTime(secret, adminKeyRotatedAtAnnotation); ok {
+		return rotatedAt
+	}
+	return secret.CreationTimestamp.Time
+}
+
+// adminKeyRotationReason returns why the admin key pair must be rotated, or an empty string
+func (r *TrusteeConfigReconciler) adminKeyRotationReason(secret *corev1.Secret, now time.Time) string {
+	request := r.trusteeConfig.Annotations[confidentialcontainersorgv1alpha1.RotateAdminKeyAnnotation]
+	if request != "" && request != secret.Annotations[adminKeyRotationRequestAnnotation] {
+		return fmt.Sprintf("rotation requested with the %s annotation", confidentialcontainersorgv1alpha1.RotateAdminKeyAnnotation)
+	}
+	interval, _ := r.adminKeyRotationPolicy()
+	if interval > 0 && !now.Before(adminKeyRotatedAt(secret).Add(interval)) {
+		return fmt.Sprintf("rotation interval of %s elapsed", interval)
+	}
+	return ""
+}
+
+// reconcileAdminKeyRotation rotates the admin key pair when requested or due, and drops the previous
+// public key once its grace period is over. The previous public key is replaced by the current one
+// rather than removed, so that a KBS configuration referencing it keeps loading
+func (r *TrusteeConfigReconciler) reconcileAdminKeyRotation(ctx context.Context, secret *corev1.Secret, now time.Time) error {
+	if reason := r.adminKeyRotationReason(secret, now); reason != "" {
+		publicKeyPEM, privateKeyPEM, err := r.generateAdminKeyPair()
+		if err != nil {
+			r.Recorder.Eventf(r.trusteeConfig, nil, corev1.EventTypeWarning, "AdminKeyRotationFailed", "AdminKeyRotationFailed", err.Error())
+			return err
+		}
+		_, gracePeriod := r.adminKeyRotationPolicy()
+
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		for k, v := range r.adminKeyRotationAnnotations(now) {
+			secret.Annotations[k] = v
+		}
+		secret.Annotations[adminKeyPreviousExpiresAtAnnotation] = now.Add(gracePeriod).UTC().Format(time.RFC3339)
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[adminPreviousPublicKey] = secret.Data["publicKey"]
+		secret.Data["publicKey"] = publicKeyPEM
+		secret.Data["privateKey"] = privateKeyPEM
+
+		r.log.Info("Rotating KBS admin key pair", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "reason", reason)
+		if err := r.Update(ctx, secret); err != nil {
+			r.Recorder.Eventf(r.trusteeConfig, nil, corev1.EventTypeWarning, "AdminKeyRotationFailed", "AdminKeyRotationFailed", err.Error())
+			return err
+		}
+		message := fmt.Sprintf("KBS admin key pair rotated: %s", reason)
+		if previousAdminKeyAccepted(r.trusteeConfig.Spec) {
+			message += fmt.Sprintf(", the previous public key is accepted until %s", now.Add(gracePeriod).UTC().Format(time.RFC3339))
+		}
+		r.Recorder.Eventf(r.trusteeConfig, nil, corev1.EventTypeNormal, "AdminKeyRotated", "AdminKeyRotated", message)
+	} else if expiresAt, ok := annotationTime(secret, adminKeyPreviousExpiresAtAnnotation); ok && !now.Before(expiresAt) {
+		delete(secret.Annotations, adminKeyPreviousExpiresAtAnnotation)
+		secret.Data[adminPreviousPublicKey] = secret.Data["publicKey"]
+
+		r.log.Info("Removing previous KBS admin public key", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		if err := r.Update(ctx, secret); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(r.trusteeConfig, nil, corev1.EventTypeNormal, "PreviousAdminKeyExpired", "PreviousAdminKeyExpired",
+			"The grace period of the previous KBS admin public key is over, it is replaced by the current one")
+	}
+
+	r.trusteeConfig.Status.AdminKey = r.adminKeyStatus(secret)
+	return nil
+}
+
+// adminKeyStatus returns the rotation status of the admin key pair stored in the auth secret
+func (r *TrusteeConfigReconciler) adminKeyStatus(secret *corev1.Secret) *confidentialcontainersorgv1alpha1.AdminKeyStatus {
+	rotatedAt := adminKeyRotatedAt(secret)
+	status := &confidentialcontainersorgv1alpha1.AdminKeyStatus{
+		LastRotationTime:    &metav1.Time{Time: rotatedAt},
+		LastRotationRequest: secret.Annotations[adminKeyRotationRequestAnnotation],
+	}
+	if interval, _ := r.adminKeyRotationPolicy(); interval > 0 {
+		status.NextRotationTime = &metav1.Time{Time: rotatedAt.Add(interval)}
+	}
+	if expiresAt, ok := annotationTime(secret, adminKeyPreviousExpiresAtAnnotation); ok {
+		status.PreviousKeyExpirationTime = &metav1.Time{Time: expiresAt}
+	}
+	return status
+}
@@ -0,0 +1,163 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// authSecret returns the KBS auth secret of the TrusteeConfig
+func authSecret(t *testing.T, r *TrusteeConfigReconciler) *corev1.Secret {
+	t.Helper()
+	secret := &corev1.Secret{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: r.getKbsAuthSecretName()}, secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// expectEvent checks that the next recorded event has the given reason and returns it
+func expectEvent(t *testing.T, r *TrusteeConfigReconciler, reason string) string {
+	t.Helper()
+	select {
+	case event := <-r.Recorder.(*events.FakeRecorder).Events:
+		if !strings.Contains(event, reason) {
+			t.Errorf("expected a %s event, got %q", reason, event)
+		}
+		return event
+	default:
+		t.Errorf("expected a %s event", reason)
+	}
+	return ""
+}
+
+func TestAdminKeyRotationInterval(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AdminKeyRotation: &confidentialcontainersorgv1alpha1.AdminKeyRotationSpec{
+			Interval:    &metav1.Duration{Duration: 720 * time.Hour},
+			GracePeriod: &metav1.Duration{Duration: time.Hour},
+		},
+	})
+	ctx := context.Background()
+
+	if err := r.createOrUpdateKbsAuthSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := authSecret(t, r)
+	original := secret.Data["publicKey"]
+	if !bytes.Equal(secret.Data[adminPreviousPublicKey], original) {
+		t.Error("expected the previous public key to match the current one before the first rotation")
+	}
+	status := r.trusteeConfig.Status.AdminKey
+	if status == nil || status.LastRotationTime == nil || status.NextRotationTime == nil || status.PreviousKeyExpirationTime != nil {
+		t.Fatalf("unexpected admin key status %+v", status)
+	}
+
+	// nothing happens before the interval elapsed
+	rotatedAt := status.LastRotationTime.Time
+	if err := r.reconcileAdminKeyRotation(ctx, secret, rotatedAt.Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if secret = authSecret(t, r); !bytes.Equal(secret.Data["publicKey"], original) {
+		t.Fatal("expected the key pair to be kept before the rotation interval elapsed")
+	}
+
+	// the key pair is rotated and the previous public key is kept for the grace period
+	now := rotatedAt.Add(721 * time.Hour)
+	if err := r.reconcileAdminKeyRotation(ctx, secret, now); err != nil {
+		t.Fatal(err)
+	}
+	// the admin API denies every request without personas, the previous key is not accepted
+	if event := expectEvent(t, r, "AdminKeyRotated"); strings.Contains(event, "accepted until") {
+		t.Errorf("expected no grace period announced without admin personas, got %q", event)
+	}
+	secret = authSecret(t, r)
+	if bytes.Equal(secret.Data["publicKey"], original) || !bytes.Equal(secret.Data[adminPreviousPublicKey], original) {
+		t.Error("expected a new key pair with the previous public key kept")
+	}
+	status = r.trusteeConfig.Status.AdminKey
+	if status.PreviousKeyExpirationTime == nil || !status.PreviousKeyExpirationTime.Time.Equal(now.Add(time.Hour).Truncate(time.Second)) {
+		t.Errorf("unexpected previous key expiration %v", status.PreviousKeyExpirationTime)
+	}
+	if next := r.nextScheduledReconcile(); !next.Equal(status.PreviousKeyExpirationTime.Time) {
+		t.Errorf("expected the next reconcile at the end of the grace period, got %v", next)
+	}
+
+	// the previous public key is dropped once the grace period is over
+	if err := r.reconcileAdminKeyRotation(ctx, secret, now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, r, "PreviousAdminKeyExpired")
+	secret = authSecret(t, r)
+	if !bytes.Equal(secret.Data[adminPreviousPublicKey], secret.Data["publicKey"]) {
+		t.Error("expected the previous public key to be replaced by the current one")
+	}
+	if r.trusteeConfig.Status.AdminKey.PreviousKeyExpirationTime != nil {
+		t.Error("expected no previous key expiration once the grace period is over")
+	}
+}
+
+func TestAdminKeyRotationAnnotation(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{})
+	ctx := context.Background()
+
+	if err := r.createOrUpdateKbsAuthSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	original := authSecret(t, r).Data["publicKey"]
+	if r.trusteeConfig.Status.AdminKey.NextRotationTime != nil {
+		t.Error("expected no scheduled rotation without an interval")
+	}
+
+	// the persona using the generated key pair accepts the previous public key in Simple mode
+	r.trusteeConfig.Spec.AdminSpec = &confidentialcontainersorgv1alpha1.AdminSpec{
+		AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationSimple,
+	}
+	r.trusteeConfig.Annotations = map[string]string{confidentialcontainersorgv1alpha1.RotateAdminKeyAnnotation: "2026-10-17T10:00:00Z"}
+	if err := r.createOrUpdateKbsAuthSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if event := expectEvent(t, r, "AdminKeyRotated"); !strings.Contains(event, "the previous public key is accepted until") {
+		t.Errorf("expected the grace period in the event, got %q", event)
+	}
+	rotated := authSecret(t, r).Data["publicKey"]
+	if bytes.Equal(rotated, original) {
+		t.Error("expected the annotation to rotate the key pair")
+	}
+	if r.trusteeConfig.Status.AdminKey.LastRotationRequest != "2026-10-17T10:00:00Z" {
+		t.Errorf("expected the handled request in status, got %+v", r.trusteeConfig.Status.AdminKey)
+	}
+
+	// the same request is only handled once
+	if err := r.createOrUpdateKbsAuthSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(authSecret(t, r).Data["publicKey"], rotated) {
+		t.Error("expected a handled request not to rotate the key pair again")
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Name of the volume holding the public keys of the admin personas
+	adminPublicKeysVolume = "admin-public-keys"
+
+	// Persona configured in Simple mode when the TrusteeConfig does not list any
+	defaultAdminPersona = "admin"
+
+	// Suffix of the persona accepting the previous generated public key during the rotation grace period
+	previousAdminPersonaSuffix = "-previous"
+)
+
+// adminPublicKeyFilename returns the file name of the public key of a persona in the admin public keys secret
+func adminPublicKeyFilename(personaID string) string {
+	return personaID + ".pub"
+}
+
+// adminPersonas returns the personas of the TrusteeConfig admin API in Simple mode
+func adminPersonas(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) []confidentialcontainersorgv1alpha1.AdminPersona {
+	if spec.AdminSpec == nil || spec.AdminSpec.AuthorizationMode != confidentialcontainersorgv1alpha1.AdminAuthorizationSimple {
+		return nil
+	}
+	if len(spec.AdminSpec.Personas) == 0 {
+		return []confidentialcontainersorgv1alpha1.AdminPersona{{ID: defaultAdminPersona}}
+	}
+	return spec.AdminSpec.Personas
+}
+
+// kbsAdminPersonas returns the [[admin.personas]] entries of the KBS configuration. A persona using the
+// generated key pair is also accepted with the previous public key, which matches the current one
+// outside of the rotation grace period
+func kbsAdminPersonas(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) []interface{} {
+	var personas []interface{}
+	for _, persona := range adminPersonas(spec) {
+		if persona.PublicKeySecretRef != nil {
+			personas = append(personas, map[string]interface{}{
+				"id":              persona.ID,
+				"public_key_path": filepath.Join(kbsDefaultConfigPath, adminPublicKeysVolume, adminPublicKeyFilename(persona.ID)),
+			})
+			continue
+		}
+		personas = append(personas,
+			map[string]interface{}{
+				"id":              persona.ID,
+				"public_key_path": filepath.Join(kbsDefaultConfigPath, "auth-secret", "publicKey"),
+			},
+			map[string]interface{}{
+				"id":              persona.ID + previousAdminPersonaSuffix,
+				"public_key_path": filepath.Join(kbsDefaultConfigPath, "auth-secret", adminPreviousPublicKey),
+			})
+	}
+	return personas
+}
+
+// previousAdminKeyAccepted returns true when KBS accepts the previous generated public key, i.e. when a
+// persona of the Simple admin mode uses the generated key pair
+func previousAdminKeyAccepted(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) bool {
+	for _, persona := range adminPersonas(spec) {
+		if persona.PublicKeySecretRef == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// adminPublicKeySecretNames returns the Secrets the public keys of the admin personas are read from
+func adminPublicKeySecretNames(trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) []string {
+	var names []string
+	for _, persona := range adminPersonas(trusteeConfig.Spec) {
+		if persona.PublicKeySecretRef != nil {
+			names = append(names, persona.PublicKeySecretRef.Name)
+		}
+	}
+	return names
+}
+
+// getAdminPublicKeysSecretName returns the name for the admin public keys secret
+func (r *TrusteeConfigReconciler) getAdminPublicKeysSecretName() string {
+	return r.trusteeConfig.Name + "-admin-public-keys"
+}
+
+// createOrUpdateAdminPublicKeysSecret copies the public keys of the admin personas into a secret mounted
+// by KBS, and returns its name. The secret is deleted when no persona has its own public key
+func (r *TrusteeConfigReconciler) createOrUpdateAdminPublicKeysSecret(ctx context.Context) (string, error) {
+	data := make(map[string][]byte)
+	for _, persona := range adminPersonas(r.trusteeConfig.Spec) {
+		ref := persona.PublicKeySecretRef
+		if ref == nil {
+			continue
+		}
+		source := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: ref.Name}, source); err != nil {
+			return "", fmt.Errorf("public key of admin persona %s: %w", persona.ID, err)
+		}
+		publicKey, ok := source.Data[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("public key of admin persona %s: Secret %s has no key %s", persona.ID, ref.Name, ref.Key)
+		}
+		if block, _ := pem.Decode(publicKey); block == nil {
+			return "", fmt.Errorf("public key of admin persona %s: key %s of Secret %s is not PEM encoded", persona.ID, ref.Key, ref.Name)
+		}
+		data[adminPublicKeyFilename(persona.ID)] = publicKey
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: r.getAdminPublicKeysSecretName(), Namespace: r.namespace}}
+	if len(data) == 0 {
+		if err := r.Delete(ctx, secret); err != nil && !k8serrors.IsNotFound(err) {
+			return "", err
+		}
+		return "", nil
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "admin-public-keys") {
+			secret.Labels[k] = v
+		}
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = data
+		return ctrl.SetControllerReference(r.trusteeConfig, secret, r.Scheme)
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret.Name, nil
+}
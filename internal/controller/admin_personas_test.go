@@ -0,0 +1,153 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const testAdminPublicKey = "-----BEGIN PUBLIC KEY-----\nMCowBQYDK2VwAyEAtNIjfd5LOsVDVAmVzD17XS6uphSN6CoMUTp0Fhhx7T4=\n-----END PUBLIC KEY-----\n"
+
+func simpleAdminSpec(personas ...confidentialcontainersorgv1alpha1.AdminPersona) confidentialcontainersorgv1alpha1.TrusteeConfigSpec {
+	return confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{
+			AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationSimple,
+			Personas:          personas,
+		},
+	}
+}
+
+func publicKeyRef(name, key string) *corev1.SecretKeySelector {
+	return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}
+}
+
+func TestApplyKbsSettingsAdminPersonas(t *testing.T) {
+	spec := simpleAdminSpec(
+		confidentialcontainersorgv1alpha1.AdminPersona{ID: "operator"},
+		confidentialcontainersorgv1alpha1.AdminPersona{ID: "alice", PublicKeySecretRef: publicKeyRef("alice", "key.pub")},
+	)
+	want := []kbsAdminPersona{
+		{ID: "operator", PublicKeyPath: "/etc/auth-secret/publicKey"},
+		{ID: "operator-previous", PublicKeyPath: "/etc/auth-secret/previousPublicKey"},
+		{ID: "alice", PublicKeyPath: "/etc/admin-public-keys/alice.pub"},
+	}
+
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		content, err := os.ReadFile("../../config/templates/" + filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		var buf bytes.Buffer
+		if err := template.Must(template.New(filename).Parse(string(content))).Execute(&buf, GetTLSConfigFromTlsConfig(nil)); err != nil {
+			t.Fatalf("Failed to render %s: %v", filename, err)
+		}
+
+		rendered, err := applyKbsSettings(buf.String(), spec)
+		if err != nil {
+			t.Fatalf("%s: applyKbsSettings() error = %v", filename, err)
+		}
+		config, err := parseKbsTomlConfig(rendered)
+		if err != nil {
+			t.Fatalf("%s: parseKbsTomlConfig() error = %v", filename, err)
+		}
+		if config.Admin.AuthorizationMode != "Simple" {
+			t.Errorf("%s: expected authorization_mode Simple, got %q", filename, config.Admin.AuthorizationMode)
+		}
+		if !reflect.DeepEqual(config.Admin.Personas, want) {
+			t.Errorf("%s: expected personas %v, got %v", filename, want, config.Admin.Personas)
+		}
+	}
+
+	// Without personas, the generated admin key is accepted
+	personas := kbsAdminPersonas(simpleAdminSpec())
+	if len(personas) != 2 || personas[0].(map[string]interface{})["id"] != defaultAdminPersona {
+		t.Errorf("expected the default admin persona, got %v", personas)
+	}
+	// Personas are ignored by the other authorization modes
+	denyAll := simpleAdminSpec(confidentialcontainersorgv1alpha1.AdminPersona{ID: "alice"})
+	denyAll.AdminSpec.AuthorizationMode = confidentialcontainersorgv1alpha1.AdminAuthorizationDenyAll
+	if personas := kbsAdminPersonas(denyAll); len(personas) != 0 {
+		t.Errorf("expected no persona in DenyAll mode, got %v", personas)
+	}
+}
+
+func TestCreateOrUpdateAdminPublicKeysSecret(t *testing.T) {
+	ctx := context.Background()
+	alice := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "alice", Namespace: testNamespace},
+		Data:       map[string][]byte{"key.pub": []byte(testAdminPublicKey), "invalid": []byte("not a key")},
+	}
+	spec := simpleAdminSpec(
+		confidentialcontainersorgv1alpha1.AdminPersona{ID: "operator"},
+		confidentialcontainersorgv1alpha1.AdminPersona{ID: "alice", PublicKeySecretRef: publicKeyRef("alice", "key.pub")},
+	)
+	r := newTestTrusteeConfigReconciler(t, spec, alice)
+
+	name, err := r.createOrUpdateAdminPublicKeysSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != r.getAdminPublicKeysSecretName() {
+		t.Fatalf("expected the secret %s, got %q", r.getAdminPublicKeysSecretName(), name)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: name}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 1 || string(secret.Data["alice.pub"]) != testAdminPublicKey {
+		t.Errorf("expected only the public key of alice, got %v", secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].UID != r.trusteeConfig.UID {
+		t.Errorf("expected the secret to be owned by the TrusteeConfig, got %v", secret.OwnerReferences)
+	}
+	if got := adminPublicKeySecretNames(r.trusteeConfig); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("expected the watched secrets [alice], got %v", got)
+	}
+
+	// A key that is not PEM encoded is rejected
+	r.trusteeConfig.Spec.AdminSpec.Personas[1].PublicKeySecretRef.Key = "invalid"
+	if _, err := r.createOrUpdateAdminPublicKeysSecret(ctx); err == nil {
+		t.Error("expected an error for a key that is not PEM encoded")
+	}
+	// A missing secret is reported
+	r.trusteeConfig.Spec.AdminSpec.Personas[1].PublicKeySecretRef = publicKeyRef("bob", "key.pub")
+	if _, err := r.createOrUpdateAdminPublicKeysSecret(ctx); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+
+	// The secret is deleted once no persona has its own public key
+	r.trusteeConfig.Spec.AdminSpec.Personas = r.trusteeConfig.Spec.AdminSpec.Personas[:1]
+	if name, err = r.createOrUpdateAdminPublicKeysSecret(ctx); err != nil || name != "" {
+		t.Fatalf("expected no secret, got %q, %v", name, err)
+	}
+	err = r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: r.getAdminPublicKeysSecretName()}, secret)
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the admin public keys secret to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/types"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Name of the volume holding the AttestationPolicy files
+	attestationPoliciesVolume = "attestation-policies"
+
+	// The attestation service evaluates the "policy" package
+	attestationPolicyPackage = "data.policy"
+
+	// Reasons used in the AttestationPolicy and KbsConfig conditions
+	reasonPolicyCompiled            = "PolicyCompiled"
+	reasonCompilationFailed         = "CompilationFailed"
+	reasonPolicyServed              = "PolicyServed"
+	reasonPolicyIDConflict          = "PolicyIDConflict"
+	reasonAttestationPoliciesFailed = "AttestationPoliciesReconcileFailed"
+)
+
+// attestationPolicyCapabilities are the OPA capabilities extended with the built-in
+// functions the attestation service provides to the policies
+var attestationPolicyCapabilities = func() *ast.Capabilities {
+	capabilities := ast.CapabilitiesForThisVersion()
+	capabilities.Builtins = append(capabilities.Builtins, &ast.Builtin{
+		Name: "query_reference_value",
+		Decl: types.NewFunction(types.Args(types.S), types.A),
+	})
+	return capabilities
+}()
+
+// attestationPolicyID returns the attestation service policy ID of an AttestationPolicy
+func attestationPolicyID(policy *confidentialcontainersorgv1alpha1.AttestationPolicy) string {
+	if policy.Spec.PolicyID != "" {
+		return policy.Spec.PolicyID
+	}
+	return policy.Name
+}
+
+// attestationPolicyFilename returns the file name of a policy in the attestation service policy directory
+func attestationPolicyFilename(policyID string) string {
+	return policyID + ".rego"
+}
+
+// servesAttestationPolicy returns true if the KbsConfig with the given name serves the AttestationPolicy
+func servesAttestationPolicy(kbsConfigName string, policy *confidentialcontainersorgv1alpha1.AttestationPolicy) bool {
+	return policy.Spec.KbsConfigName == "" || policy.Spec.KbsConfigName == kbsConfigName
+}
+
+// compileAttestationPolicy parses and compiles a Rego attestation policy, the returned error
+// holds the compiler errors
+func compileAttestationPolicy(policy *confidentialcontainersorgv1alpha1.AttestationPolicy) error {
+	filename := attestationPolicyFilename(attestationPolicyID(policy))
+	module, err := ast.ParseModuleWithOpts(filename, policy.Spec.Policy, ast.ParserOptions{Capabilities: attestationPolicyCapabilities})
+	if err != nil {
+		return err
+	}
+	if module.Package.Path.String() != attestationPolicyPackage {
+		return fmt.Errorf("%s: package %s, the attestation service expects package policy", filename, module.Package.Path)
+	}
+	compiler := ast.NewCompiler().WithCapabilities(attestationPolicyCapabilities).WithEnablePrintStatements(true)
+	if compiler.Compile(map[string]*ast.Module{filename: module}); compiler.Failed() {
+		return compiler.Errors
+	}
+	return nil
+}
+
+// conflictingAttestationPolicy returns the AttestationPolicy that takes the policy ID of the given one
+// on the KbsConfig, or nil. The oldest AttestationPolicy wins, the name breaks ties
+func conflictingAttestationPolicy(policy *confidentialcontainersorgv1alpha1.AttestationPolicy, policies []confidentialcontainersorgv1alpha1.AttestationPolicy, kbsConfigName string) *confidentialcontainersorgv1alpha1.AttestationPolicy {
+	id := attestationPolicyID(policy)
+	for i := range policies {
+		other := &policies[i]
+		if other.Name == policy.Name || !servesAttestationPolicy(kbsConfigName, other) || attestationPolicyID(other) != id {
+			continue
+		}
+		if other.CreationTimestamp.Before(&policy.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&policy.CreationTimestamp) && other.Name < policy.Name) {
+			return other
+		}
+	}
+	return nil
+}
+
+// getAttestationPoliciesConfigMapName returns the name of the ConfigMap holding the AttestationPolicies of the KbsConfig
+func getAttestationPoliciesConfigMapName(kbsConfigName string) string {
+	return kbsConfigName + "-attestation-policies"
+}
+
+// deployOrUpdateAttestationPolicies stores the AttestationPolicies served by the KbsConfig in a ConfigMap
+// mounted in the attestation service policy directory. A policy that does not compile is not rolled out,
+// the last valid version of its file is kept
+func (r *KbsConfigReconciler) deployOrUpdateAttestationPolicies(ctx context.Context) error {
+	policyList := &confidentialcontainersorgv1alpha1.AttestationPolicyList{}
+	if err := r.List(ctx, policyList, client.InNamespace(r.namespace)); err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: getAttestationPoliciesConfigMapName(r.kbsConfig.Name), Namespace: r.namespace}}
+	previous := map[string]string{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(configMap), configMap); err == nil {
+		previous = configMap.Data
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	data := make(map[string]string)
+	for i := range policyList.Items {
+		policy := &policyList.Items[i]
+		if !servesAttestationPolicy(r.kbsConfig.Name, policy) || policy.DeletionTimestamp != nil {
+			continue
+		}
+		if other := conflictingAttestationPolicy(policy, policyList.Items, r.kbsConfig.Name); other != nil {
+			r.log.Info("Skipping AttestationPolicy, its policy ID is served by another AttestationPolicy", "AttestationPolicy.Name", policy.Name, "other", other.Name)
+			continue
+		}
+		filename := attestationPolicyFilename(attestationPolicyID(policy))
+		if err := compileAttestationPolicy(policy); err != nil {
+			r.log.Info("AttestationPolicy does not compile, keeping its last valid version", "AttestationPolicy.Name", policy.Name, "err", err)
+			if content, ok := previous[filename]; ok {
+				data[filename] = content
+			}
+			continue
+		}
+		data[filename] = policy.Spec.Policy
+	}
+
+	if len(data) == 0 {
+		return r.deleteOwnedObject(ctx, configMap)
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Labels == nil {
+			configMap.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, "attestation-policies") {
+			configMap.Labels[k] = v
+		}
+		configMap.Data = data
+		return ctrl.SetControllerReference(r.kbsConfig, configMap, r.Scheme)
+	})
+	return err
+}
+
+// createAttestationPoliciesVolume returns the volume holding the AttestationPolicies of the KbsConfig
+// and a mount per policy file, or nil when the KbsConfig serves no AttestationPolicy
+func (r *KbsConfigReconciler) createAttestationPoliciesVolume(ctx context.Context) (*corev1.Volume, []corev1.VolumeMount, error) {
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: getAttestationPoliciesConfigMapName(r.kbsConfig.Name)}, configMap)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	volume, err := r.createConfigMapVolume(ctx, attestationPoliciesVolume, configMap.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	filenames := make([]string, 0, len(configMap.Data))
+	for filename := range configMap.Data {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+	volumeMounts := make([]corev1.VolumeMount, 0, len(filenames))
+	for _, filename := range filenames {
+		volumeMounts = append(volumeMounts, createVolumeMountWithSubpath(volume.Name, filepath.Join(attestationPolicyPath, filename), filename))
+	}
+	return volume, volumeMounts, nil
+}
+
+// attestationPoliciesReplace returns true if an AttestationPolicy of the KbsConfig replaces the given policy file
+func attestationPoliciesReplace(volumeMounts []corev1.VolumeMount, filename string) bool {
+	for _, volumeMount := range volumeMounts {
+		if volumeMount.SubPath == filename {
+			return true
+		}
+	}
+	return false
+}
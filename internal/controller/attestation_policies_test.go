@@ -0,0 +1,168 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	testValidAttestationPolicy = `package policy
+
+import rego.v1
+
+default executables := 33
+
+executables := 3 if {
+  input.sample.launch_digest in query_reference_value("launch_digest")
+}
+`
+	testInvalidAttestationPolicy = `package policy
+
+executables := 3 if {
+  input.sample.launch_digest in
+}
+`
+)
+
+// newTestAttestationPolicy returns an AttestationPolicy created at the given time
+func newTestAttestationPolicy(name string, created time.Time, spec confidentialcontainersorgv1alpha1.AttestationPolicySpec) *confidentialcontainersorgv1alpha1.AttestationPolicy {
+	return &confidentialcontainersorgv1alpha1.AttestationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, CreationTimestamp: metav1.NewTime(created)},
+		Spec:       spec,
+	}
+}
+
+func TestCompileAttestationPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{name: "valid", policy: testValidAttestationPolicy},
+		{name: "syntax error", policy: testInvalidAttestationPolicy, wantErr: "rego_parse_error"},
+		{name: "unknown function", policy: "package policy\n\nimport rego.v1\n\nallow if unknown_function(input)\n", wantErr: "undefined function"},
+		{name: "empty", policy: "# only a comment\n", wantErr: "empty module"},
+		{name: "wrong package", policy: "package other\n\nimport rego.v1\n\nallow := true\n", wantErr: "expects package policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compileAttestationPolicy(newTestAttestationPolicy("policy", time.Now(), confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: tt.policy}))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// the default policies generated by the operator compile
+	for _, filename := range []string{"ear_default_attestation_policy_cpu.rego", "ear_default_attestation_policy_gpu.rego"} {
+		content, err := os.ReadFile("../../config/templates/" + filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compileAttestationPolicy(newTestAttestationPolicy("default", time.Now(), confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: string(content)})); err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+	}
+}
+
+func TestDeployOrUpdateAttestationPolicies(t *testing.T) {
+	now := time.Now()
+	kbsConfig := newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{KbsAttestationPolicyConfigMapName: "cpu-policy"})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cpu-policy", Namespace: testNamespace},
+			Data:       map[string]string{defaultAttestationCpuPolicy: testValidAttestationPolicy},
+		},
+		newTestAttestationPolicy("custom", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: testValidAttestationPolicy}),
+		newTestAttestationPolicy("cpu", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{PolicyID: "default_cpu", Policy: testValidAttestationPolicy}),
+		// same policy ID as custom but created later, it loses the conflict
+		newTestAttestationPolicy("custom-copy", now.Add(time.Minute), confidentialcontainersorgv1alpha1.AttestationPolicySpec{PolicyID: "custom", Policy: "package policy\n"}),
+		newTestAttestationPolicy("invalid", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: testInvalidAttestationPolicy}),
+		newTestAttestationPolicy("other", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{KbsConfigName: "other-kbs", Policy: testValidAttestationPolicy}),
+	)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateAttestationPolicies(ctx); err != nil {
+		t.Fatal(err)
+	}
+	configMap := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: testNamespace, Name: "kbs-attestation-policies"}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if len(configMap.Data) != 2 || configMap.Data["custom.rego"] != testValidAttestationPolicy || configMap.Data["default_cpu.rego"] != testValidAttestationPolicy {
+		t.Errorf("unexpected attestation policies %v", configMap.Data)
+	}
+
+	volume, volumeMounts, err := r.createAttestationPoliciesVolume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume == nil || len(volumeMounts) != 2 || volumeMounts[0].MountPath != attestationPolicyPath+"/custom.rego" {
+		t.Fatalf("unexpected attestation policies volume %+v %+v", volume, volumeMounts)
+	}
+	if !attestationPoliciesReplace(volumeMounts, defaultAttestationCpuPolicy) || attestationPoliciesReplace(volumeMounts, defaultAttestationGpuPolicy) {
+		t.Error("expected the AttestationPolicy to replace the default CPU policy only")
+	}
+
+	// an invalid update is not rolled out
+	policy := &confidentialcontainersorgv1alpha1.AttestationPolicy{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "custom"}, policy); err != nil {
+		t.Fatal(err)
+	}
+	policy.Spec.Policy = testInvalidAttestationPolicy
+	if err := r.Update(ctx, policy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.deployOrUpdateAttestationPolicies(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if configMap.Data["custom.rego"] != testValidAttestationPolicy {
+		t.Errorf("expected the last valid policy to be kept, got %q", configMap.Data["custom.rego"])
+	}
+
+	// the ConfigMap is removed once nothing is served
+	for _, name := range []string{"custom", "cpu", "custom-copy", "invalid"} {
+		if err := r.Delete(ctx, &confidentialcontainersorgv1alpha1.AttestationPolicy{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.deployOrUpdateAttestationPolicies(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, &corev1.ConfigMap{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the attestation policies ConfigMap to be deleted, got %v", err)
+	}
+}
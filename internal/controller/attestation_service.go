@@ -0,0 +1,214 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// attestation_service.type of the KBS configuration
+	kbsAttestationServiceGrpc = "coco_as_grpc"
+	kbsAttestationServiceIta  = "intel_ta"
+
+	// Reason used in the KbsConfig conditions
+	reasonKbsConfigSecretFailed = "KbsConfigSecretReconcileFailed"
+)
+
+// AttestationServiceTemplateData holds the external attestation service settings of the KBS config templates
+type AttestationServiceTemplateData struct {
+	// Type is the attestation_service.type of the KBS configuration
+	Type string
+	// Address of the gRPC attestation service
+	Address string
+	// BaseURL and CertsURL of the Intel Trust Authority
+	BaseURL  string
+	CertsURL string
+}
+
+// attestationServiceType returns the attestation service of the TrusteeConfig
+func attestationServiceType(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) confidentialcontainersorgv1alpha1.AttestationServiceType {
+	if spec.AttestationService == nil || spec.AttestationService.Type == "" {
+		return confidentialcontainersorgv1alpha1.AttestationServiceBuiltin
+	}
+	return spec.AttestationService.Type
+}
+
+// attestationServiceTemplateData returns the template data of the external attestation service of the
+// TrusteeConfig, or nil for the attestation service built into KBS. With the MicroservicesDeployment
+// topology, the builtin attestation service is the gRPC one running next to KBS
+func attestationServiceTemplateData(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) *AttestationServiceTemplateData {
+	as := spec.AttestationService
+	switch attestationServiceType(spec) {
+	case confidentialcontainersorgv1alpha1.AttestationServiceBuiltin:
+		if kbsDeploymentType(spec) == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+			return &AttestationServiceTemplateData{Type: kbsAttestationServiceGrpc, Address: localGrpcAddress(asGrpcPort)}
+		}
+	case confidentialcontainersorgv1alpha1.AttestationServiceRemote:
+		if as.Remote == nil {
+			return nil
+		}
+		return &AttestationServiceTemplateData{Type: kbsAttestationServiceGrpc, Address: as.Remote.Address}
+	case confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority:
+		if as.IntelTrustAuthority == nil {
+			return nil
+		}
+		return &AttestationServiceTemplateData{
+			Type:     kbsAttestationServiceIta,
+			BaseURL:  as.IntelTrustAuthority.BaseURL,
+			CertsURL: as.IntelTrustAuthority.CertsURL,
+		}
+	}
+	return nil
+}
+
+// itaApiKeySecretRef returns the Intel Trust Authority API key of the TrusteeConfig, or nil
+func itaApiKeySecretRef(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) *corev1.SecretKeySelector {
+	if attestationServiceType(spec) != confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority ||
+		spec.AttestationService.IntelTrustAuthority == nil {
+		return nil
+	}
+	return spec.AttestationService.IntelTrustAuthority.APIKeySecretRef.DeepCopy()
+}
+
+// kbsImageName returns the KBS image matching the deployment type and the attestation service of the
+// KBS configuration: the builtin attestation service and the gRPC client are built in different images
+func kbsImageName(kbsDeploymentType confidentialcontainersorgv1alpha1.DeploymentType, asType string) string {
+	var imageName string
+	switch {
+	case asType == kbsAttestationServiceIta:
+		imageName = os.Getenv("KBS_IMAGE_NAME_ITA")
+		if imageName == "" {
+			imageName = DefaultKbsItaImageName
+		}
+		return imageName
+	case kbsDeploymentType != confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne || asType == kbsAttestationServiceGrpc:
+		imageName = os.Getenv("KBS_IMAGE_NAME_MICROSERVICES")
+	default:
+		imageName = os.Getenv("KBS_IMAGE_NAME")
+	}
+	if imageName == "" {
+		imageName = DefaultKbsImageName
+	}
+	return imageName
+}
+
+// kbsAttestationServiceType returns the attestation_service.type of the configuration of the KbsConfig,
+// or an empty string when it cannot be read
+func (r *KbsConfigReconciler) kbsAttestationServiceType(ctx context.Context) string {
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.kbsConfig.Spec.KbsConfigMapName}, configMap); err != nil {
+		return ""
+	}
+	tree, err := decodeTomlTree(configMap.Data[kbsConfigTomlKey])
+	if err != nil {
+		return ""
+	}
+	asType, _ := getTomlPath(tree, []string{"attestation_service", "type"}).(string)
+	return asType
+}
+
+// getKbsConfigSecretName returns the name of the Secret holding the KBS configuration with its credentials
+func getKbsConfigSecretName(kbsConfigName string) string {
+	return kbsConfigName + "-kbs-config"
+}
+
+// kbsConfigSecretNeeded returns true when the KBS configuration holds credentials, the Intel Trust Authority
+// API key or the password of the shared storage, and is mounted from a Secret
+func kbsConfigSecretNeeded(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) bool {
+	return spec.KbsItaApiKeySecretRef != nil || spec.KbsSharedStorageSpec != nil
+}
+
+// deployOrUpdateKbsConfigSecret writes the configuration of KbsConfigMapName with the Intel Trust Authority
+// API key and the shared storage credentials to a Secret mounted in its place, so that they are not stored
+// in a ConfigMap
+func (r *KbsConfigReconciler) deployOrUpdateKbsConfigSecret(ctx context.Context) error {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getKbsConfigSecretName(r.kbsConfig.Name), Namespace: r.namespace}}
+	if !kbsConfigSecretNeeded(r.kbsConfig.Spec) {
+		return r.deleteOwnedObject(ctx, secret)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.kbsConfig.Spec.KbsConfigMapName}, configMap); err != nil {
+		return err
+	}
+	content, ok := configMap.Data[kbsConfigTomlKey]
+	if !ok {
+		return fmt.Errorf("ConfigMap %s has no %s", configMap.Name, kbsConfigTomlKey)
+	}
+	doc, err := parseTomlDocument(content)
+	if err != nil {
+		return fmt.Errorf("ConfigMap %s: %w", configMap.Name, err)
+	}
+
+	if ref := r.kbsConfig.Spec.KbsItaApiKeySecretRef; ref != nil {
+		apiKeySecret := &corev1.Secret{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: ref.Name}, apiKeySecret); err != nil {
+			return err
+		}
+		apiKey, ok := apiKeySecret.Data[ref.Key]
+		if !ok {
+			return fmt.Errorf("Secret %s has no key %s", ref.Name, ref.Key)
+		}
+		if err := doc.set([]string{"attestation_service", "api_key"}, strings.TrimSpace(string(apiKey))); err != nil {
+			return err
+		}
+	}
+
+	conn, err := r.sharedStorageConnection(ctx)
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		if err := setPostgresStorageBackend(doc, conn); err != nil {
+			return err
+		}
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, "kbs-config") {
+			secret.Labels[k] = v
+		}
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = map[string][]byte{kbsConfigTomlKey: []byte(doc.String())}
+		return ctrl.SetControllerReference(r.kbsConfig, secret, r.Scheme)
+	})
+	return err
+}
+
+// createKbsConfigVolume returns the volume of the KBS configuration, the Secret holding its credentials when
+// there is one
+func (r *KbsConfigReconciler) createKbsConfigVolume(ctx context.Context) (*corev1.Volume, error) {
+	if kbsConfigSecretNeeded(r.kbsConfig.Spec) {
+		return r.createSecretVolume(ctx, "kbs-config", getKbsConfigSecretName(r.kbsConfig.Name))
+	}
+	return r.createConfigMapVolume(ctx, "kbs-config", r.kbsConfig.Spec.KbsConfigMapName)
+}
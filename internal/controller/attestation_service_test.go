@@ -0,0 +1,192 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// renderKbsTemplate renders a KBS config template with the attestation service of the TrusteeConfig spec
+func renderKbsTemplate(t *testing.T, filename string, spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) map[string]interface{} {
+	t.Helper()
+	content, err := os.ReadFile("../../config/templates/" + filename)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+	data := GetTLSConfigFromTlsConfig(nil)
+	data.AttestationService = attestationServiceTemplateData(spec)
+	var buf bytes.Buffer
+	if err := template.Must(template.New(filename).Parse(string(content))).Execute(&buf, data); err != nil {
+		t.Fatalf("Failed to render %s: %v", filename, err)
+	}
+	if _, err := parseKbsTomlConfig(buf.String()); err != nil {
+		t.Fatalf("%s: parseKbsTomlConfig() error = %v:\n%s", filename, err, buf.String())
+	}
+	tree, err := decodeTomlTree(buf.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestKbsTomlTemplatesAttestationService(t *testing.T) {
+	remote := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+			Type:   confidentialcontainersorgv1alpha1.AttestationServiceRemote,
+			Remote: &confidentialcontainersorgv1alpha1.RemoteAttestationServiceSpec{Address: "http://coco-as:50004"},
+		},
+	}
+	ita := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+			Type: confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority,
+			IntelTrustAuthority: &confidentialcontainersorgv1alpha1.IntelTrustAuthoritySpec{
+				BaseURL:         "https://api.trustauthority.intel.com",
+				CertsURL:        "https://portal.trustauthority.intel.com",
+				APIKeySecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ita"}, Key: "api-key"},
+			},
+		},
+	}
+
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		builtin := renderKbsTemplate(t, filename, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{})
+		if got := getTomlPath(builtin, []string{"attestation_service", "type"}); got != "coco_as_builtin" {
+			t.Errorf("%s: expected the builtin attestation service, got %v", filename, got)
+		}
+		if getTomlPath(builtin, []string{"attestation_service", "rvps_config"}) == nil {
+			t.Errorf("%s: expected the builtin RVPS configuration", filename)
+		}
+
+		tree := renderKbsTemplate(t, filename, remote)
+		want := map[string]interface{}{"type": "coco_as_grpc", "as_addr": "http://coco-as:50004"}
+		if got := getTomlPath(tree, []string{"attestation_service"}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected the gRPC attestation service %v, got %v", filename, want, got)
+		}
+
+		tree = renderKbsTemplate(t, filename, ita)
+		as, _ := getTomlPath(tree, []string{"attestation_service"}).(map[string]interface{})
+		if as["type"] != "intel_ta" || as["base_url"] != "https://api.trustauthority.intel.com" || as["api_key"] != "" {
+			t.Errorf("%s: expected the Intel Trust Authority, got %v", filename, as)
+		}
+		jwks := getTomlPath(tree, []string{"attestation_token", "trusted_jwk_sets"})
+		if !reflect.DeepEqual(jwks, []interface{}{"https://portal.trustauthority.intel.com"}) {
+			t.Errorf("%s: expected the Intel Trust Authority JWK set, got %v", filename, jwks)
+		}
+		if getTomlPath(tree, []string{"attestation_token", "trusted_certs_paths"}) != nil {
+			t.Errorf("%s: expected no trusted certificate of the builtin attestation service", filename)
+		}
+	}
+}
+
+func TestKbsImageName(t *testing.T) {
+	t.Setenv("KBS_IMAGE_NAME", "kbs-builtin")
+	t.Setenv("KBS_IMAGE_NAME_MICROSERVICES", "kbs-grpc")
+	t.Setenv("KBS_IMAGE_NAME_ITA", "kbs-ita")
+
+	tests := []struct {
+		deploymentType confidentialcontainersorgv1alpha1.DeploymentType
+		asType         string
+		want           string
+	}{
+		{confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne, "coco_as_builtin", "kbs-builtin"},
+		{confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne, "", "kbs-builtin"},
+		{confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne, "coco_as_grpc", "kbs-grpc"},
+		{confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices, "coco_as_grpc", "kbs-grpc"},
+		{confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne, "intel_ta", "kbs-ita"},
+	}
+	for _, tt := range tests {
+		if got := kbsImageName(tt.deploymentType, tt.asType); got != tt.want {
+			t.Errorf("kbsImageName(%s, %q) = %s, want %s", tt.deploymentType, tt.asType, got, tt.want)
+		}
+	}
+
+	t.Setenv("KBS_IMAGE_NAME_ITA", "")
+	if got := kbsImageName(confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne, "intel_ta"); got != DefaultKbsItaImageName {
+		t.Errorf("expected the default ITA image, got %s", got)
+	}
+}
+
+func TestDeployOrUpdateKbsConfigSecret(t *testing.T) {
+	ctx := context.Background()
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace},
+		Data: map[string]string{kbsConfigTomlKey: "[attestation_service]\n" +
+			"type = \"intel_ta\"\nbase_url = \"https://api.trustauthority.intel.com\"\n" +
+			"# Set by the operator from the API key Secret\napi_key = \"\"\n"},
+	}
+	apiKey := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "ita", Namespace: testNamespace},
+		Data:       map[string][]byte{"api-key": []byte("secret-key\n")},
+	}
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName: "kbs-config",
+		KbsItaApiKeySecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "ita"}, Key: "api-key",
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig, configMap, apiKey)
+
+	if err := r.deployOrUpdateKbsConfigSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: getKbsConfigSecretName("tenant-a")}, secret); err != nil {
+		t.Fatal(err)
+	}
+	want := "[attestation_service]\ntype = \"intel_ta\"\nbase_url = \"https://api.trustauthority.intel.com\"\n" +
+		"# Set by the operator from the API key Secret\napi_key = \"secret-key\"\n"
+	if got := string(secret.Data[kbsConfigTomlKey]); got != want {
+		t.Errorf("expected the configuration with the API key:\n%s\ngot:\n%s", want, got)
+	}
+	volume, err := r.createKbsConfigVolume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume.Secret == nil || volume.Secret.SecretName != secret.Name {
+		t.Errorf("expected the configuration to be mounted from %s, got %v", secret.Name, volume.VolumeSource)
+	}
+	if got := r.kbsAttestationServiceType(ctx); got != kbsAttestationServiceIta {
+		t.Errorf("expected the intel_ta attestation service, got %q", got)
+	}
+
+	// A missing API key is reported
+	kbsConfig.Spec.KbsItaApiKeySecretRef.Key = "token"
+	if err := r.deployOrUpdateKbsConfigSecret(ctx); err == nil {
+		t.Error("expected an error for a missing API key")
+	}
+
+	// The secret is deleted once the API key is not referenced anymore
+	kbsConfig.Spec.KbsItaApiKeySecretRef = nil
+	if err := r.deployOrUpdateKbsConfigSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the KBS configuration secret to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// AttestationPolicyReconciler compiles the AttestationPolicies and reports their state,
+// the valid policies are rolled out by the KbsConfig controller
+type AttestationPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	log    logr.Logger
+}
+
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=attestationpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=attestationpolicies/status,verbs=get;update;patch
+
+// Reconcile updates the status of an AttestationPolicy
+func (r *AttestationPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.log = log.FromContext(ctx)
+
+	policy := &confidentialcontainersorgv1alpha1.AttestationPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		r.log.Error(err, "Failed to get AttestationPolicy")
+		return ctrl.Result{}, err
+	}
+
+	status, err := r.attestationPolicyStatus(ctx, policy)
+	if err != nil {
+		r.log.Error(err, "Failed to compute AttestationPolicy status")
+		return ctrl.Result{}, err
+	}
+	if apiequality.Semantic.DeepEqual(policy.Status, *status) {
+		return ctrl.Result{}, nil
+	}
+	policy.Status = *status
+	if err := r.Status().Update(ctx, policy); err != nil {
+		r.log.Error(err, "Failed to update AttestationPolicy status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// attestationPolicyStatus returns the status of an AttestationPolicy: its policy ID, whether it compiles,
+// and the KbsConfigs serving it
+func (r *AttestationPolicyReconciler) attestationPolicyStatus(ctx context.Context, policy *confidentialcontainersorgv1alpha1.AttestationPolicy) (*confidentialcontainersorgv1alpha1.AttestationPolicyStatus, error) {
+	status := policy.Status.DeepCopy()
+	status.ObservedGeneration = policy.Generation
+	status.PolicyID = attestationPolicyID(policy)
+
+	valid := metav1.Condition{
+		Type:               confidentialcontainersorgv1alpha1.AttestationPolicyConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonPolicyCompiled,
+		Message:            "Policy compiled successfully",
+		ObservedGeneration: policy.Generation,
+	}
+	if err := compileAttestationPolicy(policy); err != nil {
+		valid.Status, valid.Reason = metav1.ConditionFalse, reasonCompilationFailed
+		valid.Message = fmt.Sprintf("The policy is not rolled out: %v", err)
+	}
+	meta.SetStatusCondition(&status.Conditions, valid)
+
+	kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+	if err := r.List(ctx, kbsConfigList, client.InNamespace(policy.Namespace)); err != nil {
+		return nil, err
+	}
+	policyList := &confidentialcontainersorgv1alpha1.AttestationPolicyList{}
+	if err := r.List(ctx, policyList, client.InNamespace(policy.Namespace)); err != nil {
+		return nil, err
+	}
+
+	status.KbsConfigs = nil
+	var conflicts []string
+	for _, kbsConfig := range kbsConfigList.Items {
+		if !servesAttestationPolicy(kbsConfig.Name, policy) {
+			continue
+		}
+		if other := conflictingAttestationPolicy(policy, policyList.Items, kbsConfig.Name); other != nil {
+			conflicts = append(conflicts, fmt.Sprintf("AttestationPolicy %s on KbsConfig %s", other.Name, kbsConfig.Name))
+			continue
+		}
+		status.KbsConfigs = append(status.KbsConfigs, kbsConfig.Name)
+	}
+	sort.Strings(status.KbsConfigs)
+
+	ready := metav1.Condition{
+		Type:               confidentialcontainersorgv1alpha1.AttestationPolicyConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonPolicyServed,
+		Message:            fmt.Sprintf("Policy %s served by %v", status.PolicyID, status.KbsConfigs),
+		ObservedGeneration: policy.Generation,
+	}
+	if len(conflicts) > 0 {
+		ready.Status, ready.Reason = metav1.ConditionFalse, reasonPolicyIDConflict
+		ready.Message = fmt.Sprintf("Policy ID %s is already served by %v", status.PolicyID, conflicts)
+	} else if len(status.KbsConfigs) == 0 {
+		ready.Status, ready.Reason = metav1.ConditionFalse, reasonNoKbsConfig
+		if policy.Spec.KbsConfigName != "" {
+			ready.Message = fmt.Sprintf("KbsConfig %s not found in namespace %s", policy.Spec.KbsConfigName, policy.Namespace)
+		} else {
+			ready.Message = fmt.Sprintf("No KbsConfig in namespace %s", policy.Namespace)
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+	return status, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *AttestationPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&confidentialcontainersorgv1alpha1.AttestationPolicy{}).
+		// A new or deleted KbsConfig changes where the policies are served
+		Watches(
+			&confidentialcontainersorgv1alpha1.KbsConfig{},
+			handler.EnqueueRequestsFromMapFunc(namespaceToAttestationPolicyMapper(mgr.GetClient())),
+		).
+		// An AttestationPolicy leaving a policy ID hands it over to another one
+		Watches(
+			&confidentialcontainersorgv1alpha1.AttestationPolicy{},
+			handler.EnqueueRequestsFromMapFunc(namespaceToAttestationPolicyMapper(mgr.GetClient())),
+		).
+		Complete(r)
+}
+
+// namespaceToAttestationPolicyMapper maps an object to all the AttestationPolicies of its namespace
+func namespaceToAttestationPolicyMapper(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		policyList := &confidentialcontainersorgv1alpha1.AttestationPolicyList{}
+		if err := c.List(ctx, policyList, client.InNamespace(o.GetNamespace())); err != nil {
+			ctrl.Log.WithName("attestationpolicy-controller").Info("Error in listing AttestationPolicy", "err", err)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(policyList.Items))
+		for _, policy := range policyList.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name},
+			})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestAttestationPolicyReconcile(t *testing.T) {
+	now := time.Now()
+	scheme := newTestScheme(t)
+	objs := []client.Object{
+		newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{}),
+		newTestAttestationPolicy("custom", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: testValidAttestationPolicy}),
+		newTestAttestationPolicy("custom-copy", now.Add(time.Minute), confidentialcontainersorgv1alpha1.AttestationPolicySpec{PolicyID: "custom", Policy: testValidAttestationPolicy}),
+		newTestAttestationPolicy("invalid", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{Policy: testInvalidAttestationPolicy}),
+		newTestAttestationPolicy("other", now, confidentialcontainersorgv1alpha1.AttestationPolicySpec{KbsConfigName: "absent", Policy: testValidAttestationPolicy}),
+	}
+	r := &AttestationPolicyReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+			WithStatusSubresource(&confidentialcontainersorgv1alpha1.AttestationPolicy{}).Build(),
+		Scheme: scheme,
+		log:    logr.Discard(),
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		policyID    string
+		valid       bool
+		readyReason string
+	}{
+		{name: "custom", policyID: "custom", valid: true, readyReason: reasonPolicyServed},
+		{name: "custom-copy", policyID: "custom", valid: true, readyReason: reasonPolicyIDConflict},
+		{name: "invalid", policyID: "invalid", readyReason: reasonPolicyServed},
+		{name: "other", policyID: "other", valid: true, readyReason: reasonNoKbsConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := types.NamespacedName{Namespace: testNamespace, Name: tt.name}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+			policy := &confidentialcontainersorgv1alpha1.AttestationPolicy{}
+			if err := r.Get(ctx, key, policy); err != nil {
+				t.Fatal(err)
+			}
+			if policy.Status.PolicyID != tt.policyID {
+				t.Errorf("expected policy ID %s, got %s", tt.policyID, policy.Status.PolicyID)
+			}
+			valid := meta.FindStatusCondition(policy.Status.Conditions, confidentialcontainersorgv1alpha1.AttestationPolicyConditionValid)
+			if valid == nil || (valid.Status == metav1.ConditionTrue) != tt.valid {
+				t.Fatalf("expected Valid=%v, got %+v", tt.valid, valid)
+			}
+			if !tt.valid && (valid.Reason != reasonCompilationFailed || !strings.Contains(valid.Message, "rego_parse_error")) {
+				t.Errorf("expected the compiler errors in the Valid condition, got %s: %s", valid.Reason, valid.Message)
+			}
+			ready := meta.FindStatusCondition(policy.Status.Conditions, confidentialcontainersorgv1alpha1.AttestationPolicyConditionReady)
+			if ready == nil || ready.Reason != tt.readyReason {
+				t.Errorf("expected a Ready condition with reason %s, got %+v", tt.readyReason, ready)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// getHttpsCertificateName returns the name for the cert-manager Certificate of the KBS serving certificate
+func (r *TrusteeConfigReconciler) getHttpsCertificateName() string {
+	return r.trusteeConfig.Name + "-https-certificate"
+}
+
+// getHttpsCertManagerSecretName returns the name for the secret cert-manager stores the KBS serving certificate in
+func getHttpsCertManagerSecretName(trusteeConfigName string) string {
+	return trusteeConfigName + "-https-tls"
+}
+
+// getAttestationCertificateName returns the name for the cert-manager Certificate of the attestation token certificate
+func (r *TrusteeConfigReconciler) getAttestationCertificateName() string {
+	return r.trusteeConfig.Name + "-attestation-certificate"
+}
+
+// getAttestationCertManagerSecretName returns the name for the secret cert-manager stores the attestation token certificate in
+func getAttestationCertManagerSecretName(trusteeConfigName string) string {
+	return trusteeConfigName + "-attestation-tls"
+}
+
+// mutateCertManagerCertificate sets the desired state on a cert-manager Certificate
+func mutateCertManagerCertificate(certificate *certmanagerv1.Certificate, spec *confidentialcontainersorgv1alpha1.CertManagerCertificateSpec, secretName, commonName string, dnsNames, ipAddresses []string, usages []certmanagerv1.KeyUsage) {
+	certificate.Spec = certmanagerv1.CertificateSpec{
+		SecretName:  secretName,
+		CommonName:  commonName,
+		DNSNames:    dnsNames,
+		IPAddresses: ipAddresses,
+		Duration:    spec.Duration,
+		RenewBefore: spec.RenewBefore,
+		IssuerRef: cmmeta.IssuerReference{
+			Name:  spec.IssuerRef.Name,
+			Kind:  spec.IssuerRef.Kind,
+			Group: spec.IssuerRef.Group,
+		},
+		Usages: usages,
+		// KBS loads PKCS#8 ECDSA keys for both the HTTPS server and the token signer
+		PrivateKey: &certmanagerv1.CertificatePrivateKey{
+			Algorithm:      certmanagerv1.ECDSAKeyAlgorithm,
+			Encoding:       certmanagerv1.PKCS8,
+			Size:           256,
+			RotationPolicy: certmanagerv1.RotationPolicyAlways,
+		},
+	}
+}
+
+// createOrUpdateCertManagerCertificate creates or updates a cert-manager Certificate owned by the TrusteeConfig
+func (r *TrusteeConfigReconciler) createOrUpdateCertManagerCertificate(ctx context.Context, certificate *certmanagerv1.Certificate, mutate func()) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, certificate, func() error {
+		if certificate.GetResourceVersion() != "" && !metav1.IsControlledBy(certificate, r.trusteeConfig) {
+			return fmt.Errorf("Certificate %s/%s already exists and is not managed by TrusteeConfig %s", certificate.Namespace, certificate.Name, r.trusteeConfig.Name)
+		}
+		mutate()
+		if certificate.Labels == nil {
+			certificate.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "certificate") {
+			certificate.Labels[k] = v
+		}
+		return ctrl.SetControllerReference(r.trusteeConfig, certificate, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		return fmt.Errorf("cert-manager is not installed in this cluster: %w", err)
+	} else if err != nil {
+		return err
+	}
+	if result != controllerutil.OperationResultNone {
+		r.log.Info("Reconciled cert-manager Certificate", "Certificate.Namespace", certificate.Namespace, "Certificate.Name", certificate.Name, "operation", result)
+	}
+	return nil
+}
+
+// issuedCertManagerSecret returns the secret issued by cert-manager, or an error while the certificate is pending
+func (r *TrusteeConfigReconciler) issuedCertManagerSecret(ctx context.Context, certificate *certmanagerv1.Certificate) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: certificate.Spec.SecretName}, secret)
+	if k8serrors.IsNotFound(err) || (err == nil && (len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0)) {
+		return nil, fmt.Errorf("waiting for cert-manager to issue Certificate %s/%s into secret %s", certificate.Namespace, certificate.Name, certificate.Spec.SecretName)
+	}
+	return secret, err
+}
+
+// certManagerCertificateStatus returns the status of a certificate issued by cert-manager
+func certManagerCertificateStatus(certificate *certmanagerv1.Certificate, secret *corev1.Secret) (*confidentialcontainersorgv1alpha1.CertificateStatus, error) {
+	leaf, err := parseCertificatePEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, fmt.Errorf("secret %s: %w", secret.Name, err)
+	}
+	return &confidentialcontainersorgv1alpha1.CertificateStatus{
+		NotAfter:    metav1.NewTime(leaf.NotAfter),
+		RenewalTime: certificate.Status.RenewalTime,
+		DNSNames:    leaf.DNSNames,
+	}, nil
+}
+
+// createOrUpdateCertManagerHttpsSecrets requests the KBS serving certificate from cert-manager and copies
+// the issued certificate into the HTTPS key and certificate secrets. The CA provided by the issuer, if any,
+// is published in the CA bundle ConfigMap
+func (r *TrusteeConfigReconciler) createOrUpdateCertManagerHttpsSecrets(ctx context.Context) error {
+	spec := r.trusteeConfig.Spec.HttpsSpec.CertManager
+	dnsNames, ips := r.kbsSubjectAltNames(ctx, spec.DNSNames)
+	ipAddresses := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		ipAddresses = append(ipAddresses, ip.String())
+	}
+
+	certificate := &certmanagerv1.Certificate{ObjectMeta: metav1.ObjectMeta{Name: r.getHttpsCertificateName(), Namespace: r.namespace}}
+	err := r.createOrUpdateCertManagerCertificate(ctx, certificate, func() {
+		mutateCertManagerCertificate(certificate, spec, getHttpsCertManagerSecretName(r.trusteeConfig.Name), dnsNames[0], dnsNames, ipAddresses,
+			[]certmanagerv1.KeyUsage{certmanagerv1.UsageDigitalSignature, certmanagerv1.UsageKeyEncipherment, certmanagerv1.UsageServerAuth})
+	})
+	if err != nil {
+		return err
+	}
+
+	secret, err := r.issuedCertManagerSecret(ctx, certificate)
+	if err != nil {
+		return err
+	}
+	if err = r.createOrUpdateHttpsSecrets(ctx, secret.Name); err != nil {
+		return err
+	}
+
+	status, err := certManagerCertificateStatus(certificate, secret)
+	if err != nil {
+		return err
+	}
+	if caPEM := secret.Data[caBundleKey]; len(caPEM) > 0 {
+		if err = r.createOrUpdateHttpsCABundleConfigMap(ctx, caPEM); err != nil {
+			return err
+		}
+		status.CABundleConfigMapName = r.getHttpsCABundleConfigMapName()
+	}
+	r.trusteeConfig.Status.HttpsCertificate = status
+	return nil
+}
+
+// createOrUpdateCertManagerAttestationSecrets requests the attestation token certificate from cert-manager
+// and copies the issued certificate into the attestation key and certificate secrets
+func (r *TrusteeConfigReconciler) createOrUpdateCertManagerAttestationSecrets(ctx context.Context) error {
+	spec := r.trusteeConfig.Spec.AttestationTokenVerificationSpec.CertManager
+
+	certificate := &certmanagerv1.Certificate{ObjectMeta: metav1.ObjectMeta{Name: r.getAttestationCertificateName(), Namespace: r.namespace}}
+	err := r.createOrUpdateCertManagerCertificate(ctx, certificate, func() {
+		mutateCertManagerCertificate(certificate, spec, getAttestationCertManagerSecretName(r.trusteeConfig.Name), r.trusteeConfig.Name+"-attestation-token", nil, nil,
+			[]certmanagerv1.KeyUsage{certmanagerv1.UsageDigitalSignature})
+	})
+	if err != nil {
+		return err
+	}
+
+	secret, err := r.issuedCertManagerSecret(ctx, certificate)
+	if err != nil {
+		return err
+	}
+	if err = r.createOrUpdateAttestationSecrets(ctx, secret.Name); err != nil {
+		return err
+	}
+
+	status, err := certManagerCertificateStatus(certificate, secret)
+	if err != nil {
+		return err
+	}
+	r.trusteeConfig.Status.AttestationTokenCertificate = status
+	return nil
+}
+
+// tlsSourceSecretNames returns the secrets the HTTPS and attestation secrets of a TrusteeConfig are copied from
+func tlsSourceSecretNames(trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) []string {
+	var names []string
+	httpsSpec := trusteeConfig.Spec.HttpsSpec
+	if httpsSpec.TlsSecretName != "" {
+		names = append(names, httpsSpec.TlsSecretName)
+	} else if httpsSpec.CertManager != nil {
+		names = append(names, getHttpsCertManagerSecretName(trusteeConfig.Name))
+	}
+	tokenSpec := trusteeConfig.Spec.AttestationTokenVerificationSpec
+	if tokenSpec.TlsSecretName != "" {
+		names = append(names, tokenSpec.TlsSecretName)
+	} else if tokenSpec.CertManager != nil {
+		names = append(names, getAttestationCertManagerSecretName(trusteeConfig.Name))
+	}
+	return names
+}
+
+// secretToTrusteeConfigMapper maps the TLS secrets, provided by the user or issued by cert-manager, and the
+// admin public key secrets to the TrusteeConfigs copying them, so that a renewed certificate or a replaced
+// public key is propagated to KBS
+func secretToTrusteeConfigMapper(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		trusteeConfigList := &confidentialcontainersorgv1alpha1.TrusteeConfigList{}
+		if err := c.List(ctx, trusteeConfigList, client.InNamespace(o.GetNamespace())); err != nil {
+			ctrl.Log.WithName("trusteeconfig-controller").Info("Error in listing TrusteeConfig", "err", err)
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for i := range trusteeConfigList.Items {
+			trusteeConfig := &trusteeConfigList.Items[i]
+			for _, name := range append(tlsSourceSecretNames(trusteeConfig), adminPublicKeySecretNames(trusteeConfig)...) {
+				if name == o.GetName() {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: types.NamespacedName{Namespace: trusteeConfig.Namespace, Name: trusteeConfig.Name},
+					})
+					break
+				}
+			}
+		}
+		return requests
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// issueTestCertificate stores a key pair signed by a test CA in the given secret, as cert-manager would
+func issueTestCertificate(t *testing.T, r *TrusteeConfigReconciler, secretName string, dnsNames []string) []byte {
+	t.Helper()
+	now := time.Now()
+	caPEM, caKeyPEM, err := newSelfSignedCA("test-issuer", now, confidentialcontainersorgv1alpha1.SelfSignedCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := parseCertificatePEM(caPEM)
+	caKey, _ := parsePrivateKeyPEM(caKeyPEM)
+	certPEM, keyPEM, err := newServingCertificate(ca, caKey, dnsNames, nil, now, confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: testNamespace}}
+	err = r.Get(context.Background(), client.ObjectKeyFromObject(secret), secret)
+	secret.Type = corev1.SecretTypeTLS
+	secret.Data = map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM, caBundleKey: caPEM}
+	if err == nil {
+		err = r.Update(context.Background(), secret)
+	} else {
+		err = r.Create(context.Background(), secret)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestCreateOrUpdateCertManagerHttpsSecrets(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		Profile: confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+		HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{
+			CertManager: &confidentialcontainersorgv1alpha1.CertManagerCertificateSpec{
+				IssuerRef: confidentialcontainersorgv1alpha1.IssuerReference{Name: "ca-issuer", Kind: "ClusterIssuer"},
+				DNSNames:  []string{"kbs.example.com"},
+			},
+		},
+	})
+	ctx := context.Background()
+
+	if r.isSelfSignedHttps() {
+		t.Fatal("expected cert-manager to take precedence over the self-signed certificate")
+	}
+	// the first reconcile requests the certificate and waits for it to be issued
+	err := r.createOrUpdateCertManagerHttpsSecrets(ctx)
+	if err == nil || !strings.Contains(err.Error(), "waiting for cert-manager") {
+		t.Fatalf("expected the reconcile to wait for the issued secret, got %v", err)
+	}
+
+	certificate := &certmanagerv1.Certificate{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "trustee-https-certificate"}, certificate); err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Spec.SecretName != "trustee-https-tls" || certificate.Spec.IssuerRef.Name != "ca-issuer" ||
+		certificate.Spec.IssuerRef.Kind != "ClusterIssuer" || !metav1.IsControlledBy(certificate, r.trusteeConfig) {
+		t.Errorf("unexpected Certificate %+v", certificate)
+	}
+	if key := certificate.Spec.PrivateKey; key == nil || key.Algorithm != certmanagerv1.ECDSAKeyAlgorithm || key.Encoding != certmanagerv1.PKCS8 {
+		t.Errorf("expected a PKCS#8 ECDSA private key, got %+v", key)
+	}
+	for _, name := range []string{"trustee-kbs-config-service.trustee-operator-system.svc", "kbs.example.com"} {
+		found := false
+		for _, dnsName := range certificate.Spec.DNSNames {
+			found = found || dnsName == name
+		}
+		if !found {
+			t.Errorf("expected %s in the Certificate DNS names %v", name, certificate.Spec.DNSNames)
+		}
+	}
+
+	// the issued certificate is copied into the KBS secrets
+	certPEM := issueTestCertificate(t, r, "trustee-https-tls", certificate.Spec.DNSNames)
+	if err := r.createOrUpdateCertManagerHttpsSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := servingCertificate(t, r); !bytes.Equal(current, certPEM) {
+		t.Error("expected the issued certificate in the HTTPS certificate secret")
+	}
+	status := r.trusteeConfig.Status.HttpsCertificate
+	if status == nil || status.CABundleConfigMapName != "trustee-https-ca-bundle" {
+		t.Errorf("unexpected certificate status %+v", status)
+	}
+
+	// a renewed certificate replaces the previous one
+	renewedPEM := issueTestCertificate(t, r, "trustee-https-tls", certificate.Spec.DNSNames)
+	if err := r.createOrUpdateCertManagerHttpsSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := servingCertificate(t, r); !bytes.Equal(current, renewedPEM) {
+		t.Error("expected the renewed certificate in the HTTPS certificate secret")
+	}
+}
+
+func TestCreateOrUpdateCertManagerAttestationSecrets(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AttestationTokenVerificationSpec: confidentialcontainersorgv1alpha1.AttestationTokenVerificationSpec{
+			CertManager: &confidentialcontainersorgv1alpha1.CertManagerCertificateSpec{
+				IssuerRef: confidentialcontainersorgv1alpha1.IssuerReference{Name: "token-issuer", Kind: "Issuer"},
+			},
+		},
+	})
+	ctx := context.Background()
+
+	if err := r.createOrUpdateCertManagerAttestationSecrets(ctx); err == nil {
+		t.Fatal("expected the reconcile to wait for the issued secret")
+	}
+	certificate := &certmanagerv1.Certificate{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "trustee-attestation-certificate"}, certificate); err != nil {
+		t.Fatal(err)
+	}
+	if certificate.Spec.SecretName != "trustee-attestation-tls" || certificate.Spec.CommonName != "trustee-attestation-token" {
+		t.Errorf("unexpected Certificate %+v", certificate.Spec)
+	}
+
+	certPEM := issueTestCertificate(t, r, "trustee-attestation-tls", nil)
+	if err := r.createOrUpdateCertManagerAttestationSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: r.getAttestationCertSecretName()}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data["token.crt"], certPEM) {
+		t.Error("expected the issued certificate in the attestation certificate secret")
+	}
+	if r.trusteeConfig.Status.AttestationTokenCertificate == nil {
+		t.Error("expected the attestation token certificate status to be set")
+	}
+}
+
+func TestSecretToTrusteeConfigMapper(t *testing.T) {
+	certManagerConfig := &confidentialcontainersorgv1alpha1.TrusteeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cert-manager", Namespace: testNamespace},
+		Spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+			HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{
+				CertManager: &confidentialcontainersorgv1alpha1.CertManagerCertificateSpec{},
+			},
+		},
+	}
+	userSecretConfig := &confidentialcontainersorgv1alpha1.TrusteeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-secret", Namespace: testNamespace},
+		Spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+			AttestationTokenVerificationSpec: confidentialcontainersorgv1alpha1.AttestationTokenVerificationSpec{TlsSecretName: "token-tls"},
+		},
+	}
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{}, certManagerConfig, userSecretConfig)
+	mapper := secretToTrusteeConfigMapper(r.Client)
+
+	tests := []struct {
+		secretName string
+		want       string
+	}{
+		{"cert-manager-https-tls", "cert-manager"},
+		{"token-tls", "user-secret"},
+		{"unrelated", ""},
+	}
+	for _, tt := range tests {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tt.secretName, Namespace: testNamespace}}
+		requests := mapper(context.Background(), secret)
+		if tt.want == "" && len(requests) != 0 {
+			t.Errorf("%s: expected no request, got %v", tt.secretName, requests)
+		} else if tt.want != "" && (len(requests) != 1 || requests[0].Name != tt.want) {
+			t.Errorf("%s: expected a request for %s, got %v", tt.secretName, tt.want, requests)
+		}
+	}
+}
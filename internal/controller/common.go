@@ -21,8 +21,8 @@ const (
 	// KbsFinalizerName for KbsConfig
 	KbsFinalizerName = "kbsconfig.confidentialcontainers.org/finalizer"
 
-	// KBS Deployment name
-	KbsDeploymentName = "trustee-deployment"
+	// KBS deployment name used before it was derived from the KbsConfig name
+	legacyKbsDeploymentName = "trustee-deployment"
 
 	// KBS operator default namespace
 	KbsOperatorNamespace = "trustee-operator-system"
@@ -30,14 +30,31 @@ const (
 	// Default KBS image name
 	DefaultKbsImageName = "ghcr.io/confidential-containers/key-broker-service:latest"
 
+	// Default KBS image name with the Intel Trust Authority attestation service
+	DefaultKbsItaImageName = "ghcr.io/confidential-containers/key-broker-service:ita-as-latest"
+
 	// Default AS image name
 	DefaultAsImageName = "ghcr.io/confidential-containers/attestation-service:latest"
 
 	// Default RVPS image name
 	DefaultRvpsImageName = "ghcr.io/confidential-containers/reference-value-provider-service:latest"
 
-	// KBS service name
-	KbsServiceName = "kbs-service"
+	// KBS service name used before it was derived from the KbsConfig name. The service is kept as an
+	// alias of the KBS pods of the KbsConfig that created it, until its removal in a future release
+	legacyKbsServiceName = "kbs-service"
+
+	// Annotation of the legacy KBS service telling the service to use instead
+	legacyKbsServiceDeprecatedAnnotation = "confidentialcontainers.org/deprecated"
+
+	// Component label of the KBS deployment, pods and service
+	kbsComponent = "kbs"
+
+	// Pod template annotation holding the hash of the mounted ConfigMaps and Secrets
+	configHashAnnotation = "kbs.confidentialcontainers.org/config-hash"
+
+	// Port and port name of the KBS service
+	kbsServicePort     = 8080
+	kbsServicePortName = "kbs-port"
 
 	// Root path for KBS file system
 	rootPath = "/opt"
@@ -57,6 +74,15 @@ const (
 	// Temporary path for mounting secrets before conversion
 	KbsSecretsMountPath = "/tmp/kbs-secrets"
 
+	// Port of the readiness endpoint of the secret-converter sidecar
+	SecretConverterProbePort = 8090
+
+	// Temporary path for mounting the KbsResource contents before conversion
+	KbsResourcesMountPath = "/tmp/kbs-resources"
+
+	// Temporary path for mounting the resources synced by the secret-converter sidecar
+	SidecarResourcesMountPath = "/tmp/kbs-sidecar-resources"
+
 	// KBS storage path
 	kbsStoragePath = confidentialContainersPath + "/storage/kbs"
 
@@ -96,6 +122,31 @@ const (
 	kbsDefaultLocalCacheDir = "/opt/confidential-containers/attestation-service/kds-store/vcek"
 )
 
+// kbsDeploymentName returns the name of the KBS deployment owned by the named KbsConfig
+func kbsDeploymentName(kbsConfigName string) string {
+	return kbsConfigName + "-deployment"
+}
+
+// kbsServiceName returns the name of the KBS service owned by the named KbsConfig
+func kbsServiceName(kbsConfigName string) string {
+	return kbsConfigName + "-service"
+}
+
+// kbsIngressName returns the name of the Ingress exposing the KBS service of the named KbsConfig
+func kbsIngressName(kbsConfigName string) string {
+	return kbsConfigName + "-ingress"
+}
+
+// kbsRouteName returns the name of the OpenShift Route exposing the KBS service of the named KbsConfig
+func kbsRouteName(kbsConfigName string) string {
+	return kbsConfigName + "-route"
+}
+
+// kbsHTTPRouteName returns the name of the HTTPRoute exposing the KBS service of the named KbsConfig
+func kbsHTTPRouteName(kbsConfigName string) string {
+	return kbsConfigName + "-httproute"
+}
+
 func standardLabels(instanceName, component string) map[string]string {
 	return map[string]string{
 		"app.kubernetes.io/managed-by": "trustee-operator",
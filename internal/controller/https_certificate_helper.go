@@ -0,0 +1,319 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Key holding the CA certificate in the CA bundle ConfigMap
+	caBundleKey = "ca.crt"
+)
+
+// isSelfSignedHttps returns true when the operator issues the KBS serving certificate,
+// either on request or because the Restricted profile requires HTTPS and no other certificate source is set
+func (r *TrusteeConfigReconciler) isSelfSignedHttps() bool {
+	httpsSpec := r.trusteeConfig.Spec.HttpsSpec
+	if httpsSpec.TlsSecretName != "" || httpsSpec.CertManager != nil {
+		return false
+	}
+	return httpsSpec.SelfSigned != nil || r.trusteeConfig.Spec.Profile == confidentialcontainersorgv1alpha1.ProfileTypeRestrictive
+}
+
+// getHttpsCASecretName returns the name for the secret holding the operator generated CA
+func (r *TrusteeConfigReconciler) getHttpsCASecretName() string {
+	return r.trusteeConfig.Name + "-https-ca-secret"
+}
+
+// getHttpsCABundleConfigMapName returns the name for the ConfigMap publishing the CA certificate
+func (r *TrusteeConfigReconciler) getHttpsCABundleConfigMapName() string {
+	return r.trusteeConfig.Name + "-https-ca-bundle"
+}
+
+// selfSignedDurations returns the validity and the renewal window of the serving certificate
+func (r *TrusteeConfigReconciler) selfSignedDurations() (time.Duration, time.Duration, error) {
+	duration, renewBefore := r.trusteeConfig.Spec.HttpsSpec.SelfSigned.Durations()
+	// The CA is only kept while it outlives a serving certificate issued now
+	if duration <= 0 || duration >= confidentialcontainersorgv1alpha1.SelfSignedCAValidity {
+		return 0, 0, fmt.Errorf("httpsSpec.selfSigned.duration (%s) must be positive and shorter than the CA validity (%s)",
+			duration, confidentialcontainersorgv1alpha1.SelfSignedCAValidity)
+	}
+	if renewBefore <= 0 || renewBefore >= duration {
+		return 0, 0, fmt.Errorf("httpsSpec.selfSigned.renewBefore (%s) must be positive and shorter than duration (%s)", renewBefore, duration)
+	}
+	return duration, renewBefore, nil
+}
+
+// kbsSubjectAltNames returns the DNS names and IP addresses the serving certificate is issued for:
+// the KBS service DNS names, the exposure hostname or external address and the user supplied names
+func (r *TrusteeConfigReconciler) kbsSubjectAltNames(ctx context.Context, userNames []string) ([]string, []net.IP) {
+	kbsConfigName := r.getKbsConfigName()
+	serviceName := kbsServiceName(kbsConfigName)
+	dnsNames := []string{
+		serviceName,
+		serviceName + "." + r.namespace,
+		serviceName + "." + r.namespace + ".svc",
+		serviceName + "." + r.namespace + ".svc.cluster.local",
+	}
+	var extraNames []string
+	var ips []net.IP
+
+	kbsConfig := &confidentialcontainersorgv1alpha1.KbsConfig{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: kbsConfigName}, kbsConfig)
+	if err == nil {
+		if exposure := kbsConfig.Spec.KbsExposureSpec; exposure != nil && exposure.Hostname != "" {
+			extraNames = append(extraNames, exposure.Hostname)
+		}
+		// The host generated by OpenShift for a Route or assigned to a load balancer
+		if externalURL, err := url.Parse(kbsConfig.Status.ExternalURL); err == nil && externalURL.Hostname() != "" {
+			if ip := net.ParseIP(externalURL.Hostname()); ip != nil {
+				ips = append(ips, ip)
+			} else {
+				extraNames = append(extraNames, externalURL.Hostname())
+			}
+		}
+	} else if !k8serrors.IsNotFound(err) {
+		r.log.Error(err, "Failed to get KbsConfig for the certificate subject alternative names")
+	}
+
+	extraNames = append(extraNames, userNames...)
+	// Keep a stable order so that the certificate is only reissued when the names change
+	sort.Strings(extraNames)
+	for _, name := range slices.Compact(extraNames) {
+		if !slices.Contains(dnsNames, name) {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	return dnsNames, ips
+}
+
+// createOrUpdateSelfSignedHttpsSecrets makes sure the operator CA exists, issues or renews the KBS serving
+// certificate, stores it in the HTTPS key and certificate secrets and publishes the CA bundle
+func (r *TrusteeConfigReconciler) createOrUpdateSelfSignedHttpsSecrets(ctx context.Context) error {
+	duration, renewBefore, err := r.selfSignedDurations()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	caCert, caKey, caPEM, err := r.createOrUpdateHttpsCA(ctx, now, duration)
+	if err != nil {
+		return err
+	}
+
+	var userNames []string
+	if selfSigned := r.trusteeConfig.Spec.HttpsSpec.SelfSigned; selfSigned != nil {
+		userNames = selfSigned.DNSNames
+	}
+	dnsNames, ips := r.kbsSubjectAltNames(ctx, userNames)
+	certPEM, keyPEM, err := r.currentServingCertificate(ctx)
+	if err != nil {
+		return err
+	}
+	if reason := servingCertificateRenewalReason(certPEM, keyPEM, caCert, dnsNames, ips, now, renewBefore); reason != "" {
+		r.log.Info("Issuing the KBS serving certificate", "reason", reason)
+		leafPEM, newKeyPEM, err := newServingCertificate(caCert, caKey, dnsNames, ips, now, duration)
+		if err != nil {
+			return err
+		}
+		// Serve the chain so that clients only need to trust the CA
+		certPEM = append(leafPEM, caPEM...)
+		keyPEM = newKeyPEM
+	}
+
+	if err = r.createOrUpdateHttpsKeySecret(ctx, keyPEM); err != nil {
+		return err
+	}
+	if err = r.createOrUpdateHttpsCertSecret(ctx, certPEM); err != nil {
+		return err
+	}
+	if err = r.createOrUpdateHttpsCABundleConfigMap(ctx, caPEM); err != nil {
+		return err
+	}
+
+	leaf, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+	r.trusteeConfig.Status.HttpsCertificate = &confidentialcontainersorgv1alpha1.CertificateStatus{
+		NotAfter:              metav1.NewTime(leaf.NotAfter),
+		RenewalTime:           &metav1.Time{Time: leaf.NotAfter.Add(-renewBefore)},
+		DNSNames:              leaf.DNSNames,
+		CABundleConfigMapName: r.getHttpsCABundleConfigMapName(),
+	}
+	return nil
+}
+
+// createOrUpdateHttpsCA returns the operator CA, generating a new one when it is missing,
+// invalid or would expire before a serving certificate issued now
+func (r *TrusteeConfigReconciler) createOrUpdateHttpsCA(ctx context.Context, now time.Time, duration time.Duration) (*x509.Certificate, crypto.Signer, []byte, error) {
+	secretName := r.getHttpsCASecretName()
+	found := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: secretName}, found)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return nil, nil, nil, err
+	}
+
+	if err == nil {
+		caCert, certErr := parseCertificatePEM(found.Data[corev1.TLSCertKey])
+		caKey, keyErr := parsePrivateKeyPEM(found.Data[corev1.TLSPrivateKeyKey])
+		if certErr == nil && keyErr == nil && caCert.IsCA && now.Add(duration).Before(caCert.NotAfter) {
+			return caCert, caKey, found.Data[corev1.TLSCertKey], nil
+		}
+		r.log.Info("Regenerating the HTTPS CA", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+	} else {
+		r.log.Info("Creating the HTTPS CA", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+	}
+
+	caPEM, caKeyPEM, err := newSelfSignedCA(r.trusteeConfig.Name+"-trustee-ca", now, confidentialcontainersorgv1alpha1.SelfSignedCAValidity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: r.namespace,
+			Labels:    standardLabels(r.trusteeConfig.Name, "https"),
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       caPEM,
+			corev1.TLSPrivateKeyKey: caKeyPEM,
+		},
+	}
+	if err = ctrl.SetControllerReference(r.trusteeConfig, secret, r.Scheme); err != nil {
+		return nil, nil, nil, err
+	}
+	if found.ResourceVersion == "" {
+		err = r.Create(ctx, secret)
+	} else {
+		found.Labels = secret.Labels
+		found.Data = secret.Data
+		err = r.Update(ctx, found)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	caCert, err := parseCertificatePEM(caPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	caKey, err := parsePrivateKeyPEM(caKeyPEM)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return caCert, caKey, caPEM, nil
+}
+
+// currentServingCertificate returns the certificate and key currently stored in the HTTPS secrets, if any
+func (r *TrusteeConfigReconciler) currentServingCertificate(ctx context.Context) ([]byte, []byte, error) {
+	certSecret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.getHttpsCertSecretName()}, certSecret)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	keySecret := &corev1.Secret{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.getHttpsKeySecretName()}, keySecret)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return certSecret.Data["certificate"], keySecret.Data["privateKey"], nil
+}
+
+// servingCertificateRenewalReason returns why the serving certificate must be (re)issued,
+// or an empty string when the current one can be kept
+func servingCertificateRenewalReason(certPEM, keyPEM []byte, ca *x509.Certificate, dnsNames []string, ips []net.IP, now time.Time, renewBefore time.Duration) string {
+	if len(certPEM) == 0 || len(keyPEM) == 0 {
+		return "certificate not found"
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return "invalid certificate or private key"
+	}
+	leaf, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return "invalid certificate"
+	}
+	if err = leaf.CheckSignatureFrom(ca); err != nil {
+		return "certificate not issued by the current CA"
+	}
+	if !now.Before(leaf.NotAfter.Add(-renewBefore)) {
+		return "certificate due for renewal"
+	}
+	if !slices.Equal(leaf.DNSNames, dnsNames) ||
+		!slices.EqualFunc(leaf.IPAddresses, ips, func(a, b net.IP) bool { return a.Equal(b) }) {
+		return "subject alternative names changed"
+	}
+	return ""
+}
+
+// createOrUpdateHttpsCABundleConfigMap publishes the CA certificate for the KBS clients
+func (r *TrusteeConfigReconciler) createOrUpdateHttpsCABundleConfigMap(ctx context.Context, caPEM []byte) error {
+	configMapName := r.getHttpsCABundleConfigMapName()
+	found := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: configMapName}, found)
+	if err != nil && k8serrors.IsNotFound(err) {
+		r.log.Info("Creating HTTPS CA bundle config map", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      configMapName,
+				Namespace: r.namespace,
+				Labels:    standardLabels(r.trusteeConfig.Name, "https"),
+			},
+			Data: map[string]string{caBundleKey: string(caPEM)},
+		}
+		if err = ctrl.SetControllerReference(r.trusteeConfig, configMap, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(ctx, configMap)
+	} else if err != nil {
+		return err
+	}
+
+	if bytes.Equal([]byte(found.Data[caBundleKey]), caPEM) {
+		return nil
+	}
+	r.log.Info("Updating HTTPS CA bundle config map", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
+	if found.Data == nil {
+		found.Data = map[string]string{}
+	}
+	found.Data[caBundleKey] = string(caPEM)
+	return r.Update(ctx, found)
+}
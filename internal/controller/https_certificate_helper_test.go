@@ -0,0 +1,227 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func newTestTrusteeConfigReconciler(t *testing.T, spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec, objs ...client.Object) *TrusteeConfigReconciler {
+	scheme := newTestScheme(t)
+	return &TrusteeConfigReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme: scheme,
+		trusteeConfig: &confidentialcontainersorgv1alpha1.TrusteeConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "trustee", Namespace: testNamespace, UID: types.UID("trustee-uid")},
+			Spec:       spec,
+		},
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		Recorder:  events.NewFakeRecorder(10),
+	}
+}
+
+// servingCertificate returns the serving certificate stored in the HTTPS certificate secret
+func servingCertificate(t *testing.T, r *TrusteeConfigReconciler) ([]byte, *x509.Certificate) {
+	t.Helper()
+	secret := &corev1.Secret{}
+	if err := r.Get(context.Background(), client.ObjectKey{Namespace: testNamespace, Name: r.getHttpsCertSecretName()}, secret); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := parseCertificatePEM(secret.Data["certificate"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret.Data["certificate"], leaf
+}
+
+func TestCreateOrUpdateSelfSignedHttpsSecrets(t *testing.T) {
+	kbsConfig := &confidentialcontainersorgv1alpha1.KbsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "trustee-kbs-config", Namespace: testNamespace},
+		Spec: confidentialcontainersorgv1alpha1.KbsConfigSpec{
+			KbsExposureSpec: &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+				Type:     confidentialcontainersorgv1alpha1.ExposureTypeIngress,
+				Hostname: "kbs.example.com",
+			},
+		},
+	}
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		Profile: confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+	}, kbsConfig)
+	ctx := context.Background()
+
+	if !r.isSelfSignedHttps() {
+		t.Fatal("expected the Restricted profile without TLS secret to use a self-signed certificate")
+	}
+	if err := r.createOrUpdateSelfSignedHttpsSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	caBundle := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "trustee-https-ca-bundle"}, caBundle); err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(caBundle.Data[caBundleKey])) {
+		t.Fatalf("expected a CA certificate in the bundle, got %q", caBundle.Data[caBundleKey])
+	}
+	certPEM, leaf := servingCertificate(t, r)
+	for _, name := range []string{"trustee-kbs-config-service.trustee-operator-system.svc", "kbs.example.com"} {
+		if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: name}); err != nil {
+			t.Errorf("expected the serving certificate to be valid for %s: %v", name, err)
+		}
+	}
+	status := r.trusteeConfig.Status.HttpsCertificate
+	if status == nil || !status.NotAfter.Time.Equal(leaf.NotAfter) ||
+		!status.RenewalTime.Time.Equal(leaf.NotAfter.Add(-confidentialcontainersorgv1alpha1.DefaultSelfSignedRenewBefore)) || status.CABundleConfigMapName != "trustee-https-ca-bundle" {
+		t.Errorf("unexpected certificate status %+v", status)
+	}
+
+	// a second reconcile keeps the certificate
+	if err := r.createOrUpdateSelfSignedHttpsSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := servingCertificate(t, r); !bytes.Equal(current, certPEM) {
+		t.Error("expected the serving certificate not to be reissued")
+	}
+
+	// additional names reissue the certificate with the same CA
+	r.trusteeConfig.Spec.HttpsSpec.SelfSigned = &confidentialcontainersorgv1alpha1.SelfSignedCertificateSpec{
+		DNSNames: []string{"trustee.example.com"},
+	}
+	if err := r.createOrUpdateSelfSignedHttpsSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, leaf = servingCertificate(t, r)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, DNSName: "trustee.example.com"}); err != nil {
+		t.Errorf("expected the reissued certificate to be valid for trustee.example.com: %v", err)
+	}
+}
+
+func TestServingCertificateRenewalReason(t *testing.T) {
+	now := time.Now()
+	caPEM, caKeyPEM, err := newSelfSignedCA("test-ca", now, confidentialcontainersorgv1alpha1.SelfSignedCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := parseCertificatePEM(caPEM)
+	caKey, _ := parsePrivateKeyPEM(caKeyPEM)
+	dnsNames := []string{"kbs-service"}
+	certPEM, keyPEM, err := newServingCertificate(ca, caKey, dnsNames, nil, now, confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCAPEM, _, err := newSelfSignedCA("other-ca", now, confidentialcontainersorgv1alpha1.SelfSignedCAValidity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, _ := parseCertificatePEM(otherCAPEM)
+
+	tests := []struct {
+		name       string
+		certPEM    []byte
+		ca         *x509.Certificate
+		dnsNames   []string
+		now        time.Time
+		wantReason bool
+	}{
+		{"valid", certPEM, ca, dnsNames, now, false},
+		{"missing", nil, ca, dnsNames, now, true},
+		{"other CA", certPEM, otherCA, dnsNames, now, true},
+		{"renewal window", certPEM, ca, dnsNames, now.Add(confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration - confidentialcontainersorgv1alpha1.DefaultSelfSignedRenewBefore), true},
+		{"new names", certPEM, ca, []string{"kbs-service", "kbs.example.com"}, now, true},
+	}
+	for _, tt := range tests {
+		reason := servingCertificateRenewalReason(tt.certPEM, keyPEM, tt.ca, tt.dnsNames, nil, tt.now, confidentialcontainersorgv1alpha1.DefaultSelfSignedRenewBefore)
+		if (reason != "") != tt.wantReason {
+			t.Errorf("%s: unexpected renewal reason %q", tt.name, reason)
+		}
+	}
+}
+
+func TestCreateOrUpdateHttpsCARenewal(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{})
+	ctx := context.Background()
+	now := time.Now()
+
+	ca, _, _, err := r.createOrUpdateHttpsCA(ctx, now, confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _, _, err := r.createOrUpdateHttpsCA(ctx, now, confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.Equal(ca) {
+		t.Error("expected the CA to be kept")
+	}
+
+	// the CA is regenerated when a serving certificate would outlive it
+	renewed, _, _, err := r.createOrUpdateHttpsCA(ctx, ca.NotAfter.Add(-confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration/2), confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Equal(ca) {
+		t.Error("expected the CA to be regenerated before it expires")
+	}
+}
+
+func TestSelfSignedDurationsLongDuration(t *testing.T) {
+	selfSigned := &confidentialcontainersorgv1alpha1.SelfSignedCertificateSpec{
+		Duration: &metav1.Duration{Duration: confidentialcontainersorgv1alpha1.SelfSignedCAValidity},
+	}
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{SelfSigned: selfSigned},
+	})
+	if _, _, err := r.selfSignedDurations(); err == nil {
+		t.Error("expected an error for a duration not shorter than the CA validity")
+	}
+
+	// The longest accepted duration keeps the CA across reconciles
+	selfSigned.Duration.Duration = confidentialcontainersorgv1alpha1.SelfSignedCAValidity - 24*time.Hour
+	duration, _, err := r.selfSignedDurations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	now := time.Now()
+	ca, _, _, err := r.createOrUpdateHttpsCA(ctx, now, duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, _, _, err := r.createOrUpdateHttpsCA(ctx, now.Add(time.Hour), duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same.Equal(ca) {
+		t.Error("expected the CA to be kept with a long duration")
+	}
+}
@@ -0,0 +1,449 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Key of the KBS configuration in the KBS ConfigMap
+	kbsConfigTomlKey = "kbs-config.toml"
+
+	// Annotation of the KBS ConfigMap holding the configuration generated by the last reconcile,
+	// the base of the three-way merge with the user edits
+	lastGeneratedKbsConfigAnnotation = "confidentialcontainers.org/last-generated-kbs-config"
+)
+
+// kbsTomlConfig is the typed model of kbs-config.toml. It declares the keys the operator manages:
+// the merge into an existing configuration only touches the keys of the model, the other ones belong
+// to the user. The values are merged from the decoded trees rather than from the typed fields, so
+// that a key added by the user below a table of the model, e.g. a plugin setting, is kept as it is
+type kbsTomlConfig struct {
+	HttpServer         kbsHttpServerConfig         `toml:"http_server"`
+	Admin              kbsAdminConfig              `toml:"admin"`
+	AttestationToken   kbsAttestationTokenConfig   `toml:"attestation_token"`
+	AttestationService kbsAttestationServiceConfig `toml:"attestation_service"`
+	StorageBackend     kbsStorageBackendConfig     `toml:"storage_backend"`
+	Plugins            []kbsPluginConfig           `toml:"plugins"`
+}
+
+// kbsHttpServerConfig is the [http_server] table of kbs-config.toml
+type kbsHttpServerConfig struct {
+	Sockets            []string `toml:"sockets"`
+	InsecureHttp       bool     `toml:"insecure_http"`
+	PrivateKey         string   `toml:"private_key"`
+	Certificate        string   `toml:"certificate"`
+	WorkerCount        int64    `toml:"worker_count"`
+	PayloadRequestSize int64    `toml:"payload_request_size"`
+	TlsProfile         string   `toml:"tls_profile"`
+	TlsMinVersion      string   `toml:"tls_min_version"`
+	TlsMaxVersion      string   `toml:"tls_max_version"`
+	TlsCiphers         string   `toml:"tls_ciphers"`
+	TlsGroups          string   `toml:"tls_groups"`
+}
+
+// kbsAdminConfig is the [admin] table of kbs-config.toml
+type kbsAdminConfig struct {
+	AuthorizationMode string            `toml:"authorization_mode"`
+	Type              string            `toml:"type"`
+	InsecureApi       bool              `toml:"insecure_api"`
+	AuthPublicKey     string            `toml:"auth_public_key"`
+	Personas          []kbsAdminPersona `toml:"personas"`
+}
+
+// kbsAdminPersona is an [[admin.personas]] entry of kbs-config.toml
+type kbsAdminPersona struct {
+	ID            string `toml:"id"`
+	PublicKeyPath string `toml:"public_key_path"`
+}
+
+// kbsAttestationTokenConfig is the [attestation_token] table of kbs-config.toml
+type kbsAttestationTokenConfig struct {
+	InsecureHeaderJwk    bool     `toml:"insecure_header_jwk"`
+	AttestationTokenType string   `toml:"attestation_token_type"`
+	TrustedCertsPaths    []string `toml:"trusted_certs_paths"`
+	TrustedJwkSets       []string `toml:"trusted_jwk_sets"`
+}
+
+// kbsAttestationServiceConfig is the [attestation_service] table of kbs-config.toml
+type kbsAttestationServiceConfig struct {
+	Type                   string                 `toml:"type"`
+	Timeout                int64                  `toml:"timeout"`
+	AsAddr                 string                 `toml:"as_addr"`
+	BaseUrl                string                 `toml:"base_url"`
+	ApiKey                 string                 `toml:"api_key"`
+	CertsFile              string                 `toml:"certs_file"`
+	AllowUnmatchedPolicy   bool                   `toml:"allow_unmatched_policy"`
+	AttestationTokenConfig map[string]interface{} `toml:"attestation_token_config"`
+	AttestationTokenBroker map[string]interface{} `toml:"attestation_token_broker"`
+	RvpsConfig             kbsRvpsConfig          `toml:"rvps_config"`
+	VerifierConfig         map[string]interface{} `toml:"verifier_config"`
+}
+
+// kbsRvpsConfig is the [attestation_service.rvps_config] table of kbs-config.toml
+type kbsRvpsConfig struct {
+	Type        string `toml:"type"`
+	StorageType string `toml:"storage_type"`
+	Address     string `toml:"address"`
+}
+
+// kbsStorageBackendConfig is the [storage_backend] table of kbs-config.toml
+type kbsStorageBackendConfig struct {
+	StorageType string                            `toml:"storage_type"`
+	Backends    map[string]map[string]interface{} `toml:"backends"`
+}
+
+// kbsPluginConfig is a [[plugins]] table of kbs-config.toml
+type kbsPluginConfig struct {
+	Name               string `toml:"name"`
+	StorageBackendType string `toml:"storage_backend_type"`
+	Type               string `toml:"type"`
+	DirPath            string `toml:"dir_path"`
+}
+
+// parseKbsTomlConfig parses kbs-config.toml into its typed model
+func parseKbsTomlConfig(content string) (*kbsTomlConfig, error) {
+	config := &kbsTomlConfig{}
+	if err := toml.Unmarshal([]byte(content), config); err != nil {
+		return nil, err
+	}
+	for i, plugin := range config.Plugins {
+		if plugin.Name == "" {
+			return nil, fmt.Errorf("plugins[%d]: name is not set", i)
+		}
+	}
+	return config, nil
+}
+
+// isKbsTomlModelKey returns true when the typed model declares the key at path. The maps, arrays and
+// values of the model hold every key below them
+func isKbsTomlModelKey(path []string) bool {
+	t := reflect.TypeOf(kbsTomlConfig{})
+	for _, key := range path {
+		if t.Kind() != reflect.Struct {
+			return true
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if name, _, _ := strings.Cut(field.Tag.Get("toml"), ","); name == key {
+				t = field.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// applyKbsSettings renders the KBS settings of a TrusteeConfig into the configuration generated from
+// the profile template, the settings that are not set keep the value of the profile
+func applyKbsSettings(content string, spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) (string, error) {
+	config, err := parseKbsTomlConfig(content)
+	if err != nil {
+		return "", err
+	}
+	doc, err := parseTomlDocument(content)
+	if err != nil {
+		return "", err
+	}
+
+	// The token duration and the VCEK sources are settings of the attestation service built into KBS,
+	// they are rendered into as-config.json with the MicroservicesDeployment topology
+	builtinAS := attestationServiceTemplateData(spec) == nil
+
+	var values []tomlLeaf
+	if settings := spec.KbsSettings; settings != nil {
+		if settings.WorkerCount != nil {
+			values = append(values, tomlLeaf{path: []string{"http_server", "worker_count"}, value: int64(*settings.WorkerCount)})
+		}
+		if settings.AttestationTokenDuration != nil && builtinAS {
+			// The restricted profile sets the duration in the token configuration, the permissive one in the token broker
+			table := "attestation_token_broker"
+			if config.AttestationService.AttestationTokenConfig != nil {
+				table = "attestation_token_config"
+			}
+			values = append(values, tomlLeaf{
+				path:  []string{"attestation_service", table, "duration_min"},
+				value: int64(settings.AttestationTokenDuration.Minutes()),
+			})
+		}
+		if len(settings.VcekSources) > 0 && builtinAS {
+			sources := make([]interface{}, 0, len(settings.VcekSources))
+			for _, source := range settings.VcekSources {
+				sources = append(sources, map[string]interface{}{"type": string(source)})
+			}
+			values = append(values, tomlLeaf{path: []string{"attestation_service", "verifier_config", "snp_verifier", "vcek_sources"}, value: sources})
+		}
+	}
+	if admin := spec.AdminSpec; admin != nil && admin.AuthorizationMode != "" {
+		values = append(values, tomlLeaf{path: []string{"admin", "authorization_mode"}, value: string(admin.AuthorizationMode)})
+	}
+	if personas := kbsAdminPersonas(spec); len(personas) > 0 {
+		values = append(values, tomlLeaf{path: []string{"admin", "personas"}, value: personas})
+	}
+
+	for _, leaf := range values {
+		if err := doc.set(leaf.path, leaf.value); err != nil {
+			return "", err
+		}
+	}
+	return doc.String(), nil
+}
+
+// tomlLeaf is a value of a TOML document that is not a table
+type tomlLeaf struct {
+	path  []string
+	value interface{}
+}
+
+// decodeTomlTree decodes a TOML document into nested maps
+func decodeTomlTree(content string) (map[string]interface{}, error) {
+	tree := map[string]interface{}{}
+	if err := toml.Unmarshal([]byte(content), &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// flattenToml returns the values of a TOML tree by dotted key. The tables, inline ones included,
+// are walked into, the arrays are values on their own
+func flattenToml(tree map[string]interface{}, prefix []string, leaves map[string]tomlLeaf) map[string]tomlLeaf {
+	if leaves == nil {
+		leaves = map[string]tomlLeaf{}
+	}
+	for key, value := range tree {
+		path := append(append([]string{}, prefix...), key)
+		if table, ok := value.(map[string]interface{}); ok && len(table) > 0 {
+			flattenToml(table, path, leaves)
+			continue
+		}
+		leaves[strings.Join(path, ".")] = tomlLeaf{path: path, value: value}
+	}
+	return leaves
+}
+
+func getTomlPath(tree map[string]interface{}, path []string) interface{} {
+	var value interface{} = tree
+	for _, key := range path {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = table[key]
+	}
+	return value
+}
+
+func setTomlPath(tree map[string]interface{}, path []string, value interface{}) {
+	for _, key := range path[:len(path)-1] {
+		table, ok := tree[key].(map[string]interface{})
+		if !ok {
+			table = map[string]interface{}{}
+			tree[key] = table
+		}
+		tree = table
+	}
+	tree[path[len(path)-1]] = value
+}
+
+func deleteTomlPath(tree map[string]interface{}, path []string) {
+	for _, key := range path[:len(path)-1] {
+		table, ok := tree[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		tree = table
+	}
+	delete(tree, path[len(path)-1])
+}
+
+// isKbsTlsKey returns true for the TLS settings of the [http_server] table
+func isKbsTlsKey(key string) bool {
+	return strings.HasPrefix(key, "http_server.tls_")
+}
+
+// defaultKbsTomlBase is the merge base of a ConfigMap without the previous generated configuration. The
+// existing configuration is taken as generated, except for the TLS settings which are replaced by the
+// generated ones
+func defaultKbsTomlBase(generated, existing map[string]tomlLeaf) map[string]tomlLeaf {
+	base := map[string]tomlLeaf{}
+	generatesTls := false
+	for key, leaf := range generated {
+		if isKbsTlsKey(key) {
+			generatesTls = true
+			continue
+		}
+		base[key] = leaf
+	}
+	if generatesTls {
+		for key, leaf := range existing {
+			if isKbsTlsKey(key) {
+				base[key] = leaf
+			}
+		}
+	}
+	return base
+}
+
+// kbsTomlModelLeaves returns the values of the keys of a decoded KBS configuration the typed model declares
+func kbsTomlModelLeaves(tree map[string]interface{}) map[string]tomlLeaf {
+	leaves := flattenToml(tree, nil, nil)
+	for key, leaf := range leaves {
+		if !isKbsTomlModelKey(leaf.path) {
+			delete(leaves, key)
+		}
+	}
+	return leaves
+}
+
+// mergeKbsTomlConfig three-way merges the generated KBS configuration into the existing one, previous
+// being the configuration generated by the last reconcile. The keys of the typed model the operator
+// generates the same way keep the user edits, the keys it generates differently are set, the keys it
+// no longer generates are removed unless the user edited them. The lines the merge does not change,
+// comments included, are kept. It returns the merged configuration and the user edited keys overridden
+// by the operator
+func mergeKbsTomlConfig(existing, previous, generated string) (string, []string, error) {
+	if _, err := parseKbsTomlConfig(generated); err != nil {
+		return "", nil, fmt.Errorf("generated configuration: %w", err)
+	}
+	generatedTree, err := decodeTomlTree(generated)
+	if err != nil {
+		return "", nil, fmt.Errorf("generated configuration: %w", err)
+	}
+	existingTree, err := decodeTomlTree(existing)
+	if err != nil {
+		return "", nil, fmt.Errorf("existing configuration: %w", err)
+	}
+	if _, err := parseKbsTomlConfig(existing); err != nil {
+		return "", nil, fmt.Errorf("existing configuration: %w", err)
+	}
+	doc, err := parseTomlDocument(existing)
+	if err != nil {
+		return "", nil, fmt.Errorf("existing configuration: %w", err)
+	}
+	generatedDoc, err := parseTomlDocument(generated)
+	if err != nil {
+		return "", nil, fmt.Errorf("generated configuration: %w", err)
+	}
+
+	// Every generated key must be managed, a key missing from the typed model would never be updated
+	generatedLeaves := flattenToml(generatedTree, nil, nil)
+	for key, leaf := range generatedLeaves {
+		if !isKbsTomlModelKey(leaf.path) {
+			return "", nil, fmt.Errorf("generated configuration: %s is not a key of the KBS configuration model", key)
+		}
+	}
+	existingLeaves := flattenToml(existingTree, nil, nil)
+	var baseLeaves map[string]tomlLeaf
+	if previousTree, err := decodeTomlTree(previous); previous != "" && err == nil {
+		baseLeaves = kbsTomlModelLeaves(previousTree)
+	} else {
+		baseLeaves = defaultKbsTomlBase(generatedLeaves, existingLeaves)
+	}
+
+	keys := make([]string, 0, len(generatedLeaves)+len(baseLeaves))
+	for key := range generatedLeaves {
+		keys = append(keys, key)
+	}
+	for key := range baseLeaves {
+		if _, ok := generatedLeaves[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var removals, updates []tomlLeaf
+	var overridden []string
+	for _, key := range keys {
+		g, inGenerated := generatedLeaves[key]
+		b, inBase := baseLeaves[key]
+		e, inExisting := existingLeaves[key]
+		switch {
+		case inGenerated && inBase && reflect.DeepEqual(g.value, b.value):
+			// Not changed by the operator, the user edits are kept
+		case inGenerated:
+			if inExisting && reflect.DeepEqual(e.value, g.value) {
+				continue
+			}
+			if inExisting && (!inBase || !reflect.DeepEqual(e.value, b.value)) {
+				overridden = append(overridden, key)
+			}
+			updates = append(updates, g)
+		case inExisting && reflect.DeepEqual(e.value, b.value):
+			removals = append(removals, b)
+		}
+	}
+
+	merged := existingTree
+	for _, leaf := range removals {
+		deleteTomlPath(merged, leaf.path)
+		if err := applyTomlChange(doc, merged, leaf.path, false); err != nil {
+			return "", nil, err
+		}
+	}
+	for _, leaf := range updates {
+		setTomlPath(merged, leaf.path, leaf.value)
+		arrayTable := generatedDoc.hasArrayTable(leaf.path)
+		if err := applyTomlChange(doc, merged, leaf.path, arrayTable); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// The edited document must hold exactly the merged values
+	result, err := decodeTomlTree(doc.String())
+	if err != nil {
+		return "", nil, fmt.Errorf("merged configuration: %w", err)
+	}
+	if !reflect.DeepEqual(result, merged) {
+		return "", nil, fmt.Errorf("merged configuration does not match the merged values")
+	}
+	return doc.String(), overridden, nil
+}
+
+// applyTomlChange writes the merged value of a path to the document, removing the key when it has no value
+func applyTomlChange(doc *tomlDocument, merged map[string]interface{}, path []string, arrayTable bool) error {
+	value := getTomlPath(merged, path)
+	if arrayTable || doc.hasArrayTable(path) {
+		return doc.setArrayTables(path, value)
+	}
+	kv := doc.lookup(path)
+	switch {
+	case kv == nil && value == nil:
+		return nil
+	case kv == nil:
+		return doc.insert(path, value)
+	case len(kv.path) < len(path):
+		// The key belongs to an inline table, which is written again
+		return doc.replaceValue(kv, getTomlPath(merged, kv.path))
+	case value == nil:
+		return doc.remove(kv)
+	default:
+		return doc.replaceValue(kv, value)
+	}
+}
@@ -0,0 +1,621 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestMergeKbsTomlConfig_TlsProfile(t *testing.T) {
+	existingConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+insecure_http = false
+private_key = "/etc/https-key/privateKey"
+certificate = "/etc/https-cert/certificate"
+worker_count = 4
+
+# TLS configuration - Mozilla modern profile
+
+tls_profile = "modern"
+
+[admin]
+type = "DenyAll"
+insecure_api = false
+auth_public_key = "/etc/auth-secret/publicKey"
+`
+
+	newConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+insecure_http = false
+private_key = "/etc/https-key/privateKey"
+certificate = "/etc/https-cert/certificate"
+worker_count = 4
+
+# TLS configuration - Mozilla intermediate profile
+
+tls_profile = "intermediate"
+
+[admin]
+type = "DenyAll"
+`
+
+	result := mustMergeKbsTomlConfig(t, existingConfig, "", newConfig)
+
+	// Should have intermediate profile now
+	if !strings.Contains(result, `tls_profile = "intermediate"`) {
+		t.Errorf("Expected intermediate profile in result, got:\n%s", result)
+	}
+
+	// Should NOT have modern profile
+	if strings.Contains(result, `tls_profile = "modern"`) {
+		t.Errorf("Expected modern profile to be replaced, got:\n%s", result)
+	}
+
+	// Should preserve admin section
+	if !strings.Contains(result, `auth_public_key = "/etc/auth-secret/publicKey"`) {
+		t.Errorf("Expected admin section to be preserved, got:\n%s", result)
+	}
+
+	// Should preserve http_server non-TLS settings
+	if !strings.Contains(result, `worker_count = 4`) {
+		t.Errorf("Expected worker_count to be preserved, got:\n%s", result)
+	}
+}
+
+func TestMergeKbsTomlConfig_CustomProfile(t *testing.T) {
+	existingConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+insecure_http = false
+worker_count = 4
+
+# TLS configuration - Mozilla modern profile
+
+tls_profile = "modern"
+
+[admin]
+type = "DenyAll"
+`
+
+	newConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+insecure_http = false
+worker_count = 4
+
+# TLS configuration - Mozilla custom profile
+
+tls_min_version = "1.2"
+tls_max_version = "1.3"
+tls_ciphers = "TLS_AES_128_GCM_SHA256:ECDHE-RSA-AES128-GCM-SHA256"
+tls_groups = "x25519:secp256r1"
+
+[admin]
+type = "DenyAll"
+`
+
+	result := mustMergeKbsTomlConfig(t, existingConfig, "", newConfig)
+
+	// Should have custom TLS settings
+	if !strings.Contains(result, `tls_min_version = "1.2"`) {
+		t.Errorf("Expected tls_min_version in result, got:\n%s", result)
+	}
+	if !strings.Contains(result, `tls_ciphers = "TLS_AES_128_GCM_SHA256:ECDHE-RSA-AES128-GCM-SHA256"`) {
+		t.Errorf("Expected tls_ciphers in result, got:\n%s", result)
+	}
+
+	// Should NOT have old profile setting
+	if strings.Contains(result, `tls_profile = "modern"`) {
+		t.Errorf("Expected old tls_profile to be removed, got:\n%s", result)
+	}
+
+	// Should preserve admin section
+	if !strings.Contains(result, `[admin]`) {
+		t.Errorf("Expected admin section to be preserved, got:\n%s", result)
+	}
+}
+
+func TestMergeKbsTomlConfig_NoTlsInNew(t *testing.T) {
+	existingConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+
+# TLS configuration - Mozilla modern profile
+
+tls_profile = "modern"
+
+[admin]
+type = "DenyAll"
+`
+
+	newConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+
+[admin]
+type = "DenyAll"
+`
+
+	result := mustMergeKbsTomlConfig(t, existingConfig, "", newConfig)
+
+	// Should preserve existing config unchanged when new has no TLS
+	if result != existingConfig {
+		t.Errorf("Expected existing config to be preserved when new has no TLS, got:\n%s", result)
+	}
+}
+
+func TestMergeKbsTomlConfig_Idempotent(t *testing.T) {
+	// Config with TLS block including blank lines
+	config := `[http_server]
+sockets = ["0.0.0.0:8080"]
+insecure_http = false
+worker_count = 4
+
+# TLS configuration - Mozilla intermediate profile
+
+tls_profile = "intermediate"
+
+[admin]
+type = "DenyAll"
+`
+
+	// Merge with the same config (simulating reconciliation)
+	result := mustMergeKbsTomlConfig(t, config, "", config)
+
+	// Should be idempotent - result should equal input
+	if result != config {
+		t.Errorf("Merge is not idempotent.\nExpected:\n%s\nGot:\n%s", config, result)
+	}
+
+	// Apply merge again - should still be idempotent
+	result2 := mustMergeKbsTomlConfig(t, result, "", config)
+	if result2 != config {
+		t.Errorf("Second merge is not idempotent.\nExpected:\n%s\nGot:\n%s", config, result2)
+	}
+}
+
+func TestMergeKbsTomlConfig_BlankLinesInTlsBlock(t *testing.T) {
+	existingConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+
+# TLS configuration - Mozilla modern profile
+
+tls_profile = "modern"
+
+[admin]
+type = "DenyAll"
+`
+
+	newConfig := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+
+# TLS configuration - Mozilla intermediate profile
+
+tls_profile = "intermediate"
+
+[admin]
+type = "DenyAll"
+`
+
+	result := mustMergeKbsTomlConfig(t, existingConfig, "", newConfig)
+
+	// Should have intermediate profile
+	if !strings.Contains(result, `tls_profile = "intermediate"`) {
+		t.Errorf("Expected intermediate profile in result")
+	}
+
+	// Should NOT have modern profile
+	if strings.Contains(result, `tls_profile = "modern"`) {
+		t.Errorf("Expected modern profile to be removed")
+	}
+
+	// Should NOT have duplicate blank lines
+	lines := strings.Split(result, "\n")
+	consecutiveBlankCount := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			consecutiveBlankCount++
+			if consecutiveBlankCount > 2 {
+				t.Errorf("Found more than 2 consecutive blank lines, merge is accumulating blank lines:\n%s", result)
+				break
+			}
+		} else {
+			consecutiveBlankCount = 0
+		}
+	}
+}
+
+func TestIsKbsTlsKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected bool
+	}{
+		{"http_server.tls_profile", true},
+		{"http_server.tls_min_version", true},
+		{"http_server.tls_max_version", true},
+		{"http_server.tls_ciphers", true},
+		{"http_server.tls_groups", true},
+		{"http_server.worker_count", false},
+		{"http_server.insecure_http", false},
+		{"admin.tls_profile", false},
+	}
+
+	for _, tt := range tests {
+		result := isKbsTlsKey(tt.key)
+		if result != tt.expected {
+			t.Errorf("isKbsTlsKey(%q) = %v, expected %v", tt.key, result, tt.expected)
+		}
+	}
+}
+
+func mustMergeKbsTomlConfig(t *testing.T, existing, previous, generated string) string {
+	t.Helper()
+	result, _, err := mergeKbsTomlConfig(existing, previous, generated)
+	if err != nil {
+		t.Fatalf("mergeKbsTomlConfig() error = %v", err)
+	}
+	return result
+}
+
+func TestMergeKbsTomlConfig_PreservesComments(t *testing.T) {
+	previous := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+tls_profile = "modern"
+
+[admin]
+authorization_mode = "DenyAll"
+
+[attestation_service.verifier_config.snp_verifier]
+vcek_sources = [
+    { type = "OfflineStore" },
+    { type = "KDS" }
+]
+`
+
+	// Sections reordered, comments, a multi-line array and a user edit of worker_count
+	existing := `# Edited by the cluster admin
+[admin]
+authorization_mode = "DenyAll" # no admin API
+
+[attestation_service.verifier_config.snp_verifier]
+# Configure VCEK sources to try, in order
+vcek_sources = [
+    # local cache first
+    { type = "OfflineStore" },
+    { type = "KDS" }
+]
+
+[http_server]
+sockets = ["0.0.0.0:8080"] # all interfaces
+worker_count = 8 # tuned for the node size
+tls_profile = "modern" # from TrusteeConfig
+`
+
+	generated := strings.Replace(previous, `tls_profile = "modern"`, `tls_profile = "intermediate"`, 1)
+
+	result := mustMergeKbsTomlConfig(t, existing, previous, generated)
+
+	expected := strings.Replace(existing, `tls_profile = "modern" # from TrusteeConfig`, `tls_profile = "intermediate" # from TrusteeConfig`, 1)
+	if result != expected {
+		t.Errorf("Expected only the TLS profile to change.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+}
+
+func TestMergeKbsTomlConfig_ThreeWay(t *testing.T) {
+	previous := `[http_server]
+sockets = ["0.0.0.0:8080"]
+worker_count = 4
+payload_request_size = 2
+insecure_http = true
+
+[admin]
+authorization_mode = "DenyAll"
+`
+
+	existing := `[http_server]
+sockets = ["0.0.0.0:8080"]
+# edited
+worker_count = 8
+payload_request_size = 2
+insecure_http = false
+
+[admin]
+authorization_mode = "DenyAll"
+insecure_api = true
+`
+
+	generated := `[http_server]
+sockets = ["0.0.0.0:8080", "[::]:8080"]
+worker_count = 4
+insecure_http = true
+private_key = "/etc/https-key/privateKey"
+
+[admin]
+authorization_mode = "DenyAll"
+
+[attestation_service]
+type = "coco_as_builtin"
+`
+
+	result, overridden, err := mergeKbsTomlConfig(existing, previous, generated)
+	if err != nil {
+		t.Fatalf("mergeKbsTomlConfig() error = %v", err)
+	}
+
+	expected := `[http_server]
+sockets = ["0.0.0.0:8080", "[::]:8080"]
+# edited
+worker_count = 8
+insecure_http = false
+private_key = "/etc/https-key/privateKey"
+
+[admin]
+authorization_mode = "DenyAll"
+insecure_api = true
+
+[attestation_service]
+type = "coco_as_builtin"
+`
+	if result != expected {
+		t.Errorf("Unexpected merge.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+	if len(overridden) != 0 {
+		t.Errorf("Expected no overridden user edit, got %v", overridden)
+	}
+
+	// The operator wins when both sides change the same key
+	generated = strings.Replace(generated, "worker_count = 4", "worker_count = 16", 1)
+	result, overridden, err = mergeKbsTomlConfig(existing, previous, generated)
+	if err != nil {
+		t.Fatalf("mergeKbsTomlConfig() error = %v", err)
+	}
+	if !strings.Contains(result, "# edited\nworker_count = 16\n") {
+		t.Errorf("Expected the generated worker_count, got:\n%s", result)
+	}
+	if len(overridden) != 1 || overridden[0] != "http_server.worker_count" {
+		t.Errorf("Expected http_server.worker_count to be overridden, got %v", overridden)
+	}
+}
+
+func TestMergeKbsTomlConfig_DottedAndInlineTables(t *testing.T) {
+	previous := `[attestation_service]
+rvps_config.type = "BuiltIn"
+attestation_token_config = { duration_min = 5 }
+`
+	existing := previous
+	generated := `[attestation_service]
+rvps_config.type = "BuiltIn"
+rvps_config.storage_type = "LocalJson"
+attestation_token_config = { duration_min = 10 }
+`
+
+	result := mustMergeKbsTomlConfig(t, existing, previous, generated)
+	if result != generated {
+		t.Errorf("Unexpected merge.\nExpected:\n%s\nGot:\n%s", generated, result)
+	}
+}
+
+func TestMergeKbsTomlConfig_Plugins(t *testing.T) {
+	previous := `[http_server]
+worker_count = 4
+
+[[plugins]]
+name = "resource"
+storage_backend_type = "kvstorage"
+`
+	existing := `[http_server]
+worker_count = 8
+
+# Resource plugin
+[[plugins]]
+name = "resource"
+storage_backend_type = "kvstorage"
+`
+	generated := previous + `
+[[plugins]]
+name = "sample"
+item = "value"
+`
+
+	result := mustMergeKbsTomlConfig(t, existing, previous, generated)
+	expected := `[http_server]
+worker_count = 8
+
+# Resource plugin
+[[plugins]]
+name = "resource"
+storage_backend_type = "kvstorage"
+
+[[plugins]]
+item = "value"
+name = "sample"
+`
+	if result != expected {
+		t.Errorf("Unexpected merge.\nExpected:\n%s\nGot:\n%s", expected, result)
+	}
+	config, err := parseKbsTomlConfig(result)
+	if err != nil {
+		t.Fatalf("parseKbsTomlConfig() error = %v", err)
+	}
+	if len(config.Plugins) != 2 || config.HttpServer.WorkerCount != 8 {
+		t.Errorf("Unexpected typed config: %+v", config)
+	}
+}
+
+func TestMergeKbsTomlConfig_InvalidExisting(t *testing.T) {
+	generated := "[http_server]\nworker_count = 4\n"
+
+	for _, existing := range []string{
+		"[http_server\nworker_count = 4\n",
+		"[http_server]\nworker_count = \"four\"\n",
+	} {
+		if _, _, err := mergeKbsTomlConfig(existing, generated, generated); err == nil {
+			t.Errorf("Expected an error for the existing configuration:\n%s", existing)
+		}
+	}
+}
+
+func TestKbsTomlTemplates(t *testing.T) {
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		content, err := os.ReadFile("../../config/templates/" + filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		tmpl := template.Must(template.New(filename).Parse(string(content)))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, GetTLSConfigFromTlsConfig(nil)); err != nil {
+			t.Fatalf("Failed to render %s: %v", filename, err)
+		}
+		rendered := buf.String()
+
+		if _, err := parseKbsTomlConfig(rendered); err != nil {
+			t.Errorf("%s: parseKbsTomlConfig() error = %v", filename, err)
+		}
+		if result := mustMergeKbsTomlConfig(t, rendered, rendered, rendered); result != rendered {
+			t.Errorf("%s: merging the template with itself changed it:\n%s", filename, result)
+		}
+	}
+}
+
+func TestApplyKbsSettings(t *testing.T) {
+	workerCount := int32(16)
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+			WorkerCount:              &workerCount,
+			AttestationTokenDuration: &metav1.Duration{Duration: 30 * time.Minute},
+			VcekSources:              []confidentialcontainersorgv1alpha1.VcekSourceType{confidentialcontainersorgv1alpha1.VcekSourceKDS},
+		},
+		AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationInsecureAllowAll},
+	}
+
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		content, err := os.ReadFile("../../config/templates/" + filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		var buf bytes.Buffer
+		if err := template.Must(template.New(filename).Parse(string(content))).Execute(&buf, GetTLSConfigFromTlsConfig(nil)); err != nil {
+			t.Fatalf("Failed to render %s: %v", filename, err)
+		}
+
+		// Without settings the profile defaults are kept
+		unchanged, err := applyKbsSettings(buf.String(), confidentialcontainersorgv1alpha1.TrusteeConfigSpec{})
+		if err != nil || unchanged != buf.String() {
+			t.Errorf("%s: expected the template unchanged, got %v:\n%s", filename, err, unchanged)
+		}
+
+		rendered, err := applyKbsSettings(buf.String(), spec)
+		if err != nil {
+			t.Fatalf("%s: applyKbsSettings() error = %v", filename, err)
+		}
+		config, err := parseKbsTomlConfig(rendered)
+		if err != nil {
+			t.Fatalf("%s: parseKbsTomlConfig() error = %v", filename, err)
+		}
+		if config.HttpServer.WorkerCount != 16 {
+			t.Errorf("%s: expected worker_count 16, got %d", filename, config.HttpServer.WorkerCount)
+		}
+		if config.Admin.AuthorizationMode != "InsecureAllowAll" {
+			t.Errorf("%s: expected authorization_mode InsecureAllowAll, got %q", filename, config.Admin.AuthorizationMode)
+		}
+		tokens := config.AttestationService.AttestationTokenConfig
+		if tokens == nil {
+			tokens = config.AttestationService.AttestationTokenBroker
+		}
+		if tokens["duration_min"] != int64(30) {
+			t.Errorf("%s: expected duration_min 30, got %v", filename, tokens)
+		}
+		if !strings.Contains(rendered, "# Configure VCEK sources to try, in order. Defaults to [KDS].\nvcek_sources = [{ type = \"KDS\" }]\n") {
+			t.Errorf("%s: expected the VCEK sources to be replaced, got:\n%s", filename, rendered)
+		}
+	}
+}
+
+func TestIsKbsTomlModelKey(t *testing.T) {
+	tests := []struct {
+		path []string
+		want bool
+	}{
+		{[]string{"http_server", "worker_count"}, true},
+		{[]string{"attestation_service", "verifier_config", "snp_verifier", "vcek_sources"}, true},
+		{[]string{"storage_backend", "backends", "postgres", "host"}, true},
+		{[]string{"plugins"}, true},
+		{[]string{"http_server", "custom"}, false},
+		{[]string{"custom", "key"}, false},
+	}
+	for _, tt := range tests {
+		if got := isKbsTomlModelKey(tt.path); got != tt.want {
+			t.Errorf("isKbsTomlModelKey(%v) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestKbsTomlModelCoversGeneratedKeys(t *testing.T) {
+	specs := []confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		{},
+		{
+			AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+				Type:   confidentialcontainersorgv1alpha1.AttestationServiceRemote,
+				Remote: &confidentialcontainersorgv1alpha1.RemoteAttestationServiceSpec{Address: "http://coco-as:50004"},
+			},
+		},
+		{
+			AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+				Type: confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority,
+				IntelTrustAuthority: &confidentialcontainersorgv1alpha1.IntelTrustAuthoritySpec{
+					BaseURL:  "https://api.trustauthority.intel.com",
+					CertsURL: "https://portal.trustauthority.intel.com",
+				},
+			},
+		},
+	}
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		for _, spec := range specs {
+			tree := renderKbsTemplate(t, filename, spec)
+			for key, leaf := range flattenToml(tree, nil, nil) {
+				if !isKbsTomlModelKey(leaf.path) {
+					t.Errorf("%s: generated key %s is not declared by the KBS configuration model", filename, key)
+				}
+			}
+		}
+	}
+}
+
+func TestMergeKbsTomlConfig_KeysOutsideModel(t *testing.T) {
+	// A key unknown to the model is never managed, even when it matches the previous generation
+	previous := "[http_server]\nworker_count = 4\ncustom = 1\n"
+	existing := previous
+	generated := "[http_server]\nworker_count = 4\n"
+	if result := mustMergeKbsTomlConfig(t, existing, previous, generated); result != existing {
+		t.Errorf("Expected the key outside the model to be kept.\nExpected:\n%s\nGot:\n%s", existing, result)
+	}
+
+	// The operator only generates keys of the model
+	if _, _, err := mergeKbsTomlConfig(existing, previous, previous); err == nil {
+		t.Error("Expected an error for a generated key outside the model")
+	}
+}
@@ -0,0 +1,539 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Name of the cookie used to pin a client to a KBS replica
+	kbsSessionCookieName = "kbs-session"
+
+	// Annotation listing the annotations set by the operator on the exposure object,
+	// so that they can be removed once they are no longer desired
+	managedAnnotationsAnnotation = "confidentialcontainers.org/managed-annotations"
+
+	// NGINX ingress controller annotations
+	nginxBackendProtocolAnnotation = "nginx.ingress.kubernetes.io/backend-protocol"
+	nginxAffinityAnnotation        = "nginx.ingress.kubernetes.io/affinity"
+	nginxCookieNameAnnotation      = "nginx.ingress.kubernetes.io/session-cookie-name"
+	nginxCookieExpiresAnnotation   = "nginx.ingress.kubernetes.io/session-cookie-expires"
+	nginxCookieMaxAgeAnnotation    = "nginx.ingress.kubernetes.io/session-cookie-max-age"
+	nginxCookieLifetimeSeconds     = "172800"
+
+	// OpenShift router annotations
+	routerCookieNameAnnotation     = "router.openshift.io/cookie_name"
+	routerDisableCookiesAnnotation = "haproxy.router.openshift.io/disable_cookies"
+	routerBalanceAnnotation        = "haproxy.router.openshift.io/balance"
+)
+
+// Reasons used in the Exposed condition
+const (
+	reasonExposureReady   = "ExposureReady"
+	reasonExposurePending = "ExposurePending"
+	reasonExposureFailed  = "ExposureReconcileFailed"
+)
+
+// sessionAffinityEnabled returns true unless session affinity has been explicitly disabled
+func sessionAffinityEnabled(exposure *confidentialcontainersorgv1alpha1.KbsExposureSpec) bool {
+	return exposure.SessionAffinity == nil || *exposure.SessionAffinity
+}
+
+// routeTermination returns the TLS termination of the Route, defaulting to passthrough
+// when KBS serves HTTPS and to edge otherwise
+func routeTermination(exposure *confidentialcontainersorgv1alpha1.KbsExposureSpec, https bool) confidentialcontainersorgv1alpha1.RouteTermination {
+	if exposure.Route != nil && exposure.Route.Termination != "" {
+		return exposure.Route.Termination
+	}
+	if https {
+		return confidentialcontainersorgv1alpha1.RouteTerminationPassthrough
+	}
+	return confidentialcontainersorgv1alpha1.RouteTerminationEdge
+}
+
+// applyAnnotations sets the desired annotations on the object, removes the ones previously
+// set by the operator that are no longer desired and keeps any annotation set by others
+// (e.g. openshift.io/host.generated on Routes)
+func applyAnnotations(obj metav1.Object, desired map[string]string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for _, key := range strings.Split(annotations[managedAnnotationsAnnotation], ",") {
+		if _, ok := desired[key]; !ok {
+			delete(annotations, key)
+		}
+	}
+	delete(annotations, managedAnnotationsAnnotation)
+
+	keys := make([]string, 0, len(desired))
+	for key, value := range desired {
+		annotations[key] = value
+		keys = append(keys, key)
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		annotations[managedAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+	obj.SetAnnotations(annotations)
+}
+
+// exposureAnnotations merges the annotations generated by the operator with the user ones,
+// the latter taking precedence
+func exposureAnnotations(exposure *confidentialcontainersorgv1alpha1.KbsExposureSpec, generated map[string]string) map[string]string {
+	annotations := map[string]string{}
+	for key, value := range generated {
+		annotations[key] = value
+	}
+	for key, value := range exposure.Annotations {
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// mutateKbsIngress sets the desired state on the Ingress exposing KBS
+func (r *KbsConfigReconciler) mutateKbsIngress(ingress *networkingv1.Ingress) {
+	exposure := r.kbsConfig.Spec.KbsExposureSpec
+
+	generated := map[string]string{}
+	if r.isHttpsConfigPresent() {
+		generated[nginxBackendProtocolAnnotation] = "HTTPS"
+	}
+	if sessionAffinityEnabled(exposure) {
+		generated[nginxAffinityAnnotation] = "cookie"
+		generated[nginxCookieNameAnnotation] = kbsSessionCookieName
+		generated[nginxCookieExpiresAnnotation] = nginxCookieLifetimeSeconds
+		generated[nginxCookieMaxAgeAnnotation] = nginxCookieLifetimeSeconds
+	}
+	applyAnnotations(ingress, exposureAnnotations(exposure, generated))
+
+	pathType := networkingv1.PathTypePrefix
+	ingress.Spec = networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{
+			{
+				Host: exposure.Hostname,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{
+							{
+								Path:     "/",
+								PathType: &pathType,
+								Backend: networkingv1.IngressBackend{
+									Service: &networkingv1.IngressServiceBackend{
+										Name: kbsServiceName(r.kbsConfig.Name),
+										Port: networkingv1.ServiceBackendPort{Number: kbsServicePort},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if exposure.Ingress != nil {
+		ingress.Spec.IngressClassName = exposure.Ingress.IngressClassName
+		if exposure.Ingress.TlsSecretName != "" {
+			tls := networkingv1.IngressTLS{SecretName: exposure.Ingress.TlsSecretName}
+			if exposure.Hostname != "" {
+				tls.Hosts = []string{exposure.Hostname}
+			}
+			ingress.Spec.TLS = []networkingv1.IngressTLS{tls}
+		}
+	}
+}
+
+// mutateKbsRoute sets the desired state on the OpenShift Route exposing KBS
+func (r *KbsConfigReconciler) mutateKbsRoute(ctx context.Context, route *routev1.Route) error {
+	exposure := r.kbsConfig.Spec.KbsExposureSpec
+	https := r.isHttpsConfigPresent()
+	termination := routeTermination(exposure, https)
+
+	switch termination {
+	case confidentialcontainersorgv1alpha1.RouteTerminationPassthrough, confidentialcontainersorgv1alpha1.RouteTerminationReencrypt:
+		if !https {
+			return fmt.Errorf("route termination %s requires KBS to serve HTTPS", termination)
+		}
+	case confidentialcontainersorgv1alpha1.RouteTerminationEdge:
+		if https {
+			return fmt.Errorf("route termination %s requires KBS to serve plain HTTP", termination)
+		}
+	}
+
+	generated := map[string]string{}
+	if termination == confidentialcontainersorgv1alpha1.RouteTerminationPassthrough {
+		// The router cannot set cookies on a TLS connection it does not terminate
+		if sessionAffinityEnabled(exposure) {
+			generated[routerBalanceAnnotation] = "source"
+		}
+	} else if sessionAffinityEnabled(exposure) {
+		generated[routerCookieNameAnnotation] = kbsSessionCookieName
+	} else {
+		generated[routerDisableCookiesAnnotation] = "true"
+	}
+	applyAnnotations(route, exposureAnnotations(exposure, generated))
+
+	tls := &routev1.TLSConfig{
+		Termination:                   routev1.TLSTerminationType(termination),
+		InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+	}
+	if termination == confidentialcontainersorgv1alpha1.RouteTerminationReencrypt {
+		caCertificate, err := r.kbsHttpsCertificate(ctx)
+		if err != nil {
+			return err
+		}
+		tls.DestinationCACertificate = caCertificate
+	}
+
+	// OpenShift generates a host name when none is provided, keep it on updates
+	if exposure.Hostname != "" {
+		route.Spec.Host = exposure.Hostname
+	}
+	route.Spec.To = routev1.RouteTargetReference{
+		Kind:   "Service",
+		Name:   kbsServiceName(r.kbsConfig.Name),
+		Weight: pointer(int32(100)),
+	}
+	route.Spec.Port = &routev1.RoutePort{TargetPort: intstr.FromString(kbsServicePortName)}
+	route.Spec.TLS = tls
+	route.Spec.WildcardPolicy = routev1.WildcardPolicyNone
+	return nil
+}
+
+// kbsHttpsCertificate returns the PEM encoded KBS HTTPS certificate, used by the router
+// to verify KBS with the reencrypt termination
+func (r *KbsConfigReconciler) kbsHttpsCertificate(ctx context.Context) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.kbsConfig.Spec.KbsHttpsCertSecretName}, secret)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.Contains(string(secret.Data[key]), "-----BEGIN CERTIFICATE-----") {
+			return string(secret.Data[key]), nil
+		}
+	}
+	return "", fmt.Errorf("secret %s/%s does not contain a PEM encoded certificate", r.namespace, secret.Name)
+}
+
+// mutateKbsHTTPRoute sets the desired state on the Gateway API HTTPRoute exposing KBS
+func (r *KbsConfigReconciler) mutateKbsHTTPRoute(httpRoute *gatewayv1.HTTPRoute) error {
+	exposure := r.kbsConfig.Spec.KbsExposureSpec
+	if exposure.HTTPRoute == nil || exposure.HTTPRoute.GatewayName == "" {
+		return fmt.Errorf("exposure.httpRoute.gatewayName is required when the exposure type is %s", exposure.Type)
+	}
+	applyAnnotations(httpRoute, exposureAnnotations(exposure, nil))
+
+	parentRef := gatewayv1.ParentReference{
+		Group: pointer(gatewayv1.Group(gatewayv1.GroupName)),
+		Kind:  pointer(gatewayv1.Kind("Gateway")),
+		Name:  gatewayv1.ObjectName(exposure.HTTPRoute.GatewayName),
+	}
+	if exposure.HTTPRoute.GatewayNamespace != "" {
+		parentRef.Namespace = pointer(gatewayv1.Namespace(exposure.HTTPRoute.GatewayNamespace))
+	}
+	if exposure.HTTPRoute.SectionName != "" {
+		parentRef.SectionName = pointer(gatewayv1.SectionName(exposure.HTTPRoute.SectionName))
+	}
+
+	rule := gatewayv1.HTTPRouteRule{
+		Matches: []gatewayv1.HTTPRouteMatch{
+			{
+				Path: &gatewayv1.HTTPPathMatch{
+					Type:  pointer(gatewayv1.PathMatchPathPrefix),
+					Value: pointer("/"),
+				},
+			},
+		},
+		BackendRefs: []gatewayv1.HTTPBackendRef{
+			{
+				BackendRef: gatewayv1.BackendRef{
+					BackendObjectReference: gatewayv1.BackendObjectReference{
+						Group: pointer(gatewayv1.Group("")),
+						Kind:  pointer(gatewayv1.Kind("Service")),
+						Name:  gatewayv1.ObjectName(kbsServiceName(r.kbsConfig.Name)),
+						Port:  pointer(gatewayv1.PortNumber(kbsServicePort)),
+					},
+					Weight: pointer(int32(1)),
+				},
+			},
+		},
+	}
+	if sessionAffinityEnabled(exposure) {
+		rule.SessionPersistence = &gatewayv1.SessionPersistence{
+			SessionName: pointer(kbsSessionCookieName),
+			Type:        pointer(gatewayv1.CookieBasedSessionPersistence),
+		}
+	}
+
+	httpRoute.Spec.ParentRefs = []gatewayv1.ParentReference{parentRef}
+	httpRoute.Spec.Hostnames = nil
+	if exposure.Hostname != "" {
+		httpRoute.Spec.Hostnames = []gatewayv1.Hostname{gatewayv1.Hostname(exposure.Hostname)}
+	}
+	httpRoute.Spec.Rules = []gatewayv1.HTTPRouteRule{rule}
+	return nil
+}
+
+// createOrUpdateExposureObject creates or updates an object exposing KBS and emits the matching events
+func (r *KbsConfigReconciler) createOrUpdateExposureObject(ctx context.Context, kind string, obj client.Object, mutate func() error) error {
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+		if obj.GetResourceVersion() != "" && !metav1.IsControlledBy(obj, r.kbsConfig) {
+			return fmt.Errorf("%s %s/%s already exists and is not managed by KbsConfig %s", kind, obj.GetNamespace(), obj.GetName(), r.kbsConfig.Name)
+		}
+		labels := obj.GetLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for key, value := range standardLabels(r.kbsConfig.Name, kbsComponent) {
+			labels[key] = value
+		}
+		obj.SetLabels(labels)
+		if err := mutate(); err != nil {
+			return err
+		}
+		return ctrl.SetControllerReference(r.kbsConfig, obj, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		err = fmt.Errorf("the %s API is not available in this cluster: %w", kind, err)
+	}
+	if err != nil {
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, kind+"ReconcileFailed", kind+"ReconcileFailed", err.Error())
+		return err
+	}
+
+	switch result {
+	case controllerutil.OperationResultCreated:
+		r.log.Info("Created "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, kind+"Created", kind+"Created", fmt.Sprintf("%s %s created successfully", kind, obj.GetName()))
+	case controllerutil.OperationResultUpdated:
+		r.log.Info("Updated "+kind, kind+".Namespace", obj.GetNamespace(), kind+".Name", obj.GetName())
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, kind+"Updated", kind+"Updated", fmt.Sprintf("%s %s updated successfully", kind, obj.GetName()))
+	}
+	return nil
+}
+
+// deployOrUpdateKbsExposure creates or updates the Ingress, Route or HTTPRoute exposing KBS,
+// deletes the ones left behind by a previous exposure type and updates the external URL
+// Errors are logged by the callee and hence no error is logged in this method
+func (r *KbsConfigReconciler) deployOrUpdateKbsExposure(ctx context.Context) error {
+	exposure := r.kbsConfig.Spec.KbsExposureSpec
+
+	var current confidentialcontainersorgv1alpha1.ExposureType
+	if exposure != nil {
+		current = exposure.Type
+	}
+	if err := r.deleteStaleKbsExposure(ctx, current); err != nil {
+		return err
+	}
+
+	if exposure == nil {
+		meta.RemoveStatusCondition(&r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionExposed)
+		r.kbsConfig.Status.ExternalURL = ""
+		return nil
+	}
+
+	var externalURL string
+	switch exposure.Type {
+	case confidentialcontainersorgv1alpha1.ExposureTypeIngress:
+		ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: kbsIngressName(r.kbsConfig.Name), Namespace: r.namespace}}
+		err := r.createOrUpdateExposureObject(ctx, "Ingress", ingress, func() error {
+			r.mutateKbsIngress(ingress)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		externalURL = ingressURL(ingress)
+	case confidentialcontainersorgv1alpha1.ExposureTypeRoute:
+		route := &routev1.Route{ObjectMeta: metav1.ObjectMeta{Name: kbsRouteName(r.kbsConfig.Name), Namespace: r.namespace}}
+		err := r.createOrUpdateExposureObject(ctx, "Route", route, func() error {
+			return r.mutateKbsRoute(ctx, route)
+		})
+		if err != nil {
+			return err
+		}
+		externalURL = routeURL(route)
+	case confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute:
+		httpRoute := &gatewayv1.HTTPRoute{ObjectMeta: metav1.ObjectMeta{Name: kbsHTTPRouteName(r.kbsConfig.Name), Namespace: r.namespace}}
+		err := r.createOrUpdateExposureObject(ctx, "HTTPRoute", httpRoute, func() error {
+			return r.mutateKbsHTTPRoute(httpRoute)
+		})
+		if err != nil {
+			return err
+		}
+		externalURL = r.httpRouteURL(ctx)
+	default:
+		return fmt.Errorf("unsupported exposure type %q", exposure.Type)
+	}
+
+	r.kbsConfig.Status.ExternalURL = externalURL
+	if externalURL == "" {
+		r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionExposed, metav1.ConditionFalse, reasonExposurePending,
+			fmt.Sprintf("Waiting for the %s to be assigned an address", exposure.Type))
+	} else {
+		r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionExposed, metav1.ConditionTrue, reasonExposureReady,
+			fmt.Sprintf("KBS is exposed at %s", externalURL))
+	}
+	return nil
+}
+
+// deleteStaleKbsExposure deletes the exposure objects owned by this KbsConfig whose type
+// differs from the current one. APIs missing from the cluster are skipped
+func (r *KbsConfigReconciler) deleteStaleKbsExposure(ctx context.Context, current confidentialcontainersorgv1alpha1.ExposureType) error {
+	candidates := map[confidentialcontainersorgv1alpha1.ExposureType]client.Object{
+		confidentialcontainersorgv1alpha1.ExposureTypeIngress:   &networkingv1.Ingress{},
+		confidentialcontainersorgv1alpha1.ExposureTypeRoute:     &routev1.Route{},
+		confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute: &gatewayv1.HTTPRoute{},
+	}
+	names := map[confidentialcontainersorgv1alpha1.ExposureType]string{
+		confidentialcontainersorgv1alpha1.ExposureTypeIngress:   kbsIngressName(r.kbsConfig.Name),
+		confidentialcontainersorgv1alpha1.ExposureTypeRoute:     kbsRouteName(r.kbsConfig.Name),
+		confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute: kbsHTTPRouteName(r.kbsConfig.Name),
+	}
+	for exposureType, obj := range candidates {
+		if exposureType == current {
+			continue
+		}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: names[exposureType]}, obj)
+		if k8serrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !metav1.IsControlledBy(obj, r.kbsConfig) {
+			continue
+		}
+		r.log.Info("Deleting stale KBS exposure", "Kind", exposureType, "Name", obj.GetName())
+		if err := r.Delete(ctx, obj); err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ingressURL returns the external URL of KBS exposed through an Ingress
+func ingressURL(ingress *networkingv1.Ingress) string {
+	scheme := "http"
+	if len(ingress.Spec.TLS) > 0 {
+		scheme = "https"
+	}
+	host := ""
+	if len(ingress.Spec.Rules) > 0 {
+		host = ingress.Spec.Rules[0].Host
+	}
+	if host == "" && len(ingress.Status.LoadBalancer.Ingress) > 0 {
+		host = ingress.Status.LoadBalancer.Ingress[0].Hostname
+		if host == "" {
+			host = ingress.Status.LoadBalancer.Ingress[0].IP
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	return scheme + "://" + host
+}
+
+// routeURL returns the external URL of KBS exposed through an OpenShift Route.
+// Every supported termination serves TLS to the clients
+func routeURL(route *routev1.Route) string {
+	host := route.Spec.Host
+	if host == "" && len(route.Status.Ingress) > 0 {
+		host = route.Status.Ingress[0].Host
+	}
+	if host == "" {
+		return ""
+	}
+	return "https://" + host
+}
+
+// httpRouteURL returns the external URL of KBS exposed through an HTTPRoute, based on the
+// listener and the addresses of the parent Gateway
+func (r *KbsConfigReconciler) httpRouteURL(ctx context.Context) string {
+	exposure := r.kbsConfig.Spec.KbsExposureSpec
+	gatewayNamespace := exposure.HTTPRoute.GatewayNamespace
+	if gatewayNamespace == "" {
+		gatewayNamespace = r.namespace
+	}
+	gateway := &gatewayv1.Gateway{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: gatewayNamespace, Name: exposure.HTTPRoute.GatewayName}, gateway)
+	if err != nil {
+		r.log.Info("Unable to retrieve the Gateway of the KBS HTTPRoute", "err", err)
+		return ""
+	}
+	return gatewayURL(gateway, exposure.HTTPRoute.SectionName, exposure.Hostname)
+}
+
+// gatewayURL returns the URL served by the listener of the Gateway the HTTPRoute attaches to
+func gatewayURL(gateway *gatewayv1.Gateway, sectionName, hostname string) string {
+	var listener *gatewayv1.Listener
+	for i := range gateway.Spec.Listeners {
+		l := &gateway.Spec.Listeners[i]
+		if l.Protocol != gatewayv1.HTTPProtocolType && l.Protocol != gatewayv1.HTTPSProtocolType {
+			continue
+		}
+		if sectionName == "" || string(l.Name) == sectionName {
+			listener = l
+			break
+		}
+	}
+	if listener == nil {
+		return ""
+	}
+
+	scheme := "http"
+	if listener.Protocol == gatewayv1.HTTPSProtocolType {
+		scheme = "https"
+	}
+	host := hostname
+	if host == "" && listener.Hostname != nil && !strings.HasPrefix(string(*listener.Hostname), "*") {
+		host = string(*listener.Hostname)
+	}
+	if host == "" && len(gateway.Status.Addresses) > 0 {
+		host = gateway.Status.Addresses[0].Value
+	}
+	if host == "" {
+		return ""
+	}
+	if (scheme == "http" && listener.Port != 80) || (scheme == "https" && listener.Port != 443) {
+		host = fmt.Sprintf("%s:%d", host, listener.Port)
+	}
+	return scheme + "://" + host
+}
@@ -0,0 +1,273 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	routev1 "github.com/openshift/api/route/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func newTestExposureReconciler(t *testing.T, kbsConfig *confidentialcontainersorgv1alpha1.KbsConfig, objs ...client.Object) *KbsConfigReconciler {
+	scheme := newTestScheme(t)
+	return &KbsConfigReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		Scheme:    scheme,
+		Recorder:  events.NewFakeRecorder(10),
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		kbsConfig: kbsConfig,
+	}
+}
+
+func TestDeployOrUpdateKbsIngress(t *testing.T) {
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsHttpsKeySecretName:  "kbs-https-key",
+		KbsHttpsCertSecretName: "kbs-https-certificate",
+		KbsExposureSpec: &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+			Type:     confidentialcontainersorgv1alpha1.ExposureTypeIngress,
+			Hostname: "kbs.example.com",
+			Ingress:  &confidentialcontainersorgv1alpha1.KbsIngressSpec{TlsSecretName: "kbs-ingress-tls"},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ingress := &networkingv1.Ingress{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "tenant-a-ingress"}, ingress); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(ingress, kbsConfig) {
+		t.Error("expected the Ingress to be owned by the KbsConfig")
+	}
+	if ingress.Annotations[nginxBackendProtocolAnnotation] != "HTTPS" || ingress.Annotations[nginxAffinityAnnotation] != "cookie" ||
+		ingress.Annotations[nginxCookieNameAnnotation] != kbsSessionCookieName {
+		t.Errorf("expected the backend protocol and cookie affinity annotations, got %v", ingress.Annotations)
+	}
+	backend := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if backend.Name != "tenant-a-service" || backend.Port.Number != kbsServicePort {
+		t.Errorf("unexpected Ingress backend %+v", backend)
+	}
+	if kbsConfig.Status.ExternalURL != "https://kbs.example.com" {
+		t.Errorf("expected the external URL https://kbs.example.com, got %q", kbsConfig.Status.ExternalURL)
+	}
+	if !meta.IsStatusConditionTrue(kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionExposed) {
+		t.Errorf("expected the Exposed condition to be true, got %v", kbsConfig.Status.Conditions)
+	}
+
+	// disabling the affinity removes the cookie annotations and keeps the user ones
+	kbsConfig.Spec.KbsExposureSpec.SessionAffinity = pointer(false)
+	kbsConfig.Spec.KbsExposureSpec.Annotations = map[string]string{"example.com/owner": "tenant-a"}
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(ingress), ingress); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ingress.Annotations[nginxAffinityAnnotation]; ok || ingress.Annotations["example.com/owner"] != "tenant-a" {
+		t.Errorf("expected the affinity annotation to be removed and the user one to be set, got %v", ingress.Annotations)
+	}
+}
+
+func TestDeployOrUpdateKbsRoute(t *testing.T) {
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsHttpsKeySecretName:  "kbs-https-key",
+		KbsHttpsCertSecretName: "kbs-https-certificate",
+		KbsExposureSpec: &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+			Type: confidentialcontainersorgv1alpha1.ExposureTypeRoute,
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	route := &routev1.Route{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "tenant-a-route"}, route); err != nil {
+		t.Fatal(err)
+	}
+	if route.Spec.TLS == nil || route.Spec.TLS.Termination != routev1.TLSTerminationPassthrough {
+		t.Errorf("expected the passthrough termination with HTTPS, got %+v", route.Spec.TLS)
+	}
+	if route.Annotations[routerBalanceAnnotation] != "source" {
+		t.Errorf("expected the source balancing with passthrough, got %v", route.Annotations)
+	}
+	// the host is generated by OpenShift
+	if kbsConfig.Status.ExternalURL != "" ||
+		meta.IsStatusConditionTrue(kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionExposed) {
+		t.Errorf("expected the exposure to be pending, got %q %v", kbsConfig.Status.ExternalURL, kbsConfig.Status.Conditions)
+	}
+
+	// edge termination cannot forward to an HTTPS backend
+	kbsConfig.Spec.KbsExposureSpec.Route = &confidentialcontainersorgv1alpha1.KbsRouteSpec{
+		Termination: confidentialcontainersorgv1alpha1.RouteTerminationEdge,
+	}
+	if err := r.deployOrUpdateKbsExposure(ctx); err == nil {
+		t.Error("expected an error for the edge termination with HTTPS")
+	}
+
+	// without HTTPS the default termination is edge with cookie affinity
+	kbsConfig.Spec.KbsHttpsKeySecretName = ""
+	kbsConfig.Spec.KbsHttpsCertSecretName = ""
+	kbsConfig.Spec.KbsExposureSpec.Route = nil
+	kbsConfig.Spec.KbsExposureSpec.Hostname = "kbs.apps.example.com"
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(route), route); err != nil {
+		t.Fatal(err)
+	}
+	if route.Spec.TLS.Termination != routev1.TLSTerminationEdge || route.Annotations[routerCookieNameAnnotation] != kbsSessionCookieName {
+		t.Errorf("expected the edge termination with the cookie annotation, got %+v %v", route.Spec.TLS, route.Annotations)
+	}
+	if _, ok := route.Annotations[routerBalanceAnnotation]; ok {
+		t.Errorf("expected the balance annotation to be removed, got %v", route.Annotations)
+	}
+	if kbsConfig.Status.ExternalURL != "https://kbs.apps.example.com" {
+		t.Errorf("expected the external URL https://kbs.apps.example.com, got %q", kbsConfig.Status.ExternalURL)
+	}
+}
+
+func TestDeployOrUpdateKbsHTTPRoute(t *testing.T) {
+	gateway := &gatewayv1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "public", Namespace: "gateways"},
+		Spec: gatewayv1.GatewaySpec{
+			GatewayClassName: "example",
+			Listeners: []gatewayv1.Listener{
+				{Name: "https", Protocol: gatewayv1.HTTPSProtocolType, Port: 8443, Hostname: pointer(gatewayv1.Hostname("*.example.com"))},
+			},
+		},
+	}
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsExposureSpec: &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+			Type:     confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute,
+			Hostname: "kbs.example.com",
+			HTTPRoute: &confidentialcontainersorgv1alpha1.KbsHTTPRouteSpec{
+				GatewayName:      "public",
+				GatewayNamespace: "gateways",
+				SectionName:      "https",
+			},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig, gateway)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	httpRoute := &gatewayv1.HTTPRoute{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "tenant-a-httproute"}, httpRoute); err != nil {
+		t.Fatal(err)
+	}
+	parentRef := httpRoute.Spec.ParentRefs[0]
+	if parentRef.Name != "public" || *parentRef.Namespace != "gateways" || *parentRef.SectionName != "https" {
+		t.Errorf("unexpected parent reference %+v", parentRef)
+	}
+	rule := httpRoute.Spec.Rules[0]
+	if rule.SessionPersistence == nil || *rule.SessionPersistence.SessionName != kbsSessionCookieName {
+		t.Errorf("expected a cookie based session persistence, got %+v", rule.SessionPersistence)
+	}
+	if kbsConfig.Status.ExternalURL != "https://kbs.example.com:8443" {
+		t.Errorf("expected the external URL https://kbs.example.com:8443, got %q", kbsConfig.Status.ExternalURL)
+	}
+
+	// switching the exposure type deletes the HTTPRoute
+	kbsConfig.Spec.KbsExposureSpec = &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+		Type: confidentialcontainersorgv1alpha1.ExposureTypeIngress,
+	}
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(httpRoute), httpRoute); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the stale HTTPRoute to be deleted, got %v", err)
+	}
+
+	// removing the exposure deletes the Ingress and clears the status
+	kbsConfig.Spec.KbsExposureSpec = nil
+	if err := r.deployOrUpdateKbsExposure(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "tenant-a-ingress"}, &networkingv1.Ingress{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the stale Ingress to be deleted, got %v", err)
+	}
+	if kbsConfig.Status.ExternalURL != "" || meta.FindStatusCondition(kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionExposed) != nil {
+		t.Errorf("expected the exposure status to be cleared, got %q %v", kbsConfig.Status.ExternalURL, kbsConfig.Status.Conditions)
+	}
+}
+
+func TestDeployOrUpdateKbsExposureForeignObject(t *testing.T) {
+	foreign := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-a-ingress", Namespace: testNamespace},
+	}
+	r := newTestExposureReconciler(t, newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsExposureSpec: &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+			Type: confidentialcontainersorgv1alpha1.ExposureTypeIngress,
+		},
+	}), foreign)
+
+	if err := r.deployOrUpdateKbsExposure(context.Background()); err == nil {
+		t.Error("expected an error for an Ingress not owned by the KbsConfig")
+	}
+}
+
+func TestGatewayURL(t *testing.T) {
+	gateway := &gatewayv1.Gateway{
+		Spec: gatewayv1.GatewaySpec{
+			Listeners: []gatewayv1.Listener{
+				{Name: "tcp", Protocol: gatewayv1.TCPProtocolType, Port: 9000},
+				{Name: "http", Protocol: gatewayv1.HTTPProtocolType, Port: 80},
+				{Name: "https", Protocol: gatewayv1.HTTPSProtocolType, Port: 443, Hostname: pointer(gatewayv1.Hostname("kbs.example.com"))},
+			},
+		},
+		Status: gatewayv1.GatewayStatus{
+			Addresses: []gatewayv1.GatewayStatusAddress{{Value: "192.0.2.10"}},
+		},
+	}
+
+	tests := []struct {
+		sectionName string
+		hostname    string
+		want        string
+	}{
+		{"", "", "http://192.0.2.10"},
+		{"https", "", "https://kbs.example.com"},
+		{"https", "other.example.com", "https://other.example.com"},
+		{"tcp", "", ""},
+		{"missing", "", ""},
+	}
+	for _, tt := range tests {
+		if got := gatewayURL(gateway, tt.sectionName, tt.hostname); got != tt.want {
+			t.Errorf("gatewayURL(%q, %q) = %q, want %q", tt.sectionName, tt.hostname, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Name of the volume holding the KbsResource contents
+	kbsResourcesVolume = "kbs-resources"
+
+	// kbsResourcePathsAnnotation maps the keys of the KbsResource contents secret to the resource paths
+	kbsResourcePathsAnnotation = "kbs.confidentialcontainers.org/resource-paths"
+
+	// Default repository of a KbsResource, matching the CRD default
+	defaultKbsResourceRepository = "default"
+
+	// Reasons used in the KbsResource and KbsConfig conditions
+	reasonResourceResolved   = "ResourceResolved"
+	reasonSourceNotFound     = "SourceNotFound"
+	reasonPathConflict       = "PathConflict"
+	reasonNoKbsConfig        = "NoKbsConfig"
+	reasonKbsResourcesFailed = "KbsResourcesReconcileFailed"
+)
+
+// kbsResourcePath returns the <repository>/<type>/<tag> path of a KbsResource
+func kbsResourcePath(resource *confidentialcontainersorgv1alpha1.KbsResource) string {
+	repository := resource.Spec.Repository
+	if repository == "" {
+		repository = defaultKbsResourceRepository
+	}
+	return repository + "/" + resource.Spec.Type + "/" + resource.Spec.Tag
+}
+
+// kbsResourceURI returns the URI clients use to fetch a KbsResource
+func kbsResourceURI(resource *confidentialcontainersorgv1alpha1.KbsResource) string {
+	return "kbs:///" + kbsResourcePath(resource)
+}
+
+// servesKbsResource returns true if the KbsConfig with the given name serves the KbsResource
+func servesKbsResource(kbsConfigName string, resource *confidentialcontainersorgv1alpha1.KbsResource) bool {
+	return resource.Spec.KbsConfigName == "" || resource.Spec.KbsConfigName == kbsConfigName
+}
+
+// kbsResourceSource returns the kind and name of the object holding the content of a KbsResource,
+// or empty strings for inline content
+func kbsResourceSource(resource *confidentialcontainersorgv1alpha1.KbsResource) (string, string) {
+	switch source := resource.Spec.Source; {
+	case source.SecretKeyRef != nil:
+		return "Secret", source.SecretKeyRef.Name
+	case source.ConfigMapKeyRef != nil:
+		return "ConfigMap", source.ConfigMapKeyRef.Name
+	}
+	return "", ""
+}
+
+// errKbsResourceSourceNotFound is returned when the Secret or ConfigMap key of a KbsResource does not exist
+type errKbsResourceSourceNotFound struct {
+	message string
+}
+
+func (e *errKbsResourceSourceNotFound) Error() string {
+	return e.message
+}
+
+// resolveKbsResourceContent returns the content of a KbsResource
+func resolveKbsResourceContent(ctx context.Context, c client.Reader, resource *confidentialcontainersorgv1alpha1.KbsResource) ([]byte, error) {
+	source := resource.Spec.Source
+	switch {
+	case source.SecretKeyRef != nil:
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: resource.Namespace, Name: source.SecretKeyRef.Name}, secret)
+		if k8serrors.IsNotFound(err) {
+			return nil, &errKbsResourceSourceNotFound{fmt.Sprintf("Secret %s not found", source.SecretKeyRef.Name)}
+		} else if err != nil {
+			return nil, err
+		}
+		content, ok := secret.Data[source.SecretKeyRef.Key]
+		if !ok {
+			return nil, &errKbsResourceSourceNotFound{fmt.Sprintf("key %s not found in Secret %s", source.SecretKeyRef.Key, source.SecretKeyRef.Name)}
+		}
+		return content, nil
+	case source.ConfigMapKeyRef != nil:
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, client.ObjectKey{Namespace: resource.Namespace, Name: source.ConfigMapKeyRef.Name}, configMap)
+		if k8serrors.IsNotFound(err) {
+			return nil, &errKbsResourceSourceNotFound{fmt.Sprintf("ConfigMap %s not found", source.ConfigMapKeyRef.Name)}
+		} else if err != nil {
+			return nil, err
+		}
+		if content, ok := configMap.Data[source.ConfigMapKeyRef.Key]; ok {
+			return []byte(content), nil
+		}
+		if content, ok := configMap.BinaryData[source.ConfigMapKeyRef.Key]; ok {
+			return content, nil
+		}
+		return nil, &errKbsResourceSourceNotFound{fmt.Sprintf("key %s not found in ConfigMap %s", source.ConfigMapKeyRef.Key, source.ConfigMapKeyRef.Name)}
+	case source.Inline != nil:
+		return []byte(*source.Inline), nil
+	}
+	return nil, &errKbsResourceSourceNotFound{"no source is set"}
+}
+
+// conflictingKbsResource returns the KbsResource that takes the path of the given one on the KbsConfig,
+// or nil. The oldest KbsResource wins, the name breaks ties
+func conflictingKbsResource(resource *confidentialcontainersorgv1alpha1.KbsResource, resources []confidentialcontainersorgv1alpha1.KbsResource, kbsConfigName string) *confidentialcontainersorgv1alpha1.KbsResource {
+	path := kbsResourcePath(resource)
+	for i := range resources {
+		other := &resources[i]
+		if other.Name == resource.Name || !servesKbsResource(kbsConfigName, other) || kbsResourcePath(other) != path {
+			continue
+		}
+		if other.CreationTimestamp.Before(&resource.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&resource.CreationTimestamp) && other.Name < resource.Name) {
+			return other
+		}
+	}
+	return nil
+}
+
+// servedKbsResource is a KbsResource served by a KbsConfig along with its content
+type servedKbsResource struct {
+	resource *confidentialcontainersorgv1alpha1.KbsResource
+	content  []byte
+}
+
+// getKbsResourcesSecretName returns the name of the secret holding the KbsResource contents of the KbsConfig
+func getKbsResourcesSecretName(kbsConfigName string) string {
+	return kbsConfigName + "-kbs-resources"
+}
+
+// getKbsResourcePolicyConfigMapName returns the name of the resource policy ConfigMap extended
+// with the KbsResource policy selectors
+func getKbsResourcePolicyConfigMapName(kbsConfigName string) string {
+	return kbsConfigName + "-resource-policy"
+}
+
+// servedKbsResources returns the KbsResources served by the KbsConfig, sorted by name.
+// KbsResources whose content cannot be resolved or whose path is taken are skipped,
+// their status reports why
+func (r *KbsConfigReconciler) servedKbsResources(ctx context.Context) ([]servedKbsResource, error) {
+	resourceList := &confidentialcontainersorgv1alpha1.KbsResourceList{}
+	if err := r.List(ctx, resourceList, client.InNamespace(r.namespace)); err != nil {
+		return nil, err
+	}
+	sort.Slice(resourceList.Items, func(i, j int) bool { return resourceList.Items[i].Name < resourceList.Items[j].Name })
+
+	var served []servedKbsResource
+	for i := range resourceList.Items {
+		resource := &resourceList.Items[i]
+		if !servesKbsResource(r.kbsConfig.Name, resource) || resource.DeletionTimestamp != nil {
+			continue
+		}
+		if other := conflictingKbsResource(resource, resourceList.Items, r.kbsConfig.Name); other != nil {
+			r.log.Info("Skipping KbsResource, its path is served by another KbsResource", "KbsResource.Name", resource.Name, "other", other.Name)
+			continue
+		}
+		content, err := resolveKbsResourceContent(ctx, r.Client, resource)
+		if _, notFound := err.(*errKbsResourceSourceNotFound); notFound {
+			r.log.Info("Skipping KbsResource", "KbsResource.Name", resource.Name, "err", err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		served = append(served, servedKbsResource{resource: resource, content: content})
+	}
+	return served, nil
+}
+
+// deployOrUpdateKbsResources stores the contents of the KbsResources served by the KbsConfig
+// in a secret converted into the KBS repository, and extends the resource policy with their selectors.
+// The secret-converter sidecar reads them from the sidecar resources secret instead
+func (r *KbsConfigReconciler) deployOrUpdateKbsResources(ctx context.Context) error {
+	served, err := r.servedKbsResources(ctx)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getKbsResourcesSecretName(r.kbsConfig.Name), Namespace: r.namespace}}
+	sidecarSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getSidecarResourcesSecretName(r.kbsConfig.Name), Namespace: r.namespace}}
+	if secretConverterSidecar(r.kbsConfig.Spec) {
+		err = r.deployOrUpdateSidecarResources(ctx, served)
+	} else {
+		err = r.deleteOwnedObject(ctx, sidecarSecret)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(served) == 0 || secretConverterSidecar(r.kbsConfig.Spec) {
+		if err := r.deleteOwnedObject(ctx, secret); err != nil {
+			return err
+		}
+	} else {
+		paths := make(map[string]string, len(served))
+		data := make(map[string][]byte, len(served))
+		for _, s := range served {
+			paths[s.resource.Name] = kbsResourcePath(s.resource)
+			data[s.resource.Name] = s.content
+		}
+		pathsJSON, err := json.Marshal(paths)
+		if err != nil {
+			return err
+		}
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+			if secret.Annotations == nil {
+				secret.Annotations = make(map[string]string)
+			}
+			secret.Annotations[kbsResourcePathsAnnotation] = string(pathsJSON)
+			if secret.Labels == nil {
+				secret.Labels = make(map[string]string)
+			}
+			for k, v := range standardLabels(r.kbsConfig.Name, "kbs-resources") {
+				secret.Labels[k] = v
+			}
+			secret.Type = corev1.SecretTypeOpaque
+			secret.Data = data
+			return ctrl.SetControllerReference(r.kbsConfig, secret, r.Scheme)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.deployOrUpdateKbsResourcePolicy(ctx, served)
+}
+
+// deployOrUpdateKbsResourcePolicy creates the resource policy ConfigMap extended with the KbsResource
+// policy selectors, and deletes it when no served KbsResource has a selector
+func (r *KbsConfigReconciler) deployOrUpdateKbsResourcePolicy(ctx context.Context, served []servedKbsResource) error {
+	selectors := make(map[string]map[string][]string)
+	for _, s := range served {
+		if s.resource.Spec.PolicySelector != nil {
+			selectors[kbsResourcePath(s.resource)] = map[string][]string{"tees": s.resource.Spec.PolicySelector.Tees}
+		}
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: getKbsResourcePolicyConfigMapName(r.kbsConfig.Name), Namespace: r.namespace}}
+	if len(selectors) == 0 {
+		return r.deleteOwnedObject(ctx, configMap)
+	}
+	if r.kbsConfig.Spec.KbsResourcePolicyConfigMapName == "" {
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "ResourcePolicySelectorIgnored", "ResourcePolicySelectorIgnored",
+			"KbsResource policy selectors are ignored since kbsResourcePolicyConfigMapName is not set")
+		return r.deleteOwnedObject(ctx, configMap)
+	}
+
+	base := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: r.kbsConfig.Spec.KbsResourcePolicyConfigMapName}, base); err != nil {
+		return err
+	}
+	selectorsJSON, err := json.MarshalIndent(selectors, "", "    ")
+	if err != nil {
+		return err
+	}
+	policy := base.Data[resourcePolicyFilename] +
+		"\n# Generated by the trustee-operator from the KbsResource policy selectors\n" +
+		"kbs_resource_selectors := " + string(selectorsJSON) + "\n"
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Labels == nil {
+			configMap.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, "resource-policy") {
+			configMap.Labels[k] = v
+		}
+		configMap.Data = map[string]string{resourcePolicyFilename: policy}
+		return ctrl.SetControllerReference(r.kbsConfig, configMap, r.Scheme)
+	})
+	return err
+}
+
+// deleteOwnedObject deletes an object owned by the KbsConfig if it exists
+func (r *KbsConfigReconciler) deleteOwnedObject(ctx context.Context, obj client.Object) error {
+	err := r.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(obj, r.kbsConfig) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
+
+// getResourcePolicyConfigMapName returns the resource policy ConfigMap mounted by KBS, the one
+// extended with the KbsResource policy selectors when it exists
+func (r *KbsConfigReconciler) getResourcePolicyConfigMapName(ctx context.Context) string {
+	name := getKbsResourcePolicyConfigMapName(r.kbsConfig.Name)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: name}, &corev1.ConfigMap{}); err == nil {
+		return name
+	}
+	return r.kbsConfig.Spec.KbsResourcePolicyConfigMapName
+}
+
+// createKbsResourcesVolume returns the volume projecting the KbsResource contents to their
+// <repository>/<type>/<tag> paths, or nil when the KbsConfig serves no KbsResource
+func (r *KbsConfigReconciler) createKbsResourcesVolume(ctx context.Context) (*corev1.Volume, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: getKbsResourcesSecretName(r.kbsConfig.Name)}, secret)
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string)
+	if err := json.Unmarshal([]byte(secret.Annotations[kbsResourcePathsAnnotation]), &paths); err != nil {
+		return nil, fmt.Errorf("secret %s: invalid %s annotation: %w", secret.Name, kbsResourcePathsAnnotation, err)
+	}
+	var items []corev1.KeyToPath
+	for key, path := range paths {
+		if _, ok := secret.Data[key]; ok {
+			items = append(items, corev1.KeyToPath{Key: key, Path: path})
+		}
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+	return &corev1.Volume{
+		Name: kbsResourcesVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: secret.Name,
+				Items:      items,
+			},
+		},
+	}, nil
+}
+
+// kbsResourcesReferencing returns the KbsResources of the namespace whose content comes from the given object
+func kbsResourcesReferencing(ctx context.Context, c client.Reader, namespace, kind, name string) ([]confidentialcontainersorgv1alpha1.KbsResource, error) {
+	resourceList := &confidentialcontainersorgv1alpha1.KbsResourceList{}
+	if err := c.List(ctx, resourceList, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	var resources []confidentialcontainersorgv1alpha1.KbsResource
+	for _, resource := range resourceList.Items {
+		if sourceKind, sourceName := kbsResourceSource(&resource); sourceKind == kind && sourceName == name {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// kbsConfigRequestsForResources returns the reconcile requests of the KbsConfigs serving any of the KbsResources
+func kbsConfigRequestsForResources(kbsConfigs []confidentialcontainersorgv1alpha1.KbsConfig, resources []confidentialcontainersorgv1alpha1.KbsResource) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, kbsConfig := range kbsConfigs {
+		for i := range resources {
+			if servesKbsResource(kbsConfig.Name, &resources[i]) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: kbsConfig.Namespace, Name: kbsConfig.Name},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
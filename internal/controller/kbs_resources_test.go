@@ -0,0 +1,175 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// newTestKbsResource returns a KbsResource created at the given time
+func newTestKbsResource(name string, created time.Time, spec confidentialcontainersorgv1alpha1.KbsResourceSpec) *confidentialcontainersorgv1alpha1.KbsResource {
+	return &confidentialcontainersorgv1alpha1.KbsResource{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, CreationTimestamp: metav1.NewTime(created)},
+		Spec:       spec,
+	}
+}
+
+func inlineKbsResourceSpec(repository, resourceType, tag, content string) confidentialcontainersorgv1alpha1.KbsResourceSpec {
+	return confidentialcontainersorgv1alpha1.KbsResourceSpec{
+		Repository: repository,
+		Type:       resourceType,
+		Tag:        tag,
+		Source:     confidentialcontainersorgv1alpha1.KbsResourceSource{Inline: &content},
+	}
+}
+
+func TestDeployOrUpdateKbsResources(t *testing.T) {
+	now := time.Now()
+	fromSecret := confidentialcontainersorgv1alpha1.KbsResourceSpec{
+		Repository: "my-app",
+		Type:       "keys",
+		Tag:        "workload",
+		Source: confidentialcontainersorgv1alpha1.KbsResourceSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "app-keys"}, Key: "workload.key"},
+		},
+	}
+	missing := fromSecret
+	missing.Tag = "missing"
+	missing.Source = confidentialcontainersorgv1alpha1.KbsResourceSource{
+		ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "absent"}, Key: "value"},
+	}
+	otherKbs := inlineKbsResourceSpec("default", "other", "tag", "other")
+	otherKbs.KbsConfigName = "other-kbs"
+
+	kbsConfig := newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-keys", Namespace: testNamespace},
+			Data:       map[string][]byte{"workload.key": []byte("secret-key")},
+		},
+		newTestKbsResource("workload", now, fromSecret),
+		newTestKbsResource("greeting", now, inlineKbsResourceSpec("", "sample", "greeting", "hello")),
+		// same path as greeting but created later, it loses the conflict
+		newTestKbsResource("greeting-copy", now.Add(time.Minute), inlineKbsResourceSpec("default", "sample", "greeting", "hi")),
+		newTestKbsResource("missing", now, missing),
+		newTestKbsResource("other", now, otherKbs),
+	)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "kbs-kbs-resources"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 2 || string(secret.Data["workload"]) != "secret-key" || string(secret.Data["greeting"]) != "hello" {
+		t.Errorf("unexpected KbsResources secret data %v", secret.Data)
+	}
+	if !metav1.IsControlledBy(secret, kbsConfig) {
+		t.Error("expected the KbsResources secret to be owned by the KbsConfig")
+	}
+
+	volume, err := r.createKbsResourcesVolume(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if volume == nil || volume.Secret == nil || volume.Secret.SecretName != secret.Name {
+		t.Fatalf("unexpected KbsResources volume %+v", volume)
+	}
+	expected := []corev1.KeyToPath{{Key: "greeting", Path: "default/sample/greeting"}, {Key: "workload", Path: "my-app/keys/workload"}}
+	if len(volume.Secret.Items) != len(expected) {
+		t.Fatalf("unexpected volume items %v", volume.Secret.Items)
+	}
+	for i, item := range volume.Secret.Items {
+		if item != expected[i] {
+			t.Errorf("expected volume item %v, got %v", expected[i], item)
+		}
+	}
+
+	// the secret is removed once nothing is served
+	for _, name := range []string{"workload", "greeting", "greeting-copy"} {
+		if err := r.Delete(ctx, &confidentialcontainersorgv1alpha1.KbsResource{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the KbsResources secret to be deleted, got %v", err)
+	}
+	if volume, err := r.createKbsResourcesVolume(ctx); err != nil || volume != nil {
+		t.Errorf("expected no KbsResources volume, got %+v, %v", volume, err)
+	}
+}
+
+func TestDeployOrUpdateKbsResourcePolicy(t *testing.T) {
+	selected := inlineKbsResourceSpec("default", "keys", "snp-only", "key")
+	selected.PolicySelector = &confidentialcontainersorgv1alpha1.KbsResourcePolicySelector{Tees: []string{"snp"}}
+	kbsConfig := newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{KbsResourcePolicyConfigMapName: "resource-policy"})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "resource-policy", Namespace: testNamespace},
+			Data:       map[string]string{resourcePolicyFilename: "package policy\n"},
+		},
+		newTestKbsResource("snp-only", time.Now(), selected),
+	)
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if name := r.getResourcePolicyConfigMapName(ctx); name != "kbs-resource-policy" {
+		t.Fatalf("expected KBS to mount the derived resource policy, got %s", name)
+	}
+	derived := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "kbs-resource-policy"}, derived); err != nil {
+		t.Fatal(err)
+	}
+	policy := derived.Data[resourcePolicyFilename]
+	if !strings.HasPrefix(policy, "package policy\n") || !strings.Contains(policy, "kbs_resource_selectors := {") ||
+		!strings.Contains(policy, `"default/keys/snp-only"`) || !strings.Contains(policy, `"snp"`) {
+		t.Errorf("unexpected derived resource policy:\n%s", policy)
+	}
+
+	// the original resource policy is mounted again once the selector is removed
+	resource := &confidentialcontainersorgv1alpha1.KbsResource{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "snp-only"}, resource); err != nil {
+		t.Fatal(err)
+	}
+	resource.Spec.PolicySelector = nil
+	if err := r.Update(ctx, resource); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if name := r.getResourcePolicyConfigMapName(ctx); name != "resource-policy" {
+		t.Errorf("expected KBS to mount the original resource policy, got %s", name)
+	}
+}
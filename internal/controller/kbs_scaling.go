@@ -0,0 +1,161 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Average CPU utilization targeted by the KBS autoscaler when no metrics are set
+	defaultTargetCPUUtilizationPercentage = int32(80)
+
+	// Reason used in the KbsConfig conditions
+	reasonScalingFailed = "ScalingReconcileFailed"
+)
+
+// kbsPodDisruptionBudgetName returns the name of the PodDisruptionBudget of the KBS pods
+func kbsPodDisruptionBudgetName(kbsConfigName string) string {
+	return kbsConfigName + "-pdb"
+}
+
+// kbsAutoscalerName returns the name of the HorizontalPodAutoscaler of the KBS deployment
+func kbsAutoscalerName(kbsConfigName string) string {
+	return kbsConfigName + "-hpa"
+}
+
+// kbsReplicas returns the replica count of a new KBS deployment, the minimum of the autoscaler when
+// the replicas are managed by it
+func kbsReplicas(spec confidentialcontainersorgv1alpha1.KbsDeploymentSpec) int32 {
+	switch {
+	case spec.Autoscaling != nil:
+		return autoscalingMinReplicas(spec.Autoscaling)
+	case spec.Replicas != nil:
+		return *spec.Replicas
+	}
+	return 1
+}
+
+// autoscalingMinReplicas returns the lower limit of the KBS autoscaler, 1 by default
+func autoscalingMinReplicas(autoscaling *confidentialcontainersorgv1alpha1.KbsAutoscalingSpec) int32 {
+	if autoscaling.MinReplicas != nil {
+		return *autoscaling.MinReplicas
+	}
+	return 1
+}
+
+// kbsAutoscalerMetrics returns the metrics of the KBS autoscaler: the CPU utilization, when it is set or
+// no other metric is, followed by the metrics of the spec
+func kbsAutoscalerMetrics(autoscaling *confidentialcontainersorgv1alpha1.KbsAutoscalingSpec) []autoscalingv2.MetricSpec {
+	var metrics []autoscalingv2.MetricSpec
+	if autoscaling.TargetCPUUtilizationPercentage != nil || len(autoscaling.Metrics) == 0 {
+		target := defaultTargetCPUUtilizationPercentage
+		if autoscaling.TargetCPUUtilizationPercentage != nil {
+			target = *autoscaling.TargetCPUUtilizationPercentage
+		}
+		metrics = append(metrics, autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ResourceMetricSourceType,
+			Resource: &autoscalingv2.ResourceMetricSource{
+				Name: corev1.ResourceCPU,
+				Target: autoscalingv2.MetricTarget{
+					Type:               autoscalingv2.UtilizationMetricType,
+					AverageUtilization: &target,
+				},
+			},
+		})
+	}
+	for _, metric := range autoscaling.Metrics {
+		metrics = append(metrics, *metric.DeepCopy())
+	}
+	return metrics
+}
+
+// deployOrUpdateKbsScaling creates, updates or deletes the PodDisruptionBudget and the
+// HorizontalPodAutoscaler of the KBS deployment
+func (r *KbsConfigReconciler) deployOrUpdateKbsScaling(ctx context.Context) error {
+	if err := r.deployOrUpdateKbsPodDisruptionBudget(ctx); err != nil {
+		return err
+	}
+	return r.deployOrUpdateKbsAutoscaler(ctx)
+}
+
+// deployOrUpdateKbsPodDisruptionBudget limits the voluntary evictions of the KBS pods
+func (r *KbsConfigReconciler) deployOrUpdateKbsPodDisruptionBudget(ctx context.Context) error {
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: kbsPodDisruptionBudgetName(r.kbsConfig.Name), Namespace: r.namespace}}
+	spec := r.kbsConfig.Spec.KbsDeploymentSpec.PodDisruptionBudget
+	if spec == nil {
+		return r.deleteOwnedObject(ctx, pdb)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, pdb, func() error {
+		if pdb.Labels == nil {
+			pdb.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, kbsComponent) {
+			pdb.Labels[k] = v
+		}
+		pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: standardLabels(r.kbsConfig.Name, kbsComponent)}
+		pdb.Spec.MinAvailable = spec.MinAvailable
+		pdb.Spec.MaxUnavailable = spec.MaxUnavailable
+		if spec.MinAvailable == nil && spec.MaxUnavailable == nil {
+			maxUnavailable := intstr.FromInt32(1)
+			pdb.Spec.MaxUnavailable = &maxUnavailable
+		}
+		return ctrl.SetControllerReference(r.kbsConfig, pdb, r.Scheme)
+	})
+	return err
+}
+
+// deployOrUpdateKbsAutoscaler scales the KBS deployment with a HorizontalPodAutoscaler
+func (r *KbsConfigReconciler) deployOrUpdateKbsAutoscaler(ctx context.Context) error {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: kbsAutoscalerName(r.kbsConfig.Name), Namespace: r.namespace}}
+	autoscaling := r.kbsConfig.Spec.KbsDeploymentSpec.Autoscaling
+	if autoscaling == nil {
+		return r.deleteOwnedObject(ctx, hpa)
+	}
+
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, hpa, func() error {
+		if hpa.Labels == nil {
+			hpa.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, kbsComponent) {
+			hpa.Labels[k] = v
+		}
+		minReplicas := autoscalingMinReplicas(autoscaling)
+		hpa.Spec.ScaleTargetRef = autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       kbsDeploymentName(r.kbsConfig.Name),
+		}
+		hpa.Spec.MinReplicas = &minReplicas
+		hpa.Spec.MaxReplicas = autoscaling.MaxReplicas
+		hpa.Spec.Metrics = kbsAutoscalerMetrics(autoscaling)
+		hpa.Spec.Behavior = autoscaling.Behavior.DeepCopy()
+		return ctrl.SetControllerReference(r.kbsConfig, hpa, r.Scheme)
+	})
+	return err
+}
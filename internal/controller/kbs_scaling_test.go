@@ -0,0 +1,170 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestDeployOrUpdateKbsScaling(t *testing.T) {
+	ctx := context.Background()
+	minReplicas := int32(2)
+	target := int32(60)
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsDeploymentSpec: confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+			PodDisruptionBudget: &confidentialcontainersorgv1alpha1.KbsPodDisruptionBudgetSpec{},
+			Autoscaling: &confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{
+				MinReplicas:                    &minReplicas,
+				MaxReplicas:                    6,
+				TargetCPUUtilizationPercentage: &target,
+				Metrics: []autoscalingv2.MetricSpec{{
+					Type: autoscalingv2.PodsMetricSourceType,
+					Pods: &autoscalingv2.PodsMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "kbs_attestation_requests_per_second"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType},
+					},
+				}},
+			},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig)
+
+	if err := r.deployOrUpdateKbsScaling(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pdb := &policyv1.PodDisruptionBudget{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: kbsPodDisruptionBudgetName("tenant-a")}, pdb); err != nil {
+		t.Fatal(err)
+	}
+	if pdb.Spec.MaxUnavailable == nil || pdb.Spec.MaxUnavailable.IntValue() != 1 || pdb.Spec.MinAvailable != nil {
+		t.Errorf("expected maxUnavailable to default to 1, got %+v", pdb.Spec)
+	}
+	if !reflect.DeepEqual(pdb.Spec.Selector.MatchLabels, standardLabels("tenant-a", kbsComponent)) {
+		t.Errorf("expected the disruption budget to select the KBS pods, got %v", pdb.Spec.Selector)
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: kbsAutoscalerName("tenant-a")}, hpa); err != nil {
+		t.Fatal(err)
+	}
+	if hpa.Spec.ScaleTargetRef.Kind != "Deployment" || hpa.Spec.ScaleTargetRef.Name != kbsDeploymentName("tenant-a") {
+		t.Errorf("expected the autoscaler to scale the KBS deployment, got %+v", hpa.Spec.ScaleTargetRef)
+	}
+	if *hpa.Spec.MinReplicas != 2 || hpa.Spec.MaxReplicas != 6 {
+		t.Errorf("expected 2 to 6 replicas, got %d to %d", *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+	}
+	if len(hpa.Spec.Metrics) != 2 || hpa.Spec.Metrics[0].Resource == nil ||
+		*hpa.Spec.Metrics[0].Resource.Target.AverageUtilization != 60 || hpa.Spec.Metrics[1].Pods == nil {
+		t.Errorf("expected the CPU utilization and the custom metric, got %+v", hpa.Spec.Metrics)
+	}
+
+	// Both are deleted once they are no longer configured
+	kbsConfig.Spec.KbsDeploymentSpec = confidentialcontainersorgv1alpha1.KbsDeploymentSpec{}
+	if err := r.deployOrUpdateKbsScaling(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pdb), &policyv1.PodDisruptionBudget{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the disruption budget to be deleted, got %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(hpa), &autoscalingv2.HorizontalPodAutoscaler{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the autoscaler to be deleted, got %v", err)
+	}
+}
+
+func TestKbsAutoscalerMetrics(t *testing.T) {
+	metrics := kbsAutoscalerMetrics(&confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{MaxReplicas: 3})
+	if len(metrics) != 1 || metrics[0].Resource == nil || metrics[0].Resource.Name != corev1.ResourceCPU ||
+		*metrics[0].Resource.Target.AverageUtilization != defaultTargetCPUUtilizationPercentage {
+		t.Errorf("expected the default CPU utilization target, got %+v", metrics)
+	}
+
+	external := autoscalingv2.MetricSpec{Type: autoscalingv2.ExternalMetricSourceType}
+	metrics = kbsAutoscalerMetrics(&confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{
+		MaxReplicas: 3,
+		Metrics:     []autoscalingv2.MetricSpec{external},
+	})
+	if len(metrics) != 1 || metrics[0].Type != autoscalingv2.ExternalMetricSourceType {
+		t.Errorf("expected only the metrics of the spec, got %+v", metrics)
+	}
+}
+
+func TestUpdateKbsDeploymentAutoscaling(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("OPERATOR_IMAGE_NAME", "trustee-operator:test")
+	replicas := int32(1)
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:  "kbs-config",
+		KbsAuthSecretName: "auth",
+		KbsDeploymentSpec: confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+			Replicas:    &replicas,
+			Autoscaling: &confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{MaxReplicas: 5},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: testNamespace}})
+
+	if _, err := r.deployOrUpdateKbsDeployment(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deployment := &appsv1.Deployment{}
+	key := client.ObjectKey{Namespace: testNamespace, Name: kbsDeploymentName("tenant-a")}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	// The autoscaler scales the deployment up, the reconcile keeps its replica count
+	scaled := int32(4)
+	deployment.Spec.Replicas = &scaled
+	if err := r.Update(ctx, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.deployOrUpdateKbsDeployment(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 4 {
+		t.Errorf("expected the replicas of the autoscaler to be kept, got %d", *deployment.Spec.Replicas)
+	}
+
+	// Without autoscaling the replicas of the spec apply again
+	kbsConfig.Spec.KbsDeploymentSpec.Autoscaling = nil
+	if _, err := r.deployOrUpdateKbsDeployment(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if *deployment.Spec.Replicas != 1 {
+		t.Errorf("expected the replicas of the spec, got %d", *deployment.Spec.Replicas)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Attestation policy directory volume name
+	attestationPolicyDirVolume = "attestation-policy-dir"
+
+	// RVPS directory volume name
+	rvpsDirVolume = "rvps-dir"
+
+	// Default capacity of the PersistentVolumeClaims managed by the operator
+	defaultStorageSize = "1Gi"
+)
+
+// kbsStorageDir is a writable KBS storage directory that can be backed by a persistent volume
+type kbsStorageDir struct {
+	volumeName string
+	field      string
+	spec       *confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec
+}
+
+// kbsStorageDirs returns the storage directories of the KBS instance together with their storage spec
+func kbsStorageDirs(spec confidentialcontainersorgv1alpha1.KbsStorageSpec) []kbsStorageDir {
+	return []kbsStorageDir{
+		{baseStorageDirVolume, "baseStorageDir", spec.BaseStorageDir},
+		{repositoryDir, "repositoryDir", spec.RepositoryDir},
+		{rvpsDirVolume, "rvpsDir", spec.RvpsDir},
+		{attestationPolicyDirVolume, "attestationPolicyDir", spec.AttestationPolicyDir},
+	}
+}
+
+// storageType returns the storage type of a directory, defaulting to EmptyDir
+func storageType(spec *confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec) confidentialcontainersorgv1alpha1.StorageType {
+	if spec == nil || spec.Type == "" {
+		return confidentialcontainersorgv1alpha1.StorageTypeEmptyDir
+	}
+	return spec.Type
+}
+
+// kbsStoragePVCName returns the name of the PersistentVolumeClaim managed for a storage directory
+func kbsStoragePVCName(kbsConfigName, volumeName string) string {
+	return kbsConfigName + "-" + volumeName
+}
+
+// createStorageVolume returns the emptyDir or PersistentVolumeClaim volume backing the named storage directory
+func (r *KbsConfigReconciler) createStorageVolume(volumeName string, spec *confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec) (*corev1.Volume, error) {
+	var claimName string
+	switch storageType(spec) {
+	case confidentialcontainersorgv1alpha1.StorageTypeEmptyDir:
+		return r.createEmptyDirVolume(volumeName)
+	case confidentialcontainersorgv1alpha1.StorageTypeManagedPVC:
+		claimName = kbsStoragePVCName(r.kbsConfig.Name, volumeName)
+	case confidentialcontainersorgv1alpha1.StorageTypeExistingPVC:
+		if spec.ClaimName == "" {
+			return nil, fmt.Errorf("claimName is required for the %s storage of %s", spec.Type, volumeName)
+		}
+		claimName = spec.ClaimName
+	default:
+		return nil, fmt.Errorf("unsupported storage type %q for %s", spec.Type, volumeName)
+	}
+
+	volume := corev1.Volume{
+		Name: volumeName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: claimName,
+			},
+		},
+	}
+	return &volume, nil
+}
+
+// newKbsStoragePVC returns the PersistentVolumeClaim managed by the operator for a storage directory
+func (r *KbsConfigReconciler) newKbsStoragePVC(dir kbsStorageDir) (*corev1.PersistentVolumeClaim, error) {
+	size := resource.MustParse(defaultStorageSize)
+	if dir.spec.Size != nil {
+		size = *dir.spec.Size
+	}
+	accessMode := corev1.ReadWriteOnce
+	if dir.spec.AccessMode != "" {
+		accessMode = dir.spec.AccessMode
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kbsStoragePVCName(r.kbsConfig.Name, dir.volumeName),
+			Namespace: r.namespace,
+			Labels:    standardLabels(r.kbsConfig.Name, kbsComponent),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+			StorageClassName: dir.spec.StorageClassName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	// Set KbsConfig instance as the owner and controller
+	if err := ctrl.SetControllerReference(r.kbsConfig, pvc, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pvc, nil
+}
+
+// deployOrUpdateKbsStorage creates the PersistentVolumeClaims managed by the operator and
+// expands them when a larger size is requested. The other PersistentVolumeClaim fields are
+// immutable, hence changes to the storage class or the access mode are not applied.
+// PersistentVolumeClaims are never deleted when a directory switches back to emptyDir:
+// they are garbage collected together with the KbsConfig.
+// Errors are logged by the callee and hence no error is logged in this method
+func (r *KbsConfigReconciler) deployOrUpdateKbsStorage(ctx context.Context) error {
+	dirs := append(kbsStorageDirs(r.kbsConfig.Spec.KbsStorageSpec), managedPostgresStorage(r.kbsConfig.Spec)...)
+	for _, dir := range dirs {
+		if storageType(dir.spec) != confidentialcontainersorgv1alpha1.StorageTypeManagedPVC {
+			continue
+		}
+		desired, err := r.newKbsStoragePVC(dir)
+		if err != nil {
+			return err
+		}
+
+		found := &corev1.PersistentVolumeClaim{}
+		err = r.Get(ctx, client.ObjectKeyFromObject(desired), found)
+		if err != nil && k8serrors.IsNotFound(err) {
+			r.log.Info("Creating a new PersistentVolumeClaim", "PersistentVolumeClaim.Namespace", desired.Namespace, "PersistentVolumeClaim.Name", desired.Name)
+			if err = r.Create(ctx, desired); err != nil {
+				r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "PersistentVolumeClaimCreateFailed", "PersistentVolumeClaimCreateFailed", err.Error())
+				return err
+			}
+			r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "PersistentVolumeClaimCreated", "PersistentVolumeClaimCreated",
+				fmt.Sprintf("PersistentVolumeClaim %s created for %s", desired.Name, dir.volumeName))
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !metav1.IsControlledBy(found, r.kbsConfig) {
+			return fmt.Errorf("PersistentVolumeClaim %s/%s already exists and is not managed by KbsConfig %s, use the ExistingPVC storage type to mount it",
+				found.Namespace, found.Name, r.kbsConfig.Name)
+		}
+
+		desiredSize := desired.Spec.Resources.Requests[corev1.ResourceStorage]
+		currentSize := found.Spec.Resources.Requests[corev1.ResourceStorage]
+		switch desiredSize.Cmp(currentSize) {
+		case 1:
+			r.log.Info("Expanding PersistentVolumeClaim", "PersistentVolumeClaim.Name", found.Name, "from", currentSize.String(), "to", desiredSize.String())
+			if found.Spec.Resources.Requests == nil {
+				found.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			found.Spec.Resources.Requests[corev1.ResourceStorage] = desiredSize
+			if err = r.Update(ctx, found); err != nil {
+				r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "PersistentVolumeClaimUpdateFailed", "PersistentVolumeClaimUpdateFailed", err.Error())
+				return err
+			}
+			r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "PersistentVolumeClaimUpdated", "PersistentVolumeClaimUpdated",
+				fmt.Sprintf("PersistentVolumeClaim %s expanded to %s", found.Name, desiredSize.String()))
+		case -1:
+			// Kubernetes does not support shrinking volumes
+			r.log.Info("Ignoring smaller size for PersistentVolumeClaim", "PersistentVolumeClaim.Name", found.Name, "current", currentSize.String(), "requested", desiredSize.String())
+		}
+	}
+	return nil
+}
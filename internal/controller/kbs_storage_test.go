@@ -0,0 +1,170 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestCreateStorageVolume(t *testing.T) {
+	r := &KbsConfigReconciler{
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		kbsConfig: newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{}),
+	}
+
+	volume, err := r.createStorageVolume(repositoryDir, nil)
+	if err != nil || volume.EmptyDir == nil {
+		t.Errorf("expected an emptyDir volume by default, got %+v, %v", volume, err)
+	}
+
+	volume, err = r.createStorageVolume(repositoryDir, &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+		Type: confidentialcontainersorgv1alpha1.StorageTypeManagedPVC,
+	})
+	if err != nil || volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != "tenant-a-repository-dir" {
+		t.Errorf("expected the managed PVC to be mounted, got %+v, %v", volume, err)
+	}
+	if volume.Name != repositoryDir {
+		t.Errorf("expected the volume name to be kept, got %q", volume.Name)
+	}
+
+	volume, err = r.createStorageVolume(rvpsDirVolume, &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+		Type:      confidentialcontainersorgv1alpha1.StorageTypeExistingPVC,
+		ClaimName: "my-rvps-claim",
+	})
+	if err != nil || volume.PersistentVolumeClaim == nil || volume.PersistentVolumeClaim.ClaimName != "my-rvps-claim" {
+		t.Errorf("expected the existing PVC to be mounted, got %+v, %v", volume, err)
+	}
+
+	_, err = r.createStorageVolume(rvpsDirVolume, &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+		Type: confidentialcontainersorgv1alpha1.StorageTypeExistingPVC,
+	})
+	if err == nil {
+		t.Error("expected an error for ExistingPVC without claimName")
+	}
+}
+
+func TestDeployOrUpdateKbsStorage(t *testing.T) {
+	scheme := newTestScheme(t)
+	storageClass := "fast"
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsStorageSpec: confidentialcontainersorgv1alpha1.KbsStorageSpec{
+			RepositoryDir: &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+				Type:             confidentialcontainersorgv1alpha1.StorageTypeManagedPVC,
+				StorageClassName: &storageClass,
+				AccessMode:       corev1.ReadWriteMany,
+			},
+			RvpsDir: &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+				Type:      confidentialcontainersorgv1alpha1.StorageTypeExistingPVC,
+				ClaimName: "my-rvps-claim",
+			},
+		},
+	})
+	r := &KbsConfigReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Scheme:    scheme,
+		Recorder:  events.NewFakeRecorder(10),
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		kbsConfig: kbsConfig,
+	}
+	ctx := context.Background()
+
+	if err := r.deployOrUpdateKbsStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcList); err != nil {
+		t.Fatal(err)
+	}
+	if len(pvcList.Items) != 1 {
+		t.Fatalf("expected only the managed PVC to be created, got %d", len(pvcList.Items))
+	}
+	pvc := pvcList.Items[0]
+	if pvc.Name != "tenant-a-repository-dir" || !metav1.IsControlledBy(&pvc, kbsConfig) {
+		t.Errorf("expected PVC tenant-a-repository-dir owned by the KbsConfig, got %s owned by %v", pvc.Name, pvc.OwnerReferences)
+	}
+	if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(resource.MustParse(defaultStorageSize)) != 0 {
+		t.Errorf("expected the default size %s, got %s", defaultStorageSize, size.String())
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName != storageClass ||
+		len(pvc.Spec.AccessModes) != 1 || pvc.Spec.AccessModes[0] != corev1.ReadWriteMany {
+		t.Errorf("unexpected PVC spec %+v", pvc.Spec)
+	}
+
+	// a larger size expands the PVC
+	larger := resource.MustParse("5Gi")
+	kbsConfig.Spec.KbsStorageSpec.RepositoryDir.Size = &larger
+	if err := r.deployOrUpdateKbsStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&pvc), &pvc); err != nil {
+		t.Fatal(err)
+	}
+	if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(larger) != 0 {
+		t.Errorf("expected the PVC to be expanded to 5Gi, got %s", size.String())
+	}
+
+	// a smaller size is ignored
+	smaller := resource.MustParse("2Gi")
+	kbsConfig.Spec.KbsStorageSpec.RepositoryDir.Size = &smaller
+	if err := r.deployOrUpdateKbsStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(&pvc), &pvc); err != nil {
+		t.Fatal(err)
+	}
+	if size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(larger) != 0 {
+		t.Errorf("expected the PVC not to shrink, got %s", size.String())
+	}
+}
+
+func TestDeployOrUpdateKbsStorageForeignPVC(t *testing.T) {
+	scheme := newTestScheme(t)
+	foreign := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant-a-base-storage-dir", Namespace: testNamespace},
+	}
+	r := &KbsConfigReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(foreign).Build(),
+		Scheme:    scheme,
+		Recorder:  events.NewFakeRecorder(10),
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		kbsConfig: newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+			KbsStorageSpec: confidentialcontainersorgv1alpha1.KbsStorageSpec{
+				BaseStorageDir: &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+					Type: confidentialcontainersorgv1alpha1.StorageTypeManagedPVC,
+				},
+			},
+		}),
+	}
+
+	if err := r.deployOrUpdateKbsStorage(context.Background()); err == nil {
+		t.Error("expected an error for a PVC not owned by the KbsConfig")
+	}
+}
@@ -18,19 +18,30 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	configv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/tools/events"
@@ -41,6 +52,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
 
 	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
 	"github.com/go-logr/logr"
@@ -60,10 +72,21 @@ type KbsConfigReconciler struct {
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=kbsconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=kbsconfigs/finalizers,verbs=update
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
-//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=kbsresources,verbs=get;list;watch
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=attestationpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=referencevalues,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;update
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=route.openshift.io,resources=routes/custom-host,verbs=create;update;patch
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=gateways,verbs=get;list;watch
 //+kubebuilder:rbac:groups=config.openshift.io,resources=proxies,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -125,53 +148,217 @@ func (r *KbsConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, nil
 	}
 
+	// Add the kbsFinalizer before creating any owned object so that the
+	// status conditions recorded below are not lost by the update
+	err = r.addKbsConfigFinalizer(ctx)
+	if err != nil {
+		r.log.Info("Error adding kbsFinalizer", "err", err)
+		return ctrl.Result{}, err
+	}
+
+	// Verify that every ConfigMap and Secret referenced by the KbsConfig exists
+	err = r.resolveReferencedObjects(ctx)
+	if err != nil {
+		r.log.Info("Error resolving KbsConfig references", "err", err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Create or expand the PersistentVolumeClaims backing the KBS storage directories
+	err = r.deployOrUpdateKbsStorage(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating KBS storage", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonStorageFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Gather the contents of the KbsResources served by this KbsConfig
+	err = r.deployOrUpdateKbsResources(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating KBS resources", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonKbsResourcesFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Gather the valid AttestationPolicies served by this KbsConfig
+	err = r.deployOrUpdateAttestationPolicies(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating attestation policies", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonAttestationPoliciesFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Aggregate the unexpired ReferenceValues served by this KbsConfig
+	nextExpiration, err := r.deployOrUpdateReferenceValues(ctx, time.Now())
+	if err != nil {
+		r.log.Info("Error in creating/updating reference values", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonReferenceValuesFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Deploy the PostgreSQL instance shared by the KBS replicas when it is managed by the operator
+	err = r.deployOrUpdateSharedStorage(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating shared storage", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonSharedStorageFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Store the KBS configuration with the attestation service and shared storage credentials in a Secret
+	err = r.deployOrUpdateKbsConfigSecret(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating KBS config secret", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonKbsConfigSecretFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
 	// Create or update the KBS deployment
 	created, err := r.deployOrUpdateKbsDeployment(ctx)
 	if err != nil {
 		r.log.Info("Error in creating/updating KBS deployment", "err", err)
-		return ctrl.Result{}, err
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonDeploymentFailed, err)
+		return r.reconcileFailed(ctx, err)
 	}
-	// On a fresh creation the deployment replicas will not be ready yet; requeue
-	// after a short delay so the status check reflects the actual pod state.
-	if created {
-		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+
+	// Create or update the attestation service and RVPS deployments when they run separately from KBS
+	err = r.deployOrUpdateMicroservices(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating microservices", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonDeploymentFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Create or update the PodDisruptionBudget and the HorizontalPodAutoscaler of the KBS deployment
+	err = r.deployOrUpdateKbsScaling(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating KBS disruption budget and autoscaler", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, reasonScalingFailed, err)
+		return r.reconcileFailed(ctx, err)
 	}
 
 	// Create or update the KBS service
 	err = r.deployOrUpdateKbsService(ctx)
 	if err != nil {
 		r.log.Info("Error in creating/updating KBS service", "err", err)
-		return ctrl.Result{}, err
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionServiceReady, reasonServiceFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionServiceReady, metav1.ConditionTrue,
+		reasonServiceReconciled, "KBS service is up to date")
+
+	// Create or update the Ingress, Route or HTTPRoute exposing the KBS service
+	err = r.deployOrUpdateKbsExposure(ctx)
+	if err != nil {
+		r.log.Info("Error in creating/updating KBS exposure", "err", err)
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionExposed, reasonExposureFailed, err)
+		return r.reconcileFailed(ctx, err)
+	}
+
+	// Remove the deployment left behind by older operator versions and keep their service as an alias
+	err = r.reconcileLegacyKbsResources(ctx)
+	if err != nil {
+		r.log.Info("Error reconciling legacy KBS deployment/service", "err", err)
 	}
 
 	// Update KbsConfig status based on deployment readiness
-	err = r.updateKbsConfigStatus(ctx)
+	err = r.updateKbsConfigStatus(ctx, true)
 	if err != nil {
 		r.log.Info("Error updating KbsConfig status", "err", err)
 		return ctrl.Result{}, err
 	}
 
+	// On a fresh creation the deployment replicas will not be ready yet; requeue
+	// after a short delay so the status check reflects the actual pod state.
+	if created {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	// Write the reference values again without the ReferenceValues reaching their expiration
+	if !nextExpiration.IsZero() {
+		return ctrl.Result{RequeueAfter: time.Until(nextExpiration) + time.Second}, nil
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// reconcileFailed writes the conditions recorded by the failing step and returns the original error
+func (r *KbsConfigReconciler) reconcileFailed(ctx context.Context, err error) (ctrl.Result, error) {
+	statusErr := r.updateKbsConfigStatus(ctx, false)
+	if statusErr != nil {
+		r.log.Info("Error updating KbsConfig status", "err", statusErr)
+	}
+	return ctrl.Result{}, err
+}
+
 // finalizeKbsConfig deletes the KBS deployment
 // Errors are logged by the callee and hence no error is logged in this method
 func (r *KbsConfigReconciler) finalizeKbsConfig(ctx context.Context) error {
 	// Delete the deployment
-	r.log.Info("Deleting the KBS deployment")
-	// Get the KbsDeploymentName deployment
+	deploymentName := kbsDeploymentName(r.kbsConfig.Name)
+	r.log.Info("Deleting the KBS deployment", "Deployment.Name", deploymentName)
 	deployment := &appsv1.Deployment{}
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: r.namespace,
-		Name:      KbsDeploymentName,
+		Name:      deploymentName,
 	}, deployment)
-	if err != nil {
+	if err != nil && k8serrors.IsNotFound(err) {
+		// Nothing to delete, e.g. the deployment was never created
+		return nil
+	} else if err != nil {
 		return err
 	}
 	err = r.Delete(ctx, deployment)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// reconcileLegacyKbsResources handles the deployment and service created with the fixed names used
+// before they were derived from the KbsConfig name. The deployment is deleted, the service is kept
+// so that the clients and initdata URLs pointing at it keep working, and selects the pods of the
+// new deployment. Only objects controlled by this KbsConfig are changed.
+// Errors are logged by the callee and hence no error is logged in this method
+func (r *KbsConfigReconciler) reconcileLegacyKbsResources(ctx context.Context) error {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: legacyKbsDeploymentName}, deployment)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(deployment, r.kbsConfig) {
+		r.log.Info("Deleting legacy KBS deployment", "Deployment.Name", deployment.Name)
+		err = r.Delete(ctx, deployment)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	service := &corev1.Service{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: legacyKbsServiceName}, service)
+	if err != nil && k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(service, r.kbsConfig) {
+		return nil
+	}
+	selector := standardLabels(r.kbsConfig.Name, kbsComponent)
+	deprecated := fmt.Sprintf("%s is deprecated and will be removed in a future release, use %s",
+		legacyKbsServiceName, kbsServiceName(r.kbsConfig.Name))
+	if apiequality.Semantic.DeepEqual(service.Spec.Selector, selector) && service.Annotations[legacyKbsServiceDeprecatedAnnotation] == deprecated {
+		return nil
+	}
+	service.Spec.Selector = selector
+	service.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
+	if service.Annotations == nil {
+		service.Annotations = make(map[string]string)
+	}
+	service.Annotations[legacyKbsServiceDeprecatedAnnotation] = deprecated
+	r.log.Info("Keeping legacy KBS service as an alias", "Service.Name", service.Name, "Service.Target", kbsServiceName(r.kbsConfig.Name))
+	if err := r.Update(ctx, service); err != nil {
 		return err
 	}
+	r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "LegacyKbsService", "LegacyKbsService", deprecated)
 	return nil
 }
 
@@ -179,19 +366,20 @@ func (r *KbsConfigReconciler) finalizeKbsConfig(ctx context.Context) error {
 // Errors are logged by the callee and hence no error is logged in this method
 func (r *KbsConfigReconciler) deployOrUpdateKbsService(ctx context.Context) error {
 
-	// Check if the service name kbs-service in r.namespace already exists
+	// Check if the KBS service of this KbsConfig already exists in r.namespace
 	// If it does, update the service
 	// If it does not, create the service
+	serviceName := kbsServiceName(r.kbsConfig.Name)
 	found := &corev1.Service{}
 
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: r.namespace,
-		Name:      KbsServiceName,
+		Name:      serviceName,
 	}, found)
 
 	if err != nil && k8serrors.IsNotFound(err) {
 		// Create the service
-		r.log.Info("Creating a new service", "Service.Namespace", r.namespace, "Service.Name", KbsServiceName)
+		r.log.Info("Creating a new service", "Service.Namespace", r.namespace, "Service.Name", serviceName)
 		service := r.newKbsService(ctx)
 		// If service object is nil, return error
 		if service == nil {
@@ -211,7 +399,7 @@ func (r *KbsConfigReconciler) deployOrUpdateKbsService(ctx context.Context) erro
 	}
 
 	// Service already exists, so update the service
-	r.log.Info("Updating the service", "Service.Namespace", r.namespace, "Service.Name", KbsServiceName)
+	r.log.Info("Updating the service", "Service.Namespace", r.namespace, "Service.Name", serviceName)
 	service := r.newKbsService(ctx)
 	// If service object is nil, return error
 	if service == nil {
@@ -240,23 +428,25 @@ func (r *KbsConfigReconciler) newKbsService(ctx context.Context) *corev1.Service
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: r.namespace,
-			Name:      KbsServiceName,
+			Name:      kbsServiceName(r.kbsConfig.Name),
+			Labels:    standardLabels(r.kbsConfig.Name, kbsComponent),
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: map[string]string{
-				"app": "kbs",
-			},
-			Type: serviceType,
+			Selector: standardLabels(r.kbsConfig.Name, kbsComponent),
+			Type:     serviceType,
 			Ports: []corev1.ServicePort{
 				{
-					Name:       "kbs-port",
+					Name:       kbsServicePortName,
 					Protocol:   corev1.ProtocolTCP,
-					Port:       8080,
-					TargetPort: intstr.FromInt(8080),
+					Port:       kbsServicePort,
+					TargetPort: intstr.FromInt(kbsServicePort),
 				},
 			},
 		},
 	}
+	// The sessions are held in the memory of the replica, also with a shared storage backend,
+	// the clients are pinned to the replica holding their session
+	service.Spec.SessionAffinity = corev1.ServiceAffinityClientIP
 	// Set KbsConfig instance as the owner and controller
 	err := ctrl.SetControllerReference(r.kbsConfig, service, r.Scheme)
 	if err != nil {
@@ -271,19 +461,20 @@ func (r *KbsConfigReconciler) newKbsService(ctx context.Context) *corev1.Service
 // Errors are logged by the callee and hence no error is logged in this method
 func (r *KbsConfigReconciler) deployOrUpdateKbsDeployment(ctx context.Context) (bool, error) {
 
-	// Check if the deployment name kbs-deployment in r.namespace already exists
+	// Check if the KBS deployment of this KbsConfig already exists in r.namespace
 	// If it does, update the deployment
 	// If it does not, create the deployment
+	deploymentName := kbsDeploymentName(r.kbsConfig.Name)
 	found := &appsv1.Deployment{}
 
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: r.namespace,
-		Name:      KbsDeploymentName,
+		Name:      deploymentName,
 	}, found)
 
 	if err != nil && k8serrors.IsNotFound(err) {
 		// Create the deployment
-		r.log.Info("Creating a new deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", KbsDeploymentName)
+		r.log.Info("Creating a new deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", deploymentName)
 		deployment, err := r.newKbsDeployment(ctx)
 		if err != nil {
 			return false, err
@@ -294,10 +485,9 @@ func (r *KbsConfigReconciler) deployOrUpdateKbsDeployment(ctx context.Context) (
 			return false, err
 		}
 		// Deployment created successfully
-		r.log.Info("Created a new deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", KbsDeploymentName)
+		r.log.Info("Created a new deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", deploymentName)
 		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "DeploymentCreated", "DeploymentCreated", "Trustee deployment created successfully")
-		// Add the kbsFinalizer to the KbsConfig if it doesn't already exist
-		return true, r.addKbsConfigFinalizer(ctx)
+		return true, nil
 	} else if err != nil {
 		// Unknown error
 		return false, err
@@ -309,7 +499,7 @@ func (r *KbsConfigReconciler) deployOrUpdateKbsDeployment(ctx context.Context) (
 		return false, err
 	}
 	if updated {
-		r.log.Info("Updated Deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", KbsDeploymentName)
+		r.log.Info("Updated Deployment", "Deployment.Namespace", r.namespace, "Deployment.Name", deploymentName)
 		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "DeploymentUpdated", "DeploymentUpdated", "Trustee deployment updated successfully")
 	}
 
@@ -331,10 +521,7 @@ func (r *KbsConfigReconciler) addKbsConfigFinalizer(ctx context.Context) error {
 // newKbsDeployment returns a new deployment for the KBS instance
 func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Deployment, error) {
 	// Set replica count
-	replicas := int32(1)
-	if r.kbsConfig.Spec.KbsDeploymentSpec.Replicas != nil {
-		replicas = *r.kbsConfig.Spec.KbsDeploymentSpec.Replicas
-	}
+	replicas := kbsReplicas(r.kbsConfig.Spec.KbsDeploymentSpec)
 
 	// Set rolling update strategy
 	rollingUpdate := &appsv1.RollingUpdateDeployment{
@@ -343,16 +530,57 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 			IntVal: 1,
 		},
 	}
-	// Set labels
-	labels := map[string]string{
-		"app": "kbs",
+	// Set labels, derived from the KbsConfig name so that several instances
+	// can coexist in the same namespace
+	labels := standardLabels(r.kbsConfig.Name, kbsComponent)
+
+	podSpec, err := r.buildKbsPodSpec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if r.separateMicroservices() {
+		// The attestation service and RVPS run in Deployments of their own
+		podSpec = podSpecForComponent(podSpec, kbsComponent)
 	}
+	applyPodScheduling(&podSpec, r.kbsConfig.Spec.KbsDeploymentSpec, labels)
 
-	// deployment type defaulted to microservices
-	kbsDeploymentType := r.kbsConfig.Spec.KbsDeploymentType
-	if kbsDeploymentType == "" {
-		kbsDeploymentType = confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices
+	// Create the deployment
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      kbsDeploymentName(r.kbsConfig.Name),
+			Namespace: r.namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Strategy: appsv1.DeploymentStrategy{
+				RollingUpdate: rollingUpdate,
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: r.getPodTemplateHashAnnotations(ctx),
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	// Set KbsConfig instance as the owner and controller
+	err = ctrl.SetControllerReference(r.kbsConfig, deployment, r.Scheme)
+	if err != nil {
+		return nil, err
 	}
+	return deployment, nil
+}
+
+// buildKbsPodSpec returns the spec of the KBS pod with its init container and, with the MicroservicesDeployment
+// type, the attestation service and RVPS containers
+func (r *KbsConfigReconciler) buildKbsPodSpec(ctx context.Context) (corev1.PodSpec, error) {
+	kbsDeploymentType := r.kbsConfig.Spec.DeploymentType()
 
 	var volumes []corev1.Volume
 	var kbsVM []corev1.VolumeMount
@@ -360,46 +588,62 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	var rvpsVM []corev1.VolumeMount
 
 	// kbs-config
-	volume, err := r.createConfigMapVolume(ctx, "kbs-config", r.kbsConfig.Spec.KbsConfigMapName)
+	volume, err := r.createKbsConfigVolume(ctx)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumeMount := createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
 	volumes = append(volumes, *volume)
 	kbsVM = append(kbsVM, volumeMount)
 
-	// base storage directory - create empty writable directory for session storage
-	volume, err = r.createEmptyDirVolume(baseStorageDirVolume)
+	storage := r.kbsConfig.Spec.KbsStorageSpec
+
+	// base storage directory - writable directory for session storage
+	volume, err = r.createStorageVolume(baseStorageDirVolume, storage.BaseStorageDir)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, baseStoragePath)
 	kbsVM = append(kbsVM, volumeMount)
 
-	// attestation policy directory - create empty writable directory
-	volume, err = r.createEmptyDirVolume("attestation-policy-dir")
+	// attestation policy directory - writable directory
+	volume, err = r.createStorageVolume(attestationPolicyDirVolume, storage.AttestationPolicyDir)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, attestationPolicyPath)
-	if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+	if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
 		kbsVM = append(kbsVM, volumeMount)
 	} else {
 		asVM = append(asVM, volumeMount)
 	}
 
+	// AttestationPolicies, they replace the default policies of the ConfigMaps below
+	policiesVol, policiesVM, err := r.createAttestationPoliciesVolume(ctx)
+	if err != nil {
+		return corev1.PodSpec{}, err
+	}
+	if policiesVol != nil {
+		volumes = append(volumes, *policiesVol)
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+			kbsVM = append(kbsVM, policiesVM...)
+		} else {
+			asVM = append(asVM, policiesVM...)
+		}
+	}
+
 	// attestation policy
-	if r.kbsConfig.Spec.KbsAttestationPolicyConfigMapName != "" {
+	if r.kbsConfig.Spec.KbsAttestationPolicyConfigMapName != "" && !attestationPoliciesReplace(policiesVM, defaultAttestationCpuPolicy) {
 		volume, err = r.createConfigMapVolume(ctx, "attestation-policy", r.kbsConfig.Spec.KbsAttestationPolicyConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		// attestation policy file is "/opt/confidential-containers/storage/attestation_service_policy/default_cpu.rego"
 		volumeMount = createVolumeMountWithSubpath(volume.Name, filepath.Join(attestationPolicyPath, defaultAttestationCpuPolicy), defaultAttestationCpuPolicy)
 		volumes = append(volumes, *volume)
-		if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
 			kbsVM = append(kbsVM, volumeMount)
 		} else {
 			asVM = append(asVM, volumeMount)
@@ -407,15 +651,15 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	}
 
 	// GPU attestation policy
-	if r.kbsConfig.Spec.KbsGpuAttestationPolicyConfigMapName != "" {
+	if r.kbsConfig.Spec.KbsGpuAttestationPolicyConfigMapName != "" && !attestationPoliciesReplace(policiesVM, defaultAttestationGpuPolicy) {
 		volume, err = r.createConfigMapVolume(ctx, "attestation-policy-gpu", r.kbsConfig.Spec.KbsGpuAttestationPolicyConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		// GPU attestation policy file is "/opt/confidential-containers/storage/attestation_service_policy/default_gpu.rego"
 		volumeMount = createVolumeMountWithSubpath(volume.Name, filepath.Join(attestationPolicyPath, defaultAttestationGpuPolicy), defaultAttestationGpuPolicy)
 		volumes = append(volumes, *volume)
-		if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
 			kbsVM = append(kbsVM, volumeMount)
 		} else {
 			asVM = append(asVM, volumeMount)
@@ -425,17 +669,17 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	// resource policy directory - create empty writable directory
 	volume, err = r.createEmptyDirVolume(resourcePolicyDirVolume)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, kbsStoragePath)
 	kbsVM = append(kbsVM, volumeMount)
 
-	// resource policy
-	if r.kbsConfig.Spec.KbsResourcePolicyConfigMapName != "" {
-		volume, err = r.createConfigMapVolume(ctx, "resource-policy", r.kbsConfig.Spec.KbsResourcePolicyConfigMapName)
+	// resource policy, extended with the KbsResource policy selectors if any
+	if resourcePolicyConfigMapName := r.getResourcePolicyConfigMapName(ctx); resourcePolicyConfigMapName != "" {
+		volume, err = r.createConfigMapVolume(ctx, "resource-policy", resourcePolicyConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumeMount = createVolumeMountWithSubpath(volume.Name, filepath.Join(kbsStoragePath, resourcePolicyFilename), resourcePolicyFilename)
 		volumes = append(volumes, *volume)
@@ -446,34 +690,53 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	if r.kbsConfig.Spec.IbmSEConfigSpec.CertStorePvc != "" {
 		volume, err := r.createPVCVolume(ctx, r.kbsConfig.Spec.IbmSEConfigSpec.CertStorePvc)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumeMount = createVolumeMount(volume.Name, ibmSePath)
 		volumes = append(volumes, *volume)
-		kbsVM = append(kbsVM, volumeMount)
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+			asVM = append(asVM, volumeMount)
+		} else {
+			kbsVM = append(kbsVM, volumeMount)
+		}
 	}
 
 	// auth-secret
 	volume, err = r.createSecretVolume(ctx, "auth-secret", r.kbsConfig.Spec.KbsAuthSecretName)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
 	kbsVM = append(kbsVM, volumeMount)
 
+	// public keys of the admin personas
+	if r.kbsConfig.Spec.KbsAdminPublicKeysSecretName != "" {
+		volume, err = r.createSecretVolume(ctx, adminPublicKeysVolume, r.kbsConfig.Spec.KbsAdminPublicKeysSecretName)
+		if err != nil {
+			return corev1.PodSpec{}, err
+		}
+		volumes = append(volumes, *volume)
+		volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
+		kbsVM = append(kbsVM, volumeMount)
+	}
+
 	// Mount local directories into secrets
 	for _, certCacheEntry := range r.kbsConfig.Spec.KbsLocalCertCacheSpec.Secrets {
 		volume, err = r.createSecretVolume(ctx, certCacheEntry.SecretName, certCacheEntry.SecretName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		if certCacheEntry.MountPath == "" {
 			certCacheEntry.MountPath = kbsDefaultLocalCacheDir
 		}
 		volumeMount = createVolumeMount(volume.Name, certCacheEntry.MountPath)
-		kbsVM = append(kbsVM, volumeMount)
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+			asVM = append(asVM, volumeMount)
+		} else {
+			kbsVM = append(kbsVM, volumeMount)
+		}
 	}
 
 	// https
@@ -481,7 +744,7 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	if r.isHttpsConfigPresent() {
 		volume, err = r.createSecretVolume(ctx, "https-key", r.kbsConfig.Spec.KbsHttpsKeySecretName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
@@ -489,94 +752,125 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 
 		volume, err = r.createSecretVolume(ctx, "https-cert", r.kbsConfig.Spec.KbsHttpsCertSecretName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
 		kbsVM = append(kbsVM, volumeMount)
 	}
 
-	// attestation token
+	// attestation token, signed by the attestation service and verified by KBS
 	if r.isAttestationConfigPresent() {
 		volume, err = r.createSecretVolume(ctx, "attestation-key", r.kbsConfig.Spec.KbsAttestationKeySecretName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
-		kbsVM = append(kbsVM, volumeMount)
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+			asVM = append(asVM, volumeMount)
+		} else {
+			kbsVM = append(kbsVM, volumeMount)
+		}
 
 		volume, err = r.createSecretVolume(ctx, "attestation-cert", r.kbsConfig.Spec.KbsAttestationCertSecretName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(kbsDefaultConfigPath, volume.Name))
 		kbsVM = append(kbsVM, volumeMount)
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+			asVM = append(asVM, volumeMount)
+		}
 	}
 
-	// repository directory - create empty writable directory for KBS resources
+	// repository directory - writable directory for KBS resources
 	// This must exist before secret-converter tries to write to it
-	volume, err = r.createEmptyDirVolume(repositoryDir)
+	volume, err = r.createStorageVolume(repositoryDir, storage.RepositoryDir)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, RepositoryPath)
 	kbsVM = append(kbsVM, volumeMount)
 
-	// kbs secret resources
-	// Mount secrets to /tmp/kbs-secrets/<secret-name> temporarily
-	// The secret-converter init container will copy them to the final location
-	kbsSecretVolumes, err := r.createKbsSecretResourcesVolume(ctx)
-	if err != nil {
-		return nil, err
-	}
-	volumes = append(volumes, kbsSecretVolumes...)
 	var secretConverterVM []corev1.VolumeMount
-	for _, vol := range kbsSecretVolumes {
-		// Mount to temporary location for secret-converter to read
-		volumeMount = createVolumeMount(vol.Name, filepath.Join(KbsSecretsMountPath, vol.Name))
-		secretConverterVM = append(secretConverterVM, volumeMount)
+	if secretConverterSidecar(r.kbsConfig.Spec) {
+		// The sidecar reads the secret resources and the KbsResource contents from a single secret,
+		// so that adding or removing a resource does not change the pod template
+		sidecarVol := createSidecarResourcesVolume(r.kbsConfig.Name)
+		volumes = append(volumes, sidecarVol)
+		secretConverterVM = append(secretConverterVM, createVolumeMount(sidecarVol.Name, SidecarResourcesMountPath))
+	} else {
+		// kbs secret resources
+		// Mount secrets to /tmp/kbs-secrets/<secret-name> temporarily
+		// The secret-converter init container will copy them to the final location
+		kbsSecretVolumes := r.createKbsSecretResourcesVolume()
+		volumes = append(volumes, kbsSecretVolumes...)
+		for _, vol := range kbsSecretVolumes {
+			// Mount to temporary location for secret-converter to read
+			volumeMount = createVolumeMount(vol.Name, filepath.Join(KbsSecretsMountPath, vol.Name))
+			secretConverterVM = append(secretConverterVM, volumeMount)
+		}
+
+		// KbsResource contents, projected to <repository>/<type>/<tag> for the secret-converter
+		kbsResourcesVol, err := r.createKbsResourcesVolume(ctx)
+		if err != nil {
+			return corev1.PodSpec{}, err
+		}
+		if kbsResourcesVol != nil {
+			volumes = append(volumes, *kbsResourcesVol)
+			secretConverterVM = append(secretConverterVM, createVolumeMount(kbsResourcesVol.Name, KbsResourcesMountPath))
+		}
 	}
 
-	// rvps directory - create empty writable directory for RVPS storage
-	volume, err = r.createEmptyDirVolume("rvps-dir")
+	// Service account tokens and CA bundles of the Vault sources, only mounted in the secret-converter
+	if vaultVol := createVaultSourcesVolume(r.kbsConfig.Spec); vaultVol != nil {
+		volumes = append(volumes, *vaultVol)
+		vaultVM := createVolumeMount(vaultVol.Name, VaultSourcesMountPath)
+		vaultVM.ReadOnly = true
+		secretConverterVM = append(secretConverterVM, vaultVM)
+	}
+
+	// rvps directory - writable directory for RVPS storage
+	volume, err = r.createStorageVolume(rvpsDirVolume, storage.RvpsDir)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 	volumes = append(volumes, *volume)
 	volumeMount = createVolumeMount(volume.Name, rvpsReferenceValuesPath)
 
 	// For the DeploymentTypeAllInOne case, mount the rvps directory in kbs
-	if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+	if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
 		kbsVM = append(kbsVM, volumeMount)
 	} else {
 		rvpsVM = append(rvpsVM, volumeMount)
 	}
 
-	// reference-values from ConfigMap (if provided, mount as a file into the rvps directory)
-	if r.kbsConfig.Spec.KbsRvpsRefValuesConfigMapName != "" {
-		volume, err = r.createConfigMapVolume(ctx, "reference-values", r.kbsConfig.Spec.KbsRvpsRefValuesConfigMapName)
+	// reference-values from ConfigMap (if provided, mount as a file into the rvps directory),
+	// extended with the ReferenceValues if any
+	if referenceValuesConfigMapName := r.getReferenceValuesConfigMapName(ctx); referenceValuesConfigMapName != "" {
+		volume, err = r.createConfigMapVolume(ctx, "reference-values", referenceValuesConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		// Mount the reference_value file from ConfigMap into the rvps directory with subpath
-		volumeMount = createVolumeMountWithSubpath(volume.Name, filepath.Join(rvpsReferenceValuesPath, "reference_value"), "reference_value")
+		volumeMount = createVolumeMountWithSubpath(volume.Name, filepath.Join(rvpsReferenceValuesPath, referenceValueFilename), referenceValueFilename)
 		volumes = append(volumes, *volume)
-		if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+		if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
 			kbsVM = append(kbsVM, volumeMount)
 		} else {
 			rvpsVM = append(rvpsVM, volumeMount)
 		}
 	}
 
-	if r.kbsConfig.Spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+	if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
 
 		// as-config
 		volume, err = r.createConfigMapVolume(ctx, "as-config", r.kbsConfig.Spec.KbsAsConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(asDefaultConfigPath, volume.Name))
@@ -585,7 +879,7 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 		// rvps-config
 		volume, err = r.createConfigMapVolume(ctx, "rvps-config", r.kbsConfig.Spec.KbsRvpsConfigMapName)
 		if err != nil {
-			return nil, err
+			return corev1.PodSpec{}, err
 		}
 		volumes = append(volumes, *volume)
 		volumeMount = createVolumeMount(volume.Name, filepath.Join(rvpsDefaultConfigPath, volume.Name))
@@ -594,7 +888,8 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 
 	securityContext := createSecurityContext()
 	env := buildEnvVars(r, ctx)
-	containers := []corev1.Container{r.buildKbsContainer(kbsVM, securityContext, env, kbsDeploymentType)}
+	kbsImage := kbsImageName(kbsDeploymentType, r.kbsAttestationServiceType(ctx))
+	containers := []corev1.Container{r.buildKbsContainer(kbsVM, securityContext, env, kbsImage)}
 
 	if kbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
 		// build AS container
@@ -607,49 +902,28 @@ func (r *KbsConfigReconciler) newKbsDeployment(ctx context.Context) (*appsv1.Dep
 	// Pass both the confidential-containers volume (for writing) and secret volumes (for reading)
 	allSecretConverterVM := append([]corev1.VolumeMount{}, kbsVM...)
 	allSecretConverterVM = append(allSecretConverterVM, secretConverterVM...)
-	secretConverterContainer, err := r.buildSecretConverterInitContainer(allSecretConverterVM)
+	// The sidecar reads the paths of the secret resources from the index of the sidecar resources secret
+	var secretPaths map[string]SecretResourcePath
+	var missingSecrets []string
+	if !secretConverterSidecar(r.kbsConfig.Spec) {
+		secretPaths, missingSecrets, err = r.getSecretResourcePaths(ctx)
+		if err != nil {
+			return corev1.PodSpec{}, err
+		}
+	}
+	secretConverterContainer, err := r.buildSecretConverterInitContainer(allSecretConverterVM, secretPaths, missingSecrets)
 	if err != nil {
-		return nil, err
+		return corev1.PodSpec{}, err
 	}
 
-	// Create the deployment
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      KbsDeploymentName,
-			Namespace: r.namespace,
+	return corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			secretConverterContainer,
 		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: &replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Strategy: appsv1.DeploymentStrategy{
-				RollingUpdate: rollingUpdate,
-				Type:          appsv1.RollingUpdateDeploymentStrategyType,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels:      labels,
-					Annotations: r.getConfigMapVersionAnnotations(ctx),
-				},
-				// Add the KBS container
-				Spec: corev1.PodSpec{
-					InitContainers: []corev1.Container{
-						secretConverterContainer,
-					},
-					Containers: containers,
-					// Add volumes
-					Volumes: volumes,
-				},
-			},
-		},
-	}
-	// Set KbsConfig instance as the owner and controller
-	err = ctrl.SetControllerReference(r.kbsConfig, deployment, r.Scheme)
-	if err != nil {
-		return nil, err
-	}
-	return deployment, nil
+		Containers: containers,
+		// Add volumes
+		Volumes: volumes,
+	}, nil
 }
 
 func pointer[T any](d T) *T {
@@ -679,9 +953,9 @@ func (r *KbsConfigReconciler) buildAsContainer(volumeMounts []corev1.VolumeMount
 	asCommand := []string{
 		"/usr/local/bin/grpc-as",
 		"--socket",
-		"0.0.0.0:50004",
+		fmt.Sprintf("0.0.0.0:%d", asGrpcPort),
 		"--config-file",
-		"/etc/as-config/as-config.json",
+		filepath.Join(asDefaultConfigPath, "as-config", asConfigFilename),
 	}
 
 	return corev1.Container{
@@ -689,7 +963,7 @@ func (r *KbsConfigReconciler) buildAsContainer(volumeMounts []corev1.VolumeMount
 		Image: asImageName,
 		Ports: []corev1.ContainerPort{
 			{
-				ContainerPort: 50004,
+				ContainerPort: asGrpcPort,
 				Name:          "as",
 			},
 		},
@@ -699,6 +973,7 @@ func (r *KbsConfigReconciler) buildAsContainer(volumeMounts []corev1.VolumeMount
 		// Add volume mount for config
 		VolumeMounts: volumeMounts,
 		Env:          env,
+		Resources:    r.microservicesSpec().AsDeploymentSpec.Resources,
 	}
 }
 
@@ -712,7 +987,11 @@ func (r *KbsConfigReconciler) buildRvpsContainer(volumeMounts []corev1.VolumeMou
 	rvpsCommand := []string{
 		"/usr/local/bin/rvps",
 		"-c",
-		"/etc/rvps-config/rvps-config.json",
+		filepath.Join(rvpsDefaultConfigPath, "rvps-config", rvpsConfigFilename),
+	}
+	// RVPS listens on localhost by default, its Service reaches it on the pod address
+	if r.separateMicroservices() {
+		rvpsCommand = append(rvpsCommand, "--address", fmt.Sprintf("0.0.0.0:%d", rvpsGrpcPort))
 	}
 
 	return corev1.Container{
@@ -720,7 +999,7 @@ func (r *KbsConfigReconciler) buildRvpsContainer(volumeMounts []corev1.VolumeMou
 		Image: rvpsImageName,
 		Ports: []corev1.ContainerPort{
 			{
-				ContainerPort: 50003,
+				ContainerPort: rvpsGrpcPort,
 				Name:          "rvps",
 			},
 		},
@@ -730,10 +1009,12 @@ func (r *KbsConfigReconciler) buildRvpsContainer(volumeMounts []corev1.VolumeMou
 		// Add volume mount for config
 		VolumeMounts: volumeMounts,
 		Env:          env,
+		Resources:    r.microservicesSpec().RvpsDeploymentSpec.Resources,
 	}
 }
 
-func (r *KbsConfigReconciler) buildSecretConverterInitContainer(volumeMounts []corev1.VolumeMount) (corev1.Container, error) {
+func (r *KbsConfigReconciler) buildSecretConverterInitContainer(volumeMounts []corev1.VolumeMount,
+	secretPaths map[string]SecretResourcePath, missingSecrets []string) (corev1.Container, error) {
 	// Converts directory-mounted secrets to flat files with escaped slashes
 	// This is needed because kvstorage backend expects flat files like "default\x2Fkbsres1\x2Fkey1"
 	// but Kubernetes mounts secrets as directories like "default/kbsres1/key1"
@@ -742,13 +1023,14 @@ func (r *KbsConfigReconciler) buildSecretConverterInitContainer(volumeMounts []c
 		return corev1.Container{}, fmt.Errorf("OPERATOR_IMAGE_NAME environment variable must be set (required for secret-converter init container)")
 	}
 
-	return corev1.Container{
+	container := corev1.Container{
 		Name:  "secret-converter",
 		Image: operatorImageName,
 		Command: []string{
 			"/secret-converter",
 		},
 		VolumeMounts: volumeMounts,
+		Resources:    r.kbsConfig.Spec.KbsDeploymentSpec.SecretConverterResources,
 		SecurityContext: &corev1.SecurityContext{
 			AllowPrivilegeEscalation: pointer(false),
 			Capabilities: &corev1.Capabilities{
@@ -762,22 +1044,77 @@ func (r *KbsConfigReconciler) buildSecretConverterInitContainer(volumeMounts []c
 				Type: corev1.SeccompProfileTypeRuntimeDefault,
 			},
 		},
-	}, nil
-}
+	}
 
-func (r *KbsConfigReconciler) buildKbsContainer(volumeMounts []corev1.VolumeMount,
-	securityContext *corev1.SecurityContext, env []corev1.EnvVar,
-	kbsDeploymentType confidentialcontainersorgv1alpha1.DeploymentType) corev1.Container {
-	var imageName string
-	if kbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
-		imageName = os.Getenv("KBS_IMAGE_NAME")
-	} else {
-		imageName = os.Getenv("KBS_IMAGE_NAME_MICROSERVICES")
+	if secretConverterSidecar(r.kbsConfig.Spec) {
+		// A native sidecar keeps running next to KBS and syncs the mounted secrets as Kubernetes
+		// updates them. KBS is only started once the first sync finished, the missing or empty
+		// secret resources are reported without failing the sync
+		readyProbe := &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: "/readyz",
+					Port: intstr.FromInt32(SecretConverterProbePort),
+				},
+			},
+			PeriodSeconds:    5,
+			FailureThreshold: 3,
+		}
+		// The first sync may wait for the Vault sources, it has two minutes to finish
+		startupProbe := readyProbe.DeepCopy()
+		startupProbe.FailureThreshold = 24
+		container.Args = []string{"--watch", fmt.Sprintf("--health-probe-bind-address=:%d", SecretConverterProbePort)}
+		container.RestartPolicy = pointer(corev1.ContainerRestartPolicyAlways)
+		container.Ports = []corev1.ContainerPort{{
+			Name:          "converter-probe",
+			ContainerPort: SecretConverterProbePort,
+			Protocol:      corev1.ProtocolTCP,
+		}}
+		container.StartupProbe = startupProbe
+		container.ReadinessProbe = readyProbe
+	}
+
+	// With a shared storage backend, the resource plugin reads the <repository>/<type>/<tag> files
+	if layout := repositoryLayout(r.kbsConfig.Spec); layout != RepositoryLayoutFlat {
+		container.Args = append(container.Args, fmt.Sprintf("--%s=%s", RepositoryLayoutFlag, layout))
+	}
+
+	// The secret-converter reports the secret resources that are missing or empty, the sidecar reads them
+	// from the index of the sidecar resources secret
+	if secretNames := secretResourceNames(r.kbsConfig.Spec); len(secretNames) > 0 && !secretConverterSidecar(r.kbsConfig.Spec) {
+		container.Args = append(container.Args, fmt.Sprintf("--%s=%s", SecretResourcesFlag, strings.Join(secretNames, ",")))
+	}
+	if len(missingSecrets) > 0 {
+		container.Args = append(container.Args, fmt.Sprintf("--%s=%s", MissingSecretResourcesFlag, strings.Join(missingSecrets, ",")))
 	}
-	if imageName == "" {
-		imageName = DefaultKbsImageName
+
+	// Secret resources published under custom repository, type or tag names
+	if len(secretPaths) > 0 {
+		arg, err := secretResourcePathsArg(secretPaths)
+		if err != nil {
+			return corev1.Container{}, err
+		}
+		container.Args = append(container.Args, arg)
+	}
+
+	// Secrets of Vault-compatible KV secrets engines
+	if vaultSources := resolveVaultResources(r.kbsConfig.Spec); len(vaultSources) > 0 {
+		arg, err := vaultResourcesArg(vaultSources)
+		if err != nil {
+			return corev1.Container{}, err
+		}
+		container.Args = append(container.Args, arg)
 	}
+	return container, nil
+}
+
+// secretConverterSidecar returns true when the secret-converter keeps the KBS repository in sync
+func secretConverterSidecar(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) bool {
+	return spec.KbsSecretConverterMode == confidentialcontainersorgv1alpha1.SecretConverterSidecar
+}
 
+func (r *KbsConfigReconciler) buildKbsContainer(volumeMounts []corev1.VolumeMount,
+	securityContext *corev1.SecurityContext, env []corev1.EnvVar, imageName string) corev1.Container {
 	// command array for the KBS container
 	command := []string{
 		"/usr/local/bin/kbs",
@@ -833,6 +1170,7 @@ func (r *KbsConfigReconciler) buildKbsContainer(volumeMounts []corev1.VolumeMoun
 		// Add volume mount for KBS config
 		VolumeMounts:   volumeMounts,
 		Env:            env,
+		Resources:      r.kbsConfig.Spec.KbsDeploymentSpec.Resources,
 		ReadinessProbe: healthProbe,
 		LivenessProbe:  livenessProbe,
 	}
@@ -919,59 +1257,118 @@ func (r *KbsConfigReconciler) isAttestationConfigPresent() bool {
 	return false
 }
 
-// getConfigMapVersionAnnotations collects ResourceVersions of all mounted ConfigMaps
-// and returns them as pod template annotations. When any ConfigMap changes, Kubernetes
-// increments its ResourceVersion, which updates the annotation and triggers a rolling
-// restart of the KBS pods.
-//
-// This ensures KBS pods automatically restart when ANY configuration changes:
-// - Trustee configuration (KbsConfigMapName)
-// - Attestation policies (KbsAttestationPolicyConfigMapName, KbsGpuAttestationPolicyConfigMapName)
-// - Reference values (KbsRvpsRefValuesConfigMapName)
-// - Resource policies (KbsResourcePolicyConfigMapName)
-func (r *KbsConfigReconciler) getConfigMapVersionAnnotations(ctx context.Context) map[string]string {
-	annotations := make(map[string]string)
-
-	// List of all ConfigMaps that Trustee mounts
-	// These must match the ConfigMaps used in newKbsDeployment()
+// getPodTemplateConfigMapNames returns the ConfigMaps mounted in the KBS pods, as well as in the
+// attestation service and RVPS pods of the MicroservicesDeployment topology.
+// These must match the ConfigMaps used in newKbsDeployment()
+func (r *KbsConfigReconciler) getPodTemplateConfigMapNames() []string {
 	configMapNames := []string{
 		r.kbsConfig.Spec.KbsConfigMapName,
 		r.kbsConfig.Spec.KbsRvpsRefValuesConfigMapName,
 		r.kbsConfig.Spec.KbsAttestationPolicyConfigMapName,
 		r.kbsConfig.Spec.KbsGpuAttestationPolicyConfigMapName,
 		r.kbsConfig.Spec.KbsResourcePolicyConfigMapName,
+		getKbsResourcePolicyConfigMapName(r.kbsConfig.Name),
+		getAttestationPoliciesConfigMapName(r.kbsConfig.Name),
+		getKbsReferenceValuesConfigMapName(r.kbsConfig.Name),
+	}
+	if r.kbsConfig.Spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		configMapNames = append(configMapNames, r.kbsConfig.Spec.KbsAsConfigMapName, r.kbsConfig.Spec.KbsRvpsConfigMapName)
 	}
+	return append(configMapNames, vaultCAConfigMapNames(r.kbsConfig.Spec)...)
+}
+
+// getPodTemplateSecretNames returns the Secrets mounted in the KBS pods, without the ones listed in
+// KbsRolloutExcludedSecrets. KBS loads its keys and certificates at startup and the secret-converter init
+// container only copies the resources into the repository when the pods start, so any change requires a restart
+func (r *KbsConfigReconciler) getPodTemplateSecretNames() []string {
+	spec := r.kbsConfig.Spec
+	secretNames := []string{spec.KbsAuthSecretName}
+	// The secret-converter sidecar syncs the resources without a restart
+	if !secretConverterSidecar(spec) {
+		secretNames = append(secretNames, getKbsResourcesSecretName(r.kbsConfig.Name))
+		secretNames = append(secretNames, secretResourceNames(spec)...)
+	}
+	if kbsConfigSecretNeeded(spec) {
+		secretNames = append(secretNames, getKbsConfigSecretName(r.kbsConfig.Name))
+	}
+	secretNames = append(secretNames,
+		spec.KbsAdminPublicKeysSecretName,
+		spec.KbsHttpsKeySecretName,
+		spec.KbsHttpsCertSecretName,
+		spec.KbsAttestationKeySecretName,
+		spec.KbsAttestationCertSecretName)
+	for _, certCacheEntry := range spec.KbsLocalCertCacheSpec.Secrets {
+		secretNames = append(secretNames, certCacheEntry.SecretName)
+	}
+
+	var tracked []string
+	for _, secretName := range secretNames {
+		if secretName != "" && !slices.Contains(spec.KbsRolloutExcludedSecrets, secretName) {
+			tracked = append(tracked, secretName)
+		}
+	}
+	return tracked
+}
 
-	var versions []string
-	for _, cmName := range configMapNames {
+// getPodTemplateHashAnnotations returns the pod template annotation holding a hash of the content of all
+// the mounted ConfigMaps and Secrets. When any of them changes, the annotation changes and triggers a
+// rolling restart of the pods.
+//
+// This ensures the pods automatically restart when ANY configuration changes:
+// - Trustee configuration (KbsConfigMapName, KbsAsConfigMapName, KbsRvpsConfigMapName)
+// - Attestation policies (KbsAttestationPolicyConfigMapName, KbsGpuAttestationPolicyConfigMapName, AttestationPolicies)
+// - Reference values (KbsRvpsRefValuesConfigMapName, ReferenceValues)
+// - Resource policies (KbsResourcePolicyConfigMapName)
+// - Keys and certificates (auth, admin, HTTPS, attestation token and cert cache secrets)
+// - Resources (KbsSecretResources, KbsResources)
+func (r *KbsConfigReconciler) getPodTemplateHashAnnotations(ctx context.Context) map[string]string {
+	hash := sha256.New()
+	for _, cmName := range r.getPodTemplateConfigMapNames() {
 		// Skip empty ConfigMap names (optional ConfigMaps)
 		if cmName == "" {
 			continue
 		}
-
-		// Fetch the ConfigMap to get its ResourceVersion
 		configMap := &corev1.ConfigMap{}
 		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: cmName}, configMap)
 		if err != nil {
 			// If ConfigMap doesn't exist, skip it (might be optional or not created yet)
-			r.log.V(1).Info("ConfigMap not found for version tracking", "name", cmName, "error", err)
+			r.log.V(1).Info("ConfigMap not found for content hash", "name", cmName, "error", err)
 			continue
 		}
-
-		// Append "name:version" to the list
-		versions = append(versions, fmt.Sprintf("%s:%s", cmName, configMap.ResourceVersion))
+		data := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+		for key, value := range configMap.Data {
+			data[key] = []byte(value)
+		}
+		for key, value := range configMap.BinaryData {
+			data[key] = value
+		}
+		writeContentHash(hash, "ConfigMap/"+cmName, data)
 	}
-
-	// Combine all versions into a single annotation
-	// Format: "kbs-config:12345,reference-values:67890,..."
-	if len(versions) > 0 {
-		annotations["kbs.confidentialcontainers.org/configmap-versions"] = versions[0]
-		for _, v := range versions[1:] {
-			annotations["kbs.confidentialcontainers.org/configmap-versions"] += "," + v
+	for _, secretName := range r.getPodTemplateSecretNames() {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: secretName}, secret)
+		if err != nil {
+			r.log.V(1).Info("Secret not found for content hash", "name", secretName, "error", err)
+			continue
 		}
+		writeContentHash(hash, "Secret/"+secretName, secret.Data)
 	}
+	return map[string]string{configHashAnnotation: hex.EncodeToString(hash.Sum(nil))}
+}
 
-	return annotations
+// writeContentHash feeds the name and the keys of an object, in a stable order, to the content hash
+func writeContentHash(hash io.Writer, name string, data map[string][]byte) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// Lengths are written before the values so that different splits of the same bytes hash differently
+	fmt.Fprintf(hash, "%d:%s\n", len(name), name)
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%d:%s%d:", len(key), key, len(data[key]))
+		_, _ = hash.Write(data[key])
+	}
 }
 
 // updateKbsDeployment updates an existing deployment for the KBS instance
@@ -985,6 +1382,10 @@ func (r *KbsConfigReconciler) updateKbsDeployment(ctx context.Context, deploymen
 
 	desiredTemplate := newDeployment.Spec.Template.DeepCopy()
 	desiredReplicas := newDeployment.Spec.Replicas
+	if r.kbsConfig.Spec.KbsDeploymentSpec.Autoscaling != nil && deployment.Spec.Replicas != nil {
+		// The replica count is managed by the HorizontalPodAutoscaler
+		desiredReplicas = deployment.Spec.Replicas
+	}
 
 	if apiequality.Semantic.DeepEqual(deployment.Spec.Template, *desiredTemplate) &&
 		apiequality.Semantic.DeepEqual(deployment.Spec.Replicas, desiredReplicas) {
@@ -1029,7 +1430,7 @@ func (r *KbsConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 	// Create a new controller and add a watch for KbsConfig including the following secondary resources:
 	// KbsConfigMap, KbsSecret, KbsAsConfigMap, KbsRvpsConfigMap in the same namespace as the controller
-	return ctrl.NewControllerManagedBy(mgr).
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&confidentialcontainersorgv1alpha1.KbsConfig{}).
 		// Watch externally-referenced ConfigMaps and Secrets (not owned by KbsConfig)
 		// so that changes to user-supplied configuration trigger reconciliation.
@@ -1050,7 +1451,47 @@ func (r *KbsConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		// Watch Deployment and Service to trigger reconciliation when their status changes
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
-		Complete(r)
+		// Watch the PersistentVolumeClaims managed for the KBS storage directories
+		Owns(&corev1.PersistentVolumeClaim{}).
+		// Watch the Ingress exposing KBS to report its address
+		Owns(&networkingv1.Ingress{}).
+		// Watch the PodDisruptionBudget and HorizontalPodAutoscaler of the KBS deployment
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		// Watch the KbsResources served by the KbsConfigs
+		Watches(
+			&confidentialcontainersorgv1alpha1.KbsResource{},
+			handler.EnqueueRequestsFromMapFunc(kbsResourceToKbsConfigMapper(r.Client, r.log)),
+			builder.WithPredicates(namespacePredicate(r.namespace)),
+		).
+		// Watch the AttestationPolicies served by the KbsConfigs
+		Watches(
+			&confidentialcontainersorgv1alpha1.AttestationPolicy{},
+			handler.EnqueueRequestsFromMapFunc(attestationPolicyToKbsConfigMapper(r.Client, r.log)),
+			builder.WithPredicates(namespacePredicate(r.namespace)),
+		).
+		// Watch the ReferenceValues served by the KbsConfigs
+		Watches(
+			&confidentialcontainersorgv1alpha1.ReferenceValue{},
+			handler.EnqueueRequestsFromMapFunc(referenceValueToKbsConfigMapper(r.Client, r.log)),
+			builder.WithPredicates(namespacePredicate(r.namespace)),
+		)
+
+	// Routes and HTTPRoutes are only watched when their API is served by the cluster,
+	// otherwise the controller would fail to start
+	if apiAvailable(mgr.GetRESTMapper(), routev1.GroupVersion.WithKind("Route")) {
+		b = b.Owns(&routev1.Route{})
+	}
+	if apiAvailable(mgr.GetRESTMapper(), gatewayv1.SchemeGroupVersion.WithKind("HTTPRoute")) {
+		b = b.Owns(&gatewayv1.HTTPRoute{})
+	}
+	return b.Complete(r)
+}
+
+// apiAvailable returns true if the cluster serves the given kind
+func apiAvailable(mapper meta.RESTMapper, gvk schema.GroupVersionKind) bool {
+	_, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	return err == nil
 }
 
 // create mapper to transform from ConfigMap to KbsConfig
@@ -1075,14 +1516,7 @@ func configMapToKbsConfigMapper(c client.Client, log logr.Logger) (handler.MapFu
 
 		var requests []reconcile.Request
 		for _, kbsConfig := range kbsConfigList.Items {
-			if kbsConfig.Spec.KbsConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsAsConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsRvpsConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsRvpsRefValuesConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsAttestationPolicyConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsGpuAttestationPolicyConfigMapName == configMap.Name ||
-				kbsConfig.Spec.KbsResourcePolicyConfigMapName == configMap.Name {
-
+			if referencesObject(kbsConfig.Spec, "ConfigMap", configMap.Name) {
 				requests = append(requests, reconcile.Request{
 					NamespacedName: types.NamespacedName{
 						Namespace: kbsConfig.Namespace,
@@ -1091,7 +1525,14 @@ func configMapToKbsConfigMapper(c client.Client, log logr.Logger) (handler.MapFu
 				})
 			}
 		}
-		return requests
+
+		// ConfigMaps holding the content of KbsResources
+		resources, err := kbsResourcesReferencing(ctx, c, configMap.Namespace, "ConfigMap", configMap.Name)
+		if err != nil {
+			log.Info("Error in listing KbsResource", "err", err)
+			return requests
+		}
+		return append(requests, kbsConfigRequestsForResources(kbsConfigList.Items, resources)...)
 	}
 
 	return mapperFunc, nil
@@ -1119,21 +1560,9 @@ func secretToKbsConfigMapper(c client.Client, log logr.Logger) (handler.MapFunc,
 
 		var requests []reconcile.Request
 		for _, kbsConfig := range kbsConfigList.Items {
-			// Check if secret matches any of the known secret references
-			secretMatches := kbsConfig.Spec.KbsAuthSecretName == secret.Name ||
-				kbsConfig.Spec.KbsHttpsKeySecretName == secret.Name ||
-				kbsConfig.Spec.KbsHttpsCertSecretName == secret.Name ||
-				kbsConfig.Spec.KbsAttestationKeySecretName == secret.Name ||
-				kbsConfig.Spec.KbsAttestationCertSecretName == secret.Name ||
-				(kbsConfig.Spec.KbsSecretResources != nil && contains(kbsConfig.Spec.KbsSecretResources, secret.Name))
-
-			// Check if secret matches any of the local cert cache secrets
-			for _, certCacheEntry := range kbsConfig.Spec.KbsLocalCertCacheSpec.Secrets {
-				if certCacheEntry.SecretName == secret.Name {
-					secretMatches = true
-					break
-				}
-			}
+			// Check if secret matches any of the known secret references,
+			// including the local cert cache secrets
+			secretMatches := referencesObject(kbsConfig.Spec, "Secret", secret.Name)
 
 			if secretMatches {
 				requests = append(requests, reconcile.Request{
@@ -1144,12 +1573,78 @@ func secretToKbsConfigMapper(c client.Client, log logr.Logger) (handler.MapFunc,
 				})
 			}
 		}
-		return requests
+
+		// Secrets holding the content of KbsResources
+		resources, err := kbsResourcesReferencing(ctx, c, secret.Namespace, "Secret", secret.Name)
+		if err != nil {
+			log.Info("Error in listing KbsResource", "err", err)
+			return requests
+		}
+		return append(requests, kbsConfigRequestsForResources(kbsConfigList.Items, resources)...)
 	}
 
 	return mapperFunc, nil
 }
 
+// kbsResourceToKbsConfigMapper maps a KbsResource to the KbsConfigs serving it
+func kbsResourceToKbsConfigMapper(c client.Client, log logr.Logger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		resource, ok := o.(*confidentialcontainersorgv1alpha1.KbsResource)
+		if !ok {
+			log.Info("Expected a KbsResource, but got another type", "objectType", o.GetObjectKind())
+			return nil
+		}
+		kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+		if err := c.List(ctx, kbsConfigList, client.InNamespace(resource.Namespace)); err != nil {
+			log.Info("Error in listing KbsConfig", "err", err)
+			return nil
+		}
+		return kbsConfigRequestsForResources(kbsConfigList.Items, []confidentialcontainersorgv1alpha1.KbsResource{*resource})
+	}
+}
+
+// attestationPolicyToKbsConfigMapper maps an AttestationPolicy to the KbsConfigs serving it
+func attestationPolicyToKbsConfigMapper(c client.Client, log logr.Logger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		policy, ok := o.(*confidentialcontainersorgv1alpha1.AttestationPolicy)
+		if !ok {
+			log.Info("Expected an AttestationPolicy, but got another type", "objectType", o.GetObjectKind())
+			return nil
+		}
+		kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+		if err := c.List(ctx, kbsConfigList, client.InNamespace(policy.Namespace)); err != nil {
+			log.Info("Error in listing KbsConfig", "err", err)
+			return nil
+		}
+		var requests []reconcile.Request
+		for _, kbsConfig := range kbsConfigList.Items {
+			if servesAttestationPolicy(kbsConfig.Name, policy) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: kbsConfig.Namespace, Name: kbsConfig.Name},
+				})
+			}
+		}
+		return requests
+	}
+}
+
+// referenceValueToKbsConfigMapper maps a ReferenceValue to the KbsConfigs serving it
+func referenceValueToKbsConfigMapper(c client.Client, log logr.Logger) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		rv, ok := o.(*confidentialcontainersorgv1alpha1.ReferenceValue)
+		if !ok {
+			log.Info("Expected a ReferenceValue, but got another type", "objectType", o.GetObjectKind())
+			return nil
+		}
+		kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+		if err := c.List(ctx, kbsConfigList, client.InNamespace(rv.Namespace)); err != nil {
+			log.Info("Error in listing KbsConfig", "err", err)
+			return nil
+		}
+		return kbsConfigRequestsForReferenceValue(kbsConfigList.Items, rv)
+	}
+}
+
 // namespacePredicate is a custom predicate function that filters resources based on the namespace.
 func namespacePredicate(namespace string) predicate.Predicate {
 	return predicate.Funcs{
@@ -1173,42 +1668,3 @@ func isResourceInNamespace(obj metav1.Object, namespace string) bool {
 
 	return obj.GetNamespace() == namespace
 }
-
-// updateKbsConfigStatus checks the deployment status and updates KbsConfig accordingly
-func (r *KbsConfigReconciler) updateKbsConfigStatus(ctx context.Context) error {
-	// Capture current status to detect changes before writing
-	oldIsReady := r.kbsConfig.Status.IsReady
-
-	// Get the deployment to check its status
-	deployment := &appsv1.Deployment{}
-	err := r.Get(ctx, client.ObjectKey{
-		Namespace: r.namespace,
-		Name:      KbsDeploymentName,
-	}, deployment)
-	var newIsReady bool
-	if err != nil {
-		r.log.Info("Failed to get deployment for status check", "err", err)
-		// Set status to not ready if deployment doesn't exist
-		newIsReady = false
-	} else {
-		// Check if deployment is ready (all replicas are ready)
-		newIsReady = deployment.Status.ReadyReplicas >= 1 && deployment.Status.ReadyReplicas == deployment.Status.Replicas
-		r.log.Info("Checked KbsConfig status", "IsReady", newIsReady, "ReadyReplicas", deployment.Status.ReadyReplicas, "Replicas", deployment.Status.Replicas, "AvailableReplicas", deployment.Status.AvailableReplicas, "UpdatedReplicas", deployment.Status.UpdatedReplicas)
-		if newIsReady && !oldIsReady {
-			r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "Ready", "Ready", "Trustee deployment is ready")
-		} else if !newIsReady && oldIsReady {
-			r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "NotReady", "NotReady", "Trustee deployment is no longer ready")
-		}
-	}
-
-	// Always update status to ensure it's initialized, even on first reconcile.
-	// The Status().Update() call will handle deduplication if nothing changed.
-	r.kbsConfig.Status.IsReady = newIsReady
-	err = r.Status().Update(ctx, r.kbsConfig)
-	if err != nil {
-		r.log.Info("Failed to update KbsConfig status", "err", err)
-		return err
-	}
-
-	return nil
-}
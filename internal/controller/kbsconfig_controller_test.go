@@ -0,0 +1,335 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"github.com/go-logr/logr"
+	routev1 "github.com/openshift/api/route/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/events"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	gatewayv1 "sigs.k8s.io/gateway-api/apis/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const testNamespace = "trustee-operator-system"
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := routev1.Install(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := gatewayv1.Install(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certmanagerv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func newTestKbsConfig(name string, spec confidentialcontainersorgv1alpha1.KbsConfigSpec) *confidentialcontainersorgv1alpha1.KbsConfig {
+	return &confidentialcontainersorgv1alpha1.KbsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID(name + "-uid")},
+		Spec:       spec,
+	}
+}
+
+func TestNewKbsServicePerInstance(t *testing.T) {
+	scheme := newTestScheme(t)
+	r := &KbsConfigReconciler{Scheme: scheme, log: logr.Discard(), namespace: testNamespace}
+
+	r.kbsConfig = newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+	serviceA := r.newKbsService(context.Background())
+	r.kbsConfig = newTestKbsConfig("tenant-b", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+	serviceB := r.newKbsService(context.Background())
+
+	if serviceA.Name == serviceB.Name {
+		t.Fatalf("expected distinct service names, both are %q", serviceA.Name)
+	}
+	if serviceA.Name != "tenant-a-service" {
+		t.Errorf("unexpected service name %q", serviceA.Name)
+	}
+	if serviceA.Spec.Selector["app.kubernetes.io/instance"] != "tenant-a" ||
+		serviceB.Spec.Selector["app.kubernetes.io/instance"] != "tenant-b" {
+		t.Errorf("expected selectors to target their own instance, got %v and %v", serviceA.Spec.Selector, serviceB.Spec.Selector)
+	}
+	if serviceA.Spec.Type != corev1.ServiceTypeClusterIP {
+		t.Errorf("expected ClusterIP service type by default, got %s", serviceA.Spec.Type)
+	}
+}
+
+func TestConfigMapToKbsConfigMapperMultipleInstances(t *testing.T) {
+	scheme := newTestScheme(t)
+	kbsConfigA := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:               "kbs-config-a",
+		KbsResourcePolicyConfigMapName: "shared-resource-policy",
+	})
+	kbsConfigB := newTestKbsConfig("tenant-b", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:               "kbs-config-b",
+		KbsResourcePolicyConfigMapName: "shared-resource-policy",
+	})
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(kbsConfigA, kbsConfigB).Build()
+
+	mapper, err := configMapToKbsConfigMapper(c, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requestsFor := func(name string) []string {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace}}
+		var names []string
+		for _, req := range mapper(context.Background(), cm) {
+			names = append(names, req.Name)
+		}
+		return names
+	}
+
+	if got := requestsFor("kbs-config-a"); len(got) != 1 || got[0] != "tenant-a" {
+		t.Errorf("expected only tenant-a to be enqueued, got %v", got)
+	}
+	if got := requestsFor("shared-resource-policy"); len(got) != 2 {
+		t.Errorf("expected both instances to be enqueued for a shared ConfigMap, got %v", got)
+	}
+	if got := requestsFor("unrelated"); len(got) != 0 {
+		t.Errorf("expected no request for an unrelated ConfigMap, got %v", got)
+	}
+}
+
+func TestReconcileLegacyKbsResources(t *testing.T) {
+	scheme := newTestScheme(t)
+	owner := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+	other := newTestKbsConfig("tenant-b", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+
+	legacyDeployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: legacyKbsDeploymentName, Namespace: testNamespace}}
+	legacyService := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: legacyKbsServiceName, Namespace: testNamespace}}
+	// the legacy deployment belongs to tenant-a, the legacy service to tenant-b
+	if err := ctrl.SetControllerReference(owner, legacyDeployment, scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctrl.SetControllerReference(other, legacyService, scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &KbsConfigReconciler{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(legacyDeployment, legacyService).Build(),
+		Scheme:    scheme,
+		Recorder:  events.NewFakeRecorder(10),
+		log:       logr.Discard(),
+		namespace: testNamespace,
+		kbsConfig: owner,
+	}
+
+	if err := r.reconcileLegacyKbsResources(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	err := r.Get(context.Background(), client.ObjectKeyFromObject(legacyDeployment), &appsv1.Deployment{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the legacy deployment owned by tenant-a to be deleted, got %v", err)
+	}
+	service := &corev1.Service{}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(legacyService), service); err != nil {
+		t.Fatalf("expected the legacy service owned by tenant-b to be kept, got %v", err)
+	}
+	if service.Spec.Selector != nil {
+		t.Errorf("expected the legacy service owned by tenant-b to be unchanged, got selector %v", service.Spec.Selector)
+	}
+
+	// The legacy service of the KbsConfig is kept as an alias of its new pods
+	r.kbsConfig = other
+	if err := r.reconcileLegacyKbsResources(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.Background(), client.ObjectKeyFromObject(legacyService), service); err != nil {
+		t.Fatalf("expected the legacy service owned by tenant-b to be kept, got %v", err)
+	}
+	if !reflect.DeepEqual(service.Spec.Selector, standardLabels("tenant-b", kbsComponent)) {
+		t.Errorf("expected the legacy service to select the pods of tenant-b, got %v", service.Spec.Selector)
+	}
+	if !strings.Contains(service.Annotations[legacyKbsServiceDeprecatedAnnotation], kbsServiceName("tenant-b")) {
+		t.Errorf("expected the deprecation to point at %s, got %v", kbsServiceName("tenant-b"), service.Annotations)
+	}
+}
+
+func TestPodTemplateHashAnnotations(t *testing.T) {
+	ctx := context.Background()
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:     "kbs-config",
+		KbsAuthSecretName:    "auth",
+		KbsSecretResources:   []string{"keys", "tokens"},
+		KbsDeploymentType:    confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+		KbsAsConfigMapName:   "as-config",
+		KbsRvpsConfigMapName: "rvps-config",
+	})
+	objects := map[string]client.Object{
+		"kbs-config": &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace},
+			Data: map[string]string{kbsConfigTomlKey: "[http_server]\n"}},
+		"as-config": &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "as-config", Namespace: testNamespace},
+			Data: map[string]string{"as-config.json": "{}"}},
+		"auth": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: testNamespace},
+			Data: map[string][]byte{"publicKey": []byte("key-1")}},
+		"keys": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: testNamespace},
+			Data: map[string][]byte{"key1": []byte("value-1")}},
+		"tokens": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "tokens", Namespace: testNamespace},
+			Data: map[string][]byte{"token": []byte("value-1")}},
+	}
+	var objs []client.Object
+	for _, obj := range objects {
+		objs = append(objs, obj)
+	}
+	r := newTestExposureReconciler(t, kbsConfig, objs...)
+
+	hash := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]
+	if hash == "" {
+		t.Fatal("expected a content hash annotation")
+	}
+	if again := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]; again != hash {
+		t.Errorf("expected a stable hash, got %s and %s", hash, again)
+	}
+
+	// Any change of a mounted Secret or ConfigMap content changes the hash
+	for _, name := range []string{"auth", "keys", "as-config"} {
+		obj := objects[name]
+		switch o := obj.(type) {
+		case *corev1.Secret:
+			for key := range o.Data {
+				o.Data[key] = append(o.Data[key], '2')
+			}
+		case *corev1.ConfigMap:
+			for key := range o.Data {
+				o.Data[key] += " "
+			}
+		}
+		if err := r.Update(ctx, obj); err != nil {
+			t.Fatal(err)
+		}
+		updated := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]
+		if updated == hash {
+			t.Errorf("expected the hash to change with the content of %s", name)
+		}
+		hash = updated
+	}
+
+	// Metadata only updates and excluded secrets don't change the hash
+	kbsConfig.Spec.KbsRolloutExcludedSecrets = []string{"tokens"}
+	hash = r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]
+	tokens := objects["tokens"].(*corev1.Secret)
+	tokens.Labels = map[string]string{"rotated": "true"}
+	tokens.Data["token"] = []byte("value-2")
+	if err := r.Update(ctx, tokens); err != nil {
+		t.Fatal(err)
+	}
+	keys := objects["keys"].(*corev1.Secret)
+	keys.Labels = map[string]string{"team": "a"}
+	if err := r.Update(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	if updated := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]; updated != hash {
+		t.Errorf("expected the hash not to change, got %s and %s", hash, updated)
+	}
+}
+
+func TestSecretConverterSidecar(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("OPERATOR_IMAGE_NAME", "trustee-operator:test")
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsAuthSecretName:  "auth",
+		KbsSecretResources: []string{"keys"},
+	})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: testNamespace}, Data: map[string][]byte{"publicKey": []byte("key")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: testNamespace}, Data: map[string][]byte{"key1": []byte("value-1")}})
+
+	container, err := r.buildSecretConverterInitContainer(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.RestartPolicy != nil || slices.Contains(container.Args, "--watch") || container.StartupProbe != nil {
+		t.Errorf("expected a plain init container by default, got %+v", container)
+	}
+	if !slices.Contains(container.Args, "--"+SecretResourcesFlag+"=keys") {
+		t.Errorf("expected the secret resources to be listed, got %v", container.Args)
+	}
+	initHash := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]
+
+	kbsConfig.Spec.KbsSecretConverterMode = confidentialcontainersorgv1alpha1.SecretConverterSidecar
+	container, err = r.buildSecretConverterInitContainer(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.RestartPolicy == nil || *container.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+		t.Errorf("expected a native sidecar, got restart policy %v", container.RestartPolicy)
+	}
+	if len(container.Args) == 0 || container.Args[0] != "--watch" {
+		t.Errorf("expected the watch mode, got %v", container.Args)
+	}
+	if container.StartupProbe == nil || container.StartupProbe.HTTPGet.Path != "/readyz" ||
+		container.StartupProbe.HTTPGet.Port.IntValue() != SecretConverterProbePort {
+		t.Errorf("expected KBS to wait for the first sync, got %+v", container.StartupProbe)
+	}
+	// Without a failure threshold a slow first sync would restart the sidecar before KBS starts
+	if container.StartupProbe.FailureThreshold <= container.ReadinessProbe.FailureThreshold {
+		t.Errorf("expected the startup probe to allow a longer first sync, got %+v", container.StartupProbe)
+	}
+	// The sidecar reads the secret resources from the index of the sidecar resources secret, adding
+	// one does not change the arguments
+	for _, arg := range container.Args {
+		if strings.HasPrefix(arg, "--"+SecretResourcesFlag) {
+			t.Errorf("expected no secret resources argument in sidecar mode, got %v", container.Args)
+		}
+	}
+
+	// The secret resources are synced live, their changes don't roll the pods
+	sidecarHash := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]
+	if sidecarHash == initHash {
+		t.Error("expected the secret resources to be excluded from the hash")
+	}
+	keys := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "keys"}, keys); err != nil {
+		t.Fatal(err)
+	}
+	keys.Data["key1"] = []byte("value-2")
+	if err := r.Update(ctx, keys); err != nil {
+		t.Fatal(err)
+	}
+	if hash := r.getPodTemplateHashAnnotations(ctx)[configHashAnnotation]; hash != sidecarHash {
+		t.Errorf("expected the hash not to change with the secret resources, got %s and %s", sidecarHash, hash)
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// Reasons used in the KbsConfig conditions
+const (
+	reasonReferencesResolved     = "ReferencesResolved"
+	reasonMissingReferences      = "MissingReferences"
+	reasonReferenceLookupFailed  = "ReferenceLookupFailed"
+	reasonDeploymentReady        = "DeploymentReady"
+	reasonDeploymentProgressing  = "DeploymentProgressing"
+	reasonDeploymentNotFound     = "DeploymentNotFound"
+	reasonDeploymentFailed       = "DeploymentReconcileFailed"
+	reasonStorageFailed          = "StorageReconcileFailed"
+	reasonServiceReconciled      = "ServiceReconciled"
+	reasonServiceFailed          = "ServiceReconcileFailed"
+	reasonHttpsEnabled           = "HttpsEnabled"
+	reasonHttpsDisabled          = "HttpsDisabled"
+	reasonIncompleteHttpsConfig  = "IncompleteHttpsConfig"
+	reasonReconcileSucceeded     = "ReconcileSucceeded"
+	reasonAllComponentsAvailable = "AllComponentsAvailable"
+)
+
+// readinessConditions are the conditions that must all be True for the
+// KbsConfig to be reported as Ready
+var readinessConditions = []string{
+	confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved,
+	confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable,
+	confidentialcontainersorgv1alpha1.KbsConfigConditionServiceReady,
+}
+
+// objectReference identifies a ConfigMap, Secret or PersistentVolumeClaim referenced by the KbsConfig spec
+type objectReference struct {
+	kind  string
+	field string
+	name  string
+}
+
+// setCondition records a condition on the KbsConfig instance, stamped with the current generation
+func (r *KbsConfigReconciler) setCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&r.kbsConfig.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: r.kbsConfig.Generation,
+	})
+}
+
+// markDegraded flags the KbsConfig as degraded and sets the condition of the failing step to False
+func (r *KbsConfigReconciler) markDegraded(conditionType, reason string, err error) {
+	r.setCondition(conditionType, metav1.ConditionFalse, reason, err.Error())
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionDegraded, metav1.ConditionTrue, reason, err.Error())
+}
+
+// kbsConfigReferences returns every ConfigMap, Secret and PersistentVolumeClaim the KbsConfig spec points to.
+// It is shared by the reference resolution and the ConfigMap/Secret watch mappers so
+// that a KbsConfig is re-queued for exactly the objects it depends on.
+func kbsConfigReferences(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) []objectReference {
+	var refs []objectReference
+	addRef := func(kind, field, name string) {
+		if name != "" {
+			refs = append(refs, objectReference{kind: kind, field: field, name: name})
+		}
+	}
+
+	addRef("ConfigMap", "kbsConfigMapName", spec.KbsConfigMapName)
+	addRef("Secret", "kbsAuthSecretName", spec.KbsAuthSecretName)
+	addRef("Secret", "kbsAdminPublicKeysSecretName", spec.KbsAdminPublicKeysSecretName)
+	if spec.KbsItaApiKeySecretRef != nil {
+		addRef("Secret", "kbsItaApiKeySecretRef", spec.KbsItaApiKeySecretRef.Name)
+	}
+	if shared := spec.KbsSharedStorageSpec; shared != nil && shared.Type == confidentialcontainersorgv1alpha1.SharedStoragePostgres && shared.Postgres != nil {
+		addRef("Secret", "sharedStorage.postgres.credentialsSecretName", shared.Postgres.CredentialsSecretName)
+	}
+	if spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		addRef("ConfigMap", "kbsAsConfigMapName", spec.KbsAsConfigMapName)
+		addRef("ConfigMap", "kbsRvpsConfigMapName", spec.KbsRvpsConfigMapName)
+	}
+	addRef("ConfigMap", "kbsRvpsRefValuesConfigMapName", spec.KbsRvpsRefValuesConfigMapName)
+	addRef("ConfigMap", "kbsAttestationPolicyConfigMapName", spec.KbsAttestationPolicyConfigMapName)
+	addRef("ConfigMap", "kbsGpuAttestationPolicyConfigMapName", spec.KbsGpuAttestationPolicyConfigMapName)
+	addRef("ConfigMap", "kbsResourcePolicyConfigMapName", spec.KbsResourcePolicyConfigMapName)
+	if spec.KbsHttpsKeySecretName != "" && spec.KbsHttpsCertSecretName != "" {
+		addRef("Secret", "kbsHttpsKeySecretName", spec.KbsHttpsKeySecretName)
+		addRef("Secret", "kbsHttpsCertSecretName", spec.KbsHttpsCertSecretName)
+	}
+	if spec.KbsAttestationKeySecretName != "" && spec.KbsAttestationCertSecretName != "" {
+		addRef("Secret", "kbsAttestationKeySecretName", spec.KbsAttestationKeySecretName)
+		addRef("Secret", "kbsAttestationCertSecretName", spec.KbsAttestationCertSecretName)
+	}
+	for _, secretResource := range spec.KbsSecretResources {
+		addRef("Secret", "kbsSecretResources", secretResource)
+	}
+	for _, entry := range spec.KbsSecretResourceEntries {
+		addRef("Secret", "kbsSecretResourceEntries", entry.SecretName)
+	}
+	for _, caConfigMapName := range vaultCAConfigMapNames(spec) {
+		addRef("ConfigMap", "kbsVaultResources", caConfigMapName)
+	}
+	for _, certCacheEntry := range spec.KbsLocalCertCacheSpec.Secrets {
+		addRef("Secret", "kbsLocalCertCacheSpec", certCacheEntry.SecretName)
+	}
+	for _, dir := range kbsStorageDirs(spec.KbsStorageSpec) {
+		if storageType(dir.spec) == confidentialcontainersorgv1alpha1.StorageTypeExistingPVC {
+			addRef("PersistentVolumeClaim", "storage."+dir.field, dir.spec.ClaimName)
+		}
+	}
+	return refs
+}
+
+// referencesObject returns true if the KbsConfig spec points to the ConfigMap or Secret with the given name
+func referencesObject(spec confidentialcontainersorgv1alpha1.KbsConfigSpec, kind, name string) bool {
+	for _, ref := range kbsConfigReferences(spec) {
+		if ref.kind == kind && ref.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveReferencedObjects verifies that all the ConfigMaps, Secrets and PersistentVolumeClaims referenced by the
+// KbsConfig exist and updates the ConfigResolved and TlsConfigured conditions accordingly
+// Errors are logged by the callee and hence no error is logged in this method
+func (r *KbsConfigReconciler) resolveReferencedObjects(ctx context.Context) error {
+	if r.kbsConfig.Spec.KbsConfigMapName == "" {
+		err := fmt.Errorf("kbsConfigMapName is not set")
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, reasonMissingReferences, err)
+		return err
+	}
+	if r.kbsConfig.Spec.KbsAuthSecretName == "" {
+		err := fmt.Errorf("kbsAuthSecretName is not set")
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, reasonMissingReferences, err)
+		return err
+	}
+
+	var missing []string
+	for _, ref := range kbsConfigReferences(r.kbsConfig.Spec) {
+		var obj client.Object
+		switch ref.kind {
+		case "ConfigMap":
+			obj = &corev1.ConfigMap{}
+		case "PersistentVolumeClaim":
+			obj = &corev1.PersistentVolumeClaim{}
+		default:
+			obj = &corev1.Secret{}
+		}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: ref.name}, obj)
+		if err != nil && k8serrors.IsNotFound(err) {
+			missing = append(missing, fmt.Sprintf("%s %s (%s)", ref.kind, ref.name, ref.field))
+		} else if err != nil {
+			r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, reasonReferenceLookupFailed, err)
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		err := fmt.Errorf("referenced objects not found in namespace %s: %s", r.namespace, strings.Join(missing, ", "))
+		r.markDegraded(confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, reasonMissingReferences, err)
+		return err
+	}
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, metav1.ConditionTrue,
+		reasonReferencesResolved, "All referenced objects were found")
+
+	status, reason, message := tlsConfiguredCondition(r.kbsConfig.Spec)
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionTlsConfigured, status, reason, message)
+	return nil
+}
+
+// tlsConfiguredCondition computes the TlsConfigured condition from the HTTPS secret references
+func tlsConfiguredCondition(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) (metav1.ConditionStatus, string, string) {
+	hasKey := spec.KbsHttpsKeySecretName != ""
+	hasCert := spec.KbsHttpsCertSecretName != ""
+	switch {
+	case hasKey && hasCert:
+		return metav1.ConditionTrue, reasonHttpsEnabled, "KBS serves HTTPS"
+	case hasKey:
+		return metav1.ConditionFalse, reasonIncompleteHttpsConfig, "kbsHttpsKeySecretName is set but kbsHttpsCertSecretName is missing, KBS serves plain HTTP"
+	case hasCert:
+		return metav1.ConditionFalse, reasonIncompleteHttpsConfig, "kbsHttpsCertSecretName is set but kbsHttpsKeySecretName is missing, KBS serves plain HTTP"
+	default:
+		return metav1.ConditionFalse, reasonHttpsDisabled, "HTTPS is not configured, KBS serves plain HTTP"
+	}
+}
+
+// deploymentAvailableCondition computes the DeploymentAvailable condition from the deployment status
+func deploymentAvailableCondition(deployment *appsv1.Deployment) (metav1.ConditionStatus, string, string) {
+	if deployment == nil {
+		return metav1.ConditionFalse, reasonDeploymentNotFound, "KBS deployment does not exist"
+	}
+	ready := deployment.Status.ReadyReplicas
+	replicas := deployment.Status.Replicas
+	if ready >= 1 && ready == replicas {
+		return metav1.ConditionTrue, reasonDeploymentReady, fmt.Sprintf("%d/%d replicas ready", ready, replicas)
+	}
+	return metav1.ConditionFalse, reasonDeploymentProgressing, fmt.Sprintf("%d/%d replicas ready", ready, replicas)
+}
+
+// readyCondition aggregates the readiness conditions into the Ready condition.
+// The first condition that is not True determines the reason and message.
+func readyCondition(conditions []metav1.Condition) (metav1.ConditionStatus, string, string) {
+	for _, conditionType := range readinessConditions {
+		condition := meta.FindStatusCondition(conditions, conditionType)
+		if condition == nil {
+			return metav1.ConditionFalse, conditionType + "Unknown", conditionType + ": waiting for the first reconciliation"
+		}
+		if condition.Status != metav1.ConditionTrue {
+			return metav1.ConditionFalse, condition.Reason, conditionType + ": " + condition.Message
+		}
+	}
+	return metav1.ConditionTrue, reasonAllComponentsAvailable, "KBS is available"
+}
+
+// refreshDeploymentCondition sets the DeploymentAvailable condition from the current deployment status
+func (r *KbsConfigReconciler) refreshDeploymentCondition(ctx context.Context) {
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, client.ObjectKey{
+		Namespace: r.namespace,
+		Name:      kbsDeploymentName(r.kbsConfig.Name),
+	}, deployment)
+	if err != nil {
+		r.log.Info("Failed to get deployment for status check", "err", err)
+		deployment = nil
+	} else {
+		r.log.Info("Checked KBS deployment status", "ReadyReplicas", deployment.Status.ReadyReplicas, "Replicas", deployment.Status.Replicas, "AvailableReplicas", deployment.Status.AvailableReplicas, "UpdatedReplicas", deployment.Status.UpdatedReplicas)
+	}
+	status, reason, message := deploymentAvailableCondition(deployment)
+
+	// The attestation service and RVPS running separately from KBS must be available as well
+	if status == metav1.ConditionTrue && r.separateMicroservices() {
+		for _, component := range []string{asComponent, rvpsComponent} {
+			deployment = &appsv1.Deployment{}
+			err = r.Get(ctx, client.ObjectKey{
+				Namespace: r.namespace,
+				Name:      microserviceDeploymentName(r.kbsConfig.Name, component),
+			}, deployment)
+			if err != nil {
+				deployment = nil
+			}
+			if componentStatus, componentReason, componentMessage := deploymentAvailableCondition(deployment); componentStatus != metav1.ConditionTrue {
+				status, reason, message = componentStatus, componentReason, component+": "+componentMessage
+				break
+			}
+		}
+	}
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, status, reason, message)
+}
+
+// updateKbsConfigStatus computes the aggregate conditions and writes the KbsConfig status.
+// When succeeded is true, every reconcile step passed and the Degraded condition is cleared
+// (unless the TLS configuration is incomplete) and the deployment readiness is refreshed.
+func (r *KbsConfigReconciler) updateKbsConfigStatus(ctx context.Context, succeeded bool) error {
+	// Capture current readiness to detect transitions before writing
+	wasReady := meta.IsStatusConditionTrue(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionReady)
+
+	if succeeded {
+		r.refreshDeploymentCondition(ctx)
+		tls := meta.FindStatusCondition(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionTlsConfigured)
+		if tls != nil && tls.Reason == reasonIncompleteHttpsConfig {
+			r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionDegraded, metav1.ConditionTrue, tls.Reason, tls.Message)
+		} else {
+			r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionDegraded, metav1.ConditionFalse, reasonReconcileSucceeded, "All reconcile steps succeeded")
+		}
+	}
+
+	status, reason, message := readyCondition(r.kbsConfig.Status.Conditions)
+	r.setCondition(confidentialcontainersorgv1alpha1.KbsConfigConditionReady, status, reason, message)
+	r.kbsConfig.Status.ObservedGeneration = r.kbsConfig.Generation
+
+	isReady := status == metav1.ConditionTrue
+	if isReady && !wasReady {
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeNormal, "Ready", "Ready", "Trustee deployment is ready")
+	} else if !isReady && wasReady {
+		r.Recorder.Eventf(r.kbsConfig, nil, corev1.EventTypeWarning, "NotReady", "NotReady", message)
+	}
+
+	err := r.Status().Update(ctx, r.kbsConfig)
+	if err != nil {
+		r.log.Info("Failed to update KbsConfig status", "err", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestTlsConfiguredCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		keySecret  string
+		certSecret string
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{"both secrets", "key", "cert", metav1.ConditionTrue, reasonHttpsEnabled},
+		{"no secrets", "", "", metav1.ConditionFalse, reasonHttpsDisabled},
+		{"key only", "key", "", metav1.ConditionFalse, reasonIncompleteHttpsConfig},
+		{"cert only", "", "cert", metav1.ConditionFalse, reasonIncompleteHttpsConfig},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := confidentialcontainersorgv1alpha1.KbsConfigSpec{
+				KbsHttpsKeySecretName:  tt.keySecret,
+				KbsHttpsCertSecretName: tt.certSecret,
+			}
+			status, reason, _ := tlsConfiguredCondition(spec)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("tlsConfiguredCondition() = (%s, %s), want (%s, %s)", status, reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestDeploymentAvailableCondition(t *testing.T) {
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{
+		{"missing deployment", nil, metav1.ConditionFalse, reasonDeploymentNotFound},
+		{"no ready replicas", &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 1}}, metav1.ConditionFalse, reasonDeploymentProgressing},
+		{"partially ready", &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 2, ReadyReplicas: 1}}, metav1.ConditionFalse, reasonDeploymentProgressing},
+		{"all ready", &appsv1.Deployment{Status: appsv1.DeploymentStatus{Replicas: 2, ReadyReplicas: 2}}, metav1.ConditionTrue, reasonDeploymentReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason, _ := deploymentAvailableCondition(tt.deployment)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("deploymentAvailableCondition() = (%s, %s), want (%s, %s)", status, reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestReadyCondition(t *testing.T) {
+	allTrue := []metav1.Condition{
+		{Type: confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved, Status: metav1.ConditionTrue, Reason: reasonReferencesResolved},
+		{Type: confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable, Status: metav1.ConditionTrue, Reason: reasonDeploymentReady},
+		{Type: confidentialcontainersorgv1alpha1.KbsConfigConditionServiceReady, Status: metav1.ConditionTrue, Reason: reasonServiceReconciled},
+		// TlsConfigured does not gate readiness
+		{Type: confidentialcontainersorgv1alpha1.KbsConfigConditionTlsConfigured, Status: metav1.ConditionFalse, Reason: reasonHttpsDisabled},
+	}
+
+	status, reason, _ := readyCondition(allTrue)
+	if status != metav1.ConditionTrue || reason != reasonAllComponentsAvailable {
+		t.Errorf("expected Ready to be True, got (%s, %s)", status, reason)
+	}
+
+	progressing := append([]metav1.Condition{}, allTrue...)
+	progressing[1] = metav1.Condition{
+		Type:    confidentialcontainersorgv1alpha1.KbsConfigConditionDeploymentAvailable,
+		Status:  metav1.ConditionFalse,
+		Reason:  reasonDeploymentProgressing,
+		Message: "0/1 replicas ready",
+	}
+	status, reason, message := readyCondition(progressing)
+	if status != metav1.ConditionFalse || reason != reasonDeploymentProgressing {
+		t.Errorf("expected Ready to be False with reason %s, got (%s, %s)", reasonDeploymentProgressing, status, reason)
+	}
+	if !strings.Contains(message, "0/1 replicas ready") {
+		t.Errorf("expected message to carry the failing condition message, got %q", message)
+	}
+
+	status, _, _ = readyCondition(nil)
+	if status != metav1.ConditionFalse {
+		t.Errorf("expected Ready to be False without conditions, got %s", status)
+	}
+}
+
+func TestResolveReferencedObjects(t *testing.T) {
+	scheme := newTestScheme(t)
+	namespace := testNamespace
+	existing := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: namespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "kbs-auth", Namespace: namespace}},
+	}
+
+	newReconciler := func(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) *KbsConfigReconciler {
+		return &KbsConfigReconciler{
+			Client:    fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(existing...).Build(),
+			Scheme:    scheme,
+			log:       logr.Discard(),
+			namespace: namespace,
+			kbsConfig: &confidentialcontainersorgv1alpha1.KbsConfig{
+				ObjectMeta: metav1.ObjectMeta{Name: "kbsconfig", Namespace: namespace, Generation: 3},
+				Spec:       spec,
+			},
+		}
+	}
+
+	r := newReconciler(confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:  "kbs-config",
+		KbsAuthSecretName: "kbs-auth",
+	})
+	if err := r.resolveReferencedObjects(context.Background()); err != nil {
+		t.Fatalf("expected references to resolve, got %v", err)
+	}
+	resolved := meta.FindStatusCondition(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved)
+	if resolved == nil || resolved.Status != metav1.ConditionTrue || resolved.ObservedGeneration != 3 {
+		t.Errorf("expected ConfigResolved=True for generation 3, got %+v", resolved)
+	}
+	if !meta.IsStatusConditionFalse(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionTlsConfigured) {
+		t.Errorf("expected TlsConfigured=False without HTTPS secrets")
+	}
+
+	r = newReconciler(confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName:                  "kbs-config",
+		KbsAuthSecretName:                 "kbs-auth",
+		KbsResourcePolicyConfigMapName:    "resource-policy",
+		KbsSecretResources:                []string{"kbsres1"},
+		KbsAttestationPolicyConfigMapName: "",
+	})
+	err := r.resolveReferencedObjects(context.Background())
+	if err == nil {
+		t.Fatal("expected an error for missing references")
+	}
+	for _, name := range []string{"ConfigMap resource-policy", "Secret kbsres1"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to mention %q, got %v", name, err)
+		}
+	}
+	resolved = meta.FindStatusCondition(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionConfigResolved)
+	if resolved == nil || resolved.Status != metav1.ConditionFalse || resolved.Reason != reasonMissingReferences {
+		t.Errorf("expected ConfigResolved=False with reason %s, got %+v", reasonMissingReferences, resolved)
+	}
+	if !meta.IsStatusConditionTrue(r.kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionDegraded) {
+		t.Errorf("expected Degraded=True when references are missing")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// KbsResourceReconciler reports the effective URI and the state of the KbsResources,
+// their contents are gathered by the KbsConfig controller
+type KbsResourceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	log    logr.Logger
+}
+
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=kbsresources,verbs=get;list;watch
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=kbsresources/status,verbs=get;update;patch
+
+// Reconcile updates the status of a KbsResource
+func (r *KbsResourceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.log = log.FromContext(ctx)
+
+	resource := &confidentialcontainersorgv1alpha1.KbsResource{}
+	if err := r.Get(ctx, req.NamespacedName, resource); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		r.log.Error(err, "Failed to get KbsResource")
+		return ctrl.Result{}, err
+	}
+
+	status, err := r.kbsResourceStatus(ctx, resource)
+	if err != nil {
+		r.log.Error(err, "Failed to compute KbsResource status")
+		return ctrl.Result{}, err
+	}
+	if apiequality.Semantic.DeepEqual(resource.Status, *status) {
+		return ctrl.Result{}, nil
+	}
+	resource.Status = *status
+	if err := r.Status().Update(ctx, resource); err != nil {
+		r.log.Error(err, "Failed to update KbsResource status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// kbsResourceStatus returns the status of a KbsResource: its URI, the KbsConfigs serving it
+// and whether its content is resolved and its path is not taken by another KbsResource
+func (r *KbsResourceReconciler) kbsResourceStatus(ctx context.Context, resource *confidentialcontainersorgv1alpha1.KbsResource) (*confidentialcontainersorgv1alpha1.KbsResourceStatus, error) {
+	status := resource.Status.DeepCopy()
+	status.ObservedGeneration = resource.Generation
+	status.URI = kbsResourceURI(resource)
+
+	kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+	if err := r.List(ctx, kbsConfigList, client.InNamespace(resource.Namespace)); err != nil {
+		return nil, err
+	}
+	resourceList := &confidentialcontainersorgv1alpha1.KbsResourceList{}
+	if err := r.List(ctx, resourceList, client.InNamespace(resource.Namespace)); err != nil {
+		return nil, err
+	}
+
+	status.KbsConfigs = nil
+	var conflicts []string
+	for _, kbsConfig := range kbsConfigList.Items {
+		if !servesKbsResource(kbsConfig.Name, resource) {
+			continue
+		}
+		if other := conflictingKbsResource(resource, resourceList.Items, kbsConfig.Name); other != nil {
+			conflicts = append(conflicts, fmt.Sprintf("KbsResource %s on KbsConfig %s", other.Name, kbsConfig.Name))
+			continue
+		}
+		status.KbsConfigs = append(status.KbsConfigs, kbsConfig.Name)
+	}
+	sort.Strings(status.KbsConfigs)
+
+	condition := metav1.Condition{
+		Type:               confidentialcontainersorgv1alpha1.KbsResourceConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonResourceResolved,
+		Message:            fmt.Sprintf("Resource served at %s", status.URI),
+		ObservedGeneration: resource.Generation,
+	}
+	_, err := resolveKbsResourceContent(ctx, r.Client, resource)
+	if _, notFound := err.(*errKbsResourceSourceNotFound); notFound {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonSourceNotFound, err.Error()
+		// KbsConfigs skip the resources whose content is missing
+		status.KbsConfigs = nil
+	} else if err != nil {
+		return nil, err
+	} else if len(conflicts) > 0 {
+		condition.Status, condition.Reason = metav1.ConditionFalse, reasonPathConflict
+		condition.Message = fmt.Sprintf("%s is already served by %v", kbsResourcePath(resource), conflicts)
+	} else if len(status.KbsConfigs) == 0 {
+		condition.Status, condition.Reason = metav1.ConditionFalse, reasonNoKbsConfig
+		if resource.Spec.KbsConfigName != "" {
+			condition.Message = fmt.Sprintf("KbsConfig %s not found in namespace %s", resource.Spec.KbsConfigName, resource.Namespace)
+		} else {
+			condition.Message = fmt.Sprintf("No KbsConfig in namespace %s", resource.Namespace)
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return status, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KbsResourceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&confidentialcontainersorgv1alpha1.KbsResource{}).
+		// Watch the Secrets and ConfigMaps holding the contents
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(sourceToKbsResourceMapper(mgr.GetClient(), "Secret")),
+		).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(sourceToKbsResourceMapper(mgr.GetClient(), "ConfigMap")),
+		).
+		// A new or deleted KbsConfig changes where the resources are served
+		Watches(
+			&confidentialcontainersorgv1alpha1.KbsConfig{},
+			handler.EnqueueRequestsFromMapFunc(namespaceToKbsResourceMapper(mgr.GetClient())),
+		).
+		// A KbsResource leaving a path hands it over to another one
+		Watches(
+			&confidentialcontainersorgv1alpha1.KbsResource{},
+			handler.EnqueueRequestsFromMapFunc(namespaceToKbsResourceMapper(mgr.GetClient())),
+		).
+		Complete(r)
+}
+
+// sourceToKbsResourceMapper maps a Secret or ConfigMap to the KbsResources whose content it holds
+func sourceToKbsResourceMapper(c client.Client, kind string) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		resources, err := kbsResourcesReferencing(ctx, c, o.GetNamespace(), kind, o.GetName())
+		if err != nil {
+			ctrl.Log.WithName("kbsresource-controller").Info("Error in listing KbsResource", "err", err)
+			return nil
+		}
+		return kbsResourceRequests(resources)
+	}
+}
+
+// namespaceToKbsResourceMapper maps an object to all the KbsResources of its namespace
+func namespaceToKbsResourceMapper(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		resourceList := &confidentialcontainersorgv1alpha1.KbsResourceList{}
+		if err := c.List(ctx, resourceList, client.InNamespace(o.GetNamespace())); err != nil {
+			ctrl.Log.WithName("kbsresource-controller").Info("Error in listing KbsResource", "err", err)
+			return nil
+		}
+		return kbsResourceRequests(resourceList.Items)
+	}
+}
+
+func kbsResourceRequests(resources []confidentialcontainersorgv1alpha1.KbsResource) []reconcile.Request {
+	requests := make([]reconcile.Request, 0, len(resources))
+	for _, resource := range resources {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: resource.Namespace, Name: resource.Name},
+		})
+	}
+	return requests
+}
@@ -0,0 +1,96 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestKbsResourceReconcile(t *testing.T) {
+	now := time.Now()
+	fromSecret := inlineKbsResourceSpec("default", "keys", "workload", "")
+	fromSecret.Source = confidentialcontainersorgv1alpha1.KbsResourceSource{
+		SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "absent"}, Key: "key"},
+	}
+	otherKbs := inlineKbsResourceSpec("default", "sample", "other", "other")
+	otherKbs.KbsConfigName = "absent"
+
+	scheme := newTestScheme(t)
+	objs := []client.Object{
+		newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{}),
+		newTestKbsResource("greeting", now, inlineKbsResourceSpec("default", "sample", "greeting", "hello")),
+		newTestKbsResource("greeting-copy", now.Add(time.Minute), inlineKbsResourceSpec("default", "sample", "greeting", "hi")),
+		newTestKbsResource("workload", now, fromSecret),
+		newTestKbsResource("other", now, otherKbs),
+	}
+	r := &KbsResourceReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+			WithStatusSubresource(&confidentialcontainersorgv1alpha1.KbsResource{}).Build(),
+		Scheme: scheme,
+		log:    logr.Discard(),
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		ready      bool
+		reason     string
+		kbsConfigs int
+	}{
+		{name: "greeting", ready: true, reason: reasonResourceResolved, kbsConfigs: 1},
+		{name: "greeting-copy", reason: reasonPathConflict},
+		{name: "workload", reason: reasonSourceNotFound},
+		{name: "other", reason: reasonNoKbsConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := types.NamespacedName{Namespace: testNamespace, Name: tt.name}
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+			resource := &confidentialcontainersorgv1alpha1.KbsResource{}
+			if err := r.Get(ctx, key, resource); err != nil {
+				t.Fatal(err)
+			}
+			if resource.Status.URI != "kbs:///"+kbsResourcePath(resource) {
+				t.Errorf("unexpected URI %s", resource.Status.URI)
+			}
+			if len(resource.Status.KbsConfigs) != tt.kbsConfigs {
+				t.Errorf("expected %d serving KbsConfigs, got %v", tt.kbsConfigs, resource.Status.KbsConfigs)
+			}
+			condition := meta.FindStatusCondition(resource.Status.Conditions, confidentialcontainersorgv1alpha1.KbsResourceConditionReady)
+			if condition == nil {
+				t.Fatal("expected a Ready condition")
+			}
+			if (condition.Status == "True") != tt.ready || condition.Reason != tt.reason {
+				t.Errorf("expected Ready=%v with reason %s, got %s %s: %s", tt.ready, tt.reason, condition.Status, condition.Reason, condition.Message)
+			}
+		})
+	}
+}
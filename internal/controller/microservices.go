@@ -0,0 +1,363 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Keys of the attestation service and RVPS configurations in their ConfigMaps
+	asConfigFilename   = "as-config.json"
+	rvpsConfigFilename = "rvps-config.json"
+
+	// gRPC ports of the attestation service and RVPS containers
+	asGrpcPort   = 50004
+	rvpsGrpcPort = 50003
+
+	// Working directory of the gRPC attestation service
+	asWorkDir = confidentialContainersPath + "/attestation-service"
+
+	// Components of the attestation service and RVPS, also the names of their containers
+	asComponent   = "as"
+	rvpsComponent = "rvps"
+)
+
+// microserviceDeploymentName returns the name of the Deployment of a component of the named KbsConfig
+// running separately from KBS
+func microserviceDeploymentName(kbsConfigName, component string) string {
+	return kbsConfigName + "-" + component + "-deployment"
+}
+
+// microserviceServiceName returns the name of the Service of a component of the named KbsConfig
+// running separately from KBS
+func microserviceServiceName(kbsConfigName, component string) string {
+	return kbsConfigName + "-" + component + "-service"
+}
+
+// serviceGrpcAddress returns the address of a gRPC service reached through its Service
+func serviceGrpcAddress(serviceName, namespace string, port int) string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", serviceName, namespace, port)
+}
+
+// separateMicroservices returns true when the attestation service and RVPS of the TrusteeConfig run in
+// Deployments of their own
+func separateMicroservices(deploymentType confidentialcontainersorgv1alpha1.DeploymentType, spec *confidentialcontainersorgv1alpha1.MicroservicesSpec) bool {
+	return deploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices && spec != nil && spec.SeparateDeployments
+}
+
+// kbsDeploymentType returns the topology of the KBS deployment of the TrusteeConfig
+func kbsDeploymentType(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) confidentialcontainersorgv1alpha1.DeploymentType {
+	if spec.KbsDeploymentType == "" {
+		return confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne
+	}
+	return spec.KbsDeploymentType
+}
+
+// localGrpcAddress returns the address of a gRPC service running in the KBS pod
+func localGrpcAddress(port int) string {
+	return fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// generateAsConfig returns the as-config.json of the gRPC attestation service, built from the
+// [attestation_service] table of a KBS configuration rendered with the builtin attestation service,
+// so that the profile defaults and the KBS settings apply to both topologies
+func generateAsConfig(builtinKbsConfig, rvpsAddress string) (string, error) {
+	tree, err := decodeTomlTree(builtinKbsConfig)
+	if err != nil {
+		return "", err
+	}
+	as, ok := getTomlPath(tree, []string{"attestation_service"}).(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("the KBS configuration has no attestation_service table")
+	}
+
+	// type and timeout are settings of the KBS client of the attestation service
+	delete(as, "type")
+	delete(as, "timeout")
+	if _, ok := as["work_dir"]; !ok {
+		as["work_dir"] = asWorkDir
+	}
+	as["rvps_config"] = map[string]interface{}{
+		"type":    "GrpcRemote",
+		"address": rvpsAddress,
+	}
+
+	content, err := json.MarshalIndent(as, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// generateRvpsConfig returns the rvps-config.json of RVPS, storing the reference values in the
+// directory the reference values ConfigMap is mounted in
+func generateRvpsConfig() (string, error) {
+	config := map[string]interface{}{
+		"storage": map[string]interface{}{
+			"storage_type": "LocalJson",
+			"backends": map[string]interface{}{
+				"local_json": map[string]interface{}{
+					"file_dir_path": rvpsReferenceValuesPath,
+				},
+			},
+		},
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// getAsConfigMapName returns the name for the attestation service config map
+func (r *TrusteeConfigReconciler) getAsConfigMapName() string {
+	return r.trusteeConfig.Name + "-as-config"
+}
+
+// getRvpsConfigMapName returns the name for the RVPS config map
+func (r *TrusteeConfigReconciler) getRvpsConfigMapName() string {
+	return r.trusteeConfig.Name + "-rvps-config"
+}
+
+// microservicesAddresses returns the addresses KBS reaches the attestation service at and the attestation
+// service reaches RVPS at: their Services with separate Deployments, the KBS pod otherwise
+func (r *TrusteeConfigReconciler) microservicesAddresses() (string, string) {
+	if !separateMicroservices(kbsDeploymentType(r.trusteeConfig.Spec), r.trusteeConfig.Spec.MicroservicesSpec) {
+		return localGrpcAddress(asGrpcPort), localGrpcAddress(rvpsGrpcPort)
+	}
+	kbsConfigName := r.getKbsConfigName()
+	return serviceGrpcAddress(microserviceServiceName(kbsConfigName, asComponent), r.namespace, asGrpcPort),
+		serviceGrpcAddress(microserviceServiceName(kbsConfigName, rvpsComponent), r.namespace, rvpsGrpcPort)
+}
+
+// createOrUpdateMicroservicesConfigMaps writes the attestation service and RVPS configurations of the
+// MicroservicesDeployment topology and returns the names of their ConfigMaps. The ConfigMaps are
+// generated from the TrusteeConfig on every reconcile, and deleted with the AllInOneDeployment topology
+func (r *TrusteeConfigReconciler) createOrUpdateMicroservicesConfigMaps(ctx context.Context) (string, string, error) {
+	asConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: r.getAsConfigMapName(), Namespace: r.namespace}}
+	rvpsConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: r.getRvpsConfigMapName(), Namespace: r.namespace}}
+
+	if kbsDeploymentType(r.trusteeConfig.Spec) != confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		for _, configMap := range []*corev1.ConfigMap{asConfigMap, rvpsConfigMap} {
+			if err := r.Delete(ctx, configMap); err != nil && !k8serrors.IsNotFound(err) {
+				return "", "", err
+			}
+		}
+		return "", "", nil
+	}
+
+	// Render the KBS configuration with the builtin attestation service to get its settings
+	builtinSpec := *r.trusteeConfig.Spec.DeepCopy()
+	builtinSpec.KbsDeploymentType = confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne
+	builtinKbsConfig, err := r.renderKbsTomlConfig(builtinSpec)
+	if err != nil {
+		return "", "", err
+	}
+	_, rvpsAddress := r.microservicesAddresses()
+	asConfig, err := generateAsConfig(builtinKbsConfig, rvpsAddress)
+	if err != nil {
+		return "", "", fmt.Errorf("attestation service configuration: %w", err)
+	}
+	rvpsConfig, err := generateRvpsConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("RVPS configuration: %w", err)
+	}
+
+	if err := r.createOrUpdateGeneratedConfigMap(ctx, asConfigMap, "as-config", asConfigFilename, asConfig); err != nil {
+		return "", "", err
+	}
+	if err := r.createOrUpdateGeneratedConfigMap(ctx, rvpsConfigMap, "rvps-config", rvpsConfigFilename, rvpsConfig); err != nil {
+		return "", "", err
+	}
+	return asConfigMap.Name, rvpsConfigMap.Name, nil
+}
+
+// createOrUpdateGeneratedConfigMap sets the content of a ConfigMap owned by the TrusteeConfig,
+// overwriting any manual change
+func (r *TrusteeConfigReconciler) createOrUpdateGeneratedConfigMap(ctx context.Context, configMap *corev1.ConfigMap, component, key, content string) error {
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Labels == nil {
+			configMap.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, component) {
+			configMap.Labels[k] = v
+		}
+		configMap.Data = map[string]string{key: content}
+		return ctrl.SetControllerReference(r.trusteeConfig, configMap, r.Scheme)
+	})
+	return err
+}
+
+// microservicesSpec returns the attestation service and RVPS settings of the KbsConfig
+func (r *KbsConfigReconciler) microservicesSpec() confidentialcontainersorgv1alpha1.MicroservicesSpec {
+	if r.kbsConfig.Spec.KbsMicroservicesSpec == nil {
+		return confidentialcontainersorgv1alpha1.MicroservicesSpec{}
+	}
+	return *r.kbsConfig.Spec.KbsMicroservicesSpec
+}
+
+// separateMicroservices returns true when the attestation service and RVPS of the KbsConfig run in
+// Deployments of their own
+func (r *KbsConfigReconciler) separateMicroservices() bool {
+	return separateMicroservices(r.kbsConfig.Spec.DeploymentType(), r.kbsConfig.Spec.KbsMicroservicesSpec)
+}
+
+// podSpecForComponent returns the pod spec running the container of a single component, with the volumes it
+// mounts. The secret-converter init container preparing the KBS repository only runs in the KBS pods
+func podSpecForComponent(podSpec corev1.PodSpec, component string) corev1.PodSpec {
+	var containers []corev1.Container
+	for _, container := range podSpec.Containers {
+		if container.Name == component {
+			containers = append(containers, container)
+		}
+	}
+	var initContainers []corev1.Container
+	if component == kbsComponent {
+		initContainers = podSpec.InitContainers
+	}
+
+	mounted := make(map[string]bool)
+	for _, container := range append(append([]corev1.Container{}, initContainers...), containers...) {
+		for _, volumeMount := range container.VolumeMounts {
+			mounted[volumeMount.Name] = true
+		}
+	}
+	var volumes []corev1.Volume
+	for _, volume := range podSpec.Volumes {
+		if mounted[volume.Name] {
+			volumes = append(volumes, volume)
+		}
+	}
+
+	podSpec.InitContainers = initContainers
+	podSpec.Containers = containers
+	podSpec.Volumes = volumes
+	return podSpec
+}
+
+// newMicroserviceDeployment returns the Deployment of the attestation service or RVPS running separately from KBS
+func (r *KbsConfigReconciler) newMicroserviceDeployment(ctx context.Context, component string, componentSpec confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec) (*appsv1.Deployment, error) {
+	podSpec, err := r.buildKbsPodSpec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spec := microserviceKbsDeploymentSpec(r.kbsConfig.Spec.KbsDeploymentSpec, componentSpec)
+	replicas := int32(1)
+	if spec.Replicas != nil {
+		replicas = *spec.Replicas
+	}
+	labels := standardLabels(r.kbsConfig.Name, component)
+	podSpec = podSpecForComponent(podSpec, component)
+	applyPodScheduling(&podSpec, spec, labels)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      microserviceDeploymentName(r.kbsConfig.Name, component),
+			Namespace: r.namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: r.getPodTemplateHashAnnotations(ctx),
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(r.kbsConfig, deployment, r.Scheme); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+// deployOrUpdateMicroservices creates or updates the Deployments and Services of the attestation service and
+// RVPS when they run separately from KBS, and deletes them otherwise
+func (r *KbsConfigReconciler) deployOrUpdateMicroservices(ctx context.Context) error {
+	ms := r.microservicesSpec()
+	components := []struct {
+		name string
+		port int32
+		spec confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec
+	}{
+		{name: asComponent, port: asGrpcPort, spec: ms.AsDeploymentSpec},
+		{name: rvpsComponent, port: rvpsGrpcPort, spec: ms.RvpsDeploymentSpec},
+	}
+
+	for _, component := range components {
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: microserviceDeploymentName(r.kbsConfig.Name, component.name), Namespace: r.namespace}}
+		service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: microserviceServiceName(r.kbsConfig.Name, component.name), Namespace: r.namespace}}
+		if !r.separateMicroservices() {
+			if err := r.deleteOwnedObject(ctx, service); err != nil {
+				return err
+			}
+			if err := r.deleteOwnedObject(ctx, deployment); err != nil {
+				return err
+			}
+			continue
+		}
+
+		desired, err := r.newMicroserviceDeployment(ctx, component.name, component.spec)
+		if err != nil {
+			return err
+		}
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+			deployment.Labels = desired.Labels
+			deployment.Spec.Replicas = desired.Spec.Replicas
+			deployment.Spec.Selector = desired.Spec.Selector
+			deployment.Spec.Template = desired.Spec.Template
+			return ctrl.SetControllerReference(r.kbsConfig, deployment, r.Scheme)
+		})
+		if err != nil {
+			return fmt.Errorf("%s deployment: %w", component.name, err)
+		}
+
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+			labels := standardLabels(r.kbsConfig.Name, component.name)
+			service.Labels = labels
+			service.Spec.Type = corev1.ServiceTypeClusterIP
+			service.Spec.Selector = labels
+			service.Spec.Ports = []corev1.ServicePort{{
+				Name:       component.name,
+				Protocol:   corev1.ProtocolTCP,
+				Port:       component.port,
+				TargetPort: intstr.FromInt32(component.port),
+			}}
+			return ctrl.SetControllerReference(r.kbsConfig, service, r.Scheme)
+		})
+		if err != nil {
+			return fmt.Errorf("%s service: %w", component.name, err)
+		}
+	}
+	return nil
+}
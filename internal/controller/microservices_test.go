@@ -0,0 +1,302 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"text/template"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// renderKbsConfig renders a KBS config template with the KBS settings of the TrusteeConfig spec
+func renderKbsConfig(t *testing.T, filename string, spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) string {
+	t.Helper()
+	content, err := os.ReadFile("../../config/templates/" + filename)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", filename, err)
+	}
+	data := GetTLSConfigFromTlsConfig(nil)
+	data.AttestationService = attestationServiceTemplateData(spec)
+	var buf bytes.Buffer
+	if err := template.Must(template.New(filename).Parse(string(content))).Execute(&buf, data); err != nil {
+		t.Fatalf("Failed to render %s: %v", filename, err)
+	}
+	config, err := applyKbsSettings(buf.String(), spec)
+	if err != nil {
+		t.Fatalf("%s: applyKbsSettings() error = %v", filename, err)
+	}
+	return config
+}
+
+func TestKbsTomlTemplatesMicroservices(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		KbsDeploymentType: confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+		KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+			AttestationTokenDuration: &metav1.Duration{Duration: 10 * time.Minute},
+		},
+	}
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		tree, err := decodeTomlTree(renderKbsConfig(t, filename, spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]interface{}{"type": kbsAttestationServiceGrpc, "as_addr": "http://127.0.0.1:50004"}
+		if got := getTomlPath(tree, []string{"attestation_service"}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected KBS to use the gRPC attestation service of the pod, got %v", filename, got)
+		}
+	}
+}
+
+func TestGenerateAsConfig(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+			AttestationTokenDuration: &metav1.Duration{Duration: 10 * time.Minute},
+			VcekSources:              []confidentialcontainersorgv1alpha1.VcekSourceType{confidentialcontainersorgv1alpha1.VcekSourceKDS},
+		},
+	}
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		content, err := generateAsConfig(renderKbsConfig(t, filename, spec), localGrpcAddress(rvpsGrpcPort))
+		if err != nil {
+			t.Fatalf("%s: generateAsConfig() error = %v", filename, err)
+		}
+		var config map[string]interface{}
+		if err := json.Unmarshal([]byte(content), &config); err != nil {
+			t.Fatalf("%s: as-config.json is not valid JSON: %v", filename, err)
+		}
+
+		for _, key := range []string{"type", "timeout"} {
+			if _, ok := config[key]; ok {
+				t.Errorf("%s: expected the KBS client setting %s to be dropped", filename, key)
+			}
+		}
+		if config["work_dir"] != asWorkDir {
+			t.Errorf("%s: expected the work_dir %s, got %v", filename, asWorkDir, config["work_dir"])
+		}
+		wantRvps := map[string]interface{}{"type": "GrpcRemote", "address": "http://127.0.0.1:50003"}
+		if !reflect.DeepEqual(config["rvps_config"], wantRvps) {
+			t.Errorf("%s: expected the attestation service to use the RVPS of the pod, got %v", filename, config["rvps_config"])
+		}
+
+		// The KBS settings of the builtin attestation service are kept
+		durations := []interface{}{
+			getTomlPath(config, []string{"attestation_token_broker", "duration_min"}),
+			getTomlPath(config, []string{"attestation_token_config", "duration_min"}),
+		}
+		if !reflect.DeepEqual(durations, []interface{}{float64(10), nil}) && !reflect.DeepEqual(durations, []interface{}{nil, float64(10)}) {
+			t.Errorf("%s: expected a token duration of 10 minutes, got %v", filename, durations)
+		}
+		wantVcek := []interface{}{map[string]interface{}{"type": "KDS"}}
+		if got := getTomlPath(config, []string{"verifier_config", "snp_verifier", "vcek_sources"}); !reflect.DeepEqual(got, wantVcek) {
+			t.Errorf("%s: expected the VCEK sources %v, got %v", filename, wantVcek, got)
+		}
+	}
+
+	if _, err := generateAsConfig("[http_server]\nworker_count = 4\n", localGrpcAddress(rvpsGrpcPort)); err == nil {
+		t.Error("expected an error for a configuration without attestation service")
+	}
+}
+
+func TestGenerateRvpsConfig(t *testing.T) {
+	content, err := generateRvpsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		t.Fatalf("rvps-config.json is not valid JSON: %v", err)
+	}
+	if got := getTomlPath(config, []string{"storage", "storage_type"}); got != "LocalJson" {
+		t.Errorf("expected the LocalJson storage, got %v", got)
+	}
+	if got := getTomlPath(config, []string{"storage", "backends", "local_json", "file_dir_path"}); got != rvpsReferenceValuesPath {
+		t.Errorf("expected the reference values in %s, got %v", rvpsReferenceValuesPath, got)
+	}
+}
+
+func TestMicroservicesAddresses(t *testing.T) {
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		KbsDeploymentType: confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+	})
+	asAddress, rvpsAddress := r.microservicesAddresses()
+	if asAddress != "http://127.0.0.1:50004" || rvpsAddress != "http://127.0.0.1:50003" {
+		t.Errorf("expected the addresses of the KBS pod, got %s and %s", asAddress, rvpsAddress)
+	}
+
+	r.trusteeConfig.Spec.MicroservicesSpec = &confidentialcontainersorgv1alpha1.MicroservicesSpec{SeparateDeployments: true}
+	asAddress, rvpsAddress = r.microservicesAddresses()
+	if want := "http://trustee-kbs-config-as-service." + testNamespace + ".svc:50004"; asAddress != want {
+		t.Errorf("expected the attestation service address %s, got %s", want, asAddress)
+	}
+	if want := "http://trustee-kbs-config-rvps-service." + testNamespace + ".svc:50003"; rvpsAddress != want {
+		t.Errorf("expected the RVPS address %s, got %s", want, rvpsAddress)
+	}
+}
+
+func TestCreateOrUpdateMicroservicesConfigMapsAllInOne(t *testing.T) {
+	ctx := context.Background()
+	asConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "trustee-as-config", Namespace: testNamespace}}
+	rvpsConfigMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "trustee-rvps-config", Namespace: testNamespace}}
+	r := newTestTrusteeConfigReconciler(t, confidentialcontainersorgv1alpha1.TrusteeConfigSpec{}, asConfigMap, rvpsConfigMap)
+
+	asName, rvpsName, err := r.createOrUpdateMicroservicesConfigMaps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asName != "" || rvpsName != "" {
+		t.Errorf("expected no ConfigMap with the AllInOneDeployment topology, got %q and %q", asName, rvpsName)
+	}
+	for _, configMap := range []*corev1.ConfigMap{asConfigMap, rvpsConfigMap} {
+		if err := r.Get(ctx, client.ObjectKeyFromObject(configMap), &corev1.ConfigMap{}); !k8serrors.IsNotFound(err) {
+			t.Errorf("expected %s to be deleted, got %v", configMap.Name, err)
+		}
+	}
+}
+
+func TestDeployOrUpdateMicroservices(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("OPERATOR_IMAGE_NAME", "trustee-operator:test")
+	replicas := int32(3)
+	asResources := corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}}
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsDeploymentType:    confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+		KbsConfigMapName:     "kbs-config",
+		KbsAuthSecretName:    "auth",
+		KbsAsConfigMapName:   "as-config",
+		KbsRvpsConfigMapName: "rvps-config",
+		KbsMicroservicesSpec: &confidentialcontainersorgv1alpha1.MicroservicesSpec{
+			SeparateDeployments: true,
+			AsDeploymentSpec:    confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec{Replicas: &replicas, Resources: asResources},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "as-config", Namespace: testNamespace}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "rvps-config", Namespace: testNamespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "auth", Namespace: testNamespace}})
+
+	if err := r.deployOrUpdateMicroservices(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// KBS runs alone in its pods
+	kbsDeployment, err := r.newKbsDeployment(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containers := kbsDeployment.Spec.Template.Spec.Containers; len(containers) != 1 || containers[0].Name != kbsComponent {
+		t.Errorf("expected the KBS pods to only run KBS, got %v", containers)
+	}
+	if len(kbsDeployment.Spec.Template.Spec.InitContainers) != 1 {
+		t.Errorf("expected the secret-converter init container in the KBS pods")
+	}
+	for _, volume := range kbsDeployment.Spec.Template.Spec.Volumes {
+		if volume.Name == "as-config" || volume.Name == "rvps-config" {
+			t.Errorf("expected the KBS pods not to mount %s", volume.Name)
+		}
+	}
+
+	for _, component := range []struct {
+		name     string
+		port     int32
+		replicas int32
+	}{{asComponent, asGrpcPort, 3}, {rvpsComponent, rvpsGrpcPort, 1}} {
+		deployment := &appsv1.Deployment{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceDeploymentName("tenant-a", component.name)}, deployment); err != nil {
+			t.Fatal(err)
+		}
+		if *deployment.Spec.Replicas != component.replicas {
+			t.Errorf("%s: expected %d replicas, got %d", component.name, component.replicas, *deployment.Spec.Replicas)
+		}
+		podSpec := deployment.Spec.Template.Spec
+		if len(podSpec.Containers) != 1 || podSpec.Containers[0].Name != component.name || len(podSpec.InitContainers) != 0 {
+			t.Errorf("%s: expected a single %s container, got %v", component.name, component.name, podSpec.Containers)
+		}
+		mounted := make(map[string]bool)
+		for _, volumeMount := range podSpec.Containers[0].VolumeMounts {
+			mounted[volumeMount.Name] = true
+		}
+		for _, volume := range podSpec.Volumes {
+			if !mounted[volume.Name] {
+				t.Errorf("%s: volume %s is not mounted", component.name, volume.Name)
+			}
+		}
+		if !mounted[component.name+"-config"] {
+			t.Errorf("%s: expected the %s-config volume to be mounted", component.name, component.name)
+		}
+
+		service := &corev1.Service{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceServiceName("tenant-a", component.name)}, service); err != nil {
+			t.Fatal(err)
+		}
+		if len(service.Spec.Ports) != 1 || service.Spec.Ports[0].Port != component.port ||
+			!reflect.DeepEqual(service.Spec.Selector, deployment.Spec.Template.Labels) {
+			t.Errorf("%s: expected the service to select the %s pods on port %d, got %v", component.name, component.name, component.port, service.Spec)
+		}
+	}
+
+	as := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceDeploymentName("tenant-a", asComponent)}, as); err != nil {
+		t.Fatal(err)
+	}
+	if got := as.Spec.Template.Spec.Containers[0].Resources; !reflect.DeepEqual(got, asResources) {
+		t.Errorf("expected the attestation service resources %v, got %v", asResources, got)
+	}
+	rvps := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceDeploymentName("tenant-a", rvpsComponent)}, rvps); err != nil {
+		t.Fatal(err)
+	}
+	if command := rvps.Spec.Template.Spec.Containers[0].Command; command[len(command)-1] != "0.0.0.0:50003" {
+		t.Errorf("expected RVPS to listen on the pod address, got %v", command)
+	}
+
+	// Back in the KBS pods, the Deployments and Services are deleted
+	kbsConfig.Spec.KbsMicroservicesSpec.SeparateDeployments = false
+	if err := r.deployOrUpdateMicroservices(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, component := range []string{asComponent, rvpsComponent} {
+		err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceDeploymentName("tenant-a", component)}, &appsv1.Deployment{})
+		if !k8serrors.IsNotFound(err) {
+			t.Errorf("expected the %s deployment to be deleted, got %v", component, err)
+		}
+		err = r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: microserviceServiceName("tenant-a", component)}, &corev1.Service{})
+		if !k8serrors.IsNotFound(err) {
+			t.Errorf("expected the %s service to be deleted, got %v", component, err)
+		}
+	}
+	kbsDeployment, err = r.newKbsDeployment(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containers := kbsDeployment.Spec.Template.Spec.Containers; len(containers) != 3 {
+		t.Errorf("expected the KBS pods to run KBS, the attestation service and RVPS, got %d containers", len(containers))
+	}
+}
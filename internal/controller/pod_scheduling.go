@@ -0,0 +1,122 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// applyPodScheduling sets the scheduling settings of a deployment spec on a pod spec. The topology spread
+// constraints without label selector select the pods with podLabels
+func applyPodScheduling(podSpec *corev1.PodSpec, spec confidentialcontainersorgv1alpha1.KbsDeploymentSpec, podLabels map[string]string) {
+	podSpec.NodeSelector = spec.NodeSelector
+	podSpec.Tolerations = spec.Tolerations
+	podSpec.Affinity = spec.Affinity
+	podSpec.PriorityClassName = spec.PriorityClassName
+	podSpec.ServiceAccountName = spec.ServiceAccountName
+	podSpec.TopologySpreadConstraints = nil
+	for _, constraint := range spec.TopologySpreadConstraints {
+		constraint = *constraint.DeepCopy()
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: podLabels}
+		}
+		podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, constraint)
+	}
+}
+
+// microserviceKbsDeploymentSpec returns the deployment spec of the attestation service or RVPS: the KBS
+// deployment spec overridden by the settings of the component. The KBS affinity is not inherited, and
+// the KBS topology spread constraints select the pods of the component, since the label selectors of
+// both target the KBS pods
+func microserviceKbsDeploymentSpec(kbs confidentialcontainersorgv1alpha1.KbsDeploymentSpec, component confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec) confidentialcontainersorgv1alpha1.KbsDeploymentSpec {
+	spec := *kbs.DeepCopy()
+	spec.Replicas = component.Replicas
+	spec.Resources = component.Resources
+	spec.SecretConverterResources = corev1.ResourceRequirements{}
+	spec.Affinity = component.Affinity
+	if len(component.TopologySpreadConstraints) > 0 {
+		spec.TopologySpreadConstraints = component.TopologySpreadConstraints
+	} else {
+		for i := range spec.TopologySpreadConstraints {
+			spec.TopologySpreadConstraints[i].LabelSelector = nil
+		}
+	}
+	if len(component.NodeSelector) > 0 {
+		spec.NodeSelector = component.NodeSelector
+	}
+	if len(component.Tolerations) > 0 {
+		spec.Tolerations = component.Tolerations
+	}
+	if component.PriorityClassName != "" {
+		spec.PriorityClassName = component.PriorityClassName
+	}
+	if component.ServiceAccountName != "" {
+		spec.ServiceAccountName = component.ServiceAccountName
+	}
+	return spec
+}
+
+// mergeKbsDeploymentSpec returns the generated KBS deployment spec with the manual edits of the fields
+// that are not set in the TrusteeConfig
+func mergeKbsDeploymentSpec(generated, manual confidentialcontainersorgv1alpha1.KbsDeploymentSpec, trustee *confidentialcontainersorgv1alpha1.KbsDeploymentSpec) confidentialcontainersorgv1alpha1.KbsDeploymentSpec {
+	if trustee == nil {
+		trustee = &confidentialcontainersorgv1alpha1.KbsDeploymentSpec{}
+	}
+	merged := generated
+	if trustee.Replicas == nil && manual.Replicas != nil {
+		merged.Replicas = manual.Replicas
+	}
+	if isZeroResources(trustee.Resources) && !isZeroResources(manual.Resources) {
+		merged.Resources = manual.Resources
+	}
+	if isZeroResources(trustee.SecretConverterResources) && !isZeroResources(manual.SecretConverterResources) {
+		merged.SecretConverterResources = manual.SecretConverterResources
+	}
+	if len(trustee.NodeSelector) == 0 && len(manual.NodeSelector) > 0 {
+		merged.NodeSelector = manual.NodeSelector
+	}
+	if len(trustee.Tolerations) == 0 && len(manual.Tolerations) > 0 {
+		merged.Tolerations = manual.Tolerations
+	}
+	if trustee.Affinity == nil && manual.Affinity != nil {
+		merged.Affinity = manual.Affinity
+	}
+	if len(trustee.TopologySpreadConstraints) == 0 && len(manual.TopologySpreadConstraints) > 0 {
+		merged.TopologySpreadConstraints = manual.TopologySpreadConstraints
+	}
+	if trustee.PriorityClassName == "" && manual.PriorityClassName != "" {
+		merged.PriorityClassName = manual.PriorityClassName
+	}
+	if trustee.ServiceAccountName == "" && manual.ServiceAccountName != "" {
+		merged.ServiceAccountName = manual.ServiceAccountName
+	}
+	if trustee.PodDisruptionBudget == nil && manual.PodDisruptionBudget != nil {
+		merged.PodDisruptionBudget = manual.PodDisruptionBudget
+	}
+	if trustee.Autoscaling == nil && manual.Autoscaling != nil {
+		merged.Autoscaling = manual.Autoscaling
+	}
+	return merged
+}
+
+func isZeroResources(resources corev1.ResourceRequirements) bool {
+	return apiequality.Semantic.DeepEqual(resources, corev1.ResourceRequirements{})
+}
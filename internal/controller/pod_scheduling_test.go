@@ -0,0 +1,163 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+var testInfraScheduling = confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+	NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+	Tolerations: []corev1.Toleration{{
+		Key:      "node-role.kubernetes.io/infra",
+		Operator: corev1.TolerationOpExists,
+		Effect:   corev1.TaintEffectNoSchedule,
+	}},
+	TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+	}},
+	PriorityClassName:  "system-cluster-critical",
+	ServiceAccountName: "trustee",
+}
+
+func TestApplyPodScheduling(t *testing.T) {
+	labels := standardLabels("tenant-a", kbsComponent)
+	podSpec := corev1.PodSpec{}
+	applyPodScheduling(&podSpec, testInfraScheduling, labels)
+
+	if !reflect.DeepEqual(podSpec.NodeSelector, testInfraScheduling.NodeSelector) ||
+		!reflect.DeepEqual(podSpec.Tolerations, testInfraScheduling.Tolerations) ||
+		podSpec.PriorityClassName != "system-cluster-critical" || podSpec.ServiceAccountName != "trustee" {
+		t.Errorf("expected the scheduling settings on the pod spec, got %+v", podSpec)
+	}
+	if len(podSpec.TopologySpreadConstraints) != 1 || podSpec.TopologySpreadConstraints[0].LabelSelector == nil ||
+		!reflect.DeepEqual(podSpec.TopologySpreadConstraints[0].LabelSelector.MatchLabels, labels) {
+		t.Errorf("expected the topology spread constraint to select the pods, got %v", podSpec.TopologySpreadConstraints)
+	}
+	if testInfraScheduling.TopologySpreadConstraints[0].LabelSelector != nil {
+		t.Errorf("expected the deployment spec not to be modified")
+	}
+
+	// An explicit label selector is kept
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "kbs"}}
+	spec := confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", LabelSelector: selector}},
+	}
+	applyPodScheduling(&podSpec, spec, labels)
+	if !reflect.DeepEqual(podSpec.TopologySpreadConstraints[0].LabelSelector, selector) {
+		t.Errorf("expected the label selector to be kept, got %v", podSpec.TopologySpreadConstraints[0].LabelSelector)
+	}
+	if podSpec.NodeSelector != nil || podSpec.ServiceAccountName != "" {
+		t.Errorf("expected the previous scheduling settings to be removed, got %+v", podSpec)
+	}
+}
+
+func TestMicroserviceKbsDeploymentSpec(t *testing.T) {
+	replicas := int32(2)
+	kbs := *testInfraScheduling.DeepCopy()
+	kbs.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{}}
+	kbs.TopologySpreadConstraints = append(kbs.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+		MaxSkew:       1,
+		TopologyKey:   "kubernetes.io/hostname",
+		LabelSelector: &metav1.LabelSelector{MatchLabels: standardLabels("tenant-a", kbsComponent)},
+	})
+	kbs.SecretConverterResources = corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}}
+
+	spec := microserviceKbsDeploymentSpec(kbs, confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec{
+		Replicas:          &replicas,
+		NodeSelector:      map[string]string{"zone": "a"},
+		PriorityClassName: "high",
+	})
+	if spec.Replicas == nil || *spec.Replicas != 2 || !isZeroResources(spec.SecretConverterResources) {
+		t.Errorf("expected the replicas of the component without secret-converter resources, got %+v", spec)
+	}
+	if !reflect.DeepEqual(spec.NodeSelector, map[string]string{"zone": "a"}) || spec.PriorityClassName != "high" {
+		t.Errorf("expected the settings of the component to take precedence, got %+v", spec)
+	}
+	if !reflect.DeepEqual(spec.Tolerations, kbs.Tolerations) || spec.ServiceAccountName != "trustee" {
+		t.Errorf("expected the settings that are not set to be inherited from KBS, got %+v", spec)
+	}
+
+	// The KBS affinity and label selectors target the KBS pods, they are not inherited
+	if spec.Affinity != nil {
+		t.Errorf("expected the KBS affinity not to be inherited, got %+v", spec.Affinity)
+	}
+	asLabels := standardLabels("tenant-a", asComponent)
+	podSpec := corev1.PodSpec{}
+	applyPodScheduling(&podSpec, spec, asLabels)
+	if len(podSpec.TopologySpreadConstraints) != 2 {
+		t.Fatalf("expected the KBS topology spread constraints, got %v", podSpec.TopologySpreadConstraints)
+	}
+	for _, constraint := range podSpec.TopologySpreadConstraints {
+		if constraint.LabelSelector == nil || !reflect.DeepEqual(constraint.LabelSelector.MatchLabels, asLabels) {
+			t.Errorf("expected the constraint to select the attestation service pods, got %v", constraint.LabelSelector)
+		}
+	}
+	if kbs.TopologySpreadConstraints[1].LabelSelector == nil {
+		t.Error("expected the KBS deployment spec not to be modified")
+	}
+
+	// The affinity and topology spread constraints of the component take precedence
+	affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	constraints := []corev1.TopologySpreadConstraint{{MaxSkew: 2, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.DoNotSchedule}}
+	spec = microserviceKbsDeploymentSpec(kbs, confidentialcontainersorgv1alpha1.MicroserviceDeploymentSpec{
+		Affinity:                  affinity,
+		TopologySpreadConstraints: constraints,
+	})
+	if !reflect.DeepEqual(spec.Affinity, affinity) || !reflect.DeepEqual(spec.TopologySpreadConstraints, constraints) {
+		t.Errorf("expected the affinity and topology spread constraints of the component, got %+v", spec)
+	}
+}
+
+func TestMergeKbsDeploymentSpec(t *testing.T) {
+	generatedReplicas, manualReplicas := int32(3), int32(5)
+	generated := confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+		Replicas:     &generatedReplicas,
+		NodeSelector: map[string]string{"node-role.kubernetes.io/infra": ""},
+	}
+	manual := confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+		Replicas:          &manualReplicas,
+		NodeSelector:      map[string]string{"disk": "ssd"},
+		PriorityClassName: "high",
+	}
+	trustee := &confidentialcontainersorgv1alpha1.KbsDeploymentSpec{
+		Replicas:     &generatedReplicas,
+		NodeSelector: generated.NodeSelector,
+	}
+
+	merged := mergeKbsDeploymentSpec(generated, manual, trustee)
+	if *merged.Replicas != 3 || !reflect.DeepEqual(merged.NodeSelector, generated.NodeSelector) {
+		t.Errorf("expected the settings of the TrusteeConfig to take precedence, got %+v", merged)
+	}
+	if merged.PriorityClassName != "high" {
+		t.Errorf("expected the manual priority class to be kept, got %q", merged.PriorityClassName)
+	}
+
+	merged = mergeKbsDeploymentSpec(generated, manual, nil)
+	if *merged.Replicas != 5 || !reflect.DeepEqual(merged.NodeSelector, manual.NodeSelector) {
+		t.Errorf("expected the manual edits to be kept, got %+v", merged)
+	}
+}
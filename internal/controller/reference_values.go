@@ -0,0 +1,220 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Key of the RVPS reference values file in the reference values ConfigMap
+	referenceValueFilename = "reference_value"
+
+	// Reasons used in the ReferenceValue and KbsConfig conditions
+	reasonReferenceValueServed  = "ReferenceValueServed"
+	reasonReferenceValueExpired = "ReferenceValueExpired"
+	reasonInvalidReferenceValue = "InvalidReferenceValue"
+	reasonReferenceValuesFailed = "ReferenceValuesReconcileFailed"
+)
+
+// rvpsReferenceValue is an entry of the RVPS reference values file
+type rvpsReferenceValue struct {
+	Expiration string            `json:"expiration"`
+	Value      []json.RawMessage `json:"value"`
+}
+
+// referenceValueName returns the reference value name of a ReferenceValue
+func referenceValueName(rv *confidentialcontainersorgv1alpha1.ReferenceValue) string {
+	if rv.Spec.Name != "" {
+		return rv.Spec.Name
+	}
+	return rv.Name
+}
+
+// servesReferenceValue returns true if the KbsConfig with the given name serves the ReferenceValue
+func servesReferenceValue(kbsConfigName string, rv *confidentialcontainersorgv1alpha1.ReferenceValue) bool {
+	return rv.Spec.KbsConfigName == "" || rv.Spec.KbsConfigName == kbsConfigName
+}
+
+// referenceValueExpired returns true once the expiration time of the ReferenceValue is reached
+func referenceValueExpired(rv *confidentialcontainersorgv1alpha1.ReferenceValue, now time.Time) bool {
+	return !now.Before(rv.Spec.Expiration.Time)
+}
+
+// validateReferenceValue checks that the values of a ReferenceValue are strings, numbers or booleans
+func validateReferenceValue(rv *confidentialcontainersorgv1alpha1.ReferenceValue) error {
+	if len(rv.Spec.Values) == 0 {
+		return fmt.Errorf("no value is set")
+	}
+	for i, value := range rv.Spec.Values {
+		var v interface{}
+		if err := json.Unmarshal(value.Raw, &v); err != nil {
+			return fmt.Errorf("values[%d]: %w", i, err)
+		}
+		switch v.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("values[%d]: %s is not a string, a number or a boolean", i, string(value.Raw))
+		}
+	}
+	return nil
+}
+
+// aggregateReferenceValues returns the RVPS reference values of the valid and unexpired ReferenceValues
+// served by the KbsConfig, and the earliest time one of them expires. The values sharing a name are merged
+// and expire with the earliest ReferenceValue
+func aggregateReferenceValues(rvs []confidentialcontainersorgv1alpha1.ReferenceValue, kbsConfigName string, now time.Time) (map[string]*rvpsReferenceValue, time.Time) {
+	sorted := make([]confidentialcontainersorgv1alpha1.ReferenceValue, len(rvs))
+	copy(sorted, rvs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	aggregated := make(map[string]*rvpsReferenceValue)
+	expirations := make(map[string]time.Time)
+	var nextExpiration time.Time
+	for i := range sorted {
+		rv := &sorted[i]
+		if !servesReferenceValue(kbsConfigName, rv) || rv.DeletionTimestamp != nil ||
+			referenceValueExpired(rv, now) || validateReferenceValue(rv) != nil {
+			continue
+		}
+		name := referenceValueName(rv)
+		entry, ok := aggregated[name]
+		if !ok {
+			entry = &rvpsReferenceValue{}
+			aggregated[name] = entry
+		}
+		if expiration, ok := expirations[name]; !ok || rv.Spec.Expiration.Time.Before(expiration) {
+			expirations[name] = rv.Spec.Expiration.Time
+			entry.Expiration = rv.Spec.Expiration.UTC().Format(time.RFC3339)
+		}
+		for _, value := range rv.Spec.Values {
+			if !containsRawValue(entry.Value, value.Raw) {
+				entry.Value = append(entry.Value, json.RawMessage(value.Raw))
+			}
+		}
+		if nextExpiration.IsZero() || rv.Spec.Expiration.Time.Before(nextExpiration) {
+			nextExpiration = rv.Spec.Expiration.Time
+		}
+	}
+	return aggregated, nextExpiration
+}
+
+func containsRawValue(values []json.RawMessage, value []byte) bool {
+	for _, v := range values {
+		if bytes.Equal(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// getKbsReferenceValuesConfigMapName returns the name of the reference values ConfigMap extended
+// with the ReferenceValues of the KbsConfig
+func getKbsReferenceValuesConfigMapName(kbsConfigName string) string {
+	return kbsConfigName + "-reference-values"
+}
+
+// deployOrUpdateReferenceValues writes the ReferenceValues served by the KbsConfig, merged over the reference
+// values of KbsRvpsRefValuesConfigMapName, to the RVPS reference values file. It returns the earliest time
+// one of the ReferenceValues expires, when the file must be written again without it
+func (r *KbsConfigReconciler) deployOrUpdateReferenceValues(ctx context.Context, now time.Time) (time.Time, error) {
+	rvList := &confidentialcontainersorgv1alpha1.ReferenceValueList{}
+	if err := r.List(ctx, rvList, client.InNamespace(r.namespace)); err != nil {
+		return time.Time{}, err
+	}
+	aggregated, nextExpiration := aggregateReferenceValues(rvList.Items, r.kbsConfig.Name, now)
+
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: getKbsReferenceValuesConfigMapName(r.kbsConfig.Name), Namespace: r.namespace}}
+	if len(aggregated) == 0 {
+		return nextExpiration, r.deleteOwnedObject(ctx, configMap)
+	}
+
+	referenceValues := make(map[string]json.RawMessage)
+	if name := r.kbsConfig.Spec.KbsRvpsRefValuesConfigMapName; name != "" {
+		base := &corev1.ConfigMap{}
+		if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: name}, base); err != nil {
+			return time.Time{}, err
+		}
+		if content := base.Data[referenceValueFilename]; strings.TrimSpace(content) != "" {
+			if err := json.Unmarshal([]byte(content), &referenceValues); err != nil {
+				return time.Time{}, fmt.Errorf("ConfigMap %s: %s is not a JSON object: %w", name, referenceValueFilename, err)
+			}
+		}
+	}
+	for name, entry := range aggregated {
+		raw, err := json.Marshal(entry)
+		if err != nil {
+			return time.Time{}, err
+		}
+		referenceValues[name] = raw
+	}
+	content, err := json.MarshalIndent(referenceValues, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if configMap.Labels == nil {
+			configMap.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, "reference-values") {
+			configMap.Labels[k] = v
+		}
+		configMap.Data = map[string]string{referenceValueFilename: string(content)}
+		return ctrl.SetControllerReference(r.kbsConfig, configMap, r.Scheme)
+	})
+	return nextExpiration, err
+}
+
+// getReferenceValuesConfigMapName returns the reference values ConfigMap mounted by RVPS, the one
+// extended with the ReferenceValues when it exists
+func (r *KbsConfigReconciler) getReferenceValuesConfigMapName(ctx context.Context) string {
+	name := getKbsReferenceValuesConfigMapName(r.kbsConfig.Name)
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: name}, &corev1.ConfigMap{}); err == nil {
+		return name
+	}
+	return r.kbsConfig.Spec.KbsRvpsRefValuesConfigMapName
+}
+
+// kbsConfigRequestsForReferenceValue returns the reconcile requests of the KbsConfigs serving the ReferenceValue
+func kbsConfigRequestsForReferenceValue(kbsConfigs []confidentialcontainersorgv1alpha1.KbsConfig, rv *confidentialcontainersorgv1alpha1.ReferenceValue) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, kbsConfig := range kbsConfigs {
+		if servesReferenceValue(kbsConfig.Name, rv) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: kbsConfig.Namespace, Name: kbsConfig.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,126 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// newTestReferenceValue returns a ReferenceValue with the given JSON values
+func newTestReferenceValue(name string, spec confidentialcontainersorgv1alpha1.ReferenceValueSpec, values ...string) *confidentialcontainersorgv1alpha1.ReferenceValue {
+	for _, value := range values {
+		spec.Values = append(spec.Values, apiextensionsv1.JSON{Raw: []byte(value)})
+	}
+	return &confidentialcontainersorgv1alpha1.ReferenceValue{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec:       spec,
+	}
+}
+
+func TestValidateReferenceValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		wantErr bool
+	}{
+		{name: "scalars", values: []string{`"abc"`, `1`, `true`}},
+		{name: "no value", wantErr: true},
+		{name: "object", values: []string{`{"a": 1}`}, wantErr: true},
+		{name: "null", values: []string{`null`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateReferenceValue(newTestReferenceValue("rv", confidentialcontainersorgv1alpha1.ReferenceValueSpec{}, tt.values...))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDeployOrUpdateReferenceValues(t *testing.T) {
+	now := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+	in := func(d time.Duration) metav1.Time { return metav1.NewTime(now.Add(d)) }
+
+	kbsConfig := newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{KbsRvpsRefValuesConfigMapName: "rvps-reference-values"})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "rvps-reference-values", Namespace: testNamespace},
+			Data:       map[string]string{referenceValueFilename: `{"svn": {"expiration": "2030-01-01T00:00:00Z", "value": 1}}`},
+		},
+		newTestReferenceValue("measurement-a", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Name: "snp_launch_measurement", Expiration: in(48 * time.Hour)}, `"aaa"`),
+		newTestReferenceValue("measurement-b", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Name: "snp_launch_measurement", Expiration: in(24 * time.Hour)}, `"bbb"`, `"aaa"`),
+		newTestReferenceValue("svn", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Expiration: in(72 * time.Hour)}, `2`),
+		newTestReferenceValue("expired", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Expiration: in(-time.Hour)}, `"old"`),
+		newTestReferenceValue("invalid", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Expiration: in(time.Hour)}, `{"a": 1}`),
+		newTestReferenceValue("other", confidentialcontainersorgv1alpha1.ReferenceValueSpec{KbsConfigName: "other-kbs", Expiration: in(time.Hour)}, `"other"`),
+	)
+	ctx := context.Background()
+
+	nextExpiration, err := r.deployOrUpdateReferenceValues(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nextExpiration.Equal(now.Add(24 * time.Hour)) {
+		t.Errorf("expected the next expiration in 24h, got %v", nextExpiration)
+	}
+	if name := r.getReferenceValuesConfigMapName(ctx); name != "kbs-reference-values" {
+		t.Fatalf("expected RVPS to mount the aggregated reference values, got %s", name)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: "kbs-reference-values"}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	referenceValues := map[string]rvpsReferenceValue{}
+	if err := json.Unmarshal([]byte(configMap.Data[referenceValueFilename]), &referenceValues); err != nil {
+		t.Fatal(err)
+	}
+	if len(referenceValues) != 2 {
+		t.Errorf("expected the expired, invalid and foreign values to be skipped, got %v", referenceValues)
+	}
+	measurement := referenceValues["snp_launch_measurement"]
+	if measurement.Expiration != "2026-10-18T00:00:00Z" || len(measurement.Value) != 2 ||
+		string(measurement.Value[0]) != `"aaa"` || string(measurement.Value[1]) != `"bbb"` {
+		t.Errorf("unexpected aggregated measurement %+v", measurement)
+	}
+	// the ReferenceValue overrides the value of the base ConfigMap
+	if svn := referenceValues["svn"]; len(svn.Value) != 1 || string(svn.Value[0]) != "2" {
+		t.Errorf("unexpected svn %+v", svn)
+	}
+
+	// the ConfigMap is removed once every ReferenceValue expired
+	if _, err := r.deployOrUpdateReferenceValues(ctx, now.Add(96*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(configMap), &corev1.ConfigMap{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the aggregated reference values to be deleted, got %v", err)
+	}
+	if name := r.getReferenceValuesConfigMapName(ctx); name != "rvps-reference-values" {
+		t.Errorf("expected RVPS to mount the original reference values, got %s", name)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// ReferenceValueReconciler validates the ReferenceValues and reports the expired ones,
+// the values are aggregated by the KbsConfig controller
+type ReferenceValueReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	log    logr.Logger
+}
+
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=referencevalues,verbs=get;list;watch
+//+kubebuilder:rbac:groups=confidentialcontainers.org,resources=referencevalues/status,verbs=get;update;patch
+
+// Reconcile updates the status of a ReferenceValue, and requeues it to report its expiration
+func (r *ReferenceValueReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	r.log = log.FromContext(ctx)
+
+	rv := &confidentialcontainersorgv1alpha1.ReferenceValue{}
+	if err := r.Get(ctx, req.NamespacedName, rv); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		r.log.Error(err, "Failed to get ReferenceValue")
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	status, err := r.referenceValueStatus(ctx, rv, now)
+	if err != nil {
+		r.log.Error(err, "Failed to compute ReferenceValue status")
+		return ctrl.Result{}, err
+	}
+	if !apiequality.Semantic.DeepEqual(rv.Status, *status) {
+		rv.Status = *status
+		if err := r.Status().Update(ctx, rv); err != nil {
+			r.log.Error(err, "Failed to update ReferenceValue status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !status.Expired {
+		return ctrl.Result{RequeueAfter: rv.Spec.Expiration.Sub(now) + time.Second}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// referenceValueStatus returns the status of a ReferenceValue: its name, whether it expired
+// and the KbsConfigs serving it
+func (r *ReferenceValueReconciler) referenceValueStatus(ctx context.Context, rv *confidentialcontainersorgv1alpha1.ReferenceValue, now time.Time) (*confidentialcontainersorgv1alpha1.ReferenceValueStatus, error) {
+	status := rv.Status.DeepCopy()
+	status.ObservedGeneration = rv.Generation
+	status.Name = referenceValueName(rv)
+	status.Expired = referenceValueExpired(rv, now)
+
+	kbsConfigList := &confidentialcontainersorgv1alpha1.KbsConfigList{}
+	if err := r.List(ctx, kbsConfigList, client.InNamespace(rv.Namespace)); err != nil {
+		return nil, err
+	}
+
+	condition := metav1.Condition{
+		Type:               confidentialcontainersorgv1alpha1.ReferenceValueConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: rv.Generation,
+	}
+	status.KbsConfigs = nil
+	if err := validateReferenceValue(rv); err != nil {
+		condition.Reason, condition.Message = reasonInvalidReferenceValue, err.Error()
+	} else if status.Expired {
+		condition.Reason = reasonReferenceValueExpired
+		condition.Message = fmt.Sprintf("Reference value %s expired at %s, its values are no longer served",
+			status.Name, rv.Spec.Expiration.UTC().Format(time.RFC3339))
+	} else {
+		for _, kbsConfig := range kbsConfigList.Items {
+			if servesReferenceValue(kbsConfig.Name, rv) {
+				status.KbsConfigs = append(status.KbsConfigs, kbsConfig.Name)
+			}
+		}
+		sort.Strings(status.KbsConfigs)
+		if len(status.KbsConfigs) == 0 {
+			condition.Reason = reasonNoKbsConfig
+			if rv.Spec.KbsConfigName != "" {
+				condition.Message = fmt.Sprintf("KbsConfig %s not found in namespace %s", rv.Spec.KbsConfigName, rv.Namespace)
+			} else {
+				condition.Message = fmt.Sprintf("No KbsConfig in namespace %s", rv.Namespace)
+			}
+		} else {
+			condition.Status, condition.Reason = metav1.ConditionTrue, reasonReferenceValueServed
+			condition.Message = fmt.Sprintf("Reference value %s served until %s", status.Name, rv.Spec.Expiration.UTC().Format(time.RFC3339))
+		}
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	return status, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ReferenceValueReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&confidentialcontainersorgv1alpha1.ReferenceValue{}).
+		// A new or deleted KbsConfig changes where the values are served
+		Watches(
+			&confidentialcontainersorgv1alpha1.KbsConfig{},
+			handler.EnqueueRequestsFromMapFunc(namespaceToReferenceValueMapper(mgr.GetClient())),
+		).
+		Complete(r)
+}
+
+// namespaceToReferenceValueMapper maps an object to all the ReferenceValues of its namespace
+func namespaceToReferenceValueMapper(c client.Client) handler.MapFunc {
+	return func(ctx context.Context, o client.Object) []reconcile.Request {
+		rvList := &confidentialcontainersorgv1alpha1.ReferenceValueList{}
+		if err := c.List(ctx, rvList, client.InNamespace(o.GetNamespace())); err != nil {
+			ctrl.Log.WithName("referencevalue-controller").Info("Error in listing ReferenceValue", "err", err)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0, len(rvList.Items))
+		for _, rv := range rvList.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: rv.Namespace, Name: rv.Name},
+			})
+		}
+		return requests
+	}
+}
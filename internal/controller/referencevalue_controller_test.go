@@ -0,0 +1,91 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestReferenceValueReconcile(t *testing.T) {
+	future := metav1.NewTime(time.Now().Add(time.Hour))
+	scheme := newTestScheme(t)
+	objs := []client.Object{
+		newTestKbsConfig("kbs", confidentialcontainersorgv1alpha1.KbsConfigSpec{}),
+		newTestReferenceValue("served", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Name: "svn", Expiration: future}, `1`),
+		newTestReferenceValue("expired", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Expiration: metav1.NewTime(time.Now().Add(-time.Hour))}, `1`),
+		newTestReferenceValue("invalid", confidentialcontainersorgv1alpha1.ReferenceValueSpec{Expiration: future}, `null`),
+		newTestReferenceValue("other", confidentialcontainersorgv1alpha1.ReferenceValueSpec{KbsConfigName: "absent", Expiration: future}, `1`),
+	}
+	r := &ReferenceValueReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+			WithStatusSubresource(&confidentialcontainersorgv1alpha1.ReferenceValue{}).Build(),
+		Scheme: scheme,
+		log:    logr.Discard(),
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		rvName  string
+		expired bool
+		reason  string
+		requeue bool
+	}{
+		{name: "served", rvName: "svn", reason: reasonReferenceValueServed, requeue: true},
+		{name: "expired", rvName: "expired", expired: true, reason: reasonReferenceValueExpired},
+		{name: "invalid", rvName: "invalid", reason: reasonInvalidReferenceValue, requeue: true},
+		{name: "other", rvName: "other", reason: reasonNoKbsConfig, requeue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := types.NamespacedName{Namespace: testNamespace, Name: tt.name}
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (result.RequeueAfter > 0) != tt.requeue {
+				t.Errorf("expected requeue %v, got %v", tt.requeue, result.RequeueAfter)
+			}
+			rv := &confidentialcontainersorgv1alpha1.ReferenceValue{}
+			if err := r.Get(ctx, key, rv); err != nil {
+				t.Fatal(err)
+			}
+			if rv.Status.Name != tt.rvName || rv.Status.Expired != tt.expired {
+				t.Errorf("unexpected status %+v", rv.Status)
+			}
+			condition := meta.FindStatusCondition(rv.Status.Conditions, confidentialcontainersorgv1alpha1.ReferenceValueConditionReady)
+			if condition == nil || condition.Reason != tt.reason {
+				t.Fatalf("expected a Ready condition with reason %s, got %+v", tt.reason, condition)
+			}
+			if (condition.Status == metav1.ConditionTrue) != (tt.reason == reasonReferenceValueServed) {
+				t.Errorf("unexpected Ready status %s", condition.Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Annotations of a secret resource overriding the path of its keys
+	secretResourceRepositoryAnnotation = "kbs.confidentialcontainers.org/repository"
+	secretResourceTypeAnnotation       = "kbs.confidentialcontainers.org/type"
+	// Comma separated key=tag pairs
+	secretResourceTagsAnnotation = "kbs.confidentialcontainers.org/tags"
+
+	// Flag of the secret-converter taking the custom paths of the secret resources
+	SecretResourcePathsFlag = "secret-resource-paths"
+	// Flag of the secret-converter taking the secret resources that must be mounted with at least one key
+	SecretResourcesFlag = "secret-resources"
+	// Flag of the secret-converter taking the secret resources that did not exist when the pods were created.
+	// Their volumes are optional and mounted empty, the flag tells them apart from the secrets without keys
+	MissingSecretResourcesFlag = "missing-secret-resources"
+
+	// Name of the volume holding the resources synced by the secret-converter sidecar
+	sidecarResourcesVolume = "sidecar-resources"
+	// SidecarResourcesIndexKey is the key of the sidecar resources secret describing its other keys
+	SidecarResourcesIndexKey = "index.json"
+)
+
+// resourcePathElement matches the repository, type and tag of a KBS resource path, as in the KbsResource CRD
+var resourcePathElement = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+
+// SecretResourcePath is the path the secret-converter publishes the keys of a secret under:
+// <repository>/<type>/<tag>, "default", the secret name and the key by default
+type SecretResourcePath struct {
+	Repository string            `json:"repository,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// isDefault returns true when the keys are published under default/<secret>/<key>
+func (p SecretResourcePath) isDefault() bool {
+	return p.Repository == "" && p.Type == "" && len(p.Tags) == 0
+}
+
+// Resource returns the repository, type and tag the key of the secret is published under
+func (p SecretResourcePath) Resource(secretName, key string) (string, string, string) {
+	repository, resourceType, tag := p.Repository, p.Type, key
+	if repository == "" {
+		repository = defaultKbsResourceRepository
+	}
+	if resourceType == "" {
+		resourceType = secretName
+	}
+	if p.Tags[key] != "" {
+		tag = p.Tags[key]
+	}
+	return repository, resourceType, tag
+}
+
+// SidecarResourcesIndex describes the keys of the secret gathering the resources synced by the
+// secret-converter sidecar. The secret is mounted as a whole, adding or removing a resource only
+// updates the mounted files and not the pod template
+type SidecarResourcesIndex struct {
+	// Secrets are the secret resources, false for the ones that do not exist
+	Secrets map[string]bool `json:"secrets,omitempty"`
+	// Resources maps the other keys of the secret to the resource they are published as
+	Resources map[string]SidecarResource `json:"resources,omitempty"`
+}
+
+// SidecarResource is a resource of the sidecar resources secret
+type SidecarResource struct {
+	Repository string `json:"repository"`
+	Type       string `json:"type"`
+	Tag        string `json:"tag"`
+	// Secret is the secret resource the key comes from, empty for a KbsResource
+	Secret string `json:"secret,omitempty"`
+}
+
+// secretResourceNames returns the secrets of KbsSecretResources and KbsSecretResourceEntries
+func secretResourceNames(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) []string {
+	names := append([]string{}, spec.KbsSecretResources...)
+	for _, entry := range spec.KbsSecretResourceEntries {
+		names = append(names, entry.SecretName)
+	}
+	return names
+}
+
+// getSecretResourcePaths returns the custom paths of the secret resources, set by the annotations of the
+// secrets and overridden by KbsSecretResourceEntries, and the secret resources that do not exist. Secrets
+// published under the default path are omitted
+func (r *KbsConfigReconciler) getSecretResourcePaths(ctx context.Context) (map[string]SecretResourcePath, []string, error) {
+	entries := map[string]confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{}
+	for _, entry := range r.kbsConfig.Spec.KbsSecretResourceEntries {
+		entries[entry.SecretName] = entry
+	}
+
+	paths := map[string]SecretResourcePath{}
+	var missing []string
+	for _, secretName := range secretResourceNames(r.kbsConfig.Spec) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: secretName}, secret)
+		if k8serrors.IsNotFound(err) {
+			// The secret-converter reports it
+			missing = append(missing, secretName)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		path, err := secretResourcePath(secret, entries)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !path.isDefault() {
+			paths[secretName] = path
+		}
+	}
+	return paths, missing, nil
+}
+
+// secretResourcePath returns the path of a secret resource, set by its annotations and overridden by its
+// KbsSecretResourceEntry if any
+func secretResourcePath(secret *corev1.Secret, entries map[string]confidentialcontainersorgv1alpha1.KbsSecretResourceEntry) (SecretResourcePath, error) {
+	path, err := secretResourcePathFromAnnotations(secret)
+	if err != nil {
+		return path, fmt.Errorf("secret %s: %w", secret.Name, err)
+	}
+	if entry, ok := entries[secret.Name]; ok {
+		if entry.Repository != "" {
+			path.Repository = entry.Repository
+		}
+		if entry.Type != "" {
+			path.Type = entry.Type
+		}
+		for _, key := range entry.Keys {
+			if path.Tags == nil {
+				path.Tags = map[string]string{}
+			}
+			path.Tags[key.Key] = key.Tag
+		}
+	}
+	return path, nil
+}
+
+// getSidecarResourcesSecretName returns the name of the secret gathering the resources synced by the
+// secret-converter sidecar
+func getSidecarResourcesSecretName(kbsConfigName string) string {
+	return kbsConfigName + "-sidecar-resources"
+}
+
+// deployOrUpdateSidecarResources gathers the keys of the secret resources and the KbsResource contents
+// in the secret mounted by the secret-converter sidecar, along with the index of their paths. The missing
+// secret resources are listed in the index, the sidecar reports them
+func (r *KbsConfigReconciler) deployOrUpdateSidecarResources(ctx context.Context, served []servedKbsResource) error {
+	entries := map[string]confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{}
+	for _, entry := range r.kbsConfig.Spec.KbsSecretResourceEntries {
+		entries[entry.SecretName] = entry
+	}
+
+	index := SidecarResourcesIndex{Secrets: map[string]bool{}, Resources: map[string]SidecarResource{}}
+	data := map[string][]byte{}
+	add := func(resource SidecarResource, content []byte) {
+		key := fmt.Sprintf("resource-%d", len(index.Resources))
+		index.Resources[key] = resource
+		data[key] = content
+	}
+	for _, secretName := range secretResourceNames(r.kbsConfig.Spec) {
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: secretName}, secret)
+		if k8serrors.IsNotFound(err) {
+			index.Secrets[secretName] = false
+			continue
+		} else if err != nil {
+			return err
+		}
+		index.Secrets[secretName] = true
+		path, err := secretResourcePath(secret, entries)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			repository, resourceType, tag := path.Resource(secretName, key)
+			add(SidecarResource{Repository: repository, Type: resourceType, Tag: tag, Secret: secretName}, secret.Data[key])
+		}
+	}
+	for _, s := range served {
+		repository := s.resource.Spec.Repository
+		if repository == "" {
+			repository = defaultKbsResourceRepository
+		}
+		add(SidecarResource{Repository: repository, Type: s.resource.Spec.Type, Tag: s.resource.Spec.Tag}, s.content)
+	}
+
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	data[SidecarResourcesIndexKey] = indexJSON
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: getSidecarResourcesSecretName(r.kbsConfig.Name), Namespace: r.namespace}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Labels == nil {
+			secret.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.kbsConfig.Name, "sidecar-resources") {
+			secret.Labels[k] = v
+		}
+		secret.Type = corev1.SecretTypeOpaque
+		secret.Data = data
+		return ctrl.SetControllerReference(r.kbsConfig, secret, r.Scheme)
+	})
+	return err
+}
+
+// createSidecarResourcesVolume returns the volume of the sidecar resources secret. It is the same whatever
+// the resources, and optional so that the pods start before the secret is created
+func createSidecarResourcesVolume(kbsConfigName string) corev1.Volume {
+	return corev1.Volume{
+		Name: sidecarResourcesVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: getSidecarResourcesSecretName(kbsConfigName),
+				Optional:   pointer(true),
+			},
+		},
+	}
+}
+
+// secretResourcePathFromAnnotations reads the path of a secret resource from its annotations
+func secretResourcePathFromAnnotations(secret *corev1.Secret) (SecretResourcePath, error) {
+	path := SecretResourcePath{
+		Repository: secret.Annotations[secretResourceRepositoryAnnotation],
+		Type:       secret.Annotations[secretResourceTypeAnnotation],
+	}
+	for annotation, value := range map[string]string{
+		secretResourceRepositoryAnnotation: path.Repository,
+		secretResourceTypeAnnotation:       path.Type,
+	} {
+		if value != "" && !resourcePathElement.MatchString(value) {
+			return path, fmt.Errorf("invalid %s annotation %q", annotation, value)
+		}
+	}
+
+	tags := strings.TrimSpace(secret.Annotations[secretResourceTagsAnnotation])
+	if tags == "" {
+		return path, nil
+	}
+	path.Tags = map[string]string{}
+	for _, pair := range strings.Split(tags, ",") {
+		key, tag, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" || !resourcePathElement.MatchString(tag) {
+			return path, fmt.Errorf("invalid %s annotation entry %q, expected key=tag", secretResourceTagsAnnotation, pair)
+		}
+		path.Tags[key] = tag
+	}
+	return path, nil
+}
+
+// secretResourcePathsArg returns the secret-converter argument with the custom paths of the secret resources
+func secretResourcePathsArg(paths map[string]SecretResourcePath) (string, error) {
+	// Maps are marshalled with sorted keys, the argument is stable across reconciles
+	content, err := json.Marshal(paths)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("--%s=%s", SecretResourcePathsFlag, content), nil
+}
+
+// IsValidResourcePathElement returns true when s can be the repository, type or tag of a KBS resource path
+func IsValidResourcePathElement(s string) bool {
+	return resourcePathElement.MatchString(s)
+}
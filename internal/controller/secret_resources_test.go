@@ -0,0 +1,161 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestGetSecretResourcePaths(t *testing.T) {
+	ctx := context.Background()
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsSecretResources: []string{"plain", "annotated", "absent"},
+		KbsSecretResourceEntries: []confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{{
+			SecretName: "entry",
+			Type:       "certs",
+			Keys:       []confidentialcontainersorgv1alpha1.KbsSecretResourceKey{{Key: "tls.crt", Tag: "server"}},
+		}},
+	})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: testNamespace}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "annotated", Namespace: testNamespace, Annotations: map[string]string{
+			secretResourceRepositoryAnnotation: "my-app",
+			secretResourceTagsAnnotation:       "workload.key=workload, backup.key=backup",
+		}}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "entry", Namespace: testNamespace, Annotations: map[string]string{
+			secretResourceRepositoryAnnotation: "tenant-a",
+			secretResourceTypeAnnotation:       "ignored",
+		}}})
+
+	paths, missing, err := r.getSecretResourcePaths(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]SecretResourcePath{
+		"annotated": {Repository: "my-app", Tags: map[string]string{"workload.key": "workload", "backup.key": "backup"}},
+		// The fields of the entry take precedence over the annotations
+		"entry": {Repository: "tenant-a", Type: "certs", Tags: map[string]string{"tls.crt": "server"}},
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected %+v, got %+v", want, paths)
+	}
+	// A missing secret does not fail the reconcile, the secret-converter reports it
+	if !reflect.DeepEqual(missing, []string{"absent"}) {
+		t.Errorf("expected the missing secret resources [absent], got %v", missing)
+	}
+	for _, volume := range r.createKbsSecretResourcesVolume() {
+		if volume.Secret == nil || volume.Secret.Optional == nil || !*volume.Secret.Optional {
+			t.Errorf("expected an optional secret volume, got %+v", volume)
+		}
+	}
+
+	arg, err := secretResourcePathsArg(paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(arg, "--"+SecretResourcePathsFlag+"=") || !strings.Contains(arg, `"tls.crt":"server"`) {
+		t.Errorf("unexpected secret-converter argument %s", arg)
+	}
+}
+
+func TestSecretResourcePathFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{"no annotations", nil, false},
+		{"valid", map[string]string{secretResourceTypeAnnotation: "keys", secretResourceTagsAnnotation: "a=b"}, false},
+		{"path traversal", map[string]string{secretResourceRepositoryAnnotation: "../etc"}, true},
+		{"tag without key", map[string]string{secretResourceTagsAnnotation: "=tag"}, true},
+		{"invalid tag", map[string]string{secretResourceTagsAnnotation: "key=a/b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			if _, err := secretResourcePathFromAnnotations(secret); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDeployOrUpdateSidecarResources(t *testing.T) {
+	ctx := context.Background()
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsSecretResources: []string{"keys", "absent"},
+		KbsSecretResourceEntries: []confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{{
+			SecretName: "certs",
+			Repository: "my-app",
+			Keys:       []confidentialcontainersorgv1alpha1.KbsSecretResourceKey{{Key: "tls.crt", Tag: "server"}},
+		}},
+		KbsSecretConverterMode: confidentialcontainersorgv1alpha1.SecretConverterSidecar,
+	})
+	r := newTestExposureReconciler(t, kbsConfig,
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: testNamespace}, Data: map[string][]byte{"key1": []byte("value1")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "certs", Namespace: testNamespace}, Data: map[string][]byte{"tls.crt": []byte("cert")}},
+		newTestKbsResource("greeting", time.Now(), inlineKbsResourceSpec("", "sample", "greeting", "hello")))
+
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: getSidecarResourcesSecretName("tenant-a")}, secret); err != nil {
+		t.Fatal(err)
+	}
+	var index SidecarResourcesIndex
+	if err := json.Unmarshal(secret.Data[SidecarResourcesIndexKey], &index); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"keys": true, "absent": false, "certs": true}; !reflect.DeepEqual(index.Secrets, want) {
+		t.Errorf("expected the secrets %v, got %v", want, index.Secrets)
+	}
+	got := map[string]string{}
+	for key, resource := range index.Resources {
+		got[resource.Repository+"/"+resource.Type+"/"+resource.Tag] = string(secret.Data[key])
+	}
+	want := map[string]string{"default/keys/key1": "value1", "my-app/certs/server": "cert", "default/sample/greeting": "hello"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the resources %v, got %v", want, got)
+	}
+	// The KbsResource contents are only gathered in the sidecar resources secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: getKbsResourcesSecretName("tenant-a")}, &corev1.Secret{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected no KbsResources secret in sidecar mode, got %v", err)
+	}
+
+	// Back to the init container, the sidecar resources secret is removed
+	kbsConfig.Spec.KbsSecretConverterMode = confidentialcontainersorgv1alpha1.SecretConverterInitContainer
+	kbsConfig.Spec.KbsSecretResources = []string{"keys"}
+	if err := r.deployOrUpdateKbsResources(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(secret), &corev1.Secret{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expected the sidecar resources secret to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Component label of the PostgreSQL instance managed by the operator
+	postgresComponent = "postgres"
+
+	// Default PostgreSQL image name
+	DefaultPostgresImageName = "docker.io/library/postgres:17-alpine"
+
+	// Port, database and user of the PostgreSQL instance managed by the operator
+	postgresPort            = 5432
+	defaultPostgresDatabase = "kbs"
+	managedPostgresUser     = "kbs"
+
+	// Keys of the PostgreSQL credentials Secret
+	postgresUsernameKey = "username"
+	postgresPasswordKey = "password"
+
+	// Volume and mount path of the data of the PostgreSQL instance managed by the operator
+	postgresDataVolume = "postgres-data"
+	postgresDataPath   = "/var/lib/postgresql/data"
+
+	// storage_backend.storage_type of the KBS configuration
+	kbsStorageTypePostgres = "Postgres"
+
+	// The resource plugin of the KBS configuration and the type of its local repository
+	kbsResourcePluginName    = "resource"
+	kbsRepositoryTypeLocalFs = "LocalFs"
+
+	// Flag of the secret-converter setting the layout of the files it writes to the repository: flat
+	// files with escaped slashes read through the KBS storage backend, or <repository>/<type>/<tag>
+	// directories read by the LocalFs repository of the resource plugin
+	RepositoryLayoutFlag   = "repository-layout"
+	RepositoryLayoutFlat   = "flat"
+	RepositoryLayoutNested = "nested"
+
+	// Reason used in the KbsConfig conditions
+	reasonSharedStorageFailed = "SharedStorageReconcileFailed"
+)
+
+// postgresConnection holds the settings KBS connects to the shared database with
+type postgresConnection struct {
+	host     string
+	port     int32
+	database string
+	username string
+	password string
+}
+
+// sharedStorageType returns the shared storage of the KbsConfig, or an empty string
+func sharedStorageType(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) confidentialcontainersorgv1alpha1.SharedStorageType {
+	if spec.KbsSharedStorageSpec == nil {
+		return ""
+	}
+	return spec.KbsSharedStorageSpec.Type
+}
+
+// managedPostgresName returns the name of the Deployment, Service and credentials Secret of the PostgreSQL
+// instance managed for a KbsConfig
+func managedPostgresName(kbsConfigName string) string {
+	return kbsConfigName + "-postgres"
+}
+
+// managedPostgresStorage returns the storage directory of the PostgreSQL instance managed by the operator, if any
+func managedPostgresStorage(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) []kbsStorageDir {
+	if sharedStorageType(spec) != confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres ||
+		spec.KbsSharedStorageSpec.ManagedPostgres == nil {
+		return nil
+	}
+	return []kbsStorageDir{{postgresDataVolume, "sharedStorage.managedPostgres.storage", spec.KbsSharedStorageSpec.ManagedPostgres.Storage}}
+}
+
+// generatePostgresPassword returns a random password for the PostgreSQL instance managed by the operator
+func generatePostgresPassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// deployOrUpdateSharedStorage creates or updates the PostgreSQL instance when the KbsConfig asks for a managed
+// shared storage, and deletes it otherwise. The managed PersistentVolumeClaim is left to the garbage collector
+// like the ones of the KBS storage directories
+func (r *KbsConfigReconciler) deployOrUpdateSharedStorage(ctx context.Context) error {
+	name := managedPostgresName(r.kbsConfig.Name)
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: r.namespace}}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: r.namespace}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: r.namespace}}
+
+	if sharedStorageType(r.kbsConfig.Spec) != confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres {
+		for _, obj := range []client.Object{service, deployment, secret} {
+			if err := r.deleteOwnedObject(ctx, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	labels := standardLabels(r.kbsConfig.Name, postgresComponent)
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = labels
+		secret.Type = corev1.SecretTypeOpaque
+		// The password is generated once and kept afterwards
+		if len(secret.Data[postgresPasswordKey]) == 0 {
+			password, err := generatePostgresPassword()
+			if err != nil {
+				return err
+			}
+			secret.Data = map[string][]byte{postgresPasswordKey: []byte(password)}
+		}
+		secret.Data[postgresUsernameKey] = []byte(managedPostgresUser)
+		return ctrl.SetControllerReference(r.kbsConfig, secret, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("postgres credentials: %w", err)
+	}
+
+	desired, err := r.newManagedPostgresDeployment()
+	if err != nil {
+		return err
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		deployment.Labels = desired.Labels
+		deployment.Spec.Replicas = desired.Spec.Replicas
+		deployment.Spec.Selector = desired.Spec.Selector
+		deployment.Spec.Strategy = desired.Spec.Strategy
+		deployment.Spec.Template = desired.Spec.Template
+		return ctrl.SetControllerReference(r.kbsConfig, deployment, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("postgres deployment: %w", err)
+	}
+
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, service, func() error {
+		service.Labels = labels
+		service.Spec.Type = corev1.ServiceTypeClusterIP
+		service.Spec.Selector = labels
+		service.Spec.Ports = []corev1.ServicePort{{
+			Name:       postgresComponent,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       postgresPort,
+			TargetPort: intstr.FromInt32(postgresPort),
+		}}
+		return ctrl.SetControllerReference(r.kbsConfig, service, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("postgres service: %w", err)
+	}
+	return nil
+}
+
+// newManagedPostgresDeployment returns the single replica Deployment of the PostgreSQL instance managed by the operator
+func (r *KbsConfigReconciler) newManagedPostgresDeployment() (*appsv1.Deployment, error) {
+	name := managedPostgresName(r.kbsConfig.Name)
+	labels := standardLabels(r.kbsConfig.Name, postgresComponent)
+	managed := r.kbsConfig.Spec.KbsSharedStorageSpec.ManagedPostgres
+	if managed == nil {
+		managed = &confidentialcontainersorgv1alpha1.ManagedPostgresSpec{}
+	}
+
+	dataVolume, err := r.createStorageVolume(postgresDataVolume, managed.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if dataVolume.EmptyDir != nil {
+		// The database does not fit in memory
+		dataVolume.EmptyDir.Medium = corev1.StorageMediumDefault
+	}
+
+	imageName := os.Getenv("POSTGRES_IMAGE_NAME")
+	if imageName == "" {
+		imageName = DefaultPostgresImageName
+	}
+	credential := func(key string) *corev1.EnvVarSource {
+		return &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+		}}
+	}
+	securityContext := createSecurityContext()
+	securityContext.RunAsNonRoot = pointer(true)
+	securityContext.RunAsUser = pointer(int64(999))
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: r.namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer(int32(1)),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			// Two instances must never run on the same data
+			Strategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					SecurityContext: &corev1.PodSecurityContext{FSGroup: pointer(int64(999))},
+					Containers: []corev1.Container{{
+						Name:            postgresComponent,
+						Image:           imageName,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Ports: []corev1.ContainerPort{{
+							Name:          postgresComponent,
+							ContainerPort: postgresPort,
+							Protocol:      corev1.ProtocolTCP,
+						}},
+						Env: []corev1.EnvVar{
+							{Name: "POSTGRES_DB", Value: defaultPostgresDatabase},
+							{Name: "POSTGRES_USER", ValueFrom: credential(postgresUsernameKey)},
+							{Name: "POSTGRES_PASSWORD", ValueFrom: credential(postgresPasswordKey)},
+							{Name: "PGDATA", Value: postgresDataPath + "/pgdata"},
+						},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								Exec: &corev1.ExecAction{Command: []string{"pg_isready", "-U", managedPostgresUser, "-d", defaultPostgresDatabase}},
+							},
+							PeriodSeconds: 10,
+						},
+						Resources:       managed.Resources,
+						SecurityContext: securityContext,
+						VolumeMounts:    []corev1.VolumeMount{{Name: postgresDataVolume, MountPath: postgresDataPath}},
+					}},
+					Volumes: []corev1.Volume{*dataVolume},
+				},
+			},
+		},
+	}
+	return deployment, nil
+}
+
+// sharedStorageConnection returns the connection to the shared database of the KbsConfig, or nil when the
+// KBS replicas don't share a storage backend
+func (r *KbsConfigReconciler) sharedStorageConnection(ctx context.Context) (*postgresConnection, error) {
+	spec := r.kbsConfig.Spec.KbsSharedStorageSpec
+	conn := &postgresConnection{port: postgresPort, database: defaultPostgresDatabase}
+	var credentialsSecretName string
+	switch sharedStorageType(r.kbsConfig.Spec) {
+	case "":
+		return nil, nil
+	case confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres:
+		credentialsSecretName = managedPostgresName(r.kbsConfig.Name)
+		conn.host = fmt.Sprintf("%s.%s.svc", credentialsSecretName, r.namespace)
+	case confidentialcontainersorgv1alpha1.SharedStoragePostgres:
+		if spec.Postgres == nil {
+			return nil, fmt.Errorf("sharedStorage.postgres is required when type is %s", spec.Type)
+		}
+		credentialsSecretName = spec.Postgres.CredentialsSecretName
+		conn.host = spec.Postgres.Host
+		if spec.Postgres.Port != 0 {
+			conn.port = spec.Postgres.Port
+		}
+		if spec.Postgres.Database != "" {
+			conn.database = spec.Postgres.Database
+		}
+	default:
+		return nil, fmt.Errorf("unsupported shared storage type %q", spec.Type)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: r.namespace, Name: credentialsSecretName}, secret); err != nil {
+		return nil, fmt.Errorf("postgres credentials: %w", err)
+	}
+	for key, value := range map[string]*string{postgresUsernameKey: &conn.username, postgresPasswordKey: &conn.password} {
+		data, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("postgres credentials: Secret %s has no key %s", credentialsSecretName, key)
+		}
+		*value = strings.TrimSpace(string(data))
+	}
+	return conn, nil
+}
+
+// setPostgresStorageBackend points the storage backend of a KBS configuration at the shared database.
+// The resource plugin keeps serving the repository written by the secret-converter in the pod
+func setPostgresStorageBackend(doc *tomlDocument, conn *postgresConnection) error {
+	settings := []struct {
+		key   string
+		value interface{}
+	}{
+		{"db", conn.database},
+		{"username", conn.username},
+		{"password", conn.password},
+		{"host", conn.host},
+		{"port", int64(conn.port)},
+	}
+	if err := doc.set([]string{"storage_backend", "storage_type"}, kbsStorageTypePostgres); err != nil {
+		return err
+	}
+	for _, setting := range settings {
+		if err := doc.set([]string{"storage_backend", "backends", "postgres", setting.key}, setting.value); err != nil {
+			return err
+		}
+	}
+	return setLocalResourcePlugin(doc)
+}
+
+// setLocalResourcePlugin moves the resource plugin from the KBS storage backend to the LocalFs repository
+// of the directory the secret-converter writes to
+func setLocalResourcePlugin(doc *tomlDocument) error {
+	tree, err := decodeTomlTree(doc.String())
+	if err != nil {
+		return err
+	}
+	plugins, ok := tree["plugins"].([]interface{})
+	if !ok {
+		return nil
+	}
+	found := false
+	for _, p := range plugins {
+		plugin, ok := p.(map[string]interface{})
+		if !ok || plugin["name"] != kbsResourcePluginName {
+			continue
+		}
+		delete(plugin, "storage_backend_type")
+		plugin["type"] = kbsRepositoryTypeLocalFs
+		plugin["dir_path"] = RepositoryPath
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return doc.setArrayTables([]string{"plugins"}, plugins)
+}
+
+// repositoryLayout returns the layout of the repository written by the secret-converter
+func repositoryLayout(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) string {
+	if sharedStorageType(spec) != "" {
+		return RepositoryLayoutNested
+	}
+	return RepositoryLayoutFlat
+}
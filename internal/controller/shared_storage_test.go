@@ -0,0 +1,237 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"text/template"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestDeployOrUpdateManagedPostgres(t *testing.T) {
+	ctx := context.Background()
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsSharedStorageSpec: &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+			Type: confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres,
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig)
+
+	if err := r.deployOrUpdateSharedStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	key := client.ObjectKey{Namespace: testNamespace, Name: managedPostgresName("tenant-a")}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		t.Fatal(err)
+	}
+	password := string(secret.Data[postgresPasswordKey])
+	if password == "" || string(secret.Data[postgresUsernameKey]) != managedPostgresUser {
+		t.Errorf("expected generated credentials, got %v", secret.Data)
+	}
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, key, deployment); err != nil {
+		t.Fatal(err)
+	}
+	if deployment.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType ||
+		deployment.Spec.Template.Spec.Containers[0].Image != DefaultPostgresImageName {
+		t.Errorf("expected a single postgres instance recreated on updates, got %+v", deployment.Spec)
+	}
+	if volume := deployment.Spec.Template.Spec.Volumes[0]; volume.EmptyDir == nil || volume.EmptyDir.Medium != corev1.StorageMediumDefault {
+		t.Errorf("expected the data on a disk backed emptyDir, got %+v", volume.VolumeSource)
+	}
+	service := &corev1.Service{}
+	if err := r.Get(ctx, key, service); err != nil {
+		t.Fatal(err)
+	}
+	if service.Spec.Ports[0].Port != postgresPort {
+		t.Errorf("expected the postgres port, got %+v", service.Spec.Ports)
+	}
+
+	// The password is kept across reconciles and KBS connects with it to the service
+	if err := r.deployOrUpdateSharedStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := r.sharedStorageConnection(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.password != password || conn.host != "tenant-a-postgres."+testNamespace+".svc" || conn.database != defaultPostgresDatabase {
+		t.Errorf("expected the connection to the managed instance, got %+v", conn)
+	}
+
+	// The instance is deleted with the shared storage
+	kbsConfig.Spec.KbsSharedStorageSpec = nil
+	if err := r.deployOrUpdateSharedStorage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []client.Object{&corev1.Secret{}, &appsv1.Deployment{}, &corev1.Service{}} {
+		if err := r.Get(ctx, key, obj); !k8serrors.IsNotFound(err) {
+			t.Errorf("expected the %T of the managed postgres to be deleted, got %v", obj, err)
+		}
+	}
+}
+
+func TestSharedStorageKbsConfigSecret(t *testing.T) {
+	ctx := context.Background()
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace},
+		Data:       map[string]string{kbsConfigTomlKey: "[http_server]\nsockets = [\"0.0.0.0:8080\"]\n"},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: testNamespace},
+		Data:       map[string][]byte{postgresUsernameKey: []byte("trustee"), postgresPasswordKey: []byte("pass\n")},
+	}
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsConfigMapName: "kbs-config",
+		KbsSharedStorageSpec: &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+			Type: confidentialcontainersorgv1alpha1.SharedStoragePostgres,
+			Postgres: &confidentialcontainersorgv1alpha1.PostgresEndpointSpec{
+				Host:                  "postgres.db.svc",
+				Port:                  5433,
+				CredentialsSecretName: "postgres",
+			},
+		},
+	})
+	r := newTestExposureReconciler(t, kbsConfig, configMap, credentials)
+
+	if err := r.deployOrUpdateKbsConfigSecret(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: getKbsConfigSecretName("tenant-a")}, secret); err != nil {
+		t.Fatal(err)
+	}
+	got := string(secret.Data[kbsConfigTomlKey])
+	for _, want := range []string{
+		"storage_type = \"Postgres\"",
+		"[storage_backend.backends.postgres]",
+		"db = \"kbs\"",
+		"username = \"trustee\"",
+		"password = \"pass\"",
+		"host = \"postgres.db.svc\"",
+		"port = 5433",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in the KBS configuration, got:\n%s", want, got)
+		}
+	}
+
+	// Missing credentials are reported
+	credentials.Data = map[string][]byte{postgresUsernameKey: []byte("trustee")}
+	if err := r.Update(ctx, credentials); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.deployOrUpdateKbsConfigSecret(ctx); err == nil {
+		t.Error("expected an error for a missing password")
+	}
+}
+
+func TestNewKbsServiceSessionAffinity(t *testing.T) {
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{})
+	r := newTestExposureReconciler(t, kbsConfig)
+
+	if service := r.newKbsService(context.Background()); service.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		t.Errorf("expected ClientIP session affinity without shared storage, got %q", service.Spec.SessionAffinity)
+	}
+	kbsConfig.Spec.KbsSharedStorageSpec = &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+		Type: confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres,
+	}
+	// The sessions are not shared by the replicas, the clients stay pinned
+	if service := r.newKbsService(context.Background()); service.Spec.SessionAffinity != corev1.ServiceAffinityClientIP {
+		t.Errorf("expected ClientIP session affinity with shared storage, got %q", service.Spec.SessionAffinity)
+	}
+}
+
+func TestSharedStorageResourcePlugin(t *testing.T) {
+	conn := &postgresConnection{host: "postgres.db.svc", port: 5432, database: "kbs", username: "trustee", password: "pass"}
+	for _, filename := range []string{"kbs-config-permissive.toml", "kbs-config-restricted.toml"} {
+		content, err := os.ReadFile("../../config/templates/" + filename)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", filename, err)
+		}
+		var buf bytes.Buffer
+		if err := template.Must(template.New(filename).Parse(string(content))).Execute(&buf, GetTLSConfigFromTlsConfig(nil)); err != nil {
+			t.Fatalf("Failed to render %s: %v", filename, err)
+		}
+		doc, err := parseTomlDocument(buf.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := setPostgresStorageBackend(doc, conn); err != nil {
+			t.Fatalf("%s: setPostgresStorageBackend() error = %v", filename, err)
+		}
+		config, err := parseKbsTomlConfig(doc.String())
+		if err != nil {
+			t.Fatalf("%s: parseKbsTomlConfig() error = %v", filename, err)
+		}
+		if config.StorageBackend.StorageType != kbsStorageTypePostgres {
+			t.Errorf("%s: expected the Postgres storage backend, got %q", filename, config.StorageBackend.StorageType)
+		}
+		found := false
+		for _, plugin := range config.Plugins {
+			if plugin.Name != kbsResourcePluginName {
+				continue
+			}
+			found = true
+			// The resource plugin keeps serving the repository the secret-converter writes to
+			if plugin.Type != kbsRepositoryTypeLocalFs || plugin.DirPath != RepositoryPath || plugin.StorageBackendType != "" {
+				t.Errorf("%s: expected the resource plugin on the LocalFs repository %s, got %+v", filename, RepositoryPath, plugin)
+			}
+		}
+		if !found {
+			t.Errorf("%s: expected a resource plugin", filename)
+		}
+	}
+
+	// The secret-converter writes the <repository>/<type>/<tag> files read by the LocalFs repository
+	kbsConfig := newTestKbsConfig("tenant-a", confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsSharedStorageSpec: &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+			Type: confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres,
+		},
+	})
+	t.Setenv("OPERATOR_IMAGE_NAME", "trustee-operator:test")
+	r := newTestExposureReconciler(t, kbsConfig)
+	container, err := r.buildSecretConverterInitContainer(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(container.Args, "--"+RepositoryLayoutFlag+"="+RepositoryLayoutNested) {
+		t.Errorf("expected the nested repository layout, got %v", container.Args)
+	}
+	kbsConfig.Spec.KbsSharedStorageSpec = nil
+	if container, err = r.buildSecretConverterInitContainer(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, arg := range container.Args {
+		if strings.HasPrefix(arg, "--"+RepositoryLayoutFlag) {
+			t.Errorf("expected the default flat layout without shared storage, got %v", container.Args)
+		}
+	}
+}
@@ -29,6 +29,9 @@ type KbsConfigTemplateData struct {
 	TlsMaxVersion string
 	TlsCiphers    string
 	TlsGroups     string
+
+	// AttestationService is the external attestation service, the builtin one is configured when nil
+	AttestationService *AttestationServiceTemplateData
 }
 
 // GetTLSConfigFromTlsConfig converts TlsConfig to template data
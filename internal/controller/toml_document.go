@@ -0,0 +1,434 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// tomlDocument is a TOML document edited in place: the changes only rewrite the lines of the
+// key-values they touch, the other lines, comments included, are kept as they are
+type tomlDocument struct {
+	content    []byte
+	keyValues  []tomlKeyValue
+	tables     []tomlTable
+	firstTable int
+}
+
+// tomlKeyValue is a key-value of a tomlDocument
+type tomlKeyValue struct {
+	// path is the full path of the key, the table path followed by the key
+	path []string
+	// tablePath is the path of the table holding the key-value
+	tablePath []string
+	// arrayTable is true when the key-value belongs to an array of tables
+	arrayTable bool
+	// start and end of the lines of the key-value
+	start, end int
+	// start and end of the value
+	valueStart, valueEnd int
+}
+
+// tomlTable is a table header of a tomlDocument
+type tomlTable struct {
+	path       []string
+	arrayTable bool
+	// start and end of the lines of the header and of the key-values of the table
+	start, end int
+}
+
+var bareTomlKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseTomlDocument parses a TOML document, keeping track of where its key-values and tables are
+func parseTomlDocument(content string) (*tomlDocument, error) {
+	doc := &tomlDocument{content: []byte(content), firstTable: len(content)}
+	expressions, err := scanTomlExpressions(doc.content)
+	if err != nil {
+		return nil, err
+	}
+
+	var table *tomlTable
+	// Without a comment on its line, the value of a key-value ends before the next expression
+	pending := -1
+	for _, expr := range expressions {
+		first := expr.start
+		if pending >= 0 {
+			doc.setKeyValueEnd(pending, doc.lineStart(first))
+			pending = -1
+		}
+
+		switch expr.kind {
+		case tomlTableExpression, tomlArrayTableExpression:
+			doc.tables = append(doc.tables, tomlTable{
+				path:       expr.key,
+				arrayTable: expr.kind == tomlArrayTableExpression,
+				start:      doc.lineStart(first),
+				end:        doc.lineEnd(first),
+			})
+			table = &doc.tables[len(doc.tables)-1]
+			if table.start < doc.firstTable {
+				doc.firstTable = table.start
+			}
+		case tomlKeyValueExpression:
+			kv := tomlKeyValue{start: doc.lineStart(first)}
+			if table != nil {
+				kv.tablePath = table.path
+				kv.arrayTable = table.arrayTable
+			}
+			kv.path = append(append([]string{}, kv.tablePath...), expr.key...)
+			equal := bytes.IndexByte(doc.content[expr.keyEnd:], '=')
+			if equal < 0 {
+				return nil, fmt.Errorf("no value for key %s", strings.Join(kv.path, "."))
+			}
+			kv.valueStart = skipTomlWhitespace(doc.content, expr.keyEnd+equal+1)
+			doc.keyValues = append(doc.keyValues, kv)
+			if expr.comment >= 0 {
+				doc.setKeyValueEnd(len(doc.keyValues)-1, expr.comment)
+			} else {
+				pending = len(doc.keyValues) - 1
+			}
+		}
+	}
+	if pending >= 0 {
+		doc.setKeyValueEnd(pending, len(doc.content))
+	}
+
+	// A table ends with its last key-value
+	for i := range doc.tables {
+		for _, kv := range doc.keyValues {
+			if kv.start > doc.tables[i].start && (i+1 == len(doc.tables) || kv.start < doc.tables[i+1].start) {
+				doc.tables[i].end = kv.end
+			}
+		}
+	}
+	return doc, nil
+}
+
+// setKeyValueEnd sets the end of the value of a key-value, the value being followed by
+// blanks and either a comment or the next expression
+func (d *tomlDocument) setKeyValueEnd(i int, next int) {
+	kv := &d.keyValues[i]
+	end := next
+	for end > kv.valueStart && strings.ContainsRune(" \t\r\n", rune(d.content[end-1])) {
+		end--
+	}
+	kv.valueEnd = end
+	kv.end = d.lineEnd(end)
+}
+
+func (d *tomlDocument) lineStart(offset int) int {
+	return bytes.LastIndexByte(d.content[:offset], '\n') + 1
+}
+
+func (d *tomlDocument) lineEnd(offset int) int {
+	if i := bytes.IndexByte(d.content[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(d.content)
+}
+
+func skipTomlWhitespace(content []byte, offset int) int {
+	for offset < len(content) && (content[offset] == ' ' || content[offset] == '\t') {
+		offset++
+	}
+	return offset
+}
+
+func (d *tomlDocument) String() string {
+	return string(d.content)
+}
+
+// lookup returns the key-value of a path or of the inline table holding it, or nil
+func (d *tomlDocument) lookup(path []string) *tomlKeyValue {
+	for i := range d.keyValues {
+		kv := &d.keyValues[i]
+		if !kv.arrayTable && len(kv.path) <= len(path) && pathHasPrefix(path, kv.path) {
+			return kv
+		}
+	}
+	return nil
+}
+
+// hasArrayTable returns true if the document holds an array of tables at the path
+func (d *tomlDocument) hasArrayTable(path []string) bool {
+	for _, table := range d.tables {
+		if table.arrayTable && reflect.DeepEqual(table.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// splice replaces a range of the document and parses it again
+func (d *tomlDocument) splice(start, end int, text string) error {
+	var buf bytes.Buffer
+	buf.Write(d.content[:start])
+	buf.WriteString(text)
+	buf.Write(d.content[end:])
+	updated, err := parseTomlDocument(buf.String())
+	if err != nil {
+		return err
+	}
+	*d = *updated
+	return nil
+}
+
+// replaceValue replaces the value of a key-value
+func (d *tomlDocument) replaceValue(kv *tomlKeyValue, value interface{}) error {
+	encoded, err := encodeTomlValue(value)
+	if err != nil {
+		return err
+	}
+	return d.splice(kv.valueStart, kv.valueEnd, encoded)
+}
+
+// remove removes the lines of a key-value
+func (d *tomlDocument) remove(kv *tomlKeyValue) error {
+	return d.splice(kv.start, kv.end, "")
+}
+
+// set writes the value of a key, the key is inserted when missing
+func (d *tomlDocument) set(path []string, value interface{}) error {
+	kv := d.lookup(path)
+	switch {
+	case kv == nil:
+		return d.insert(path, value)
+	case len(kv.path) < len(path):
+		return fmt.Errorf("%s is set by the inline table %s", strings.Join(path, "."), strings.Join(kv.path, "."))
+	default:
+		return d.replaceValue(kv, value)
+	}
+}
+
+// insert adds a key-value missing from the document. The key is written after the last key-value
+// sharing its parent, in the table of its parent otherwise, and in a new table at the end of the
+// document when there is none
+func (d *tomlDocument) insert(path []string, value interface{}) error {
+	encoded, err := encodeTomlValue(value)
+	if err != nil {
+		return err
+	}
+	parent := path[:len(path)-1]
+
+	var sibling *tomlKeyValue
+	for i := range d.keyValues {
+		kv := &d.keyValues[i]
+		if !kv.arrayTable && len(kv.path) > len(parent) && pathHasPrefix(kv.path, parent) && len(kv.tablePath) <= len(parent) {
+			sibling = kv
+		}
+	}
+	if sibling != nil {
+		line := encodeTomlKey(path[len(sibling.tablePath):]) + " = " + encoded + "\n"
+		return d.splice(sibling.end, sibling.end, d.newlineBefore(sibling.end)+line)
+	}
+
+	line := encodeTomlKey(path[len(path)-1:]) + " = " + encoded + "\n"
+	if len(parent) == 0 {
+		return d.splice(d.firstTable, d.firstTable, line)
+	}
+	for _, table := range d.tables {
+		if !table.arrayTable && reflect.DeepEqual(table.path, parent) {
+			return d.splice(table.end, table.end, d.newlineBefore(table.end)+line)
+		}
+	}
+	end := len(d.content)
+	return d.splice(end, end, d.newlineBefore(end)+"\n["+encodeTomlKey(parent)+"]\n"+line)
+}
+
+// setArrayTables replaces the array of tables at the path, the new tables take the place of the first
+// table of the array or are appended to the document
+func (d *tomlDocument) setArrayTables(path []string, value interface{}) error {
+	tables, ok := value.([]interface{})
+	var blocks []string
+	for _, t := range tables {
+		table, isTable := t.(map[string]interface{})
+		if !isTable {
+			ok = false
+			break
+		}
+		block := "[[" + encodeTomlKey(path) + "]]\n"
+		for _, key := range sortedTomlKeys(table) {
+			encoded, err := encodeTomlValue(table[key])
+			if err != nil {
+				return err
+			}
+			block += encodeTomlKey([]string{key}) + " = " + encoded + "\n"
+		}
+		blocks = append(blocks, block)
+	}
+	if value != nil && !ok {
+		return fmt.Errorf("%s is not an array of tables", strings.Join(path, "."))
+	}
+
+	position := -1
+	for i := len(d.tables) - 1; i >= 0; i-- {
+		table := d.tables[i]
+		if !table.arrayTable || !reflect.DeepEqual(table.path, path) {
+			continue
+		}
+		var buf bytes.Buffer
+		buf.Write(d.content[:table.start])
+		buf.Write(d.content[table.end:])
+		d.content = buf.Bytes()
+		position = table.start
+	}
+	text := strings.Join(blocks, "\n")
+	if position < 0 {
+		position = len(d.content)
+		if text != "" {
+			text = d.newlineBefore(position) + "\n" + text
+		}
+	}
+	return d.splice(position, position, text)
+}
+
+// newlineBefore returns the newline missing before an insertion at the end of a line without one
+func (d *tomlDocument) newlineBefore(offset int) string {
+	if offset > 0 && d.content[offset-1] != '\n' {
+		return "\n"
+	}
+	return ""
+}
+
+func pathHasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedTomlKeys(table map[string]interface{}) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeTomlKey encodes a dotted key, quoting the parts that are not bare keys
+func encodeTomlKey(path []string) string {
+	parts := make([]string, len(path))
+	for i, part := range path {
+		if bareTomlKey.MatchString(part) {
+			parts[i] = part
+		} else {
+			parts[i] = encodeTomlString(part)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// encodeTomlString encodes a TOML basic string
+func encodeTomlString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// encodeTomlValue encodes a value decoded by go-toml, the tables are written inline
+func encodeTomlValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return encodeTomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		switch {
+		case math.IsInf(v, 1):
+			return "inf", nil
+		case math.IsInf(v, -1):
+			return "-inf", nil
+		case math.IsNaN(v):
+			return "nan", nil
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			s += ".0"
+		}
+		return s, nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case toml.LocalDate, toml.LocalTime, toml.LocalDateTime:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			encoded, err := encodeTomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = encoded
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return "{}", nil
+		}
+		items := make([]string, 0, len(v))
+		for _, key := range sortedTomlKeys(v) {
+			encoded, err := encodeTomlValue(v[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, encodeTomlKey([]string{key})+" = "+encoded)
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	default:
+		return "", fmt.Errorf("unsupported TOML value %v (%T)", value, value)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import "github.com/pelletier/go-toml/v2/unstable"
+
+// The unstable package of go-toml is the only parser of the library keeping the comments and
+// the offsets of the expressions, which the in place edits of tomlDocument need. It has no
+// compatibility guarantee: this file is its only user, and the version is pinned in go.mod.
+
+// tomlExpressionKind is the kind of a top level expression of a TOML document
+type tomlExpressionKind int
+
+const (
+	tomlCommentExpression tomlExpressionKind = iota
+	tomlKeyValueExpression
+	tomlTableExpression
+	tomlArrayTableExpression
+)
+
+// tomlExpression is a top level expression of a TOML document
+type tomlExpression struct {
+	kind tomlExpressionKind
+	// key of a key-value or table header
+	key []string
+	// start of the expression, the first part of the key for a key-value or table header
+	start int
+	// end of the last part of the key
+	keyEnd int
+	// start of the comment following a key-value on its line, -1 without comment
+	comment int
+}
+
+// scanTomlExpressions returns the top level expressions of a TOML document, comments included
+func scanTomlExpressions(content []byte) ([]tomlExpression, error) {
+	p := &unstable.Parser{KeepComments: true}
+	p.Reset(content)
+
+	var expressions []tomlExpression
+	for p.NextExpression() {
+		node := p.Expression()
+		expr := tomlExpression{comment: -1}
+		switch node.Kind {
+		case unstable.Comment:
+			expr.kind = tomlCommentExpression
+			expr.start = int(node.Raw.Offset)
+			expressions = append(expressions, expr)
+			continue
+		case unstable.KeyValue:
+			expr.kind = tomlKeyValueExpression
+			if comment := node.Next(); comment != nil && comment.Kind == unstable.Comment {
+				expr.comment = int(comment.Raw.Offset)
+			}
+		case unstable.Table:
+			expr.kind = tomlTableExpression
+		case unstable.ArrayTable:
+			expr.kind = tomlArrayTableExpression
+		default:
+			continue
+		}
+
+		expr.start = -1
+		it := node.Key()
+		for it.Next() {
+			key := it.Node()
+			expr.key = append(expr.key, string(key.Data))
+			if expr.start < 0 {
+				expr.start = int(key.Raw.Offset)
+			}
+			expr.keyEnd = int(key.Raw.Offset + key.Raw.Length)
+		}
+		expressions = append(expressions, expr)
+	}
+	if err := p.Error(); err != nil {
+		return nil, err
+	}
+	return expressions, nil
+}
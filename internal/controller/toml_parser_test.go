@@ -0,0 +1,70 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScanTomlExpressions(t *testing.T) {
+	content := `# header
+top = 1
+[http_server]
+"quoted key" = "value" # comment
+[[plugins]]
+name = "resource"
+`
+	expressions, err := scanTomlExpressions([]byte(content))
+	if err != nil {
+		t.Fatalf("scanTomlExpressions() error = %v", err)
+	}
+
+	want := []struct {
+		kind tomlExpressionKind
+		key  []string
+		text string
+	}{
+		{tomlCommentExpression, nil, "# header"},
+		{tomlKeyValueExpression, []string{"top"}, "top"},
+		{tomlTableExpression, []string{"http_server"}, "http_server"},
+		{tomlKeyValueExpression, []string{"quoted key"}, `"quoted key"`},
+		{tomlArrayTableExpression, []string{"plugins"}, "plugins"},
+		{tomlKeyValueExpression, []string{"name"}, "name"},
+	}
+	if len(expressions) != len(want) {
+		t.Fatalf("expected %d expressions, got %+v", len(want), expressions)
+	}
+	for i, expr := range expressions {
+		if expr.kind != want[i].kind || !reflect.DeepEqual(expr.key, want[i].key) {
+			t.Errorf("expression %d: expected %v %v, got %+v", i, want[i].kind, want[i].key, expr)
+		}
+		if expr.kind != tomlCommentExpression && content[expr.start:expr.keyEnd] != want[i].text {
+			t.Errorf("expression %d: expected the key %s, got %q", i, want[i].text, content[expr.start:expr.keyEnd])
+		}
+	}
+	if comment := expressions[3].comment; comment < 0 || content[comment:comment+9] != "# comment" {
+		t.Errorf("expected the comment of the key-value, got offset %d", comment)
+	}
+	if expressions[1].comment != -1 {
+		t.Errorf("expected no comment, got offset %d", expressions[1].comment)
+	}
+
+	if _, err := scanTomlExpressions([]byte("[http_server\n")); err == nil {
+		t.Error("expected an error for an invalid document")
+	}
+}
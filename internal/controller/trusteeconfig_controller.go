@@ -24,14 +24,18 @@ import (
 	"fmt"
 	"os"
 	"text/template"
+	"time"
 
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -47,6 +51,7 @@ type TrusteeConfigReconciler struct {
 	trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig
 	log           logr.Logger
 	namespace     string
+	Recorder      events.EventRecorder
 }
 
 //+kubebuilder:rbac:groups=confidentialcontainers.org,resources=trusteeconfigs,verbs=get;list;watch;create;update;patch;delete
@@ -58,6 +63,8 @@ type TrusteeConfigReconciler struct {
 //+kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
 //+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -109,13 +116,18 @@ func (r *TrusteeConfigReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		Namespace:  kbsConfig.Namespace,
 	}
 	// TrusteeConfig is only considered ready when the underlying KbsConfig
-	// deployment is also ready. The Watches(&KbsConfig{}) in SetupWithManager
-	// will re-trigger this reconcile when KbsConfig.Status.IsReady transitions
-	// to true, so there is no need to poll with RequeueAfter.
-	r.log.V(1).Info("KbsConfig status check", "KbsConfig.IsReady", kbsConfig.Status.IsReady, "KbsConfig.Name", kbsConfig.Name)
-	r.trusteeConfig.Status.IsReady = kbsConfig.Status.IsReady
-	if kbsConfig.Status.IsReady {
+	// reports the Ready condition for its current generation. The
+	// Watches(&KbsConfig{}) in SetupWithManager will re-trigger this reconcile
+	// when the KbsConfig conditions change, so there is no need to poll with RequeueAfter.
+	kbsReady := kbsConfig.Status.ObservedGeneration == kbsConfig.Generation &&
+		meta.IsStatusConditionTrue(kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionReady)
+	r.log.V(1).Info("KbsConfig status check", "KbsConfig.Ready", kbsReady, "KbsConfig.Name", kbsConfig.Name)
+	r.trusteeConfig.Status.IsReady = kbsReady
+	if kbsReady {
 		r.trusteeConfig.Status.StatusDescription = "TrusteeConfig is ready and KbsConfig is deployed successfully"
+	} else if ready := meta.FindStatusCondition(kbsConfig.Status.Conditions, confidentialcontainersorgv1alpha1.KbsConfigConditionReady); ready != nil && ready.Reason != reasonDeploymentProgressing {
+		r.trusteeConfig.Status.StatusDescription = "TrusteeConfig reconciled but KbsConfig is not ready: " + ready.Message
+		r.log.Info("KbsConfig not ready yet, waiting for KbsConfig status update", "reason", ready.Reason)
 	} else {
 		r.trusteeConfig.Status.StatusDescription = "TrusteeConfig reconciled but KbsConfig deployment is in progress"
 		r.log.Info("KbsConfig not ready yet, waiting for KbsConfig status update")
@@ -128,15 +140,42 @@ func (r *TrusteeConfigReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	}
 
 	r.log.Info("Successfully reconciled TrusteeConfig")
+
+	if next := r.nextScheduledReconcile(); !next.IsZero() {
+		return ctrl.Result{RequeueAfter: max(time.Until(next), time.Minute)}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
+// nextScheduledReconcile returns the earliest time a reconcile is due, or the zero time.
+// The operator comes back when the self-signed serving certificate is due for renewal, when the
+// admin key pair is due for rotation and when the grace period of the previous admin key is over.
+// Certificates issued by cert-manager are picked up through the secret watch
+func (r *TrusteeConfigReconciler) nextScheduledReconcile() time.Time {
+	var candidates []*metav1.Time
+	if certificate := r.trusteeConfig.Status.HttpsCertificate; certificate != nil && r.isSelfSignedHttps() {
+		candidates = append(candidates, certificate.RenewalTime)
+	}
+	if adminKey := r.trusteeConfig.Status.AdminKey; adminKey != nil {
+		candidates = append(candidates, adminKey.NextRotationTime, adminKey.PreviousKeyExpirationTime)
+	}
+
+	var next time.Time
+	for _, candidate := range candidates {
+		if candidate != nil && (next.IsZero() || candidate.Time.Before(next)) {
+			next = candidate.Time
+		}
+	}
+	return next
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *TrusteeConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	r.Recorder = mgr.GetEventRecorder("trusteeconfig-controller")
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&confidentialcontainersorgv1alpha1.TrusteeConfig{}).
 		// Watch the KbsConfig this controller creates so that a status change
-		// (e.g. IsReady transitioning to true) re-triggers TrusteeConfig reconcile.
+		// (e.g. the Ready condition turning True) re-triggers TrusteeConfig reconcile.
 		Watches(
 			&confidentialcontainersorgv1alpha1.KbsConfig{},
 			handler.EnqueueRequestForOwner(mgr.GetScheme(), mgr.GetRESTMapper(), &confidentialcontainersorgv1alpha1.TrusteeConfig{}),
@@ -148,7 +187,19 @@ func (r *TrusteeConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.ConfigMap{}).
 		Owns(&corev1.Secret{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
-		Complete(r)
+		// Watch the TLS secrets the HTTPS and attestation secrets are copied from, they are
+		// not owned by TrusteeConfig (user provided or issued by cert-manager)
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(secretToTrusteeConfigMapper(mgr.GetClient())),
+		)
+
+	// cert-manager Certificates are only watched when cert-manager is installed,
+	// otherwise the controller would fail to start
+	if apiAvailable(mgr.GetRESTMapper(), certmanagerv1.SchemeGroupVersion.WithKind(certmanagerv1.CertificateKind)) {
+		b = b.Owns(&certmanagerv1.Certificate{})
+	}
+	return b.Complete(r)
 }
 
 // createOrUpdateKbsConfig creates or updates a KbsConfig based on TrusteeConfig
@@ -236,12 +287,31 @@ func (r *TrusteeConfigReconciler) detectManualChanges(current, generated confide
 		// Deployment spec
 		current.KbsDeploymentSpec.Replicas != nil &&
 			(generated.KbsDeploymentSpec.Replicas == nil || *current.KbsDeploymentSpec.Replicas != *generated.KbsDeploymentSpec.Replicas),
+		!isZeroResources(current.KbsDeploymentSpec.Resources) &&
+			!apiequality.Semantic.DeepEqual(current.KbsDeploymentSpec.Resources, generated.KbsDeploymentSpec.Resources),
+		!isZeroResources(current.KbsDeploymentSpec.SecretConverterResources) &&
+			!apiequality.Semantic.DeepEqual(current.KbsDeploymentSpec.SecretConverterResources, generated.KbsDeploymentSpec.SecretConverterResources),
+
+		// Custom pod scheduling, disruption budget and autoscaling
+		(len(current.KbsDeploymentSpec.NodeSelector) > 0 || len(current.KbsDeploymentSpec.Tolerations) > 0 ||
+			current.KbsDeploymentSpec.Affinity != nil || len(current.KbsDeploymentSpec.TopologySpreadConstraints) > 0 ||
+			current.KbsDeploymentSpec.PriorityClassName != "" || current.KbsDeploymentSpec.ServiceAccountName != "" ||
+			current.KbsDeploymentSpec.PodDisruptionBudget != nil || current.KbsDeploymentSpec.Autoscaling != nil) &&
+			!apiequality.Semantic.DeepEqual(current.KbsDeploymentSpec, generated.KbsDeploymentSpec),
 
 		// Custom environment variables
 		len(current.KbsEnvVars) > 0 && !r.mapsEqual(current.KbsEnvVars, generated.KbsEnvVars),
 
 		// Custom secret resources
 		len(current.KbsSecretResources) > 0 && !r.stringSlicesEqual(current.KbsSecretResources, generated.KbsSecretResources),
+		len(current.KbsSecretResourceEntries) > 0 && !apiequality.Semantic.DeepEqual(current.KbsSecretResourceEntries, generated.KbsSecretResourceEntries),
+		len(current.KbsVaultResources) > 0 && !apiequality.Semantic.DeepEqual(current.KbsVaultResources, generated.KbsVaultResources),
+
+		// Secrets excluded from the rollouts
+		len(current.KbsRolloutExcludedSecrets) > 0 && !r.stringSlicesEqual(current.KbsRolloutExcludedSecrets, generated.KbsRolloutExcludedSecrets),
+
+		// Custom secret-converter mode
+		current.KbsSecretConverterMode != "" && current.KbsSecretConverterMode != generated.KbsSecretConverterMode,
 
 		// Custom local cert cache
 		len(current.KbsLocalCertCacheSpec.Secrets) > 0 && !r.certCacheSpecsEqual(current.KbsLocalCertCacheSpec, generated.KbsLocalCertCacheSpec),
@@ -252,6 +322,13 @@ func (r *TrusteeConfigReconciler) detectManualChanges(current, generated confide
 		current.IbmSEConfigSpec.CertStorePvc != "" &&
 			current.IbmSEConfigSpec.CertStorePvc != generated.IbmSEConfigSpec.CertStorePvc &&
 			current.IbmSEConfigSpec.CertStorePvc != r.getIBMSEPVCName(),
+
+		// Custom storage backends
+		!apiequality.Semantic.DeepEqual(current.KbsStorageSpec, confidentialcontainersorgv1alpha1.KbsStorageSpec{}) &&
+			!apiequality.Semantic.DeepEqual(current.KbsStorageSpec, generated.KbsStorageSpec),
+
+		// Custom exposure
+		current.KbsExposureSpec != nil && !apiequality.Semantic.DeepEqual(current.KbsExposureSpec, generated.KbsExposureSpec),
 	}
 
 	// Return true if any user-configurable field has been modified
@@ -307,11 +384,10 @@ func (r *TrusteeConfigReconciler) mergeKbsConfigSpecs(generatedSpec, manualSpec
 	merged := generatedSpec
 
 	// Preserve user-configurable fields from manual edits
-	// KbsDeploymentSpec.Replicas: preserve user override if different from generated default
-	if manualSpec.KbsDeploymentSpec.Replicas != nil &&
-		(generatedSpec.KbsDeploymentSpec.Replicas == nil || *manualSpec.KbsDeploymentSpec.Replicas != *generatedSpec.KbsDeploymentSpec.Replicas) {
-		merged.KbsDeploymentSpec.Replicas = manualSpec.KbsDeploymentSpec.Replicas
-	}
+	// KbsDeploymentSpec: preserve the replicas, resources and scheduling overrides that the
+	// TrusteeConfig does not set
+	merged.KbsDeploymentSpec = mergeKbsDeploymentSpec(generatedSpec.KbsDeploymentSpec, manualSpec.KbsDeploymentSpec,
+		r.trusteeConfig.Spec.KbsDeploymentSpec)
 
 	// Merge environment variables - preserve manual ones, add generated ones
 	if merged.KbsEnvVars == nil {
@@ -327,6 +403,22 @@ func (r *TrusteeConfigReconciler) mergeKbsConfigSpecs(generatedSpec, manualSpec
 	if len(manualSpec.KbsSecretResources) > 0 {
 		merged.KbsSecretResources = manualSpec.KbsSecretResources
 	}
+	if len(manualSpec.KbsSecretResourceEntries) > 0 {
+		merged.KbsSecretResourceEntries = manualSpec.KbsSecretResourceEntries
+	}
+	if len(manualSpec.KbsVaultResources) > 0 {
+		merged.KbsVaultResources = manualSpec.KbsVaultResources
+	}
+
+	// Preserve the secrets excluded from the rollouts
+	if len(manualSpec.KbsRolloutExcludedSecrets) > 0 {
+		merged.KbsRolloutExcludedSecrets = manualSpec.KbsRolloutExcludedSecrets
+	}
+
+	// Preserve the secret-converter mode
+	if manualSpec.KbsSecretConverterMode != "" {
+		merged.KbsSecretConverterMode = manualSpec.KbsSecretConverterMode
+	}
 
 	// Preserve manual local cert cache configuration
 	if len(manualSpec.KbsLocalCertCacheSpec.Secrets) > 0 {
@@ -340,9 +432,21 @@ func (r *TrusteeConfigReconciler) mergeKbsConfigSpecs(generatedSpec, manualSpec
 		merged.IbmSEConfigSpec.CertStorePvc = manualSpec.IbmSEConfigSpec.CertStorePvc
 	}
 
+	// Preserve manual storage configuration
+	if !apiequality.Semantic.DeepEqual(manualSpec.KbsStorageSpec, confidentialcontainersorgv1alpha1.KbsStorageSpec{}) {
+		merged.KbsStorageSpec = manualSpec.KbsStorageSpec
+	}
+
+	// Preserve manual exposure configuration
+	if manualSpec.KbsExposureSpec != nil {
+		merged.KbsExposureSpec = manualSpec.KbsExposureSpec
+	}
+
 	r.log.Info("Merged KbsConfig specs", "preservedFields", []string{
 		"KbsDeploymentSpec", "KbsEnvVars",
-		"KbsSecretResources", "KbsLocalCertCacheSpec", "IbmSEConfigSpec",
+		"KbsSecretResources", "KbsSecretResourceEntries", "KbsVaultResources", "KbsRolloutExcludedSecrets", "KbsSecretConverterMode", "KbsLocalCertCacheSpec",
+		"IbmSEConfigSpec", "KbsStorageSpec",
+		"KbsExposureSpec",
 	})
 
 	return merged
@@ -371,16 +475,23 @@ func (r *TrusteeConfigReconciler) ensureSecretLabels(ctx context.Context, secret
 func (r *TrusteeConfigReconciler) buildKbsConfigSpec(ctx context.Context) (confidentialcontainersorgv1alpha1.KbsConfigSpec, error) {
 	spec := confidentialcontainersorgv1alpha1.KbsConfigSpec{}
 
-	// Set service type from TrusteeConfig
+	// Set service type from TrusteeConfig, defaulting to ClusterIP explicitly so that
+	// the generated spec matches the one stored after admission defaulting
+	spec.KbsServiceType = corev1.ServiceTypeClusterIP
 	if r.trusteeConfig.Spec.KbsServiceType != "" {
 		spec.KbsServiceType = r.trusteeConfig.Spec.KbsServiceType
 	}
 
-	spec.KbsDeploymentType = confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne
+	spec.KbsDeploymentType = kbsDeploymentType(r.trusteeConfig.Spec)
 
-	// Set default replicas to 1
-	defaultReplicas := int32(1)
-	spec.KbsDeploymentSpec.Replicas = &defaultReplicas
+	// Set the KBS pods settings of the TrusteeConfig, with default replicas to 1
+	if r.trusteeConfig.Spec.KbsDeploymentSpec != nil {
+		spec.KbsDeploymentSpec = *r.trusteeConfig.Spec.KbsDeploymentSpec.DeepCopy()
+	}
+	if spec.KbsDeploymentSpec.Replicas == nil {
+		defaultReplicas := int32(1)
+		spec.KbsDeploymentSpec.Replicas = &defaultReplicas
+	}
 
 	// Configure based on profile type
 	var err error
@@ -399,6 +510,25 @@ func (r *TrusteeConfigReconciler) buildKbsConfigSpec(ctx context.Context) (confi
 		return spec, err
 	}
 
+	// Generate the attestation service and RVPS configurations of the microservices topology
+	if spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		spec.KbsMicroservicesSpec = r.trusteeConfig.Spec.MicroservicesSpec.DeepCopy()
+	}
+	if spec.KbsAsConfigMapName, spec.KbsRvpsConfigMapName, err = r.createOrUpdateMicroservicesConfigMaps(ctx); err != nil {
+		return spec, fmt.Errorf("microservices ConfigMaps: %w", err)
+	}
+
+	// The API key of the Intel Trust Authority is set in the KBS configuration by the KbsConfig controller
+	spec.KbsItaApiKeySecretRef = itaApiKeySecretRef(r.trusteeConfig.Spec)
+
+	// The shared storage is deployed and set in the KBS configuration by the KbsConfig controller as well
+	spec.KbsSharedStorageSpec = r.trusteeConfig.Spec.SharedStorage.DeepCopy()
+
+	// Copy the public keys of the admin personas, when the admin API is in Simple mode
+	if spec.KbsAdminPublicKeysSecretName, err = r.createOrUpdateAdminPublicKeysSecret(ctx); err != nil {
+		return spec, fmt.Errorf("admin public keys: %w", err)
+	}
+
 	// Configure IBM SE PVC after profile configuration (applies to all profiles).
 	// The PV must be pre-created by the cluster administrator and named in spec.ibmSEPVName.
 	if r.isIBMSE() {
@@ -408,20 +538,38 @@ func (r *TrusteeConfigReconciler) buildKbsConfigSpec(ctx context.Context) (confi
 		spec.IbmSEConfigSpec.CertStorePvc = r.getIBMSEPVCName()
 	}
 
-	// Configure HTTPS if specified
+	// Configure HTTPS if specified, otherwise request the certificate from cert-manager or
+	// issue a self-signed certificate when requested or required by the profile
+	r.trusteeConfig.Status.HttpsCertificate = nil
 	if r.trusteeConfig.Spec.HttpsSpec.TlsSecretName != "" {
-		if err = r.createOrUpdateHttpsSecrets(ctx); err != nil {
+		if err = r.createOrUpdateHttpsSecrets(ctx, r.trusteeConfig.Spec.HttpsSpec.TlsSecretName); err != nil {
 			return spec, fmt.Errorf("HTTPS secrets: %w", err)
 		}
 		spec = r.configureHttps(spec)
+	} else if r.trusteeConfig.Spec.HttpsSpec.CertManager != nil {
+		if err = r.createOrUpdateCertManagerHttpsSecrets(ctx); err != nil {
+			return spec, fmt.Errorf("cert-manager HTTPS certificate: %w", err)
+		}
+		spec = r.configureHttps(spec)
+	} else if r.isSelfSignedHttps() {
+		if err = r.createOrUpdateSelfSignedHttpsSecrets(ctx); err != nil {
+			return spec, fmt.Errorf("self-signed HTTPS certificate: %w", err)
+		}
+		spec = r.configureHttps(spec)
 	}
 
 	// Configure attestation token verification if specified
+	r.trusteeConfig.Status.AttestationTokenCertificate = nil
 	if r.trusteeConfig.Spec.AttestationTokenVerificationSpec.TlsSecretName != "" {
-		if err = r.createOrUpdateAttestationSecrets(ctx); err != nil {
+		if err = r.createOrUpdateAttestationSecrets(ctx, r.trusteeConfig.Spec.AttestationTokenVerificationSpec.TlsSecretName); err != nil {
 			return spec, fmt.Errorf("attestation secrets: %w", err)
 		}
 		spec = r.configureAttestationTokenVerification(spec)
+	} else if r.trusteeConfig.Spec.AttestationTokenVerificationSpec.CertManager != nil {
+		if err = r.createOrUpdateCertManagerAttestationSecrets(ctx); err != nil {
+			return spec, fmt.Errorf("cert-manager attestation token certificate: %w", err)
+		}
+		spec = r.configureAttestationTokenVerification(spec)
 	}
 
 	return spec, nil
@@ -553,10 +701,15 @@ func (r *TrusteeConfigReconciler) getHttpsCertSecretName() string {
 
 // generateKbsTomlConfig generates the TOML configuration for KBS
 func (r *TrusteeConfigReconciler) generateKbsTomlConfig() (string, error) {
+	return r.renderKbsTomlConfig(r.trusteeConfig.Spec)
+}
+
+// renderKbsTomlConfig renders the KBS configuration template of the profile with the settings of spec
+func (r *TrusteeConfigReconciler) renderKbsTomlConfig(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec) (string, error) {
 	var templateFile string
 
 	// Select template file based on profile type
-	switch r.trusteeConfig.Spec.Profile {
+	switch spec.Profile {
 	case confidentialcontainersorgv1alpha1.ProfileTypeRestrictive:
 		templateFile = "/config/templates/kbs-config-restricted.toml"
 		r.log.Info("Using restricted configuration template")
@@ -576,7 +729,13 @@ func (r *TrusteeConfigReconciler) generateKbsTomlConfig() (string, error) {
 	}
 
 	// Get TLS configuration data for template rendering
-	tlsData := GetTLSConfigFromTlsConfig(r.trusteeConfig.Spec.TlsConfig)
+	tlsData := GetTLSConfigFromTlsConfig(spec.TlsConfig)
+	tlsData.AttestationService = attestationServiceTemplateData(spec)
+	if tlsData.AttestationService != nil && tlsData.AttestationService.Type == kbsAttestationServiceGrpc &&
+		attestationServiceType(spec) == confidentialcontainersorgv1alpha1.AttestationServiceBuiltin {
+		// KBS reaches the attestation service of the microservices topology
+		tlsData.AttestationService.Address, _ = r.microservicesAddresses()
+	}
 
 	// Parse template
 	tmpl, err := template.New("kbs-config").Parse(string(templateContent))
@@ -592,8 +751,19 @@ func (r *TrusteeConfigReconciler) generateKbsTomlConfig() (string, error) {
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
 
+	// Render the KBS settings over the profile defaults
+	config, err := applyKbsSettings(buf.String(), spec)
+	if err != nil {
+		r.log.Error(err, "Failed to apply KBS settings", "template", templateFile)
+		return "", fmt.Errorf("failed to apply KBS settings: %w", err)
+	}
+	if _, err := parseKbsTomlConfig(config); err != nil {
+		r.log.Error(err, "Rendered KBS configuration is invalid", "template", templateFile)
+		return "", fmt.Errorf("invalid KBS configuration: %w", err)
+	}
+
 	r.log.Info("Rendered KBS configuration", "tlsProfile", tlsData.TlsProfile)
-	return buf.String(), nil
+	return config, nil
 }
 
 // generateKbsConfigMap creates a ConfigMap for KBS configuration
@@ -607,9 +777,12 @@ func (r *TrusteeConfigReconciler) generateKbsConfigMap(ctx context.Context) (*co
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      r.getKbsConfigMapName(),
 			Namespace: r.namespace,
+			Annotations: map[string]string{
+				lastGeneratedKbsConfigAnnotation: configToml,
+			},
 		},
 		Data: map[string]string{
-			"kbs-config.toml": configToml,
+			kbsConfigTomlKey: configToml,
 		},
 	}
 
@@ -643,25 +816,39 @@ func (r *TrusteeConfigReconciler) createOrUpdateKbsConfigMap(ctx context.Context
 		return err
 	}
 
-	// ConfigMap exists - generate new config with current TLS settings
+	// ConfigMap exists - merge the newly generated config with the user edits
 	newConfigMap, err := r.generateKbsConfigMap(ctx)
 	if err != nil {
 		return err
 	}
 
-	newConfig := newConfigMap.Data["kbs-config.toml"]
-	existingConfig := found.Data["kbs-config.toml"]
+	newConfig := newConfigMap.Data[kbsConfigTomlKey]
+	existingConfig := found.Data[kbsConfigTomlKey]
+	previousConfig := found.Annotations[lastGeneratedKbsConfigAnnotation]
 
-	// Merge TLS settings from new config into existing config
-	mergedConfig := mergeTlsSettings(existingConfig, newConfig)
+	mergedConfig, overridden, err := mergeKbsTomlConfig(existingConfig, previousConfig, newConfig)
+	if err != nil {
+		r.log.Error(err, "Failed to merge KBS config", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
+		return err
+	}
+	for _, key := range overridden {
+		r.log.Info("Overriding user edit of KBS config key with the generated value", "ConfigMap.Name", configMapName, "key", key)
+	}
 
-	if mergedConfig != existingConfig {
-		r.log.Info("Updating KBS config map with new TLS settings", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
-		found.Data["kbs-config.toml"] = mergedConfig
+	if mergedConfig != existingConfig || previousConfig != newConfig {
+		r.log.Info("Updating KBS config map", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
+		if found.Data == nil {
+			found.Data = make(map[string]string)
+		}
+		found.Data[kbsConfigTomlKey] = mergedConfig
+		if found.Annotations == nil {
+			found.Annotations = make(map[string]string)
+		}
+		found.Annotations[lastGeneratedKbsConfigAnnotation] = newConfig
 		return r.Update(ctx, found)
 	}
 
-	r.log.V(1).Info("KBS config map TLS settings unchanged", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
+	r.log.V(1).Info("KBS config map unchanged", "ConfigMap.Namespace", r.namespace, "ConfigMap.Name", configMapName)
 	return nil
 }
 
@@ -669,37 +856,23 @@ func (r *TrusteeConfigReconciler) createOrUpdateKbsConfigMap(ctx context.Context
 func (r *TrusteeConfigReconciler) generateKbsAuthSecret(ctx context.Context) (*corev1.Secret, error) {
 	secretName := r.getKbsAuthSecretName()
 
-	// Generate Ed25519 key pair
-	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
-	if err != nil {
-		r.log.Error(err, "Failed to generate Ed25519 key pair")
-		return nil, err
-	}
-
-	// Encode private key to PEM format
-	privateKeyPEM, err := encodeEd25519PrivateKeyToPEM(privateKey)
+	publicKeyPEM, privateKeyPEM, err := r.generateAdminKeyPair()
 	if err != nil {
-		r.log.Error(err, "Failed to encode private key to PEM")
 		return nil, err
 	}
 
-	// Encode public key to PEM format
-	publicKeyPEM, err := encodeEd25519PublicKeyToPEM(publicKey)
-	if err != nil {
-		r.log.Error(err, "Failed to encode public key to PEM")
-		return nil, err
-	}
-
-	// Prepare secret data
+	// Prepare secret data, the previous public key matches the current one until the first rotation
 	data := make(map[string][]byte)
 	data["publicKey"] = publicKeyPEM
 	data["privateKey"] = privateKeyPEM
+	data[adminPreviousPublicKey] = publicKeyPEM
 
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      secretName,
-			Namespace: r.namespace,
-			Labels:    standardLabels(r.trusteeConfig.Name, "auth"),
+			Name:        secretName,
+			Namespace:   r.namespace,
+			Labels:      standardLabels(r.trusteeConfig.Name, "auth"),
+			Annotations: r.adminKeyRotationAnnotations(time.Now()),
 		},
 		Type: corev1.SecretTypeOpaque,
 		Data: data,
@@ -714,6 +887,32 @@ func (r *TrusteeConfigReconciler) generateKbsAuthSecret(ctx context.Context) (*c
 	return secret, nil
 }
 
+// generateAdminKeyPair generates a PEM encoded Ed25519 key pair for the KBS admin API
+func (r *TrusteeConfigReconciler) generateAdminKeyPair() ([]byte, []byte, error) {
+	// Generate Ed25519 key pair
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		r.log.Error(err, "Failed to generate Ed25519 key pair")
+		return nil, nil, err
+	}
+
+	// Encode private key to PEM format
+	privateKeyPEM, err := encodeEd25519PrivateKeyToPEM(privateKey)
+	if err != nil {
+		r.log.Error(err, "Failed to encode private key to PEM")
+		return nil, nil, err
+	}
+
+	// Encode public key to PEM format
+	publicKeyPEM, err := encodeEd25519PublicKeyToPEM(publicKey)
+	if err != nil {
+		r.log.Error(err, "Failed to encode public key to PEM")
+		return nil, nil, err
+	}
+
+	return publicKeyPEM, privateKeyPEM, nil
+}
+
 // generateKbsSampleSecret creates a sample Secret for KBS
 func (r *TrusteeConfigReconciler) generateKbsSampleSecret(ctx context.Context) (*corev1.Secret, error) {
 	secretName := r.getKbsSampleSecretName()
@@ -773,14 +972,19 @@ func (r *TrusteeConfigReconciler) createOrUpdateKbsAuthSecret(ctx context.Contex
 		if err != nil {
 			return err
 		}
+		r.trusteeConfig.Status.AdminKey = r.adminKeyStatus(secret)
 	} else if err != nil {
 		return err
 	} else {
-		// Secret already exists, preserve its content and ensure labels are added
+		// Secret already exists, preserve its content unless the key pair is due for rotation
+		// and ensure labels are added
 		r.log.Info("KBS auth secret already exists, preserving existing content", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
 		if err := r.ensureSecretLabels(ctx, found, "auth"); err != nil {
 			return err
 		}
+		if err := r.reconcileAdminKeyRotation(ctx, found, time.Now()); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -822,10 +1026,10 @@ func (r *TrusteeConfigReconciler) createOrUpdateKbsSampleSecret(ctx context.Cont
 }
 
 // createOrUpdateHttpsSecrets creates or updates the HTTPS key and certificate secrets from the TLS secret
-func (r *TrusteeConfigReconciler) createOrUpdateHttpsSecrets(ctx context.Context) error {
+// provided by the user or issued by cert-manager
+func (r *TrusteeConfigReconciler) createOrUpdateHttpsSecrets(ctx context.Context, tlsSecretName string) error {
 	// Read the TLS secret
 	tlsSecret := &corev1.Secret{}
-	tlsSecretName := r.trusteeConfig.Spec.HttpsSpec.TlsSecretName
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: r.namespace,
 		Name:      tlsSecretName,
@@ -872,7 +1076,7 @@ func (r *TrusteeConfigReconciler) createOrUpdateHttpsSecrets(ctx context.Context
 	return nil
 }
 
-// createOrUpdateHttpsKeySecret creates or updates the HTTPS key secret
+// createOrUpdateHttpsKeySecret creates or updates the HTTPS key secret, keeping it in sync with the source key
 func (r *TrusteeConfigReconciler) createOrUpdateHttpsKeySecret(ctx context.Context, keyData []byte) error {
 	secretName := r.getHttpsKeySecretName()
 
@@ -895,18 +1099,28 @@ func (r *TrusteeConfigReconciler) createOrUpdateHttpsKeySecret(ctx context.Conte
 		}
 	} else if err != nil {
 		return err
-	} else {
-		// Secret already exists, preserve its content and ensure labels are added
-		r.log.Info("HTTPS key secret already exists, preserving existing content", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
-		if err := r.ensureSecretLabels(ctx, found, "https"); err != nil {
+	} else if !bytes.Equal(found.Data["privateKey"], keyData) {
+		// The source certificate was renewed or replaced, KBS pods are rolled by the
+		// KbsConfig controller when the secret changes
+		r.log.Info("Updating HTTPS key secret", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+		found.Data = map[string][]byte{"privateKey": keyData}
+		if found.Labels == nil {
+			found.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "https") {
+			found.Labels[k] = v
+		}
+		if err = r.Update(ctx, found); err != nil {
 			return err
 		}
+	} else if err := r.ensureSecretLabels(ctx, found, "https"); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// createOrUpdateHttpsCertSecret creates or updates the HTTPS certificate secret
+// createOrUpdateHttpsCertSecret creates or updates the HTTPS certificate secret, keeping it in sync with the source certificate
 func (r *TrusteeConfigReconciler) createOrUpdateHttpsCertSecret(ctx context.Context, certData []byte) error {
 	secretName := r.getHttpsCertSecretName()
 
@@ -929,12 +1143,22 @@ func (r *TrusteeConfigReconciler) createOrUpdateHttpsCertSecret(ctx context.Cont
 		}
 	} else if err != nil {
 		return err
-	} else {
-		// Secret already exists, preserve its content and ensure labels are added
-		r.log.Info("HTTPS certificate secret already exists, preserving existing content", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
-		if err := r.ensureSecretLabels(ctx, found, "https"); err != nil {
+	} else if !bytes.Equal(found.Data["certificate"], certData) {
+		// The source certificate was renewed or replaced, KBS pods are rolled by the
+		// KbsConfig controller when the secret changes
+		r.log.Info("Updating HTTPS certificate secret", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+		found.Data = map[string][]byte{"certificate": certData}
+		if found.Labels == nil {
+			found.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "https") {
+			found.Labels[k] = v
+		}
+		if err = r.Update(ctx, found); err != nil {
 			return err
 		}
+	} else if err := r.ensureSecretLabels(ctx, found, "https"); err != nil {
+		return err
 	}
 
 	return nil
@@ -987,10 +1211,10 @@ func (r *TrusteeConfigReconciler) generateHttpsCertSecret(certData []byte) (*cor
 }
 
 // createOrUpdateAttestationSecrets creates or updates the attestation key and certificate secrets from the TLS secret
-func (r *TrusteeConfigReconciler) createOrUpdateAttestationSecrets(ctx context.Context) error {
+// provided by the user or issued by cert-manager
+func (r *TrusteeConfigReconciler) createOrUpdateAttestationSecrets(ctx context.Context, tlsSecretName string) error {
 	// Read the TLS secret
 	tlsSecret := &corev1.Secret{}
-	tlsSecretName := r.trusteeConfig.Spec.AttestationTokenVerificationSpec.TlsSecretName
 	err := r.Get(ctx, client.ObjectKey{
 		Namespace: r.namespace,
 		Name:      tlsSecretName,
@@ -1037,7 +1261,7 @@ func (r *TrusteeConfigReconciler) createOrUpdateAttestationSecrets(ctx context.C
 	return nil
 }
 
-// createOrUpdateAttestationKeySecret creates or updates the attestation key secret
+// createOrUpdateAttestationKeySecret creates or updates the attestation key secret, keeping it in sync with the source key
 func (r *TrusteeConfigReconciler) createOrUpdateAttestationKeySecret(ctx context.Context, keyData []byte) error {
 	secretName := r.getAttestationKeySecretName()
 
@@ -1060,18 +1284,28 @@ func (r *TrusteeConfigReconciler) createOrUpdateAttestationKeySecret(ctx context
 		}
 	} else if err != nil {
 		return err
-	} else {
-		// Secret already exists, preserve its content and ensure labels are added
-		r.log.Info("Attestation key secret already exists, preserving existing content", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
-		if err := r.ensureSecretLabels(ctx, found, "attestation"); err != nil {
+	} else if !bytes.Equal(found.Data["token.key"], keyData) {
+		// The source certificate was renewed or replaced, KBS pods are rolled by the
+		// KbsConfig controller when the secret changes
+		r.log.Info("Updating attestation key secret", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+		found.Data = map[string][]byte{"token.key": keyData}
+		if found.Labels == nil {
+			found.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "attestation") {
+			found.Labels[k] = v
+		}
+		if err = r.Update(ctx, found); err != nil {
 			return err
 		}
+	} else if err := r.ensureSecretLabels(ctx, found, "attestation"); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// createOrUpdateAttestationCertSecret creates or updates the attestation certificate secret
+// createOrUpdateAttestationCertSecret creates or updates the attestation certificate secret, keeping it in sync with the source certificate
 func (r *TrusteeConfigReconciler) createOrUpdateAttestationCertSecret(ctx context.Context, certData []byte) error {
 	secretName := r.getAttestationCertSecretName()
 
@@ -1094,12 +1328,22 @@ func (r *TrusteeConfigReconciler) createOrUpdateAttestationCertSecret(ctx contex
 		}
 	} else if err != nil {
 		return err
-	} else {
-		// Secret already exists, preserve its content and ensure labels are added
-		r.log.Info("Attestation certificate secret already exists, preserving existing content", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
-		if err := r.ensureSecretLabels(ctx, found, "attestation"); err != nil {
+	} else if !bytes.Equal(found.Data["token.crt"], certData) {
+		// The source certificate was renewed or replaced, KBS pods are rolled by the
+		// KbsConfig controller when the secret changes
+		r.log.Info("Updating attestation certificate secret", "Secret.Namespace", r.namespace, "Secret.Name", secretName)
+		found.Data = map[string][]byte{"token.crt": certData}
+		if found.Labels == nil {
+			found.Labels = make(map[string]string)
+		}
+		for k, v := range standardLabels(r.trusteeConfig.Name, "attestation") {
+			found.Labels[k] = v
+		}
+		if err = r.Update(ctx, found); err != nil {
 			return err
 		}
+	} else if err := r.ensureSecretLabels(ctx, found, "attestation"); err != nil {
+		return err
 	}
 
 	return nil
@@ -0,0 +1,175 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const (
+	// Volume of the secret-converter holding the service account tokens and CA bundles of the Vault sources
+	vaultSourcesVolume = "vault-sources"
+	// VaultSourcesMountPath is the mount path of the Vault sources volume in the secret-converter
+	VaultSourcesMountPath = "/var/run/secrets/kbs-vault"
+
+	// Flag of the secret-converter taking the Vault sources
+	VaultResourcesFlag = "vault-resources"
+
+	defaultVaultAuthMountPath = "kubernetes"
+	defaultVaultKVMountPath   = "secret"
+	defaultVaultKVVersion     = int32(2)
+
+	// Lifetime of the service account tokens, the kubelet renews them at 80% of it
+	vaultTokenExpirationSeconds = int64(3600)
+)
+
+// VaultResourceSource is a KbsVaultResourceSource with its defaults applied and the files of its
+// token and CA bundle, as passed to the secret-converter
+type VaultResourceSource struct {
+	Address       string            `json:"address"`
+	Namespace     string            `json:"namespace,omitempty"`
+	CAFile        string            `json:"caFile,omitempty"`
+	AuthMountPath string            `json:"authMountPath"`
+	Role          string            `json:"role"`
+	TokenFile     string            `json:"tokenFile"`
+	MountPath     string            `json:"mountPath"`
+	KVVersion     int32             `json:"kvVersion"`
+	Path          string            `json:"path"`
+	Repository    string            `json:"repository"`
+	Type          string            `json:"type"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+// vaultTokenPath returns the path of the service account token of a Vault source in the volume
+func vaultTokenPath(index int) string {
+	return fmt.Sprintf("token-%d", index)
+}
+
+// vaultCAPath returns the path of the CA bundle of a Vault source in the volume
+func vaultCAPath(index int) string {
+	return fmt.Sprintf("ca-%d.crt", index)
+}
+
+// vaultResourceType returns the type of the resources of a Vault source, the last element of its path by default
+func vaultResourceType(source confidentialcontainersorgv1alpha1.KbsVaultResourceSource) string {
+	if source.Type != "" {
+		return source.Type
+	}
+	return path.Base(strings.Trim(source.Path, "/"))
+}
+
+// resolveVaultResources applies the defaults of the Vault sources of the spec
+func resolveVaultResources(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) []VaultResourceSource {
+	var sources []VaultResourceSource
+	for i, source := range spec.KbsVaultResources {
+		resolved := VaultResourceSource{
+			Address:       strings.TrimRight(source.Address, "/"),
+			Namespace:     source.Namespace,
+			AuthMountPath: source.AuthMountPath,
+			Role:          source.Role,
+			TokenFile:     filepath.Join(VaultSourcesMountPath, vaultTokenPath(i)),
+			MountPath:     source.MountPath,
+			KVVersion:     source.KVVersion,
+			Path:          strings.Trim(source.Path, "/"),
+			Repository:    source.Repository,
+			Type:          vaultResourceType(source),
+		}
+		if resolved.AuthMountPath == "" {
+			resolved.AuthMountPath = defaultVaultAuthMountPath
+		}
+		if resolved.MountPath == "" {
+			resolved.MountPath = defaultVaultKVMountPath
+		}
+		if resolved.KVVersion == 0 {
+			resolved.KVVersion = defaultVaultKVVersion
+		}
+		if resolved.Repository == "" {
+			resolved.Repository = defaultKbsResourceRepository
+		}
+		if source.CAConfigMapName != "" {
+			resolved.CAFile = filepath.Join(VaultSourcesMountPath, vaultCAPath(i))
+		}
+		for _, key := range source.Keys {
+			if resolved.Tags == nil {
+				resolved.Tags = map[string]string{}
+			}
+			resolved.Tags[key.Key] = key.Tag
+		}
+		sources = append(sources, resolved)
+	}
+	return sources
+}
+
+// vaultCAConfigMapNames returns the ConfigMaps holding the CA bundles of the Vault servers
+func vaultCAConfigMapNames(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) []string {
+	var names []string
+	for _, source := range spec.KbsVaultResources {
+		if source.CAConfigMapName != "" {
+			names = append(names, source.CAConfigMapName)
+		}
+	}
+	return names
+}
+
+// createVaultSourcesVolume returns the volume projecting a service account token, and the CA bundle when
+// set, for each Vault source, or nil when the KbsConfig has no Vault source
+func createVaultSourcesVolume(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) *corev1.Volume {
+	if len(spec.KbsVaultResources) == 0 {
+		return nil
+	}
+	var sources []corev1.VolumeProjection
+	for i, source := range spec.KbsVaultResources {
+		sources = append(sources, corev1.VolumeProjection{
+			ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+				Audience:          source.TokenAudience,
+				ExpirationSeconds: pointer(vaultTokenExpirationSeconds),
+				Path:              vaultTokenPath(i),
+			},
+		})
+		if source.CAConfigMapName != "" {
+			sources = append(sources, corev1.VolumeProjection{
+				ConfigMap: &corev1.ConfigMapProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: source.CAConfigMapName},
+					Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: vaultCAPath(i)}},
+				},
+			})
+		}
+	}
+	return &corev1.Volume{
+		Name: vaultSourcesVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	}
+}
+
+// vaultResourcesArg returns the secret-converter argument with the Vault sources
+func vaultResourcesArg(sources []VaultResourceSource) (string, error) {
+	content, err := json.Marshal(sources)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("--%s=%s", VaultResourcesFlag, content), nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+func TestResolveVaultResources(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.KbsConfigSpec{
+		KbsVaultResources: []confidentialcontainersorgv1alpha1.KbsVaultResourceSource{
+			{Address: "https://vault.vault.svc:8200/", Role: "kbs", Path: "/kbs/keys/"},
+			{
+				Address:         "https://openbao.example.com",
+				CAConfigMapName: "openbao-ca",
+				AuthMountPath:   "k8s",
+				Role:            "kbs",
+				TokenAudience:   "openbao",
+				MountPath:       "kv",
+				KVVersion:       1,
+				Path:            "certs",
+				Repository:      "tenant-a",
+				Type:            "tls",
+				Keys:            []confidentialcontainersorgv1alpha1.KbsSecretResourceKey{{Key: "tls.crt", Tag: "server"}},
+			},
+		},
+	}
+
+	want := []VaultResourceSource{
+		{
+			Address:       "https://vault.vault.svc:8200",
+			AuthMountPath: "kubernetes",
+			Role:          "kbs",
+			TokenFile:     VaultSourcesMountPath + "/token-0",
+			MountPath:     "secret",
+			KVVersion:     2,
+			Path:          "kbs/keys",
+			Repository:    "default",
+			Type:          "keys",
+		},
+		{
+			Address:       "https://openbao.example.com",
+			CAFile:        VaultSourcesMountPath + "/ca-1.crt",
+			AuthMountPath: "k8s",
+			Role:          "kbs",
+			TokenFile:     VaultSourcesMountPath + "/token-1",
+			MountPath:     "kv",
+			KVVersion:     1,
+			Path:          "certs",
+			Repository:    "tenant-a",
+			Type:          "tls",
+			Tags:          map[string]string{"tls.crt": "server"},
+		},
+	}
+	sources := resolveVaultResources(spec)
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("expected %+v, got %+v", want, sources)
+	}
+
+	volume := createVaultSourcesVolume(spec)
+	if volume == nil || volume.Projected == nil || len(volume.Projected.Sources) != 3 {
+		t.Fatalf("expected a token for each source and a CA bundle, got %+v", volume)
+	}
+	if token := volume.Projected.Sources[1].ServiceAccountToken; token == nil || token.Path != "token-1" || token.Audience != "openbao" {
+		t.Errorf("expected the token of the second source with its audience, got %+v", volume.Projected.Sources[1])
+	}
+	if ca := volume.Projected.Sources[2].ConfigMap; ca == nil || ca.Name != "openbao-ca" || ca.Items[0].Path != "ca-1.crt" {
+		t.Errorf("expected the CA bundle of the second source, got %+v", volume.Projected.Sources[2])
+	}
+	if createVaultSourcesVolume(confidentialcontainersorgv1alpha1.KbsConfigSpec{}) != nil {
+		t.Error("expected no volume without Vault sources")
+	}
+
+	arg, err := vaultResourcesArg(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(arg, "--"+VaultResourcesFlag+"=[") || !strings.Contains(arg, `"tokenFile":"`+VaultSourcesMountPath+`/token-0"`) {
+		t.Errorf("unexpected secret-converter argument %s", arg)
+	}
+}
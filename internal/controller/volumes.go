@@ -62,32 +62,22 @@ func (r *KbsConfigReconciler) createSecretVolume(ctx context.Context, volumeName
 	return &volume, nil
 }
 
-// Method to add KbsSecretResources to the KBS volumes
-func (r *KbsConfigReconciler) createKbsSecretResourcesVolume(ctx context.Context) ([]corev1.Volume, error) {
+// Method to add KbsSecretResources and KbsSecretResourceEntries to the KBS volumes. The volumes are
+// optional, a missing secret is mounted empty and reported by the secret-converter
+func (r *KbsConfigReconciler) createKbsSecretResourcesVolume() []corev1.Volume {
 	var secretVolumes []corev1.Volume
-	if r.kbsConfig.Spec.KbsSecretResources != nil {
-		for _, secretResource := range r.kbsConfig.Spec.KbsSecretResources {
-			r.log.Info("Retrieving KbsSecretResource", "Secret.Namespace", r.namespace, "Secret.Name", secretResource)
-			foundSecret := &corev1.Secret{}
-			err := r.Get(ctx, client.ObjectKey{
-				Namespace: r.namespace,
-				Name:      secretResource,
-			}, foundSecret)
-			if err != nil {
-				return nil, err
-			}
-
-			secretVolumes = append(secretVolumes, corev1.Volume{
-				Name: secretResource,
-				VolumeSource: corev1.VolumeSource{
-					Secret: &corev1.SecretVolumeSource{
-						SecretName: secretResource,
-					},
+	for _, secretResource := range secretResourceNames(r.kbsConfig.Spec) {
+		secretVolumes = append(secretVolumes, corev1.Volume{
+			Name: secretResource,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretResource,
+					Optional:   pointer(true),
 				},
-			})
-		}
+			},
+		})
 	}
-	return secretVolumes, nil
+	return secretVolumes
 }
 
 func (r *KbsConfigReconciler) createConfigMapVolume(ctx context.Context, volumeName string, configMapName string) (*corev1.Volume, error) {
@@ -0,0 +1,621 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+var kbsconfiglog = logf.Log.WithName("kbsconfig-resource")
+
+// resourcePathElement matches the repository, type and tag of a KBS resource path
+var resourcePathElement = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9_.-]*$`)
+
+// reservedVolumeNames are the volume names used by the operator in the KBS deployment.
+// Secrets listed in kbsSecretResources and kbsLocalCertCacheSpec are mounted as volumes
+// named after the secret, so they must not clash with these.
+var reservedVolumeNames = []string{
+	"kbs-config", "auth-secret", "https-key", "https-cert", "attestation-key", "attestation-cert",
+	"attestation-policy", "attestation-policy-gpu", "resource-policy", "reference-values",
+	"as-config", "rvps-config", "base-storage-dir", "attestation-policy-dir", "resource-policy-dir",
+	"repository-dir", "rvps-dir", "admin-public-keys", "vault-sources",
+}
+
+// SetupKbsConfigWebhookWithManager registers the defaulting and validating webhooks for KbsConfig.
+// The referenced ConfigMaps and Secrets are looked up in the operator namespace.
+func SetupKbsConfigWebhookWithManager(mgr ctrl.Manager, namespace string) error {
+	return ctrl.NewWebhookManagedBy(mgr, &confidentialcontainersorgv1alpha1.KbsConfig{}).
+		WithDefaulter(&KbsConfigCustomDefaulter{}).
+		WithValidator(&KbsConfigCustomValidator{Client: mgr.GetClient(), Namespace: namespace}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-confidentialcontainers-org-v1alpha1-kbsconfig,mutating=true,failurePolicy=fail,sideEffects=None,groups=confidentialcontainers.org,resources=kbsconfigs,verbs=create;update,versions=v1alpha1,name=mkbsconfig-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// KbsConfigCustomDefaulter sets default values on the KbsConfig spec
+type KbsConfigCustomDefaulter struct{}
+
+// Default implements admission.Defaulter
+func (d *KbsConfigCustomDefaulter) Default(_ context.Context, kbsConfig *confidentialcontainersorgv1alpha1.KbsConfig) error {
+	kbsconfiglog.V(1).Info("Defaulting KbsConfig", "name", kbsConfig.Name)
+	defaultKbsConfigSpec(&kbsConfig.Spec)
+	return nil
+}
+
+// defaultKbsConfigSpec fills in the deployment type, service type and replicas when unset
+func defaultKbsConfigSpec(spec *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+	spec.KbsDeploymentType = spec.DeploymentType()
+	if spec.KbsServiceType == "" {
+		spec.KbsServiceType = corev1.ServiceTypeClusterIP
+	}
+	if spec.KbsDeploymentSpec.Replicas == nil {
+		replicas := int32(1)
+		spec.KbsDeploymentSpec.Replicas = &replicas
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-confidentialcontainers-org-v1alpha1-kbsconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=confidentialcontainers.org,resources=kbsconfigs,verbs=create;update,versions=v1alpha1,name=vkbsconfig-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// KbsConfigCustomValidator rejects inconsistent KbsConfig specs and checks the referenced objects
+type KbsConfigCustomValidator struct {
+	Client    client.Reader
+	Namespace string
+}
+
+// ValidateCreate implements admission.Validator
+func (v *KbsConfigCustomValidator) ValidateCreate(ctx context.Context, kbsConfig *confidentialcontainersorgv1alpha1.KbsConfig) (admission.Warnings, error) {
+	kbsconfiglog.Info("Validating KbsConfig creation", "name", kbsConfig.Name)
+	return v.validateKbsConfig(ctx, kbsConfig)
+}
+
+// ValidateUpdate implements admission.Validator
+func (v *KbsConfigCustomValidator) ValidateUpdate(ctx context.Context, oldKbsConfig, kbsConfig *confidentialcontainersorgv1alpha1.KbsConfig) (admission.Warnings, error) {
+	// Metadata-only updates (e.g. finalizer removal during deletion) must never be blocked
+	if kbsConfig.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(oldKbsConfig.Spec, kbsConfig.Spec) {
+		return nil, nil
+	}
+	kbsconfiglog.Info("Validating KbsConfig update", "name", kbsConfig.Name)
+	return v.validateKbsConfig(ctx, kbsConfig)
+}
+
+// ValidateDelete implements admission.Validator
+func (v *KbsConfigCustomValidator) ValidateDelete(_ context.Context, _ *confidentialcontainersorgv1alpha1.KbsConfig) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *KbsConfigCustomValidator) validateKbsConfig(ctx context.Context, kbsConfig *confidentialcontainersorgv1alpha1.KbsConfig) (admission.Warnings, error) {
+	specPath := field.NewPath("spec")
+	allErrs := validateKbsConfigSpec(kbsConfig.Spec, specPath)
+	warnings := kbsConfigSpecWarnings(kbsConfig.Spec)
+
+	refErrs, refWarnings := v.validateReferences(ctx, kbsConfig.Spec, specPath)
+	allErrs = append(allErrs, refErrs...)
+	warnings = append(warnings, refWarnings...)
+
+	if len(allErrs) > 0 {
+		return warnings, k8serrors.NewInvalid(confidentialcontainersorgv1alpha1.GroupVersion.WithKind("KbsConfig").GroupKind(), kbsConfig.Name, allErrs)
+	}
+	return warnings, nil
+}
+
+// validateKbsConfigSpec checks the consistency of the KbsConfig spec without looking at the cluster
+func validateKbsConfigSpec(spec confidentialcontainersorgv1alpha1.KbsConfigSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if spec.KbsConfigMapName == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("kbsConfigMapName"), "the KBS configuration ConfigMap is mandatory"))
+	}
+	if spec.KbsAuthSecretName == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("kbsAuthSecretName"), "the KBS admin public key Secret is mandatory"))
+	}
+
+	if spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		if spec.KbsAsConfigMapName == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("kbsAsConfigMapName"),
+				"the Attestation Service ConfigMap is required when kbsDeploymentType is MicroservicesDeployment"))
+		}
+		if spec.KbsRvpsConfigMapName == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("kbsRvpsConfigMapName"),
+				"the RVPS ConfigMap is required when kbsDeploymentType is MicroservicesDeployment"))
+		}
+	}
+
+	allErrs = append(allErrs, validateSecretPair(specPath,
+		"kbsHttpsKeySecretName", spec.KbsHttpsKeySecretName,
+		"kbsHttpsCertSecretName", spec.KbsHttpsCertSecretName)...)
+	allErrs = append(allErrs, validateSecretPair(specPath,
+		"kbsAttestationKeySecretName", spec.KbsAttestationKeySecretName,
+		"kbsAttestationCertSecretName", spec.KbsAttestationCertSecretName)...)
+
+	allErrs = append(allErrs, validateKbsDeploymentSpec(spec.KbsDeploymentSpec, specPath.Child("KbsDeploymentSpec"))...)
+	if shared := spec.KbsSharedStorageSpec; shared != nil {
+		allErrs = append(allErrs, validateSharedStorage(shared, specPath.Child("sharedStorage"))...)
+	}
+	allErrs = append(allErrs, validateVaultResources(spec.KbsVaultResources, specPath.Child("kbsVaultResources"))...)
+
+	// Secrets are mounted as volumes named after the secret, so names must be unique
+	volumeNames := map[string]bool{}
+	for _, name := range reservedVolumeNames {
+		volumeNames[name] = true
+	}
+	for i, secretName := range spec.KbsSecretResources {
+		fldPath := specPath.Child("kbsSecretResources").Index(i)
+		if secretName == "" {
+			allErrs = append(allErrs, field.Required(fldPath, "secret name must not be empty"))
+			continue
+		}
+		if volumeNames[secretName] {
+			allErrs = append(allErrs, field.Duplicate(fldPath, secretName))
+			continue
+		}
+		volumeNames[secretName] = true
+	}
+	for i, entry := range spec.KbsSecretResourceEntries {
+		fldPath := specPath.Child("kbsSecretResourceEntries").Index(i)
+		if entry.SecretName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("secretName"), "secret name must not be empty"))
+			continue
+		}
+		if volumeNames[entry.SecretName] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("secretName"), entry.SecretName))
+			continue
+		}
+		volumeNames[entry.SecretName] = true
+		keys := map[string]bool{}
+		for j, key := range entry.Keys {
+			if keys[key.Key] {
+				allErrs = append(allErrs, field.Duplicate(fldPath.Child("keys").Index(j).Child("key"), key.Key))
+			}
+			keys[key.Key] = true
+		}
+	}
+	for i, secretName := range spec.KbsRolloutExcludedSecrets {
+		if secretName == "" {
+			allErrs = append(allErrs, field.Required(specPath.Child("kbsRolloutExcludedSecrets").Index(i), "secret name must not be empty"))
+		}
+	}
+	for i, certCacheEntry := range spec.KbsLocalCertCacheSpec.Secrets {
+		fldPath := specPath.Child("kbsLocalCertCacheSpec", "secrets").Index(i).Child("secretName")
+		if certCacheEntry.SecretName == "" {
+			allErrs = append(allErrs, field.Required(fldPath, "secret name must not be empty"))
+			continue
+		}
+		if volumeNames[certCacheEntry.SecretName] {
+			allErrs = append(allErrs, field.Duplicate(fldPath, certCacheEntry.SecretName))
+			continue
+		}
+		volumeNames[certCacheEntry.SecretName] = true
+	}
+
+	for _, dir := range storageDirs(spec.KbsStorageSpec) {
+		if dir.spec == nil {
+			continue
+		}
+		fldPath := specPath.Child("storage", dir.field)
+		switch dir.spec.Type {
+		case confidentialcontainersorgv1alpha1.StorageTypeExistingPVC:
+			if dir.spec.ClaimName == "" {
+				allErrs = append(allErrs, field.Required(fldPath.Child("claimName"), "claimName is required when type is ExistingPVC"))
+			}
+		case confidentialcontainersorgv1alpha1.StorageTypeManagedPVC:
+			if dir.spec.Size != nil && dir.spec.Size.Sign() <= 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("size"), dir.spec.Size.String(), "must be greater than 0"))
+			}
+		}
+	}
+
+	allErrs = append(allErrs, validateExposure(spec, specPath.Child("exposure"))...)
+
+	return allErrs
+}
+
+// validateExposure checks that the exposure options match the exposure type and the KBS protocol
+func validateExposure(spec confidentialcontainersorgv1alpha1.KbsConfigSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	exposure := spec.KbsExposureSpec
+	if exposure == nil {
+		return nil
+	}
+	https := spec.KbsHttpsKeySecretName != "" && spec.KbsHttpsCertSecretName != ""
+
+	switch exposure.Type {
+	case confidentialcontainersorgv1alpha1.ExposureTypeRoute:
+		if exposure.Route == nil {
+			break
+		}
+		switch exposure.Route.Termination {
+		case confidentialcontainersorgv1alpha1.RouteTerminationPassthrough, confidentialcontainersorgv1alpha1.RouteTerminationReencrypt:
+			if !https {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("route", "termination"), exposure.Route.Termination,
+					"requires KBS to serve HTTPS, set kbsHttpsKeySecretName and kbsHttpsCertSecretName"))
+			}
+		case confidentialcontainersorgv1alpha1.RouteTerminationEdge:
+			if https {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("route", "termination"), exposure.Route.Termination,
+					"requires KBS to serve plain HTTP, use passthrough or reencrypt when HTTPS is configured"))
+			}
+		}
+	case confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute:
+		if exposure.HTTPRoute == nil || exposure.HTTPRoute.GatewayName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("httpRoute", "gatewayName"), "the Gateway is required when type is HTTPRoute"))
+		}
+	case confidentialcontainersorgv1alpha1.ExposureTypeIngress:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), exposure.Type, []string{
+			string(confidentialcontainersorgv1alpha1.ExposureTypeIngress),
+			string(confidentialcontainersorgv1alpha1.ExposureTypeRoute),
+			string(confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute),
+		}))
+	}
+	return allErrs
+}
+
+// exposureWarnings returns warnings for exposure options that are ignored or need extra configuration
+func exposureWarnings(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) admission.Warnings {
+	var warnings admission.Warnings
+	exposure := spec.KbsExposureSpec
+	if exposure == nil {
+		return nil
+	}
+	https := spec.KbsHttpsKeySecretName != "" && spec.KbsHttpsCertSecretName != ""
+
+	if exposure.Type != confidentialcontainersorgv1alpha1.ExposureTypeIngress && exposure.Ingress != nil {
+		warnings = append(warnings, fmt.Sprintf("spec.exposure.ingress is ignored when the exposure type is %s", exposure.Type))
+	}
+	if exposure.Type != confidentialcontainersorgv1alpha1.ExposureTypeRoute && exposure.Route != nil {
+		warnings = append(warnings, fmt.Sprintf("spec.exposure.route is ignored when the exposure type is %s", exposure.Type))
+	}
+	if exposure.Type != confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute && exposure.HTTPRoute != nil {
+		warnings = append(warnings, fmt.Sprintf("spec.exposure.httpRoute is ignored when the exposure type is %s", exposure.Type))
+	}
+	if https && exposure.Type == confidentialcontainersorgv1alpha1.ExposureTypeIngress {
+		warnings = append(warnings, "spec.exposure: KBS serves HTTPS, the Ingress relies on the nginx.ingress.kubernetes.io/backend-protocol annotation, "+
+			"other ingress controllers need an equivalent annotation in spec.exposure.annotations")
+	}
+	if https && exposure.Type == confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute {
+		warnings = append(warnings, "spec.exposure: KBS serves HTTPS, a BackendTLSPolicy is required for the Gateway to reach the KBS service")
+	}
+	return warnings
+}
+
+// storageDir is a KBS storage directory together with its field name in the storage section
+type storageDir struct {
+	field string
+	spec  *confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec
+}
+
+func storageDirs(storage confidentialcontainersorgv1alpha1.KbsStorageSpec) []storageDir {
+	return []storageDir{
+		{"baseStorageDir", storage.BaseStorageDir},
+		{"repositoryDir", storage.RepositoryDir},
+		{"rvpsDir", storage.RvpsDir},
+		{"attestationPolicyDir", storage.AttestationPolicyDir},
+	}
+}
+
+// validateKbsDeploymentSpec checks the replicas, the disruption budget and the autoscaling of the KBS deployment
+func validateKbsDeploymentSpec(deployment confidentialcontainersorgv1alpha1.KbsDeploymentSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if replicas := deployment.Replicas; replicas != nil && *replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("replicas"), *replicas, "must be greater than or equal to 0"))
+	}
+	if pdb := deployment.PodDisruptionBudget; pdb != nil && pdb.MinAvailable != nil && pdb.MaxUnavailable != nil {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("podDisruptionBudget"),
+			"only one of minAvailable and maxUnavailable can be set"))
+	}
+	if autoscaling := deployment.Autoscaling; autoscaling != nil && autoscaling.MinReplicas != nil &&
+		*autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("autoscaling", "minReplicas"), *autoscaling.MinReplicas,
+			"must be less than or equal to maxReplicas"))
+	}
+	return allErrs
+}
+
+// validateVaultResources checks that the Vault sources have a server address, a role and a path
+// whose last element can be the type of the resources
+func validateVaultResources(sources []confidentialcontainersorgv1alpha1.KbsVaultResourceSource, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, source := range sources {
+		sourcePath := fldPath.Index(i)
+		if address, err := url.Parse(source.Address); err != nil || (address.Scheme != "http" && address.Scheme != "https") || address.Host == "" {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("address"), source.Address, "must be an http or https URL"))
+		}
+		if source.Role == "" {
+			allErrs = append(allErrs, field.Required(sourcePath.Child("role"), "the role of the Kubernetes auth method is required"))
+		}
+		trimmed := strings.Trim(source.Path, "/")
+		if trimmed == "" {
+			allErrs = append(allErrs, field.Required(sourcePath.Child("path"), "the path of the secret is required"))
+		} else if source.Type == "" && !resourcePathElement.MatchString(path.Base(trimmed)) {
+			allErrs = append(allErrs, field.Invalid(sourcePath.Child("path"), source.Path,
+				"the last element of the path is not a valid resource type, set type"))
+		}
+		keys := map[string]bool{}
+		for j, key := range source.Keys {
+			if keys[key.Key] {
+				allErrs = append(allErrs, field.Duplicate(sourcePath.Child("keys").Index(j).Child("key"), key.Key))
+			}
+			keys[key.Key] = true
+		}
+	}
+	return allErrs
+}
+
+// validateSharedStorage checks that an existing shared database comes with its address and credentials
+func validateSharedStorage(shared *confidentialcontainersorgv1alpha1.KbsSharedStorageSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch shared.Type {
+	case confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres:
+		if managed := shared.ManagedPostgres; managed != nil && managed.Storage != nil &&
+			managed.Storage.Type == confidentialcontainersorgv1alpha1.StorageTypeExistingPVC && managed.Storage.ClaimName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("managedPostgres", "storage", "claimName"),
+				"claimName is required when type is ExistingPVC"))
+		}
+	case confidentialcontainersorgv1alpha1.SharedStoragePostgres:
+		postgres := shared.Postgres
+		if postgres == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("postgres"), "postgres is required when type is Postgres"))
+			break
+		}
+		if postgres.Host == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("postgres", "host"), "the database host is mandatory"))
+		}
+		if postgres.CredentialsSecretName == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("postgres", "credentialsSecretName"),
+				"the database credentials Secret is mandatory"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), shared.Type,
+			[]string{string(confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres), string(confidentialcontainersorgv1alpha1.SharedStoragePostgres)}))
+	}
+	return allErrs
+}
+
+// sharedStorageWarnings returns warnings for the shared storage settings that don't apply to its type
+func sharedStorageWarnings(shared *confidentialcontainersorgv1alpha1.KbsSharedStorageSpec, fieldPath string) admission.Warnings {
+	var warnings admission.Warnings
+	if shared == nil {
+		return nil
+	}
+	if shared.Type != confidentialcontainersorgv1alpha1.SharedStoragePostgres && shared.Postgres != nil {
+		warnings = append(warnings, fmt.Sprintf("%s.postgres is ignored when the shared storage type is %s", fieldPath, shared.Type))
+	}
+	if shared.Type != confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres && shared.ManagedPostgres != nil {
+		warnings = append(warnings, fmt.Sprintf("%s.managedPostgres is ignored when the shared storage type is %s", fieldPath, shared.Type))
+	}
+	return warnings
+}
+
+// kbsReplicaRange returns the lowest and highest replica count of the KBS deployment
+func kbsReplicaRange(deployment confidentialcontainersorgv1alpha1.KbsDeploymentSpec) (int32, int32) {
+	if autoscaling := deployment.Autoscaling; autoscaling != nil {
+		minReplicas := int32(1)
+		if autoscaling.MinReplicas != nil {
+			minReplicas = *autoscaling.MinReplicas
+		}
+		return minReplicas, autoscaling.MaxReplicas
+	}
+	if deployment.Replicas != nil {
+		return *deployment.Replicas, *deployment.Replicas
+	}
+	return 1, 1
+}
+
+// validateSecretPair rejects a key/certificate secret pair where only one side is set
+func validateSecretPair(specPath *field.Path, keyField, keyName, certField, certName string) field.ErrorList {
+	var allErrs field.ErrorList
+	if keyName != "" && certName == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child(certField), fmt.Sprintf("must be set together with %s", keyField)))
+	}
+	if keyName == "" && certName != "" {
+		allErrs = append(allErrs, field.Required(specPath.Child(keyField), fmt.Sprintf("must be set together with %s", certField)))
+	}
+	return allErrs
+}
+
+// kbsConfigSpecWarnings returns warnings for settings that are accepted but have no effect
+func kbsConfigSpecWarnings(spec confidentialcontainersorgv1alpha1.KbsConfigSpec) admission.Warnings {
+	var warnings admission.Warnings
+	if spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+		if spec.KbsAsConfigMapName != "" {
+			warnings = append(warnings, "spec.kbsAsConfigMapName is ignored when kbsDeploymentType is AllInOneDeployment")
+		}
+		if spec.KbsRvpsConfigMapName != "" {
+			warnings = append(warnings, "spec.kbsRvpsConfigMapName is ignored when kbsDeploymentType is AllInOneDeployment")
+		}
+		if spec.KbsMicroservicesSpec != nil {
+			warnings = append(warnings, "spec.kbsMicroservicesSpec is ignored when kbsDeploymentType is AllInOneDeployment")
+		}
+	}
+	if ms := spec.KbsMicroservicesSpec; ms != nil && !ms.SeparateDeployments &&
+		(ms.AsDeploymentSpec.Replicas != nil || ms.RvpsDeploymentSpec.Replicas != nil) {
+		warnings = append(warnings, "the replicas of spec.kbsMicroservicesSpec are ignored without separateDeployments, "+
+			"the attestation service and RVPS run in the KBS pods")
+	}
+
+	minReplicas, maxReplicas := kbsReplicaRange(spec.KbsDeploymentSpec)
+	if pdb := spec.KbsDeploymentSpec.PodDisruptionBudget; pdb != nil && pdb.MinAvailable != nil &&
+		pdb.MinAvailable.Type == intstr.Int && pdb.MinAvailable.IntVal >= minReplicas {
+		warnings = append(warnings, "spec.KbsDeploymentSpec.podDisruptionBudget: minAvailable is not lower than the number of KBS "+
+			"replicas, the KBS pods cannot be evicted and node drains are blocked")
+	}
+
+	multipleReplicas := maxReplicas > 1
+	for _, dir := range storageDirs(spec.KbsStorageSpec) {
+		if dir.spec == nil {
+			continue
+		}
+		fieldPath := "spec.storage." + dir.field
+		if dir.spec.Type != confidentialcontainersorgv1alpha1.StorageTypeManagedPVC &&
+			(dir.spec.Size != nil || dir.spec.StorageClassName != nil || dir.spec.AccessMode != "") {
+			warnings = append(warnings, fmt.Sprintf("%s: size, storageClassName and accessMode are ignored unless type is ManagedPVC", fieldPath))
+		}
+		if dir.spec.Type != confidentialcontainersorgv1alpha1.StorageTypeExistingPVC && dir.spec.ClaimName != "" {
+			warnings = append(warnings, fmt.Sprintf("%s: claimName is ignored unless type is ExistingPVC", fieldPath))
+		}
+		if multipleReplicas && dir.spec.Type == confidentialcontainersorgv1alpha1.StorageTypeManagedPVC && dir.spec.AccessMode != corev1.ReadWriteMany {
+			warnings = append(warnings, fmt.Sprintf("%s: the managed PersistentVolumeClaim is not ReadWriteMany, replicas scheduled on different nodes will fail to mount it", fieldPath))
+		}
+	}
+
+	warnings = append(warnings, sharedStorageWarnings(spec.KbsSharedStorageSpec, "spec.sharedStorage")...)
+	warnings = append(warnings, exposureWarnings(spec)...)
+	return warnings
+}
+
+// configMapReference is a ConfigMap referenced by the KbsConfig spec together with
+// the key the operator mounts out of it
+type configMapReference struct {
+	field string
+	name  string
+	key   string
+}
+
+// validateReferences checks that the referenced ConfigMaps and Secrets carry the expected keys.
+// Missing objects only produce warnings: they may be created after the KbsConfig (e.g. by
+// GitOps tools applying resources in any order) and are reported by the ConfigResolved condition.
+func (v *KbsConfigCustomValidator) validateReferences(ctx context.Context, spec confidentialcontainersorgv1alpha1.KbsConfigSpec, specPath *field.Path) (field.ErrorList, admission.Warnings) {
+	var allErrs field.ErrorList
+	var warnings admission.Warnings
+	if v.Client == nil {
+		return nil, nil
+	}
+
+	configMaps := []configMapReference{
+		{"kbsConfigMapName", spec.KbsConfigMapName, "kbs-config.toml"},
+		{"kbsRvpsRefValuesConfigMapName", spec.KbsRvpsRefValuesConfigMapName, "reference_value"},
+		{"kbsAttestationPolicyConfigMapName", spec.KbsAttestationPolicyConfigMapName, "default_cpu.rego"},
+		{"kbsGpuAttestationPolicyConfigMapName", spec.KbsGpuAttestationPolicyConfigMapName, "default_gpu.rego"},
+		{"kbsResourcePolicyConfigMapName", spec.KbsResourcePolicyConfigMapName, "resource-policy.rego"},
+	}
+	if spec.DeploymentType() == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		configMaps = append(configMaps,
+			configMapReference{"kbsAsConfigMapName", spec.KbsAsConfigMapName, "as-config.json"},
+			configMapReference{"kbsRvpsConfigMapName", spec.KbsRvpsConfigMapName, "rvps-config.json"})
+	}
+	for i, source := range spec.KbsVaultResources {
+		configMaps = append(configMaps,
+			configMapReference{fmt.Sprintf("kbsVaultResources[%d].caConfigMapName", i), source.CAConfigMapName, "ca.crt"})
+	}
+	for _, ref := range configMaps {
+		if ref.name == "" {
+			continue
+		}
+		configMap := &corev1.ConfigMap{}
+		err := v.Client.Get(ctx, client.ObjectKey{Namespace: v.Namespace, Name: ref.name}, configMap)
+		if err != nil {
+			warnings = append(warnings, lookupWarning("ConfigMap", ref.name, "spec."+ref.field, v.Namespace, err))
+			continue
+		}
+		if _, ok := configMap.Data[ref.key]; !ok {
+			allErrs = append(allErrs, field.Invalid(specPath.Child(ref.field), ref.name,
+				fmt.Sprintf("ConfigMap %s/%s must contain the key %q", v.Namespace, ref.name, ref.key)))
+		}
+	}
+
+	secrets := []struct {
+		fldPath *field.Path
+		name    string
+	}{
+		{specPath.Child("kbsAuthSecretName"), spec.KbsAuthSecretName},
+		{specPath.Child("kbsAdminPublicKeysSecretName"), spec.KbsAdminPublicKeysSecretName},
+		{specPath.Child("kbsHttpsKeySecretName"), spec.KbsHttpsKeySecretName},
+		{specPath.Child("kbsHttpsCertSecretName"), spec.KbsHttpsCertSecretName},
+		{specPath.Child("kbsAttestationKeySecretName"), spec.KbsAttestationKeySecretName},
+		{specPath.Child("kbsAttestationCertSecretName"), spec.KbsAttestationCertSecretName},
+	}
+	if ref := spec.KbsItaApiKeySecretRef; ref != nil {
+		secrets = append(secrets, struct {
+			fldPath *field.Path
+			name    string
+		}{specPath.Child("kbsItaApiKeySecretRef", "name"), ref.Name})
+	}
+	if shared := spec.KbsSharedStorageSpec; shared != nil && shared.Type == confidentialcontainersorgv1alpha1.SharedStoragePostgres && shared.Postgres != nil {
+		secrets = append(secrets, struct {
+			fldPath *field.Path
+			name    string
+		}{specPath.Child("sharedStorage", "postgres", "credentialsSecretName"), shared.Postgres.CredentialsSecretName})
+	}
+	for i, secretName := range spec.KbsSecretResources {
+		secrets = append(secrets, struct {
+			fldPath *field.Path
+			name    string
+		}{specPath.Child("kbsSecretResources").Index(i), secretName})
+	}
+	for i, entry := range spec.KbsSecretResourceEntries {
+		secrets = append(secrets, struct {
+			fldPath *field.Path
+			name    string
+		}{specPath.Child("kbsSecretResourceEntries").Index(i).Child("secretName"), entry.SecretName})
+	}
+	for _, ref := range secrets {
+		if ref.name == "" {
+			continue
+		}
+		secret := &corev1.Secret{}
+		err := v.Client.Get(ctx, client.ObjectKey{Namespace: v.Namespace, Name: ref.name}, secret)
+		if err != nil {
+			warnings = append(warnings, lookupWarning("Secret", ref.name, ref.fldPath.String(), v.Namespace, err))
+			continue
+		}
+		if len(secret.Data) == 0 && len(secret.StringData) == 0 {
+			allErrs = append(allErrs, field.Invalid(ref.fldPath, ref.name,
+				fmt.Sprintf("Secret %s/%s has no data", v.Namespace, ref.name)))
+		}
+	}
+
+	for _, dir := range storageDirs(spec.KbsStorageSpec) {
+		if dir.spec == nil || dir.spec.Type != confidentialcontainersorgv1alpha1.StorageTypeExistingPVC || dir.spec.ClaimName == "" {
+			continue
+		}
+		fldPath := specPath.Child("storage", dir.field, "claimName")
+		err := v.Client.Get(ctx, client.ObjectKey{Namespace: v.Namespace, Name: dir.spec.ClaimName}, &corev1.PersistentVolumeClaim{})
+		if err != nil {
+			warnings = append(warnings, lookupWarning("PersistentVolumeClaim", dir.spec.ClaimName, fldPath.String(), v.Namespace, err))
+		}
+	}
+
+	return allErrs, warnings
+}
+
+// lookupWarning returns the warning for a referenced object that could not be retrieved
+func lookupWarning(kind, name, fieldPath, namespace string, err error) string {
+	if k8serrors.IsNotFound(err) {
+		return fmt.Sprintf("%s %s/%s referenced by %s does not exist yet, the resource will not become ready until it is created",
+			kind, namespace, name, fieldPath)
+	}
+	return fmt.Sprintf("unable to verify %s %s/%s referenced by %s: %v", kind, namespace, name, fieldPath, err)
+}
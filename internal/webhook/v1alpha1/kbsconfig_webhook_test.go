@@ -0,0 +1,329 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+const testNamespace = "trustee-operator-system"
+
+func newTestClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := confidentialcontainersorgv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+}
+
+func newValidKbsConfig() *confidentialcontainersorgv1alpha1.KbsConfig {
+	return &confidentialcontainersorgv1alpha1.KbsConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "kbsconfig", Namespace: testNamespace},
+		Spec: confidentialcontainersorgv1alpha1.KbsConfigSpec{
+			KbsConfigMapName:  "kbs-config",
+			KbsAuthSecretName: "kbs-auth",
+		},
+	}
+}
+
+func kbsConfigReferences() []client.Object {
+	return []client.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kbs-config", Namespace: testNamespace},
+			Data:       map[string]string{"kbs-config.toml": ""},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "kbs-auth", Namespace: testNamespace},
+			Data:       map[string][]byte{"publicKey": []byte("key")},
+		},
+	}
+}
+
+func TestDefaultKbsConfigSpec(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.KbsConfigSpec{}
+	defaultKbsConfigSpec(&spec)
+	if spec.KbsDeploymentType != confidentialcontainersorgv1alpha1.DeploymentTypeAllInOne {
+		t.Errorf("expected AllInOneDeployment by default, got %q", spec.KbsDeploymentType)
+	}
+	if spec.KbsServiceType != corev1.ServiceTypeClusterIP {
+		t.Errorf("expected ClusterIP by default, got %q", spec.KbsServiceType)
+	}
+	if spec.KbsDeploymentSpec.Replicas == nil || *spec.KbsDeploymentSpec.Replicas != 1 {
+		t.Errorf("expected 1 replica by default, got %v", spec.KbsDeploymentSpec.Replicas)
+	}
+
+	spec = confidentialcontainersorgv1alpha1.KbsConfigSpec{KbsAsConfigMapName: "as", KbsRvpsConfigMapName: "rvps"}
+	defaultKbsConfigSpec(&spec)
+	if spec.KbsDeploymentType != confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		t.Errorf("expected MicroservicesDeployment when AS and RVPS ConfigMaps are set, got %q", spec.KbsDeploymentType)
+	}
+
+	spec = confidentialcontainersorgv1alpha1.KbsConfigSpec{KbsServiceType: corev1.ServiceTypeNodePort}
+	defaultKbsConfigSpec(&spec)
+	if spec.KbsServiceType != corev1.ServiceTypeNodePort {
+		t.Errorf("expected an explicit service type to be kept, got %q", spec.KbsServiceType)
+	}
+}
+
+func TestValidateKbsConfigSpec(t *testing.T) {
+	tests := []struct {
+		name      string
+		mutate    func(*confidentialcontainersorgv1alpha1.KbsConfigSpec)
+		wantField string
+	}{
+		{"missing config map", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) { s.KbsConfigMapName = "" }, "spec.kbsConfigMapName"},
+		{"microservices without AS config", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsDeploymentType = confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices
+			s.KbsRvpsConfigMapName = "rvps"
+		}, "spec.kbsAsConfigMapName"},
+		{"https key without cert", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) { s.KbsHttpsKeySecretName = "key" }, "spec.kbsHttpsCertSecretName"},
+		{"attestation cert without key", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) { s.KbsAttestationCertSecretName = "cert" }, "spec.kbsAttestationKeySecretName"},
+		{"duplicate secret resource", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSecretResources = []string{"kbsres1", "kbsres1"}
+		}, "spec.kbsSecretResources[1]"},
+		{"secret resource entry listed twice", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSecretResources = []string{"kbsres1"}
+			s.KbsSecretResourceEntries = []confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{{SecretName: "kbsres1", Repository: "tenant-a"}}
+		}, "spec.kbsSecretResourceEntries[0].secretName"},
+		{"duplicate secret resource entry key", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSecretResourceEntries = []confidentialcontainersorgv1alpha1.KbsSecretResourceEntry{{
+				SecretName: "kbsres1",
+				Keys: []confidentialcontainersorgv1alpha1.KbsSecretResourceKey{
+					{Key: "key1", Tag: "a"},
+					{Key: "key1", Tag: "b"},
+				},
+			}}
+		}, "spec.kbsSecretResourceEntries[0].keys[1].key"},
+		{"vault source without role", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsVaultResources = []confidentialcontainersorgv1alpha1.KbsVaultResourceSource{{Address: "https://vault:8200", Path: "kbs/keys"}}
+		}, "spec.kbsVaultResources[0].role"},
+		{"vault source with invalid address", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsVaultResources = []confidentialcontainersorgv1alpha1.KbsVaultResourceSource{{Address: "vault:8200", Role: "kbs", Path: "kbs/keys"}}
+		}, "spec.kbsVaultResources[0].address"},
+		{"vault path without valid type", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsVaultResources = []confidentialcontainersorgv1alpha1.KbsVaultResourceSource{{Address: "https://vault:8200", Role: "kbs", Path: "kbs/.keys"}}
+		}, "spec.kbsVaultResources[0].path"},
+		{"existing PVC without claim", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsStorageSpec.RepositoryDir = &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{Type: confidentialcontainersorgv1alpha1.StorageTypeExistingPVC}
+		}, "spec.storage.repositoryDir.claimName"},
+		{"reserved volume name", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSecretResources = []string{"kbs-config"}
+		}, "spec.kbsSecretResources[0]"},
+		{"httpRoute without gateway", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsExposureSpec = &confidentialcontainersorgv1alpha1.KbsExposureSpec{Type: confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute}
+		}, "spec.exposure.httpRoute.gatewayName"},
+		{"passthrough route without https", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsExposureSpec = &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+				Type:  confidentialcontainersorgv1alpha1.ExposureTypeRoute,
+				Route: &confidentialcontainersorgv1alpha1.KbsRouteSpec{Termination: confidentialcontainersorgv1alpha1.RouteTerminationPassthrough},
+			}
+		}, "spec.exposure.route.termination"},
+		{"edge route with https", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsHttpsKeySecretName = "key"
+			s.KbsHttpsCertSecretName = "cert"
+			s.KbsExposureSpec = &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+				Type:  confidentialcontainersorgv1alpha1.ExposureTypeRoute,
+				Route: &confidentialcontainersorgv1alpha1.KbsRouteSpec{Termination: confidentialcontainersorgv1alpha1.RouteTerminationEdge},
+			}
+		}, "spec.exposure.route.termination"},
+		{"disruption budget with minAvailable and maxUnavailable", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			minAvailable, maxUnavailable := intstr.FromInt32(1), intstr.FromString("50%")
+			s.KbsDeploymentSpec.PodDisruptionBudget = &confidentialcontainersorgv1alpha1.KbsPodDisruptionBudgetSpec{
+				MinAvailable:   &minAvailable,
+				MaxUnavailable: &maxUnavailable,
+			}
+		}, "spec.KbsDeploymentSpec.podDisruptionBudget"},
+		{"autoscaling minReplicas above maxReplicas", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			minReplicas := int32(4)
+			s.KbsDeploymentSpec.Autoscaling = &confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{MinReplicas: &minReplicas, MaxReplicas: 3}
+		}, "spec.KbsDeploymentSpec.autoscaling.minReplicas"},
+		{"empty rollout excluded secret", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsRolloutExcludedSecrets = []string{""}
+		}, "spec.kbsRolloutExcludedSecrets[0]"},
+		{"postgres shared storage without postgres", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSharedStorageSpec = &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{Type: confidentialcontainersorgv1alpha1.SharedStoragePostgres}
+		}, "spec.sharedStorage.postgres"},
+		{"postgres shared storage without credentials", func(s *confidentialcontainersorgv1alpha1.KbsConfigSpec) {
+			s.KbsSharedStorageSpec = &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+				Type:     confidentialcontainersorgv1alpha1.SharedStoragePostgres,
+				Postgres: &confidentialcontainersorgv1alpha1.PostgresEndpointSpec{Host: "postgres.db.svc"},
+			}
+		}, "spec.sharedStorage.postgres.credentialsSecretName"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := newValidKbsConfig().Spec
+			tt.mutate(&spec)
+			errs := validateKbsConfigSpec(spec, field.NewPath("spec"))
+			found := false
+			for _, err := range errs {
+				if err.Field == tt.wantField {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected an error on %s, got %v", tt.wantField, errs)
+			}
+		})
+	}
+
+	if errs := validateKbsConfigSpec(newValidKbsConfig().Spec, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+}
+
+func TestValidateKbsConfigReferences(t *testing.T) {
+	ctx := context.Background()
+
+	// all references exist
+	v := &KbsConfigCustomValidator{Client: newTestClient(t, kbsConfigReferences()...), Namespace: testNamespace}
+	warnings, err := v.ValidateCreate(ctx, newValidKbsConfig())
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("expected no error and no warning, got %v, %v", err, warnings)
+	}
+
+	// a missing reference is only a warning
+	kbsConfig := newValidKbsConfig()
+	kbsConfig.Spec.KbsSecretResources = []string{"kbsres1"}
+	warnings, err = v.ValidateCreate(ctx, kbsConfig)
+	if err != nil {
+		t.Errorf("expected a missing Secret not to be rejected, got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "kbsres1") {
+		t.Errorf("expected a warning about kbsres1, got %v", warnings)
+	}
+
+	// a ConfigMap without the expected key is rejected
+	badConfigMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "resource-policy", Namespace: testNamespace},
+		Data:       map[string]string{"policy.rego": ""},
+	}
+	v.Client = newTestClient(t, append(kbsConfigReferences(), badConfigMap)...)
+	kbsConfig = newValidKbsConfig()
+	kbsConfig.Spec.KbsResourcePolicyConfigMapName = "resource-policy"
+	_, err = v.ValidateCreate(ctx, kbsConfig)
+	if !k8serrors.IsInvalid(err) || !strings.Contains(err.Error(), "resource-policy.rego") {
+		t.Errorf("expected an Invalid error about the missing key, got %v", err)
+	}
+}
+
+func TestValidateKbsConfigUpdate(t *testing.T) {
+	v := &KbsConfigCustomValidator{Client: newTestClient(t), Namespace: testNamespace}
+	invalid := newValidKbsConfig()
+	invalid.Spec.KbsConfigMapName = ""
+
+	// metadata-only updates are never blocked
+	updated := invalid.DeepCopy()
+	updated.Finalizers = nil
+	if _, err := v.ValidateUpdate(context.Background(), invalid, updated); err != nil {
+		t.Errorf("expected an unchanged spec to be accepted, got %v", err)
+	}
+
+	if _, err := v.ValidateUpdate(context.Background(), newValidKbsConfig(), invalid); err == nil {
+		t.Error("expected a spec change dropping kbsConfigMapName to be rejected")
+	}
+}
+
+func TestKbsConfigStorageWarnings(t *testing.T) {
+	replicas := int32(2)
+	spec := newValidKbsConfig().Spec
+	spec.KbsDeploymentSpec.Replicas = &replicas
+	spec.KbsStorageSpec.RepositoryDir = &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{Type: confidentialcontainersorgv1alpha1.StorageTypeManagedPVC}
+	spec.KbsStorageSpec.RvpsDir = &confidentialcontainersorgv1alpha1.KbsStorageVolumeSpec{
+		Type:       confidentialcontainersorgv1alpha1.StorageTypeManagedPVC,
+		AccessMode: corev1.ReadWriteMany,
+	}
+
+	warnings := kbsConfigSpecWarnings(spec)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "spec.storage.repositoryDir") {
+		t.Errorf("expected a single ReadWriteMany warning for repositoryDir, got %v", warnings)
+	}
+}
+
+func TestKbsConfigExposureWarnings(t *testing.T) {
+	spec := newValidKbsConfig().Spec
+	spec.KbsHttpsKeySecretName = "key"
+	spec.KbsHttpsCertSecretName = "cert"
+	spec.KbsExposureSpec = &confidentialcontainersorgv1alpha1.KbsExposureSpec{
+		Type:      confidentialcontainersorgv1alpha1.ExposureTypeHTTPRoute,
+		Ingress:   &confidentialcontainersorgv1alpha1.KbsIngressSpec{TlsSecretName: "tls"},
+		HTTPRoute: &confidentialcontainersorgv1alpha1.KbsHTTPRouteSpec{GatewayName: "gateway"},
+	}
+
+	if errs := validateKbsConfigSpec(spec, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+	warnings := kbsConfigSpecWarnings(spec)
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "spec.exposure.ingress") || !strings.Contains(warnings[1], "BackendTLSPolicy") {
+		t.Errorf("expected warnings for the ignored ingress options and the BackendTLSPolicy, got %v", warnings)
+	}
+}
+
+func TestKbsConfigSharedStorageWarnings(t *testing.T) {
+	spec := newValidKbsConfig().Spec
+	spec.KbsSharedStorageSpec = &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+		Type:     confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres,
+		Postgres: &confidentialcontainersorgv1alpha1.PostgresEndpointSpec{Host: "postgres.db.svc", CredentialsSecretName: "postgres"},
+	}
+
+	if errs := validateKbsConfigSpec(spec, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+	warnings := kbsConfigSpecWarnings(spec)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "spec.sharedStorage.postgres") {
+		t.Errorf("expected a warning on the ignored postgres endpoint, got %v", warnings)
+	}
+}
+
+func TestKbsConfigDisruptionBudgetWarnings(t *testing.T) {
+	replicas := int32(2)
+	minAvailable := intstr.FromInt32(2)
+	spec := newValidKbsConfig().Spec
+	spec.KbsDeploymentSpec.Replicas = &replicas
+	spec.KbsDeploymentSpec.PodDisruptionBudget = &confidentialcontainersorgv1alpha1.KbsPodDisruptionBudgetSpec{MinAvailable: &minAvailable}
+
+	warnings := kbsConfigSpecWarnings(spec)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "podDisruptionBudget") {
+		t.Errorf("expected a warning on the blocking disruption budget, got %v", warnings)
+	}
+
+	// The autoscaler keeps more replicas than minAvailable
+	minReplicas := int32(3)
+	spec.KbsDeploymentSpec.Autoscaling = &confidentialcontainersorgv1alpha1.KbsAutoscalingSpec{MinReplicas: &minReplicas, MaxReplicas: 5}
+	if warnings := kbsConfigSpecWarnings(spec); len(warnings) != 0 {
+		t.Errorf("expected no warnings with autoscaling, got %v", warnings)
+	}
+}
@@ -0,0 +1,424 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+var trusteeconfiglog = logf.Log.WithName("trusteeconfig-resource")
+
+const (
+	// defaultTlsProfile is the TLS profile applied when TrusteeConfig does not set one
+	defaultTlsProfile = "intermediate"
+
+	// minCertificateDuration is the shortest accepted validity of the certificates issued for KBS
+	minCertificateDuration = time.Hour
+
+	// defaultAdminKeyGracePeriod is how long the previous admin public key is kept, matching the CRD default
+	defaultAdminKeyGracePeriod = 24 * time.Hour
+
+	// minAdminKeyRotationInterval is the shortest accepted admin key rotation interval
+	minAdminKeyRotationInterval = time.Hour
+)
+
+// SetupTrusteeConfigWebhookWithManager registers the defaulting and validating webhooks for TrusteeConfig.
+func SetupTrusteeConfigWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, &confidentialcontainersorgv1alpha1.TrusteeConfig{}).
+		WithDefaulter(&TrusteeConfigCustomDefaulter{}).
+		WithValidator(&TrusteeConfigCustomValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-confidentialcontainers-org-v1alpha1-trusteeconfig,mutating=true,failurePolicy=fail,sideEffects=None,groups=confidentialcontainers.org,resources=trusteeconfigs,verbs=create;update,versions=v1alpha1,name=mtrusteeconfig-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// TrusteeConfigCustomDefaulter sets default values on the TrusteeConfig spec
+type TrusteeConfigCustomDefaulter struct{}
+
+// Default implements admission.Defaulter
+func (d *TrusteeConfigCustomDefaulter) Default(_ context.Context, trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) error {
+	trusteeconfiglog.V(1).Info("Defaulting TrusteeConfig", "name", trusteeConfig.Name)
+	defaultTrusteeConfigSpec(&trusteeConfig.Spec)
+	return nil
+}
+
+// defaultTrusteeConfigSpec fills in the profile, service type, TLS profile, self-signed certificate durations
+// and admin key grace period when unset
+func defaultTrusteeConfigSpec(spec *confidentialcontainersorgv1alpha1.TrusteeConfigSpec) {
+	if spec.Profile == "" {
+		spec.Profile = confidentialcontainersorgv1alpha1.ProfileTypePermissive
+	}
+	if spec.KbsServiceType == "" {
+		spec.KbsServiceType = corev1.ServiceTypeClusterIP
+	}
+	if spec.TlsConfig == nil {
+		spec.TlsConfig = &confidentialcontainersorgv1alpha1.TlsConfig{}
+	}
+	if spec.TlsConfig.Profile == "" {
+		spec.TlsConfig.Profile = defaultTlsProfile
+	}
+	if selfSigned := spec.HttpsSpec.SelfSigned; selfSigned != nil {
+		if selfSigned.Duration == nil {
+			selfSigned.Duration = &metav1.Duration{Duration: confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration}
+		}
+		if selfSigned.RenewBefore == nil {
+			selfSigned.RenewBefore = &metav1.Duration{Duration: confidentialcontainersorgv1alpha1.DefaultSelfSignedRenewBefore}
+		}
+	}
+	if rotation := spec.AdminKeyRotation; rotation != nil && rotation.GracePeriod == nil {
+		rotation.GracePeriod = &metav1.Duration{Duration: defaultAdminKeyGracePeriod}
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-confidentialcontainers-org-v1alpha1-trusteeconfig,mutating=false,failurePolicy=fail,sideEffects=None,groups=confidentialcontainers.org,resources=trusteeconfigs,verbs=create;update,versions=v1alpha1,name=vtrusteeconfig-v1alpha1.kb.io,admissionReviewVersions=v1
+
+// TrusteeConfigCustomValidator rejects inconsistent TrusteeConfig specs and checks the TLS secrets
+type TrusteeConfigCustomValidator struct {
+	Client client.Reader
+}
+
+// ValidateCreate implements admission.Validator
+func (v *TrusteeConfigCustomValidator) ValidateCreate(ctx context.Context, trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) (admission.Warnings, error) {
+	trusteeconfiglog.Info("Validating TrusteeConfig creation", "name", trusteeConfig.Name)
+	return v.validateTrusteeConfig(ctx, trusteeConfig)
+}
+
+// ValidateUpdate implements admission.Validator
+func (v *TrusteeConfigCustomValidator) ValidateUpdate(ctx context.Context, oldTrusteeConfig, trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) (admission.Warnings, error) {
+	// Metadata-only updates (e.g. finalizer removal during deletion) must never be blocked
+	if trusteeConfig.DeletionTimestamp != nil || apiequality.Semantic.DeepEqual(oldTrusteeConfig.Spec, trusteeConfig.Spec) {
+		return nil, nil
+	}
+	trusteeconfiglog.Info("Validating TrusteeConfig update", "name", trusteeConfig.Name)
+	return v.validateTrusteeConfig(ctx, trusteeConfig)
+}
+
+// ValidateDelete implements admission.Validator
+func (v *TrusteeConfigCustomValidator) ValidateDelete(_ context.Context, _ *confidentialcontainersorgv1alpha1.TrusteeConfig) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *TrusteeConfigCustomValidator) validateTrusteeConfig(ctx context.Context, trusteeConfig *confidentialcontainersorgv1alpha1.TrusteeConfig) (admission.Warnings, error) {
+	specPath := field.NewPath("spec")
+	spec := trusteeConfig.Spec
+	allErrs := validateTrusteeConfigSpec(spec, specPath)
+	var warnings admission.Warnings
+
+	if spec.HttpsSpec.TlsSecretName != "" && spec.HttpsSpec.CertManager != nil {
+		warnings = append(warnings, "spec.httpsSpec.certManager is ignored since spec.httpsSpec.tlsSecretName is set")
+	}
+	if spec.HttpsSpec.SelfSigned != nil {
+		if spec.HttpsSpec.TlsSecretName != "" {
+			warnings = append(warnings, "spec.httpsSpec.selfSigned is ignored since spec.httpsSpec.tlsSecretName is set")
+		} else if spec.HttpsSpec.CertManager != nil {
+			warnings = append(warnings, "spec.httpsSpec.selfSigned is ignored since spec.httpsSpec.certManager is set")
+		}
+	}
+	if spec.AttestationTokenVerificationSpec.TlsSecretName != "" && spec.AttestationTokenVerificationSpec.CertManager != nil {
+		warnings = append(warnings, "spec.attestationTokenVerificationSpec.certManager is ignored since spec.attestationTokenVerificationSpec.tlsSecretName is set")
+	}
+	warnings = append(warnings, sharedStorageWarnings(spec.SharedStorage, "spec.sharedStorage")...)
+
+	tlsSecrets := []struct {
+		fldPath *field.Path
+		name    string
+	}{
+		{specPath.Child("httpsSpec", "tlsSecretName"), spec.HttpsSpec.TlsSecretName},
+		{specPath.Child("attestationTokenVerificationSpec", "tlsSecretName"), spec.AttestationTokenVerificationSpec.TlsSecretName},
+	}
+	if as := spec.AttestationService; as != nil && as.Type == confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority &&
+		as.IntelTrustAuthority != nil && v.Client != nil {
+		ref := as.IntelTrustAuthority.APIKeySecretRef
+		fldPath := specPath.Child("attestationService", "intelTrustAuthority", "apiKeySecretRef")
+		secret := &corev1.Secret{}
+		if err := v.Client.Get(ctx, client.ObjectKey{Namespace: trusteeConfig.Namespace, Name: ref.Name}, secret); err != nil {
+			warnings = append(warnings, lookupWarning("Secret", ref.Name, fldPath.String(), trusteeConfig.Namespace, err))
+		} else if len(secret.Data[ref.Key]) == 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("key"), ref.Key,
+				fmt.Sprintf("Secret %s/%s has no API key under %s", trusteeConfig.Namespace, ref.Name, ref.Key)))
+		}
+	}
+
+	if admin := spec.AdminSpec; admin != nil && v.Client != nil {
+		for i, persona := range admin.Personas {
+			ref := persona.PublicKeySecretRef
+			if ref == nil {
+				continue
+			}
+			fldPath := specPath.Child("adminSpec", "personas").Index(i).Child("publicKeySecretRef")
+			secret := &corev1.Secret{}
+			if err := v.Client.Get(ctx, client.ObjectKey{Namespace: trusteeConfig.Namespace, Name: ref.Name}, secret); err != nil {
+				warnings = append(warnings, lookupWarning("Secret", ref.Name, fldPath.String(), trusteeConfig.Namespace, err))
+				continue
+			}
+			if block, _ := pem.Decode(secret.Data[ref.Key]); block == nil {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("key"), ref.Key,
+					fmt.Sprintf("Secret %s/%s has no PEM encoded public key under %s", trusteeConfig.Namespace, ref.Name, ref.Key)))
+			}
+		}
+	}
+
+	for _, ref := range tlsSecrets {
+		if ref.name == "" || v.Client == nil {
+			continue
+		}
+		secret := &corev1.Secret{}
+		err := v.Client.Get(ctx, client.ObjectKey{Namespace: trusteeConfig.Namespace, Name: ref.name}, secret)
+		if err != nil {
+			warnings = append(warnings, lookupWarning("Secret", ref.name, ref.fldPath.String(), trusteeConfig.Namespace, err))
+			continue
+		}
+		if fieldErr := validateTlsSecret(secret, ref.fldPath); fieldErr != nil {
+			allErrs = append(allErrs, fieldErr)
+		}
+	}
+
+	if len(allErrs) > 0 {
+		return warnings, k8serrors.NewInvalid(confidentialcontainersorgv1alpha1.GroupVersion.WithKind("TrusteeConfig").GroupKind(), trusteeConfig.Name, allErrs)
+	}
+	return warnings, nil
+}
+
+// validateTrusteeConfigSpec checks the consistency of the TrusteeConfig spec without looking at the cluster
+func validateTrusteeConfigSpec(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch spec.Profile {
+	case "", confidentialcontainersorgv1alpha1.ProfileTypePermissive:
+	case confidentialcontainersorgv1alpha1.ProfileTypeRestrictive:
+		// The restricted KBS configuration disables plain HTTP, a self-signed certificate
+		// is issued by the operator when neither a TLS secret nor cert-manager is configured
+	default:
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("profileType"), spec.Profile,
+			[]string{string(confidentialcontainersorgv1alpha1.ProfileTypePermissive), string(confidentialcontainersorgv1alpha1.ProfileTypeRestrictive)}))
+	}
+
+	if selfSigned := spec.HttpsSpec.SelfSigned; selfSigned != nil {
+		allErrs = append(allErrs, validateSelfSigned(selfSigned, specPath.Child("httpsSpec", "selfSigned"))...)
+	}
+	if certManager := spec.HttpsSpec.CertManager; certManager != nil {
+		allErrs = append(allErrs, validateCertManager(certManager, specPath.Child("httpsSpec", "certManager"))...)
+	}
+	if certManager := spec.AttestationTokenVerificationSpec.CertManager; certManager != nil {
+		allErrs = append(allErrs, validateCertManager(certManager, specPath.Child("attestationTokenVerificationSpec", "certManager"))...)
+	}
+
+	if rotation := spec.AdminKeyRotation; rotation != nil {
+		allErrs = append(allErrs, validateAdminKeyRotation(rotation, specPath.Child("adminKeyRotation"))...)
+	}
+
+	if settings := spec.KbsSettings; settings != nil {
+		allErrs = append(allErrs, validateKbsSettings(settings, specPath.Child("kbsSettings"))...)
+	}
+	if admin := spec.AdminSpec; admin != nil {
+		allErrs = append(allErrs, validateAdminSpec(admin, spec.Profile, specPath.Child("adminSpec"))...)
+	}
+	if as := spec.AttestationService; as != nil && as.Type != "" && as.Type != confidentialcontainersorgv1alpha1.AttestationServiceBuiltin {
+		allErrs = append(allErrs, validateExternalAttestationService(spec, specPath)...)
+	}
+
+	if spec.MicroservicesSpec != nil && spec.KbsDeploymentType != confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("microservicesSpec"),
+			"only applies when kbsDeploymentType is MicroservicesDeployment"))
+	}
+	if deployment := spec.KbsDeploymentSpec; deployment != nil {
+		allErrs = append(allErrs, validateKbsDeploymentSpec(*deployment, specPath.Child("kbsDeploymentSpec"))...)
+	}
+	if shared := spec.SharedStorage; shared != nil {
+		allErrs = append(allErrs, validateSharedStorage(shared, specPath.Child("sharedStorage"))...)
+	}
+
+	if spec.IbmSE != nil && spec.IbmSE.PVName == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("ibmSE", "pvName"), "the IBM SE PersistentVolume name is required when ibmSE is set"))
+	}
+
+	return allErrs
+}
+
+// validateAdminSpec checks the admin authorization mode against the profile and the identifiers of the
+// personas, including the ones accepting the previous generated public key
+func validateAdminSpec(admin *confidentialcontainersorgv1alpha1.AdminSpec, profile confidentialcontainersorgv1alpha1.ProfileType, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if admin.AuthorizationMode == confidentialcontainersorgv1alpha1.AdminAuthorizationInsecureAllowAll &&
+		profile == confidentialcontainersorgv1alpha1.ProfileTypeRestrictive {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("authorizationMode"),
+			"InsecureAllowAll is not allowed with the Restricted profile"))
+	}
+
+	ids := make(map[string]bool)
+	generatedKey := false
+	for i, persona := range admin.Personas {
+		ids[persona.ID] = true
+		if persona.PublicKeySecretRef != nil {
+			continue
+		}
+		if generatedKey {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("personas").Index(i).Child("publicKeySecretRef"), nil,
+				"only one persona can use the operator-generated admin key"))
+		}
+		generatedKey = true
+	}
+	for i, persona := range admin.Personas {
+		if persona.PublicKeySecretRef == nil && ids[persona.ID+"-previous"] {
+			allErrs = append(allErrs, field.Duplicate(fldPath.Child("personas").Index(i).Child("id"), persona.ID+"-previous"))
+		}
+	}
+	return allErrs
+}
+
+// validateExternalAttestationService rejects the KBS settings of the builtin attestation service and the
+// microservices topology when another attestation service verifies the evidence
+func validateExternalAttestationService(spec confidentialcontainersorgv1alpha1.TrusteeConfigSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	msg := fmt.Sprintf("only applies to the Builtin attestation service, not to %s", spec.AttestationService.Type)
+	if spec.KbsDeploymentType == confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("kbsDeploymentType"),
+			fmt.Sprintf("MicroservicesDeployment runs the Builtin attestation service next to KBS, it cannot be used with %s", spec.AttestationService.Type)))
+	}
+	if settings := spec.KbsSettings; settings != nil {
+		if settings.AttestationTokenDuration != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("kbsSettings", "attestationTokenDuration"), msg))
+		}
+		if len(settings.VcekSources) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("kbsSettings", "vcekSources"), msg))
+		}
+	}
+	return allErrs
+}
+
+// validateSelfSigned checks the validity, the renewal window and the names of the self-signed serving certificate
+func validateSelfSigned(selfSigned *confidentialcontainersorgv1alpha1.SelfSignedCertificateSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	duration, renewBefore := selfSigned.Durations()
+	if duration < minCertificateDuration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("duration"), duration.String(),
+			fmt.Sprintf("must be at least %s", minCertificateDuration)))
+	}
+	// The CA is only kept while it outlives a serving certificate issued now
+	if duration >= confidentialcontainersorgv1alpha1.SelfSignedCAValidity {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("duration"), duration.String(),
+			fmt.Sprintf("must be shorter than the CA validity (%s)", confidentialcontainersorgv1alpha1.SelfSignedCAValidity)))
+	}
+	if renewBefore <= 0 || renewBefore >= duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewBefore"), renewBefore.String(),
+			"must be positive and shorter than duration"))
+	}
+	for i, name := range selfSigned.DNSNames {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("dnsNames").Index(i), name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateAdminKeyRotation checks that the previous admin key expires before the next rotation
+func validateAdminKeyRotation(rotation *confidentialcontainersorgv1alpha1.AdminKeyRotationSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	gracePeriod := defaultAdminKeyGracePeriod
+	if rotation.GracePeriod != nil {
+		gracePeriod = rotation.GracePeriod.Duration
+	}
+	if gracePeriod < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("gracePeriod"), gracePeriod.String(), "must not be negative"))
+	}
+	if rotation.Interval != nil {
+		interval := rotation.Interval.Duration
+		if interval < minAdminKeyRotationInterval {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("interval"), interval.String(),
+				fmt.Sprintf("must be at least %s", minAdminKeyRotationInterval)))
+		} else if gracePeriod >= interval {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("gracePeriod"), gracePeriod.String(),
+				"must be shorter than interval"))
+		}
+	}
+	return allErrs
+}
+
+// validateKbsSettings checks that the attestation token duration is a whole number of minutes
+func validateKbsSettings(settings *confidentialcontainersorgv1alpha1.KbsSettingsSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if duration := settings.AttestationTokenDuration; duration != nil {
+		if duration.Duration < time.Minute || duration.Duration%time.Minute != 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("attestationTokenDuration"), duration.Duration.String(),
+				"must be a whole number of minutes, at least 1m"))
+		}
+	}
+	return allErrs
+}
+
+// validateCertManager checks the durations and the names requested from cert-manager,
+// the defaults of the issuer apply when the durations are not set
+func validateCertManager(certManager *confidentialcontainersorgv1alpha1.CertManagerCertificateSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if certManager.Duration != nil && certManager.Duration.Duration < minCertificateDuration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("duration"), certManager.Duration.Duration.String(),
+			fmt.Sprintf("must be at least %s", minCertificateDuration)))
+	}
+	if renewBefore := certManager.RenewBefore; renewBefore != nil {
+		if renewBefore.Duration <= 0 || (certManager.Duration != nil && renewBefore.Duration >= certManager.Duration.Duration) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("renewBefore"), renewBefore.Duration.String(),
+				"must be positive and shorter than duration"))
+		}
+	}
+	for i, name := range certManager.DNSNames {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("dnsNames").Index(i), name, msg))
+		}
+	}
+	return allErrs
+}
+
+// validateTlsSecret checks that the secret is a kubernetes.io/tls secret holding a valid key pair
+func validateTlsSecret(secret *corev1.Secret, fldPath *field.Path) *field.Error {
+	if secret.Type != corev1.SecretTypeTLS {
+		return field.Invalid(fldPath, secret.Name,
+			fmt.Sprintf("Secret %s/%s must be of type %s, got %q", secret.Namespace, secret.Name, corev1.SecretTypeTLS, secret.Type))
+	}
+	crt, key := secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+	if len(crt) == 0 || len(key) == 0 {
+		return field.Invalid(fldPath, secret.Name,
+			fmt.Sprintf("Secret %s/%s must contain non-empty %s and %s", secret.Namespace, secret.Name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey))
+	}
+	if _, err := tls.X509KeyPair(crt, key); err != nil {
+		return field.Invalid(fldPath, secret.Name,
+			fmt.Sprintf("Secret %s/%s does not hold a valid certificate and private key pair: %v", secret.Namespace, secret.Name, err))
+	}
+	return nil
+}
@@ -0,0 +1,426 @@
+/*
+Copyright Confidential Containers Contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	confidentialcontainersorgv1alpha1 "github.com/confidential-containers/trustee-operator/api/v1alpha1"
+)
+
+// selfSignedPair returns a PEM encoded self-signed certificate and its private key
+func selfSignedPair(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kbs-service"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestDefaultTrusteeConfigSpec(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{}
+	defaultTrusteeConfigSpec(&spec)
+	if spec.Profile != confidentialcontainersorgv1alpha1.ProfileTypePermissive {
+		t.Errorf("expected the Permissive profile by default, got %q", spec.Profile)
+	}
+	if spec.KbsServiceType != corev1.ServiceTypeClusterIP {
+		t.Errorf("expected ClusterIP by default, got %q", spec.KbsServiceType)
+	}
+	if spec.TlsConfig == nil || spec.TlsConfig.Profile != defaultTlsProfile {
+		t.Errorf("expected the %s TLS profile by default, got %+v", defaultTlsProfile, spec.TlsConfig)
+	}
+}
+
+func TestValidateTrusteeConfigSpec(t *testing.T) {
+	specPath := field.NewPath("spec")
+
+	// the Restricted profile falls back to a self-signed certificate without TLS secret
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{Profile: confidentialcontainersorgv1alpha1.ProfileTypeRestrictive}
+	if errs := validateTrusteeConfigSpec(spec, specPath); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+
+	spec.HttpsSpec.TlsSecretName = "kbs-https"
+	if errs := validateTrusteeConfigSpec(spec, specPath); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+
+	spec.IbmSE = &confidentialcontainersorgv1alpha1.IbmSETeeConfig{}
+	errs := validateTrusteeConfigSpec(spec, specPath)
+	if len(errs) != 1 || errs[0].Field != "spec.ibmSE.pvName" {
+		t.Errorf("expected ibmSE to require a PV name, got %v", errs)
+	}
+}
+
+func TestSelfSignedTrusteeConfigSpec(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{
+			SelfSigned: &confidentialcontainersorgv1alpha1.SelfSignedCertificateSpec{},
+		},
+	}
+	defaultTrusteeConfigSpec(&spec)
+	selfSigned := spec.HttpsSpec.SelfSigned
+	if selfSigned.Duration == nil || selfSigned.Duration.Duration != confidentialcontainersorgv1alpha1.DefaultSelfSignedDuration ||
+		selfSigned.RenewBefore == nil || selfSigned.RenewBefore.Duration != confidentialcontainersorgv1alpha1.DefaultSelfSignedRenewBefore {
+		t.Errorf("expected the default durations, got %+v", selfSigned)
+	}
+	if errs := validateTrusteeConfigSpec(spec, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+
+	selfSigned.Duration = &metav1.Duration{Duration: 24 * time.Hour}
+	selfSigned.RenewBefore = &metav1.Duration{Duration: 48 * time.Hour}
+	selfSigned.DNSNames = []string{"kbs.example.com", "Not_A_Name"}
+	errs := validateTrusteeConfigSpec(spec, field.NewPath("spec"))
+	if len(errs) != 2 || errs[0].Field != "spec.httpsSpec.selfSigned.renewBefore" || errs[1].Field != "spec.httpsSpec.selfSigned.dnsNames[1]" {
+		t.Errorf("expected errors on renewBefore and dnsNames[1], got %v", errs)
+	}
+
+	// A serving certificate outliving the CA would regenerate the CA at every reconcile
+	selfSigned.Duration = &metav1.Duration{Duration: confidentialcontainersorgv1alpha1.SelfSignedCAValidity}
+	selfSigned.DNSNames = nil
+	errs = validateTrusteeConfigSpec(spec, field.NewPath("spec"))
+	if len(errs) != 1 || errs[0].Field != "spec.httpsSpec.selfSigned.duration" {
+		t.Errorf("expected an error on duration, got %v", errs)
+	}
+}
+
+func TestCertManagerTrusteeConfigSpec(t *testing.T) {
+	issuerRef := confidentialcontainersorgv1alpha1.IssuerReference{Name: "ca-issuer", Kind: "ClusterIssuer"}
+	trusteeConfig := &confidentialcontainersorgv1alpha1.TrusteeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "trusteeconfig", Namespace: testNamespace},
+		Spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+			Profile: confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+			HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{
+				CertManager: &confidentialcontainersorgv1alpha1.CertManagerCertificateSpec{
+					IssuerRef: issuerRef,
+					DNSNames:  []string{"kbs.example.com"},
+				},
+				SelfSigned: &confidentialcontainersorgv1alpha1.SelfSignedCertificateSpec{},
+			},
+			AttestationTokenVerificationSpec: confidentialcontainersorgv1alpha1.AttestationTokenVerificationSpec{
+				CertManager: &confidentialcontainersorgv1alpha1.CertManagerCertificateSpec{
+					IssuerRef:   issuerRef,
+					Duration:    &metav1.Duration{Duration: 24 * time.Hour},
+					RenewBefore: &metav1.Duration{Duration: 8 * time.Hour},
+				},
+			},
+		},
+	}
+	v := &TrusteeConfigCustomValidator{}
+	warnings, err := v.ValidateCreate(context.Background(), trusteeConfig)
+	if err != nil {
+		t.Errorf("expected a valid spec, got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "spec.httpsSpec.selfSigned") {
+		t.Errorf("expected a warning about the ignored selfSigned section, got %v", warnings)
+	}
+
+	trusteeConfig.Spec.AttestationTokenVerificationSpec.CertManager.RenewBefore = &metav1.Duration{Duration: 48 * time.Hour}
+	trusteeConfig.Spec.HttpsSpec.CertManager.DNSNames = []string{"Not_A_Name"}
+	errs := validateTrusteeConfigSpec(trusteeConfig.Spec, field.NewPath("spec"))
+	if len(errs) != 2 || errs[0].Field != "spec.httpsSpec.certManager.dnsNames[0]" ||
+		errs[1].Field != "spec.attestationTokenVerificationSpec.certManager.renewBefore" {
+		t.Errorf("expected errors on dnsNames[0] and renewBefore, got %v", errs)
+	}
+}
+
+func TestAdminKeyRotationTrusteeConfigSpec(t *testing.T) {
+	spec := confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+		AdminKeyRotation: &confidentialcontainersorgv1alpha1.AdminKeyRotationSpec{
+			Interval: &metav1.Duration{Duration: 720 * time.Hour},
+		},
+	}
+	defaultTrusteeConfigSpec(&spec)
+	if gracePeriod := spec.AdminKeyRotation.GracePeriod; gracePeriod == nil || gracePeriod.Duration != defaultAdminKeyGracePeriod {
+		t.Errorf("expected the default grace period, got %v", gracePeriod)
+	}
+	if errs := validateTrusteeConfigSpec(spec, field.NewPath("spec")); len(errs) != 0 {
+		t.Errorf("expected a valid spec, got %v", errs)
+	}
+
+	tests := []struct {
+		name        string
+		interval    time.Duration
+		gracePeriod time.Duration
+		wantField   string
+	}{
+		{"short interval", time.Minute, 0, "spec.adminKeyRotation.interval"},
+		{"grace period past the next rotation", 24 * time.Hour, 24 * time.Hour, "spec.adminKeyRotation.gracePeriod"},
+		{"negative grace period", 24 * time.Hour, -time.Hour, "spec.adminKeyRotation.gracePeriod"},
+	}
+	for _, tt := range tests {
+		spec.AdminKeyRotation.Interval = &metav1.Duration{Duration: tt.interval}
+		spec.AdminKeyRotation.GracePeriod = &metav1.Duration{Duration: tt.gracePeriod}
+		errs := validateTrusteeConfigSpec(spec, field.NewPath("spec"))
+		if len(errs) != 1 || errs[0].Field != tt.wantField {
+			t.Errorf("%s: expected an error on %s, got %v", tt.name, tt.wantField, errs)
+		}
+	}
+}
+
+func TestKbsSettingsTrusteeConfigSpec(t *testing.T) {
+	workerCount := int32(8)
+	negativeReplicas := int32(-1)
+	tests := []struct {
+		name      string
+		spec      confidentialcontainersorgv1alpha1.TrusteeConfigSpec
+		wantField string
+	}{
+		{
+			name: "valid settings",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				Profile: confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+					AttestationTokenDuration: &metav1.Duration{Duration: 10 * time.Minute},
+				},
+				AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationDenyAll},
+			},
+		},
+		{
+			name: "token duration shorter than a minute",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+					AttestationTokenDuration: &metav1.Duration{Duration: 30 * time.Second},
+				},
+			},
+			wantField: "spec.kbsSettings.attestationTokenDuration",
+		},
+		{
+			name: "token duration not in whole minutes",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+					AttestationTokenDuration: &metav1.Duration{Duration: 90 * time.Second},
+				},
+			},
+			wantField: "spec.kbsSettings.attestationTokenDuration",
+		},
+		{
+			name: "admin API open with the restricted profile",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				Profile:   confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+				AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationInsecureAllowAll},
+			},
+			wantField: "spec.adminSpec.authorizationMode",
+		},
+		{
+			name: "worker count with a remote attestation service",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{WorkerCount: &workerCount},
+				AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+					Type:   confidentialcontainersorgv1alpha1.AttestationServiceRemote,
+					Remote: &confidentialcontainersorgv1alpha1.RemoteAttestationServiceSpec{Address: "http://coco-as:50004"},
+				},
+			},
+		},
+		{
+			name: "token duration with the Intel Trust Authority",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+					AttestationTokenDuration: &metav1.Duration{Duration: 10 * time.Minute},
+				},
+				AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+					Type: confidentialcontainersorgv1alpha1.AttestationServiceIntelTrustAuthority,
+					IntelTrustAuthority: &confidentialcontainersorgv1alpha1.IntelTrustAuthoritySpec{
+						APIKeySecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "ita"}, Key: "api-key"},
+					},
+				},
+			},
+			wantField: "spec.kbsSettings.attestationTokenDuration",
+		},
+		{
+			name: "microservices with the builtin attestation service",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsDeploymentType: confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+				KbsSettings: &confidentialcontainersorgv1alpha1.KbsSettingsSpec{
+					AttestationTokenDuration: &metav1.Duration{Duration: 10 * time.Minute},
+				},
+			},
+		},
+		{
+			name: "microservices with a remote attestation service",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsDeploymentType: confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+				AttestationService: &confidentialcontainersorgv1alpha1.AttestationServiceSpec{
+					Type:   confidentialcontainersorgv1alpha1.AttestationServiceRemote,
+					Remote: &confidentialcontainersorgv1alpha1.RemoteAttestationServiceSpec{Address: "http://coco-as:50004"},
+				},
+			},
+			wantField: "spec.kbsDeploymentType",
+		},
+		{
+			name: "separate microservices deployments",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsDeploymentType: confidentialcontainersorgv1alpha1.DeploymentTypeMicroservices,
+				MicroservicesSpec: &confidentialcontainersorgv1alpha1.MicroservicesSpec{SeparateDeployments: true},
+			},
+		},
+		{
+			name: "microservices settings with the all-in-one deployment",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				MicroservicesSpec: &confidentialcontainersorgv1alpha1.MicroservicesSpec{SeparateDeployments: true},
+			},
+			wantField: "spec.microservicesSpec",
+		},
+		{
+			name: "negative KBS replicas",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				KbsDeploymentSpec: &confidentialcontainersorgv1alpha1.KbsDeploymentSpec{Replicas: &negativeReplicas},
+			},
+			wantField: "spec.kbsDeploymentSpec.replicas",
+		},
+		{
+			name: "shared postgres without host",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				SharedStorage: &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{
+					Type:     confidentialcontainersorgv1alpha1.SharedStoragePostgres,
+					Postgres: &confidentialcontainersorgv1alpha1.PostgresEndpointSpec{CredentialsSecretName: "postgres"},
+				},
+			},
+			wantField: "spec.sharedStorage.postgres.host",
+		},
+		{
+			name: "managed shared postgres",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				SharedStorage: &confidentialcontainersorgv1alpha1.KbsSharedStorageSpec{Type: confidentialcontainersorgv1alpha1.SharedStorageManagedPostgres},
+			},
+		},
+		{
+			name: "personas with their own keys and the generated key",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{
+					AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationSimple,
+					Personas: []confidentialcontainersorgv1alpha1.AdminPersona{
+						{ID: "operator"},
+						{ID: "alice", PublicKeySecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "alice"}, Key: "key.pub"}},
+					},
+				},
+			},
+		},
+		{
+			name: "two personas using the generated key",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{
+					AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationSimple,
+					Personas:          []confidentialcontainersorgv1alpha1.AdminPersona{{ID: "alice"}, {ID: "bob"}},
+				},
+			},
+			wantField: "spec.adminSpec.personas[1].publicKeySecretRef",
+		},
+		{
+			name: "persona taking the previous key identifier",
+			spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				AdminSpec: &confidentialcontainersorgv1alpha1.AdminSpec{
+					AuthorizationMode: confidentialcontainersorgv1alpha1.AdminAuthorizationSimple,
+					Personas: []confidentialcontainersorgv1alpha1.AdminPersona{
+						{ID: "admin"},
+						{ID: "admin-previous", PublicKeySecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "old"}, Key: "key.pub"}},
+					},
+				},
+			},
+			wantField: "spec.adminSpec.personas[0].id",
+		},
+	}
+	for _, tt := range tests {
+		errs := validateTrusteeConfigSpec(tt.spec, field.NewPath("spec"))
+		if tt.wantField == "" && len(errs) != 0 {
+			t.Errorf("%s: expected a valid spec, got %v", tt.name, errs)
+		}
+		if tt.wantField != "" && (len(errs) != 1 || errs[0].Field != tt.wantField) {
+			t.Errorf("%s: expected an error on %s, got %v", tt.name, tt.wantField, errs)
+		}
+	}
+}
+
+func TestValidateTrusteeConfigTlsSecret(t *testing.T) {
+	crt, key := selfSignedPair(t)
+	validSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kbs-https", Namespace: testNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: crt, corev1.TLSPrivateKeyKey: key},
+	}
+	opaqueSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: testNamespace},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{corev1.TLSCertKey: crt, corev1.TLSPrivateKeyKey: key},
+	}
+	mismatchedKey, _ := selfSignedPair(t)
+	mismatchedSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mismatched", Namespace: testNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: mismatchedKey, corev1.TLSPrivateKeyKey: key},
+	}
+	v := &TrusteeConfigCustomValidator{Client: newTestClient(t, validSecret, opaqueSecret, mismatchedSecret)}
+
+	newTrusteeConfig := func(secretName string) *confidentialcontainersorgv1alpha1.TrusteeConfig {
+		return &confidentialcontainersorgv1alpha1.TrusteeConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "trusteeconfig", Namespace: testNamespace},
+			Spec: confidentialcontainersorgv1alpha1.TrusteeConfigSpec{
+				Profile:   confidentialcontainersorgv1alpha1.ProfileTypeRestrictive,
+				HttpsSpec: confidentialcontainersorgv1alpha1.HttpsSpec{TlsSecretName: secretName},
+			},
+		}
+	}
+
+	warnings, err := v.ValidateCreate(context.Background(), newTrusteeConfig("kbs-https"))
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("expected a valid TLS secret to be accepted, got %v, %v", err, warnings)
+	}
+
+	warnings, err = v.ValidateCreate(context.Background(), newTrusteeConfig("not-yet-created"))
+	if err != nil || len(warnings) != 1 {
+		t.Errorf("expected a missing TLS secret to produce a warning only, got %v, %v", err, warnings)
+	}
+
+	for _, name := range []string{"opaque", "mismatched"} {
+		_, err = v.ValidateCreate(context.Background(), newTrusteeConfig(name))
+		if !k8serrors.IsInvalid(err) || !strings.Contains(err.Error(), name) {
+			t.Errorf("expected secret %s to be rejected, got %v", name, err)
+		}
+	}
+}
@@ -59,6 +59,11 @@ if [[ "$ALL_IN_ONE" != "true" ]] ; then
 fi
 popd
 
+# cert-manager issues the serving certificate of the admission webhooks
+CERT_MANAGER_VERSION="${CERT_MANAGER_VERSION:-v1.16.2}"
+kubectl apply -f https://github.com/cert-manager/cert-manager/releases/download/${CERT_MANAGER_VERSION}/cert-manager.yaml
+kubectl wait --for=condition=Available --timeout=300s -n cert-manager deployment --all
+
 make docker-build docker-push
 make build-installer
 kubectl apply -f dist/install.yaml